@@ -0,0 +1,470 @@
+package queryparser
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// AggregateFunc names an aggregate function
+type AggregateFunc string
+
+const (
+	AggCount         AggregateFunc = "count"
+	AggCountDistinct AggregateFunc = "countDistinct"
+	AggSum           AggregateFunc = "sum"
+	AggAvg           AggregateFunc = "avg"
+	AggMin           AggregateFunc = "min"
+	AggMax           AggregateFunc = "max"
+)
+
+// Aggregate is an aggregate computed per group, e.g.
+//
+//	{"func": "sum", "field": "total", "alias": "revenue"}
+//
+// Field may be empty for count, which counts rows. Alias names the result
+// in the output, in having and in sort; it defaults to the function name
+// followed by the field, e.g. sum_total.
+type Aggregate struct {
+	Func  AggregateFunc `json:"func"`
+	Field string        `json:"field,omitempty"`
+	Alias string        `json:"alias,omitempty"`
+}
+
+// Name returns the alias of the aggregate
+func (a Aggregate) Name() string {
+	if a.Alias != "" {
+		return a.Alias
+	}
+	if a.Field == "" {
+		return string(a.Func)
+	}
+	return string(a.Func) + "_" + strings.ReplaceAll(a.Field, ".", "_")
+}
+
+// aliasPattern matches aggregate aliases, which are inlined as identifiers
+var aliasPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// grouped reports whether the options describe an aggregation
+func (o *QueryOptions) grouped() bool {
+	return o != nil && (len(o.GroupBy) > 0 || len(o.Aggregates) > 0)
+}
+
+// isAggregateAlias reports whether a name is the alias of one of the aggregates
+func (o *QueryOptions) isAggregateAlias(name string) bool {
+	if o == nil {
+		return false
+	}
+	for _, agg := range o.Aggregates {
+		if agg.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isNumeric reports whether a type holds numbers
+func isNumeric(typ reflect.Type) bool {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// havingOperators are the operators allowed in having
+var havingOperators = map[Operator]bool{
+	OpEq: true, OpNe: true, OpGt: true, OpGte: true, OpLt: true, OpLte: true,
+	OpIn: true, OpNin: true, OpBetween: true, OpAnd: true, OpOr: true,
+}
+
+// validateAggregation checks the grouping options: grouped and aggregated
+// fields must be single values of the model, sum and avg need numbers,
+// aliases must be unique identifiers, having may only reference aliases and
+// sorting a grouped query is limited to grouped fields and aliases. The
+// model checks are skipped when schema is nil.
+func validateAggregation(options *QueryOptions, schema *modelSchema) error {
+	if !options.grouped() {
+		if options != nil && len(options.Having) > 0 {
+			return fmt.Errorf("having requires groupBy or aggregates")
+		}
+		return nil
+	}
+
+	scalar := func(name string) (*schemaField, bool, error) {
+		if schema == nil {
+			return nil, true, nil
+		}
+		chain, ok := schema.lookup(name)
+		if !ok {
+			return nil, false, unknownField("field %q is not a valid JSON field", name)
+		}
+		return chain[len(chain)-1], isScalarPath(name, chain), nil
+	}
+
+	for _, name := range options.GroupBy {
+		_, ok, err := scalar(name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("field %q cannot be used for grouping", name)
+		}
+	}
+
+	aliases := make(map[string]bool)
+	for _, agg := range options.Aggregates {
+		switch agg.Func {
+		case AggCount, AggCountDistinct, AggSum, AggAvg, AggMin, AggMax:
+		default:
+			return fmt.Errorf("unknown aggregate function %q", agg.Func)
+		}
+
+		if agg.Field == "" {
+			if agg.Func != AggCount {
+				return fmt.Errorf("aggregate %s requires a field", agg.Func)
+			}
+		} else {
+			field, ok, err := scalar(agg.Field)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("field %q cannot be aggregated", agg.Field)
+			}
+			numeric := agg.Func == AggSum || agg.Func == AggAvg
+			if numeric && field != nil && !isNumeric(field.Type) {
+				return fmt.Errorf("aggregate %s requires a numeric field, %q is not one", agg.Func, agg.Field)
+			}
+		}
+
+		name := agg.Name()
+		if !aliasPattern.MatchString(name) {
+			return fmt.Errorf("invalid aggregate alias %q", name)
+		}
+		if aliases[name] {
+			return fmt.Errorf("duplicate aggregate alias %q", name)
+		}
+		aliases[name] = true
+	}
+
+	err := Walk(options.Having, func(c *Cursor) error {
+		f := c.Filter()
+		if !havingOperators[f.Operator] {
+			return fmt.Errorf("operator %s is not supported in having", f.Operator)
+		}
+		if f.Operator != OpAnd && f.Operator != OpOr && !aliases[f.Field] {
+			return fmt.Errorf("having field %q is not an aggregate alias", f.Field)
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, sortField := range options.sortFields() {
+		name := sortField.Field
+		grouped := false
+		for _, g := range options.GroupBy {
+			grouped = grouped || g == name
+		}
+		if !grouped && !aliases[name] {
+			return fmt.Errorf("field %q must be grouped or an aggregate alias to be used for sorting", name)
+		}
+	}
+	return nil
+}
+
+// isScalarPath reports whether a path resolves to a single value that can be
+// sorted or grouped: it does not cross a repeated field or a relation and
+// does not end at a struct, slice or map. Paths below a schemaless JSON
+// column are single values.
+func isScalarPath(name string, chain []*schemaField) bool {
+	for _, field := range chain[:len(chain)-1] {
+		if field.Repeated || field.Relation != nil {
+			return false
+		}
+	}
+	schemaless := len(chain) < strings.Count(name, ".")+1
+	return schemaless || chain[len(chain)-1].Sortable
+}
+
+// aggregateExpr returns the SQL expression of an aggregate
+func aggregateExpr(agg Aggregate, columns columnResolver) string {
+	if agg.Field == "" {
+		return "COUNT(*)"
+	}
+	column := columns.columnOrField(agg.Field)
+	switch agg.Func {
+	case AggCountDistinct:
+		return "COUNT(DISTINCT " + column + ")"
+	default:
+		return strings.ToUpper(string(agg.Func)) + "(" + column + ")"
+	}
+}
+
+// applyAggregation replaces the selected columns with the grouped fields and
+// aggregates, and adds GROUP BY and HAVING. Having is compiled over the
+// aggregate expressions because not every database accepts aliases there.
+func (qb *SqlBuilder) applyAggregation(options *QueryOptions, columns columnResolver) (*SqlBuilder, error) {
+	if !options.grouped() {
+		return qb, nil
+	}
+
+	selected := make([]string, 0, len(options.GroupBy)+len(options.Aggregates))
+	groups := make([]string, 0, len(options.GroupBy))
+	for _, name := range options.GroupBy {
+		column := columns.columnOrField(name)
+		groups = append(groups, column)
+		if column != name && aliasPattern.MatchString(name) {
+			column += " AS " + name
+		}
+		selected = append(selected, column)
+	}
+
+	expressions := make(map[string]string, len(options.Aggregates))
+	for _, agg := range options.Aggregates {
+		expr := aggregateExpr(agg, columns)
+		expressions[agg.Name()] = expr
+		selected = append(selected, expr+" AS "+agg.Name())
+	}
+
+	qb.selectBuilder = qb.selectBuilder.RemoveColumns().Columns(selected...)
+	if len(groups) > 0 {
+		qb.selectBuilder = qb.selectBuilder.GroupBy(groups...)
+	}
+
+	if len(options.Having) > 0 {
+		// Aliases are the only fields of having, so they get a schema of their own
+		aggregates := columnResolver{schema: &modelSchema{}, columnMap: expressions}
+		condition, err := qb.buildConditions(options.Having, aggregates)
+		if err != nil {
+			return nil, err
+		}
+		qb.selectBuilder = qb.selectBuilder.Having(condition)
+	}
+	return qb, nil
+}
+
+// Aggregations builds Elasticsearch aggregations from the grouping options.
+// Without groupBy the metrics are returned at the top level. With groupBy
+// they are nested in one terms aggregation per grouped field, named after
+// the field, with the limit as the number of buckets, sort fields as the
+// bucket order and having as a bucket_selector named "_having". count
+// without a field is a filter aggregation whose doc_count is the count, and
+// countDistinct is an approximate cardinality aggregation.
+func (b *DSLBuilder) Aggregations(options *QueryOptions, model any) (map[string]map[string]any, error) {
+	var schema *modelSchema
+	if model != nil {
+		var err error
+		schema, err = schemaOf(model)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get model schema: %w", err)
+		}
+	}
+	if err := validateAggregation(options, schema); err != nil {
+		return nil, err
+	}
+	if !options.grouped() {
+		return nil, nil
+	}
+	if err := authorizeModel(b.ctx, nil, options, schema, b.authorizer); err != nil {
+		return nil, err
+	}
+
+	metrics := make(map[string]map[string]any, len(options.Aggregates))
+	paths := make(map[string]string, len(options.Aggregates))
+	for _, agg := range options.Aggregates {
+		name := agg.Name()
+		if agg.Field != "" {
+			field, err := sortPath(schema, agg.Field, "aggregation")
+			if err != nil {
+				return nil, err
+			}
+			agg.Field = field
+		}
+		metrics[name], paths[name] = metricAggregation(agg)
+	}
+	if len(options.GroupBy) == 0 {
+		if len(options.Having) > 0 {
+			return nil, fmt.Errorf("having requires groupBy in ElasticBuilder")
+		}
+		return metrics, nil
+	}
+
+	var inner map[string]any
+	for i := len(options.GroupBy) - 1; i >= 0; i-- {
+		field := options.GroupBy[i]
+		path, err := sortPath(schema, field, "grouping")
+		if err != nil {
+			return nil, err
+		}
+		terms := map[string]any{"field": path}
+		if options.Limit != nil {
+			terms["size"] = *options.Limit
+		}
+		var order []any
+		for _, sortField := range options.sortFields() {
+			name, direction := sortField.Field, "asc"
+			if sortField.Direction == SortDesc {
+				direction = "desc"
+			}
+			if name == field {
+				order = append(order, map[string]any{"_key": direction})
+			} else if i == len(options.GroupBy)-1 && options.isAggregateAlias(name) {
+				order = append(order, map[string]any{name: direction})
+			}
+		}
+		if len(order) > 0 {
+			terms["order"] = order
+		}
+
+		subAggregations := make(map[string]any)
+		if inner != nil {
+			subAggregations[options.GroupBy[i+1]] = inner
+		} else {
+			for _, agg := range options.Aggregates {
+				subAggregations[agg.Name()] = metrics[agg.Name()]
+			}
+			if len(options.Having) > 0 {
+				selector, err := bucketSelector(options.Having, paths)
+				if err != nil {
+					return nil, err
+				}
+				subAggregations["_having"] = selector
+			}
+		}
+		inner = map[string]any{"terms": terms}
+		if len(subAggregations) > 0 {
+			inner["aggregations"] = subAggregations
+		}
+	}
+	return map[string]map[string]any{options.GroupBy[0]: inner}, nil
+}
+
+// metricAggregation returns the Elasticsearch aggregation of an aggregate and
+// the buckets path of its value
+func metricAggregation(agg Aggregate) (map[string]any, string) {
+	name := agg.Name()
+	if agg.Field == "" {
+		return map[string]any{"filter": matchAllQuery()}, name + "._count"
+	}
+	field := map[string]any{"field": agg.Field}
+	switch agg.Func {
+	case AggCount:
+		return map[string]any{"value_count": field}, name
+	case AggCountDistinct:
+		return map[string]any{"cardinality": field}, name
+	case AggSum:
+		return map[string]any{"sum": field}, name
+	case AggAvg:
+		return map[string]any{"avg": field}, name
+	case AggMin:
+		return map[string]any{"min": field}, name
+	default:
+		return map[string]any{"max": field}, name
+	}
+}
+
+// bucketSelector compiles having into a bucket_selector with a Painless
+// condition. Aliases are read through buckets paths and values are passed as
+// script parameters.
+func bucketSelector(having []Filter, paths map[string]string) (map[string]any, error) {
+	params := make(map[string]any)
+	used := make(map[string]bool)
+	param := func(v any) string {
+		name := fmt.Sprintf("_v%d", len(params))
+		params[name] = v
+		return "params." + name
+	}
+
+	var compile func(filters []Filter, join string) (string, error)
+	compile = func(filters []Filter, join string) (string, error) {
+		parts := make([]string, 0, len(filters))
+		for _, f := range filters {
+			var part string
+			switch f.Operator {
+			case OpAnd, OpOr:
+				op := " && "
+				if f.Operator == OpOr {
+					op = " || "
+				}
+				nested, err := compile(f.Filters, op)
+				if err != nil {
+					return "", err
+				}
+				part = "(" + nested + ")"
+			default:
+				used[f.Field] = true
+				condition, err := painlessCondition(f, "params."+f.Field, param)
+				if err != nil {
+					return "", err
+				}
+				part = condition
+			}
+			parts = append(parts, part)
+		}
+		return strings.Join(parts, join), nil
+	}
+
+	source, err := compile(having, " && ")
+	if err != nil {
+		return nil, err
+	}
+	selector := map[string]any{"script": scriptSource(source, params)}
+	if len(used) > 0 {
+		bucketsPath := make(map[string]any, len(used))
+		for alias := range used {
+			bucketsPath[alias] = paths[alias]
+		}
+		selector["buckets_path"] = bucketsPath
+	}
+	return map[string]any{"bucket_selector": selector}, nil
+}
+
+// painlessCondition compiles a comparison on one having alias
+func painlessCondition(f Filter, operand string, param func(any) string) (string, error) {
+	comparisons := map[Operator]string{OpEq: "==", OpNe: "!=", OpGt: ">", OpGte: ">=", OpLt: "<", OpLte: "<="}
+	if op, ok := comparisons[f.Operator]; ok {
+		return operand + " " + op + " " + param(f.Value), nil
+	}
+
+	switch f.Operator {
+	case OpIn, OpNin:
+		values, ok := sliceValues(f.Value)
+		if !ok || len(values) == 0 {
+			return "", fmt.Errorf("%s operator requires a non-empty array for field %q", f.Operator, f.Field)
+		}
+		parts := make([]string, len(values))
+		for i, v := range values {
+			parts[i] = operand + " == " + param(v)
+		}
+		condition := "(" + strings.Join(parts, " || ") + ")"
+		if f.Operator == OpNin {
+			condition = "!" + condition
+		}
+		return condition, nil
+	case OpBetween:
+		b, err := betweenValue(f)
+		if err != nil {
+			return "", err
+		}
+		lower, upper := ">=", "<="
+		if !b.lowerInclusive() {
+			lower = ">"
+		}
+		if !b.upperInclusive() {
+			upper = "<"
+		}
+		return "(" + operand + " " + lower + " " + param(b.From) + " && " + operand + " " + upper + " " + param(b.To) + ")", nil
+	default:
+		return "", fmt.Errorf("operator %s is not supported in having", f.Operator)
+	}
+}
@@ -0,0 +1,231 @@
+package queryparser
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Sale is a model for aggregation tests
+type Sale struct {
+	ID       int      `json:"id" db:"id"`
+	Status   string   `json:"status" db:"status"`
+	Region   string   `json:"region" db:"region_code"`
+	Total    float64  `json:"total" db:"total"`
+	Customer string   `json:"customer" db:"customer_id"`
+	Tags     []string `json:"tags" db:"tags"`
+}
+
+func TestParseAggregationOptions(t *testing.T) {
+	options, err := ParseQueryOptions(`{
+		"groupBy": ["status"],
+		"aggregates": [{"func": "count", "alias": "orders"}, {"func": "sum", "field": "total"}],
+		"having": {"orders": {"$gt": 10}},
+		"sort": {"sum_total": "desc"}
+	}`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"status"}, options.GroupBy)
+	assert.Equal(t, []Aggregate{{Func: AggCount, Alias: "orders"}, {Func: AggSum, Field: "total"}}, options.Aggregates)
+	assert.Equal(t, []Filter{{Field: "orders", Operator: OpGt, Value: float64(10)}}, options.Having)
+	assert.Equal(t, "sum_total", options.Aggregates[1].Name())
+}
+
+func TestSqlAggregation(t *testing.T) {
+	tests := []struct {
+		name     string
+		filters  []Filter
+		options  *QueryOptions
+		wantSQL  string
+		wantArgs []any
+	}{
+		{
+			name: "count and sum by status",
+			filters: []Filter{
+				{Field: "total", Operator: OpGt, Value: 0},
+			},
+			options: &QueryOptions{
+				GroupBy: []string{"status"},
+				Aggregates: []Aggregate{
+					{Func: AggCount, Alias: "orders"},
+					{Func: AggSum, Field: "total", Alias: "revenue"},
+				},
+				Sort: map[string]SortDirection{"revenue": SortDesc},
+			},
+			wantSQL:  "SELECT status, COUNT(*) AS orders, SUM(total) AS revenue FROM sales WHERE (total > $1) GROUP BY status ORDER BY revenue DESC",
+			wantArgs: []any{0},
+		},
+		{
+			name: "mapped group column and having",
+			options: &QueryOptions{
+				GroupBy: []string{"region", "status"},
+				Aggregates: []Aggregate{
+					{Func: AggCountDistinct, Field: "customer", Alias: "customers"},
+					{Func: AggAvg, Field: "total"},
+				},
+				Having: []Filter{
+					{Field: "customers", Operator: OpGte, Value: 5},
+					{Operator: OpOr, Filters: []Filter{
+						{Field: "avg_total", Operator: OpLt, Value: 10},
+						{Field: "avg_total", Operator: OpBetween, Value: []any{100, 200}},
+					}},
+				},
+				Limit: intPtr(10),
+			},
+			wantSQL: "SELECT region_code AS region, status, COUNT(DISTINCT customer_id) AS customers, AVG(total) AS avg_total FROM sales " +
+				"GROUP BY region_code, status HAVING (COUNT(DISTINCT customer_id) >= $1 AND (AVG(total) < $2 OR AVG(total) BETWEEN $3 AND $4)) LIMIT 10",
+			wantArgs: []any{5, 10, 100, 200},
+		},
+		{
+			name: "aggregates without grouping",
+			options: &QueryOptions{
+				Aggregates: []Aggregate{{Func: AggMin, Field: "total"}, {Func: AggMax, Field: "total"}},
+			},
+			wantSQL: "SELECT MIN(total) AS min_total, MAX(total) AS max_total FROM sales",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qb, err := NewSqlBuilder(context.Background()).WithSelect("sales").Apply(tt.filters, tt.options, &Sale{})
+			assert.NoError(t, err)
+
+			sql, args, err := qb.ToSql()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantSQL, sql)
+			assert.Equal(t, tt.wantArgs, args)
+		})
+	}
+}
+
+func TestAggregationErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *QueryOptions
+		wantErr string
+	}{
+		{
+			name:    "unknown group field",
+			options: &QueryOptions{GroupBy: []string{"color"}},
+			wantErr: `field "color" is not a valid JSON field`,
+		},
+		{
+			name:    "group by array",
+			options: &QueryOptions{GroupBy: []string{"tags"}},
+			wantErr: `field "tags" cannot be used for grouping`,
+		},
+		{
+			name:    "sum of a string",
+			options: &QueryOptions{Aggregates: []Aggregate{{Func: AggSum, Field: "status"}}},
+			wantErr: `aggregate sum requires a numeric field, "status" is not one`,
+		},
+		{
+			name:    "unknown function",
+			options: &QueryOptions{Aggregates: []Aggregate{{Func: "median", Field: "total"}}},
+			wantErr: `unknown aggregate function "median"`,
+		},
+		{
+			name:    "missing field",
+			options: &QueryOptions{Aggregates: []Aggregate{{Func: AggAvg}}},
+			wantErr: "aggregate avg requires a field",
+		},
+		{
+			name:    "invalid alias",
+			options: &QueryOptions{Aggregates: []Aggregate{{Func: AggCount, Alias: "a; DROP"}}},
+			wantErr: `invalid aggregate alias "a; DROP"`,
+		},
+		{
+			name:    "duplicate alias",
+			options: &QueryOptions{Aggregates: []Aggregate{{Func: AggCount}, {Func: AggCount}}},
+			wantErr: `duplicate aggregate alias "count"`,
+		},
+		{
+			name: "having on a model field",
+			options: &QueryOptions{
+				GroupBy:    []string{"status"},
+				Aggregates: []Aggregate{{Func: AggCount}},
+				Having:     []Filter{{Field: "total", Operator: OpGt, Value: 1}},
+			},
+			wantErr: `having field "total" is not an aggregate alias`,
+		},
+		{
+			name:    "having without grouping",
+			options: &QueryOptions{Having: []Filter{{Field: "count", Operator: OpGt, Value: 1}}},
+			wantErr: "having requires groupBy or aggregates",
+		},
+		{
+			name: "sort by ungrouped field",
+			options: &QueryOptions{
+				GroupBy: []string{"status"},
+				Sort:    map[string]SortDirection{"total": SortAsc},
+			},
+			wantErr: `field "total" must be grouped or an aggregate alias to be used for sorting`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSqlBuilder(context.Background()).WithSelect("sales").Apply(nil, tt.options, &Sale{})
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestElasticAggregations(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *QueryOptions
+		want    string
+	}{
+		{
+			name: "metrics without grouping",
+			options: &QueryOptions{
+				Aggregates: []Aggregate{{Func: AggSum, Field: "total"}, {Func: AggCountDistinct, Field: "customer", Alias: "customers"}},
+			},
+			want: `{"aggregations":{"customers":{"cardinality":{"field":"customer"}},"sum_total":{"sum":{"field":"total"}}},"query":{"bool":{}},"size":0}`,
+		},
+		{
+			name: "terms with metrics, order and having",
+			options: &QueryOptions{
+				GroupBy:    []string{"region", "status"},
+				Aggregates: []Aggregate{{Func: AggCount, Alias: "orders"}, {Func: AggAvg, Field: "total"}},
+				Having:     []Filter{{Field: "orders", Operator: OpGt, Value: 10}, {Field: "avg_total", Operator: OpIn, Value: []any{1, 2}}},
+				Sort:       map[string]SortDirection{"orders": SortDesc, "region": SortAsc},
+				Limit:      intPtr(5),
+			},
+			want: `{"aggregations":{"region":{"aggregations":{"status":{"aggregations":{` +
+				`"_having":{"bucket_selector":{"buckets_path":{"avg_total":"avg_total","orders":"orders._count"},` +
+				`"script":{"params":{"_v0":10,"_v1":1,"_v2":2},"source":"params.orders \u003e params._v0 \u0026\u0026 (params.avg_total == params._v1 || params.avg_total == params._v2)"}}},` +
+				`"avg_total":{"avg":{"field":"total"}},"orders":{"filter":{"match_all":{}}}},` +
+				`"terms":{"field":"status","order":[{"orders":"desc"}],"size":5}}},` +
+				`"terms":{"field":"region","order":[{"_key":"asc"}],"size":5}}},"query":{"bool":{}},"size":0}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := NewElasticBuilder(nil).SearchSource(nil, tt.options, &Sale{})
+			assert.NoError(t, err)
+
+			body, err := source.Source()
+			assert.NoError(t, err)
+			bodyStr, err := json.Marshal(body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(bodyStr))
+		})
+	}
+
+	_, err := NewElasticBuilder(nil).Aggregations(&QueryOptions{
+		Aggregates: []Aggregate{{Func: AggCount}},
+		Having:     []Filter{{Field: "count", Operator: OpGt, Value: 1}},
+	}, &Sale{})
+	assert.EqualError(t, err, "having requires groupBy in ElasticBuilder")
+
+	_, err = NewElasticBuilder(nil).Search(nil, nil, &Sale{})
+	assert.EqualError(t, err, "elastic builder has no search service")
+}
+
+func intPtr(n int) *int {
+	return &n
+}
@@ -0,0 +1,315 @@
+package queryparser
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// isArrayOperator reports whether an operator applies to slice fields only
+func isArrayOperator(op Operator) bool {
+	switch op {
+	case OpAll, OpContains, OpSize, OpElemMatch:
+		return true
+	default:
+		return false
+	}
+}
+
+// arrayValues returns the values of an $all or $contains filter
+func arrayValues(filter Filter) ([]any, error) {
+	values, ok := sliceValues(filter.Value)
+	if !ok || len(values) == 0 {
+		return nil, fmt.Errorf("%s operator requires a non-empty array for field %q", filter.Operator, filter.Field)
+	}
+	return values, nil
+}
+
+// sizeValue returns the length of a $size filter
+func sizeValue(filter Filter) (int, error) {
+	f, ok := toFloat(filter.Value)
+	if !ok || f < 0 || f != math.Trunc(f) {
+		return 0, fmt.Errorf("$size operator requires a non-negative integer for field %q", filter.Field)
+	}
+	return int(f), nil
+}
+
+// validateArrayFilter checks that an array operator is used on a slice field
+// and validates the conditions of $elemMatch against the element type.
+// Paths below a schemaless JSON column are accepted as arrays.
+func validateArrayFilter(filter Filter, schema *modelSchema) error {
+	chain, ok := schema.lookup(filter.Field)
+	if !ok {
+		return unknownField("field %q is not a valid JSON field", filter.Field)
+	}
+	field := chain[len(chain)-1]
+	schemaless := len(chain) < strings.Count(filter.Field, ".")+1
+	if !schemaless && !field.Repeated {
+		return fmt.Errorf("operator %s requires an array field, %q is not one", filter.Operator, filter.Field)
+	}
+	if (schemaless && !field.Filterable) || (!schemaless && !acceptsOperator(chain, filter.Operator)) {
+		return unknownField("field %q is not a valid JSON field", filter.Field)
+	}
+	if filter.Operator != OpElemMatch {
+		return nil
+	}
+
+	if len(filter.Filters) == 0 {
+		return fmt.Errorf("$elemMatch operator requires nested filters for field %q", filter.Field)
+	}
+	if field.Children != nil && !schemaless {
+		return validateFields(filter.Filters, nil, field.Children)
+	}
+
+	// Elements are scalars, or objects without a schema
+	return Walk(filter.Filters, func(c *Cursor) error {
+		f := c.Filter()
+		if f.Operator == OpOr || f.Operator == OpAnd {
+			return nil
+		}
+		if isArrayOperator(f.Operator) {
+			return fmt.Errorf("operator %s is not supported inside $elemMatch on field %q", f.Operator, filter.Field)
+		}
+		if f.Field == "" {
+			return nil
+		}
+		if !schemaless {
+			return fmt.Errorf("elements of field %q are not objects, use operators in $elemMatch", filter.Field)
+		}
+		for _, key := range strings.Split(f.Field, ".") {
+			if !jsonKeyPattern.MatchString(key) {
+				return fmt.Errorf("invalid JSON key %q in field %q", key, filter.Field+"."+f.Field)
+			}
+		}
+		return nil
+	}, nil)
+}
+
+// arrayElemType returns the Go type of the elements of a slice field
+func arrayElemType(typ reflect.Type) reflect.Type {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array {
+		typ = typ.Elem()
+	}
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ
+}
+
+// pgArrayType returns the Postgres element type used to cast array literals
+// compared with a field. It is taken from a query:"array=<type>" tag or
+// derived from the Go element type.
+func pgArrayType(field *schemaField) string {
+	if field.ArrayType != "" {
+		return field.ArrayType
+	}
+	typ := arrayElemType(field.Type)
+	if typ == timeType {
+		return "timestamptz"
+	}
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return "bigint"
+	case reflect.Int32, reflect.Uint16:
+		return "integer"
+	case reflect.Int8, reflect.Int16, reflect.Uint8:
+		return "smallint"
+	case reflect.Float64:
+		return "double precision"
+	case reflect.Float32:
+		return "real"
+	case reflect.Bool:
+		return "boolean"
+	default:
+		return "text"
+	}
+}
+
+// buildArrayCondition converts an array operator into a Squirrel condition.
+// Postgres array columns use @> for $all, && for $contains, cardinality for
+// $size and an EXISTS over unnest for $elemMatch.
+func (qb *SqlBuilder) buildArrayCondition(filter Filter, columns columnResolver) (squirrel.Sqlizer, error) {
+	if jp, ok := columns.jsonPath(filter.Field); ok {
+		return qb.buildJSONArrayCondition(filter, jp, columns.schema)
+	}
+
+	chain, ok := columns.schema.lookup(filter.Field)
+	if !ok {
+		return nil, unknownField("field %q is not a valid JSON field", filter.Field)
+	}
+	field := chain[len(chain)-1]
+	if filter.Operator == OpElemMatch {
+		return qb.buildElemMatch(filter, field, columns)
+	}
+
+	column := columns.columnOrField(filter.Field)
+	switch filter.Operator {
+	case OpAll, OpContains:
+		values, err := arrayValues(filter)
+		if err != nil {
+			return nil, err
+		}
+		op := "@>"
+		if filter.Operator == OpContains {
+			op = "&&"
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(values)), ",")
+		return squirrel.Expr(fmt.Sprintf("%s %s ARRAY[%s]::%s[]", column, op, placeholders, pgArrayType(field)), values...), nil
+	case OpSize:
+		size, err := sizeValue(filter)
+		if err != nil {
+			return nil, err
+		}
+		return squirrel.Expr("cardinality("+column+") = ?", size), nil
+	default:
+		return nil, fmt.Errorf("unsupported operator: %s", filter.Operator)
+	}
+}
+
+// buildElemMatch converts $elemMatch on a slice field. Elements of a relation
+// are matched in one EXISTS subquery (or on the joined row), elements of a
+// struct slice on its joined alias, and scalar elements of a Postgres array by
+// unnesting the column.
+func (qb *SqlBuilder) buildElemMatch(filter Filter, field *schemaField, columns columnResolver) (squirrel.Sqlizer, error) {
+	switch {
+	case field.Relation != nil:
+		related := columnResolver{schema: field.Children, naming: columns.naming, table: field.Relation.Table}
+		condition, err := qb.buildConditions(filter.Filters, related)
+		if err != nil {
+			return nil, err
+		}
+		return qb.relationCondition(field.Relation, condition), nil
+
+	case field.Children != nil:
+		alias, ok := columns.fieldColumn(field)
+		if !ok {
+			alias = field.JSON
+		}
+		elements := columnResolver{schema: field.Children, naming: columns.naming, table: alias}
+		return qb.buildConditions(filter.Filters, elements)
+
+	default:
+		condition, err := buildTrees(filter.Filters, func(f Filter) (squirrel.Sqlizer, error) {
+			return qb.buildFieldCondition(f, "elem")
+		})
+		if err != nil {
+			return nil, err
+		}
+		from := "unnest(" + columns.columnOrField(filter.Field) + ") AS elem"
+		return squirrel.Expr("EXISTS (?)", squirrel.Select("1").From(from).Where(condition)), nil
+	}
+}
+
+// buildJSONArrayCondition converts an array operator on an array stored in a
+// JSON column. $all and $contains need jsonb; $size and $elemMatch work on
+// both json and jsonb.
+func (qb *SqlBuilder) buildJSONArrayCondition(filter Filter, jp *jsonPath, schema *modelSchema) (squirrel.Sqlizer, error) {
+	container := jp.container(len(jp.keys))
+	functions := "jsonb"
+	if !jp.jsonb {
+		functions = "json"
+	}
+
+	switch filter.Operator {
+	case OpAll, OpContains:
+		if !jp.jsonb {
+			return nil, fmt.Errorf("%s operator on field %q requires a jsonb column", filter.Operator, filter.Field)
+		}
+		values, err := arrayValues(filter)
+		if err != nil {
+			return nil, err
+		}
+		if filter.Operator == OpAll {
+			doc, err := json.Marshal(values)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode value for field %q: %w", filter.Field, err)
+			}
+			return squirrel.Expr(container+" @> ?::jsonb", string(doc)), nil
+		}
+		// Overlap: the array contains any one-element array of the values
+		docs := make([]any, len(values))
+		for i, value := range values {
+			doc, err := json.Marshal([]any{value})
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode value for field %q: %w", filter.Field, err)
+			}
+			docs[i] = string(doc)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?::jsonb,", len(values)), ",")
+		return squirrel.Expr(container+" @> ANY (ARRAY["+placeholders+"])", docs...), nil
+
+	case OpSize:
+		size, err := sizeValue(filter)
+		if err != nil {
+			return nil, err
+		}
+		return squirrel.Expr(functions+"_array_length("+container+") = ?", size), nil
+
+	case OpElemMatch:
+		var elem reflect.Type
+		var children *modelSchema
+		if jp.leaf != nil {
+			elem = arrayElemType(jp.leaf)
+			if chain, ok := schema.lookup(filter.Field); ok {
+				children = chain[len(chain)-1].Children
+			}
+		}
+
+		var from string
+		var condition squirrel.Sqlizer
+		var err error
+		if scalarElemMatch(filter) {
+			from = functions + "_array_elements_text(" + container + ") AS elem"
+			condition, err = buildTrees(filter.Filters, func(f Filter) (squirrel.Sqlizer, error) {
+				cast := valueCast(f.Value)
+				if elem != nil {
+					cast = sqlCast(elem)
+				}
+				if cast == "" || f.Operator == OpLike || f.Operator == OpExists {
+					return qb.buildFieldCondition(f, "elem")
+				}
+				return qb.buildFieldCondition(f, "elem::"+cast)
+			})
+		} else {
+			from = functions + "_array_elements(" + container + ") AS elem"
+			condition, err = buildTrees(filter.Filters, func(f Filter) (squirrel.Sqlizer, error) {
+				keys := strings.Split(f.Field, ".")
+				path := &jsonPath{column: "elem", keys: keys, jsonb: jp.jsonb}
+				if children != nil {
+					if chain, ok := children.lookup(f.Field); ok && len(chain) == len(keys) {
+						path.leaf = chain[len(chain)-1].Type
+					}
+				}
+				return qb.buildJSONCondition(f, path)
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+		return squirrel.Expr("EXISTS (?)", squirrel.Select("1").From(from).Where(condition)), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported operator: %s", filter.Operator)
+	}
+}
+
+// scalarElemMatch reports whether the conditions of an $elemMatch apply to
+// scalar elements, i.e. none of them names a field
+func scalarElemMatch(filter Filter) bool {
+	scalar := true
+	_ = Walk(filter.Filters, func(c *Cursor) error {
+		f := c.Filter()
+		if f.Operator != OpOr && f.Operator != OpAnd && f.Field != "" {
+			scalar = false
+		}
+		return nil
+	}, nil)
+	return scalar
+}
@@ -0,0 +1,265 @@
+package queryparser
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ArrayLine is an element of ArrayPost.Lines
+type ArrayLine struct {
+	SKU string `json:"sku"`
+	Qty int    `json:"qty"`
+}
+
+// ArrayPost has Postgres array columns and an array stored in a jsonb column
+type ArrayPost struct {
+	ID     int         `json:"id" db:"id"`
+	Title  string      `json:"title" db:"title"`
+	Tags   []string    `json:"tags" db:"tags"`
+	Scores []int32     `json:"scores" db:"scores"`
+	Codes  []int       `json:"codes" db:"codes" query:"array=integer"`
+	Lines  []ArrayLine `json:"lines" db:"lines" query:"jsonb"`
+}
+
+func TestArrayOperators(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		filters  []Filter
+		wantSQL  string
+		wantArgs []any
+	}{
+		{
+			name:     "$all",
+			filters:  []Filter{{Field: "tags", Operator: OpAll, Value: []any{"go", "sql"}}},
+			wantSQL:  "SELECT * FROM posts WHERE (tags @> ARRAY[$1,$2]::text[])",
+			wantArgs: []any{"go", "sql"},
+		},
+		{
+			name:     "$contains",
+			filters:  []Filter{{Field: "scores", Operator: OpContains, Value: []any{1, 2}}},
+			wantSQL:  "SELECT * FROM posts WHERE (scores && ARRAY[$1,$2]::integer[])",
+			wantArgs: []any{1, 2},
+		},
+		{
+			name:     "array type from tag",
+			filters:  []Filter{{Field: "codes", Operator: OpAll, Value: []any{7}}},
+			wantSQL:  "SELECT * FROM posts WHERE (codes @> ARRAY[$1]::integer[])",
+			wantArgs: []any{7},
+		},
+		{
+			name:     "$size",
+			filters:  []Filter{{Field: "tags", Operator: OpSize, Value: float64(2)}},
+			wantSQL:  "SELECT * FROM posts WHERE (cardinality(tags) = $1)",
+			wantArgs: []any{2},
+		},
+		{
+			name: "$elemMatch on scalar elements",
+			filters: []Filter{{Field: "scores", Operator: OpElemMatch, Filters: []Filter{
+				{Operator: OpGte, Value: 80},
+				{Operator: OpLt, Value: 90},
+			}}},
+			wantSQL:  "SELECT * FROM posts WHERE (EXISTS (SELECT 1 FROM unnest(scores) AS elem WHERE (elem >= $1 AND elem < $2)))",
+			wantArgs: []any{80, 90},
+		},
+		{
+			name:     "$all on a jsonb array",
+			filters:  []Filter{{Field: "lines", Operator: OpAll, Value: []any{map[string]any{"sku": "a"}}}},
+			wantSQL:  "SELECT * FROM posts WHERE (lines @> $1::jsonb)",
+			wantArgs: []any{`[{"sku":"a"}]`},
+		},
+		{
+			name:     "$contains on a jsonb array",
+			filters:  []Filter{{Field: "lines", Operator: OpContains, Value: []any{map[string]any{"sku": "a"}, map[string]any{"sku": "b"}}}},
+			wantSQL:  "SELECT * FROM posts WHERE (lines @> ANY (ARRAY[$1::jsonb,$2::jsonb]))",
+			wantArgs: []any{`[{"sku":"a"}]`, `[{"sku":"b"}]`},
+		},
+		{
+			name:     "$size on a jsonb array",
+			filters:  []Filter{{Field: "lines", Operator: OpSize, Value: 0}},
+			wantSQL:  "SELECT * FROM posts WHERE (jsonb_array_length(lines) = $1)",
+			wantArgs: []any{0},
+		},
+		{
+			name: "$elemMatch on jsonb objects",
+			filters: []Filter{{Field: "lines", Operator: OpElemMatch, Filters: []Filter{
+				{Field: "sku", Operator: OpEq, Value: "a"},
+				{Field: "qty", Operator: OpGt, Value: 1},
+			}}},
+			wantSQL: "SELECT * FROM posts WHERE (EXISTS (SELECT 1 FROM jsonb_array_elements(lines) AS elem " +
+				"WHERE (elem->>'sku' = $1 AND (elem->>'qty')::numeric > $2)))",
+			wantArgs: []any{"a", 1},
+		},
+		{
+			name: "array operators inside $or",
+			filters: []Filter{{Operator: OpOr, Filters: []Filter{
+				{Field: "tags", Operator: OpContains, Value: []any{"go"}},
+				{Field: "title", Operator: OpEq, Value: "Go"},
+			}}},
+			wantSQL:  "SELECT * FROM posts WHERE ((tags && ARRAY[$1]::text[] OR title = $2))",
+			wantArgs: []any{"go", "Go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qb, err := NewSqlBuilder(ctx).WithSelect("posts").Apply(tt.filters, nil, &ArrayPost{})
+			assert.NoError(t, err)
+
+			sql, args, err := qb.ToSql()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantSQL, sql)
+			assert.Equal(t, tt.wantArgs, args)
+		})
+	}
+}
+
+func TestElemMatchRelation(t *testing.T) {
+	filters := []Filter{{Field: "orders", Operator: OpElemMatch, Filters: []Filter{
+		{Field: "status", Operator: OpEq, Value: "open"},
+		{Field: "total", Operator: OpGt, Value: 100},
+	}}}
+
+	qb, err := NewSqlBuilder(context.Background()).WithSelect("users").Apply(filters, nil, &RelUser{})
+	assert.NoError(t, err)
+
+	sql, args, err := qb.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM users WHERE (EXISTS (SELECT 1 FROM orders WHERE orders.user_id = users.id AND (orders.status = $1 AND orders.total > $2)))", sql)
+	assert.Equal(t, []any{"open", 100}, args)
+}
+
+func TestArrayOperatorErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters []Filter
+		wantErr string
+	}{
+		{
+			name:    "not an array field",
+			filters: []Filter{{Field: "title", Operator: OpAll, Value: []any{"a"}}},
+			wantErr: `operator $all requires an array field, "title" is not one`,
+		},
+		{
+			name:    "empty $all",
+			filters: []Filter{{Field: "tags", Operator: OpAll, Value: []any{}}},
+			wantErr: `$all operator requires a non-empty array for field "tags"`,
+		},
+		{
+			name:    "negative $size",
+			filters: []Filter{{Field: "tags", Operator: OpSize, Value: -1}},
+			wantErr: `$size operator requires a non-negative integer for field "tags"`,
+		},
+		{
+			name:    "fractional $size",
+			filters: []Filter{{Field: "tags", Operator: OpSize, Value: 1.5}},
+			wantErr: `$size operator requires a non-negative integer for field "tags"`,
+		},
+		{
+			name: "unknown element field",
+			filters: []Filter{{Field: "lines", Operator: OpElemMatch, Filters: []Filter{
+				{Field: "color", Operator: OpEq, Value: "red"},
+			}}},
+			wantErr: `field "color" is not a valid JSON field`,
+		},
+		{
+			name: "field condition on scalar elements",
+			filters: []Filter{{Field: "tags", Operator: OpElemMatch, Filters: []Filter{
+				{Field: "name", Operator: OpEq, Value: "go"},
+			}}},
+			wantErr: `elements of field "tags" are not objects, use operators in $elemMatch`,
+		},
+		{
+			name:    "empty $elemMatch",
+			filters: []Filter{{Field: "tags", Operator: OpElemMatch}},
+			wantErr: `$elemMatch operator requires nested filters for field "tags"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSqlBuilder(context.Background()).WithSelect("posts").Apply(tt.filters, nil, &ArrayPost{})
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestParseElemMatch(t *testing.T) {
+	filters, err := ParseFilter(`{"scores": {"$elemMatch": {"$gte": 80}}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, []Filter{{Field: "scores", Operator: OpElemMatch, Filters: []Filter{
+		{Field: "", Operator: OpGte, Value: float64(80)},
+	}}}, filters)
+
+	filters, err = ParseFilter(`{"lines": {"$elemMatch": {"sku": "a"}}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, []Filter{{Field: "lines", Operator: OpElemMatch, Filters: []Filter{
+		{Field: "sku", Operator: OpEq, Value: "a"},
+	}}}, filters)
+
+	_, err = ParseFilter(`{"lines": {"$elemMatch": "a"}}`)
+	assert.EqualError(t, err, `$elemMatch operator requires an object for field "lines"`)
+}
+
+func TestElasticArrayOperators(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters []Filter
+		model   any
+		want    string
+	}{
+		{
+			name:    "$all",
+			filters: []Filter{{Field: "tags", Operator: OpAll, Value: []any{"go", "sql"}}},
+			model:   &ArrayPost{},
+			want:    `{"bool":{"must":{"terms_set":{"tags":{"minimum_should_match_script":{"source":"params.num_terms"},"terms":["go","sql"]}}}}}`,
+		},
+		{
+			name:    "$contains",
+			filters: []Filter{{Field: "tags", Operator: OpContains, Value: []any{"go"}}},
+			model:   &ArrayPost{},
+			want:    `{"bool":{"must":{"terms":{"tags":["go"]}}}}`,
+		},
+		{
+			name:    "$size",
+			filters: []Filter{{Field: "tags", Operator: OpSize, Value: 2}},
+			model:   &ArrayPost{},
+			want:    `{"bool":{"must":{"script":{"script":{"params":{"field":"tags","size":2},"source":"doc[params.field].size() == params.size"}}}}}`,
+		},
+		{
+			name: "$elemMatch on scalar elements",
+			filters: []Filter{{Field: "scores", Operator: OpElemMatch, Filters: []Filter{
+				{Operator: OpGte, Value: 80},
+				{Operator: OpLt, Value: 90},
+			}}},
+			model: &ArrayPost{},
+			want:  `{"bool":{"must":{"bool":{"must":{"range":{"scores":{"gte":80,"lt":90}}}}}}}`,
+		},
+		{
+			name: "$elemMatch on nested elements",
+			filters: []Filter{{Field: "lines", Operator: OpElemMatch, Filters: []Filter{
+				{Field: "sku", Operator: OpEq, Value: "a"},
+				{Field: "qty", Operator: OpGt, Value: 1},
+			}}},
+			model: &Customer{},
+			want:  `{"bool":{"must":{"nested":{"path":"lines","query":{"bool":{"must":[{"term":{"lines.sku":"a"}},{"range":{"lines.qty":{"gt":1}}}]}}}}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewElasticBuilder(nil).Apply(tt.filters, nil, tt.model)
+			assert.NoError(t, err)
+
+			source, err := got.Source()
+			assert.NoError(t, err)
+			sourceStr, err := json.Marshal(source)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(sourceStr))
+		})
+	}
+}
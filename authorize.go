@@ -0,0 +1,265 @@
+package queryparser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Authorizer decides whether the caller identified by ctx may use a field
+// with an operator. It returns nil to allow it and an error to forbid it.
+// Fields are full paths, e.g. "parts.sku" for a field of the elements of an
+// $elemMatch. Uses of a field in QueryOptions are passed as OpSort,
+// OpSelect, OpGroupBy and OpAggregate.
+//
+// Every builder consults its Authorizer and checks the roles of fields
+// against the roles of its context, see SetContext. Without a model,
+// ElasticBuilder, MongoBuilder and DSLBuilder cannot look fields up, so
+// they then refuse to consult an Authorizer.
+//
+// Example:
+//
+//	qb.SetAuthorizer(func(ctx context.Context, field string, op Operator) error {
+//		if field == "email" && op == OpLike && !isSupport(ctx) {
+//			return errors.New("only support can search emails")
+//		}
+//		return nil
+//	})
+type Authorizer func(ctx context.Context, field string, op Operator) error
+
+// Operations passed to an Authorizer for the uses of a field in QueryOptions.
+// They are not filter operators.
+const (
+	OpSort      Operator = "$sort"      // Sort
+	OpSelect    Operator = "$select"    // Fields and Highlight
+	OpGroupBy   Operator = "$groupBy"   // GroupBy
+	OpAggregate Operator = "$aggregate" // Aggregates and Facets
+)
+
+var (
+	// ErrUnknownField is wrapped by the errors returned for fields that the
+	// model does not have or that do not support an operator or option
+	ErrUnknownField = errors.New("unknown field")
+	// ErrForbiddenField is wrapped by the errors returned for fields that
+	// the caller is not allowed to use, by their roles or an Authorizer
+	ErrForbiddenField = errors.New("forbidden field")
+)
+
+// fieldError is an error about a field that wraps ErrUnknownField or
+// ErrForbiddenField, and the error of an Authorizer if any, without repeating
+// them in its message
+type fieldError struct {
+	message string
+	errs    []error
+}
+
+func (e *fieldError) Error() string {
+	return e.message
+}
+
+func (e *fieldError) Unwrap() []error {
+	return e.errs
+}
+
+// unknownField returns an error wrapping ErrUnknownField
+func unknownField(format string, args ...any) error {
+	return &fieldError{message: fmt.Sprintf(format, args...), errs: []error{ErrUnknownField}}
+}
+
+// rolesKey is the context key of the roles set by WithRoles
+type rolesKey struct{}
+
+// WithRoles returns a copy of ctx carrying the roles of the caller, which
+// the builders and Authorize check against the roles of fields tagged
+// query:"roles=admin|hr"
+func WithRoles(ctx context.Context, roles ...string) context.Context {
+	return context.WithValue(ctx, rolesKey{}, roles)
+}
+
+// RolesFromContext returns the roles set by WithRoles
+func RolesFromContext(ctx context.Context) []string {
+	if ctx == nil {
+		return nil
+	}
+	roles, _ := ctx.Value(rolesKey{}).([]string)
+	return roles
+}
+
+// SetAuthorizer sets the Authorizer that Apply consults, with the context
+// of the SqlBuilder, for every use of a field. Fields tagged with roles are
+// checked against the roles of the context whether or not one is set.
+//
+// Example:
+//
+//	qb := NewSqlBuilder(WithRoles(r.Context(), "support"))
+//	qb.SetAuthorizer(authorizer)
+func (qb *SqlBuilder) SetAuthorizer(auth Authorizer) {
+	qb.authorizer = auth
+}
+
+// Authorize checks that the caller identified by ctx may use every field of
+// the filters and options: fields tagged with roles require one of them in
+// the roles of ctx, and auth, if not nil, is consulted for every use of a
+// field. The builders do this themselves with their context; Authorize
+// checks a request before it reaches one, e.g. in a handler.
+//
+// Fields are validated first, so that an unknown field is reported as such
+// rather than as forbidden. Errors wrap ErrUnknownField or ErrForbiddenField.
+func Authorize(ctx context.Context, filters []Filter, options *QueryOptions, model any, auth Authorizer) error {
+	schema, err := schemaOf(model)
+	if err != nil {
+		return fmt.Errorf("failed to get model schema: %w", err)
+	}
+	if err := validateFields(filters, options, schema); err != nil {
+		return err
+	}
+	return authorizeFields(ctx, filters, options, schema, auth)
+}
+
+// authorizeModel checks the fields of a builder without a context of its
+// own, as authorizeFields. Fields are only known through a model, so without
+// one roles cannot apply and an Authorizer is refused rather than skipped.
+func authorizeModel(ctx context.Context, filters []Filter, options *QueryOptions, schema *modelSchema, auth Authorizer) error {
+	if schema == nil {
+		if auth != nil {
+			return fmt.Errorf("an authorizer requires a model")
+		}
+		return nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return authorizeFields(ctx, filters, options, schema, auth)
+}
+
+// authorizeFields checks every use of a field in validated filters and
+// options
+func authorizeFields(ctx context.Context, filters []Filter, options *QueryOptions, schema *modelSchema, auth Authorizer) error {
+	a := authorization{ctx: ctx, schema: schema, auth: auth, roles: RolesFromContext(ctx)}
+	if err := a.filters(filters, ""); err != nil {
+		return err
+	}
+	if options == nil {
+		return nil
+	}
+	if err := a.filters(options.PostFilter, ""); err != nil {
+		return err
+	}
+
+	sortFields := make([]string, 0, len(options.Sort)+len(options.SortBy))
+	for _, field := range options.sortFields() {
+		sortFields = append(sortFields, field.Field)
+	}
+
+	uses := []struct {
+		op     Operator
+		fields []string
+	}{
+		{OpSort, sortFields},
+		{OpSelect, options.Fields},
+		{OpSelect, options.Highlight},
+		{OpGroupBy, options.GroupBy},
+	}
+	for _, use := range uses {
+		for _, field := range use.fields {
+			if use.op == OpSort && options.isAggregateAlias(field) {
+				continue
+			}
+			if err := a.field(field, use.op); err != nil {
+				return err
+			}
+		}
+	}
+	for _, agg := range options.Aggregates {
+		if agg.Field != "" {
+			if err := a.field(agg.Field, OpAggregate); err != nil {
+				return err
+			}
+		}
+	}
+	for _, facet := range options.Facets {
+		if err := a.field(facet.Field, OpAggregate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authorization holds the caller of one authorizeFields call
+type authorization struct {
+	ctx    context.Context
+	schema *modelSchema
+	auth   Authorizer
+	roles  []string
+}
+
+// filters checks the fields of a filter tree. Fields of $elemMatch
+// conditions are relative to the array at prefix.
+func (a authorization) filters(filters []Filter, prefix string) error {
+	return Walk(filters, func(c *Cursor) error {
+		filter := c.Filter()
+		field := filter.Field
+		if prefix != "" {
+			field = strings.TrimSuffix(prefix+"."+field, ".")
+		}
+		switch filter.Operator {
+		case OpOr, OpAnd:
+			return nil
+		case OpText:
+			// $text searches every searchable field
+			for _, f := range a.schema.searchable() {
+				if err := a.field(f.JSON, OpText); err != nil {
+					return err
+				}
+			}
+			return nil
+		case OpElemMatch:
+			if err := a.field(field, OpElemMatch); err != nil {
+				return err
+			}
+			if err := a.filters(filter.Filters, field); err != nil {
+				return err
+			}
+			return SkipChildren
+		}
+		return a.field(field, filter.Operator)
+	}, nil)
+}
+
+// field checks a single use of a field: the roles of the field and of the
+// fields enclosing it, then the Authorizer
+func (a authorization) field(field string, op Operator) error {
+	if chain, ok := a.schema.lookup(field); ok {
+		for _, f := range chain {
+			if len(f.Roles) > 0 && !hasAnyRole(a.roles, f.Roles) {
+				return &fieldError{
+					message: fmt.Sprintf("field %q is forbidden, it requires role %s", field, strings.Join(f.Roles, " or ")),
+					errs:    []error{ErrForbiddenField},
+				}
+			}
+		}
+	}
+	if a.auth == nil {
+		return nil
+	}
+	if err := a.auth(a.ctx, field, op); err != nil {
+		return &fieldError{
+			message: fmt.Sprintf("field %q is forbidden with %s: %v", field, op, err),
+			errs:    []error{ErrForbiddenField, err},
+		}
+	}
+	return nil
+}
+
+// hasAnyRole reports whether roles contains one of the allowed roles
+func hasAnyRole(roles, allowed []string) bool {
+	for _, role := range roles {
+		for _, a := range allowed {
+			if role == a {
+				return true
+			}
+		}
+	}
+	return false
+}
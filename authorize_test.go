@@ -0,0 +1,257 @@
+package queryparser
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type StaffReview struct {
+	Score int    `json:"score"`
+	Notes string `json:"notes" query:"roles=admin"`
+}
+
+type StaffContact struct {
+	City  string `json:"city"`
+	Phone string `json:"phone" query:"roles=admin"`
+}
+
+type Staff struct {
+	ID      int           `json:"id" db:"id"`
+	Name    string        `json:"name" db:"name" query:"searchable"`
+	Email   string        `json:"email" db:"email" query:"roles=admin|support"`
+	Salary  float64       `json:"salary" db:"salary" query:"roles=admin"`
+	Reviews []StaffReview `json:"reviews" db:"reviews" query:"jsonb"`
+	Contact StaffContact  `json:"contact" db:"contact" query:"jsonb"`
+}
+
+func TestAuthorizeRoles(t *testing.T) {
+	tests := []struct {
+		name    string
+		roles   []string
+		filter  string
+		options *QueryOptions
+		wantErr string
+	}{
+		{name: "unrestricted field", filter: `{"name": "Ada"}`},
+		{name: "restricted field", filter: `{"salary": {"$gt": 1000}}`, wantErr: `field "salary" is forbidden, it requires role admin`},
+		{name: "restricted field with role", roles: []string{"admin"}, filter: `{"salary": {"$gt": 1000}}`},
+		{name: "one of the roles", roles: []string{"support"}, filter: `{"email": {"$like": "%@example.com"}}`},
+		{name: "other role", roles: []string{"support"}, filter: `{"salary": {"$gt": 1000}}`, wantErr: `field "salary" is forbidden, it requires role admin`},
+		{
+			name:    "inside a group",
+			roles:   []string{"support"},
+			filter:  `{"$or": [{"name": "Ada"}, {"$and": [{"id": 1}, {"salary": 1}]}]}`,
+			wantErr: `field "salary" is forbidden, it requires role admin`,
+		},
+		{name: "nested field", filter: `{"contact.phone": {"$like": "555%"}}`, wantErr: `field "contact.phone" is forbidden, it requires role admin`},
+		{name: "allowed nested field", filter: `{"contact.city": "Oslo"}`},
+		{name: "elemMatch", filter: `{"reviews": {"$elemMatch": {"score": 5, "notes": "great"}}}`, wantErr: `field "reviews.notes" is forbidden, it requires role admin`},
+		{name: "elemMatch of allowed fields", filter: `{"reviews": {"$elemMatch": {"score": {"$gte": 4}}}}`},
+		{name: "sort", options: &QueryOptions{Sort: map[string]SortDirection{"name": SortAsc, "email": SortDesc}}, wantErr: `field "email" is forbidden, it requires role admin or support`},
+		{name: "selection", options: &QueryOptions{Fields: []string{"id", "salary"}}, wantErr: `field "salary" is forbidden, it requires role admin`},
+		{name: "grouping", options: &QueryOptions{GroupBy: []string{"email"}}, wantErr: `field "email" is forbidden, it requires role admin or support`},
+		{
+			name:    "aggregate",
+			options: &QueryOptions{GroupBy: []string{"name"}, Aggregates: []Aggregate{{Func: AggAvg, Field: "salary"}}},
+			wantErr: `field "salary" is forbidden, it requires role admin`,
+		},
+		{name: "unknown field", filter: `{"bonus": 1}`, wantErr: `field "bonus" is not a valid JSON field`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var filters []Filter
+			if tt.filter != "" {
+				var err error
+				filters, err = ParseFilter(tt.filter)
+				if !assert.NoError(t, err) {
+					return
+				}
+			}
+			ctx := WithRoles(context.Background(), tt.roles...)
+			_, err := NewSqlBuilder(ctx).WithSelect("staff").Apply(filters, tt.options, &Staff{})
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+			assert.Equal(t, tt.name != "unknown field", errors.Is(err, ErrForbiddenField))
+			assert.Equal(t, tt.name == "unknown field", errors.Is(err, ErrUnknownField))
+		})
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	type use struct {
+		field string
+		op    Operator
+	}
+	errNotOwner := errors.New("only the owner can filter by email")
+	var uses []use
+	auth := func(ctx context.Context, field string, op Operator) error {
+		assert.Equal(t, []string{"admin"}, RolesFromContext(ctx))
+		uses = append(uses, use{field, op})
+		if field == "email" && op == OpLike {
+			return errNotOwner
+		}
+		return nil
+	}
+
+	filters := []Filter{
+		{Operator: OpText, Value: "ada"},
+		{Operator: OpOr, Filters: []Filter{
+			{Field: "id", Operator: OpIn, Value: []any{1, 2}},
+			{Field: "reviews", Operator: OpElemMatch, Filters: []Filter{{Field: "score", Operator: OpGte, Value: 4}}},
+		}},
+	}
+	options := &QueryOptions{Sort: map[string]SortDirection{"salary": SortDesc, "id": SortAsc}, Fields: []string{"name"}}
+	qb := NewSqlBuilder(WithRoles(context.Background(), "admin"))
+	qb.SetAuthorizer(auth)
+	_, err := qb.WithSelect("staff").Apply(filters, options, &Staff{})
+	assert.NoError(t, err)
+	assert.Equal(t, []use{
+		{"name", OpText},
+		{"id", OpIn},
+		{"reviews", OpElemMatch},
+		{"reviews.score", OpGte},
+		{"id", OpSort},
+		{"salary", OpSort},
+		{"name", OpSelect},
+	}, uses)
+
+	_, err = qb.WithSelect("staff").Apply([]Filter{{Field: "email", Operator: OpLike, Value: "%ada%"}}, nil, &Staff{})
+	assert.EqualError(t, err, `field "email" is forbidden with $like: only the owner can filter by email`)
+	assert.ErrorIs(t, err, ErrForbiddenField)
+	assert.ErrorIs(t, err, errNotOwner)
+
+	// Roles are checked before the authorizer
+	uses = nil
+	qb = NewSqlBuilder(context.Background())
+	qb.SetAuthorizer(auth)
+	_, err = qb.WithSelect("staff").Apply([]Filter{{Field: "salary", Operator: OpEq, Value: 1}}, nil, &Staff{})
+	assert.ErrorIs(t, err, ErrForbiddenField)
+	assert.Empty(t, uses)
+}
+
+func TestAuthorize(t *testing.T) {
+	filters := []Filter{{Field: "salary", Operator: OpGt, Value: 1000}}
+	err := Authorize(context.Background(), filters, nil, &Staff{}, nil)
+	assert.EqualError(t, err, `field "salary" is forbidden, it requires role admin`)
+	assert.NoError(t, Authorize(WithRoles(context.Background(), "admin"), filters, nil, &Staff{}, nil))
+
+	err = Authorize(context.Background(), []Filter{{Field: "salary.amount", Operator: OpGt, Value: 1}}, nil, &Staff{}, nil)
+	assert.ErrorIs(t, err, ErrUnknownField)
+	assert.NotErrorIs(t, err, ErrForbiddenField)
+
+	schema := Schema{Name: "Staff", Fields: []SchemaField{
+		{Name: "id", Type: TypeInteger},
+		{Name: "salary", Type: TypeNumber, Roles: []string{"admin", "payroll"}},
+	}}
+	err = Authorize(WithRoles(context.Background(), "support"), filters, nil, schema, nil)
+	assert.EqualError(t, err, `field "salary" is forbidden, it requires role admin or payroll`)
+	assert.NoError(t, Authorize(WithRoles(context.Background(), "payroll"), filters, nil, schema, nil))
+
+	described, err := SchemaOf(&Staff{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin", "support"}, described.Fields[2].Roles)
+}
+
+func TestBuilderRoles(t *testing.T) {
+	errForbidden := errors.New("no searching by name")
+	auth := func(ctx context.Context, field string, op Operator) error {
+		if field == "name" {
+			return errForbidden
+		}
+		return nil
+	}
+
+	// Each backend runs the filters and options with the roles and
+	// authorizer of its context
+	backends := []struct {
+		name string
+		run  func(ctx context.Context, auth Authorizer, filters []Filter, options *QueryOptions) error
+	}{
+		{"elastic", func(ctx context.Context, auth Authorizer, filters []Filter, options *QueryOptions) error {
+			eb := NewElasticBuilder(nil)
+			eb.SetContext(ctx)
+			eb.SetAuthorizer(auth)
+			_, err := eb.SearchSource(filters, options, &Staff{})
+			return err
+		}},
+		{"dsl", func(ctx context.Context, auth Authorizer, filters []Filter, options *QueryOptions) error {
+			b := NewDSLBuilder()
+			b.SetContext(ctx)
+			b.SetAuthorizer(auth)
+			_, err := b.Source(filters, options, &Staff{})
+			return err
+		}},
+		{"mongo", func(ctx context.Context, auth Authorizer, filters []Filter, options *QueryOptions) error {
+			b := NewMongoBuilder()
+			b.SetContext(ctx)
+			b.SetAuthorizer(auth)
+			_, err := b.Find(filters, options, &Staff{})
+			return err
+		}},
+		{"slice", func(ctx context.Context, auth Authorizer, filters []Filter, options *QueryOptions) error {
+			b := NewSliceBuilder[Staff]()
+			b.SetContext(ctx)
+			b.SetAuthorizer(auth)
+			_, err := b.Apply([]Staff{{ID: 1, Salary: 2000}}, filters, options)
+			return err
+		}},
+	}
+
+	salary := []Filter{{Field: "salary", Operator: OpGt, Value: 1000}}
+	tests := []struct {
+		name    string
+		roles   []string
+		auth    Authorizer
+		filters []Filter
+		options *QueryOptions
+		wantErr string
+	}{
+		{name: "unrestricted field", filters: []Filter{{Field: "id", Operator: OpEq, Value: 1}}},
+		{name: "restricted field", filters: salary, wantErr: `field "salary" is forbidden, it requires role admin`},
+		{name: "restricted field with role", roles: []string{"admin"}, filters: salary},
+		{name: "other role", roles: []string{"support"}, filters: salary, wantErr: `field "salary" is forbidden, it requires role admin`},
+		{name: "sort", options: &QueryOptions{Sort: map[string]SortDirection{"email": SortAsc}}, wantErr: `field "email" is forbidden, it requires role admin or support`},
+		{name: "selection", options: &QueryOptions{Fields: []string{"salary"}}, wantErr: `field "salary" is forbidden, it requires role admin`},
+		{
+			name:    "authorizer",
+			auth:    auth,
+			filters: []Filter{{Field: "name", Operator: OpEq, Value: "Ada"}},
+			wantErr: `field "name" is forbidden with $eq: no searching by name`,
+		},
+	}
+
+	for _, backend := range backends {
+		for _, tt := range tests {
+			t.Run(backend.name+"/"+tt.name, func(t *testing.T) {
+				err := backend.run(WithRoles(context.Background(), tt.roles...), tt.auth, tt.filters, tt.options)
+				if tt.wantErr == "" {
+					assert.NoError(t, err)
+					return
+				}
+				assert.EqualError(t, err, tt.wantErr)
+				assert.ErrorIs(t, err, ErrForbiddenField)
+			})
+		}
+	}
+
+	// Without a model fields cannot be checked, so an authorizer is refused
+	b := NewDSLBuilder()
+	b.SetAuthorizer(auth)
+	_, err := b.Query(salary, nil, nil)
+	assert.EqualError(t, err, "an authorizer requires a model")
+	mb := NewMongoBuilder()
+	mb.SetAuthorizer(auth)
+	_, err = mb.Query(salary, nil, nil)
+	assert.EqualError(t, err, "an authorizer requires a model")
+
+	// Restricted fields are forbidden to a builder without a context
+	_, err = NewDSLBuilder().Query(salary, nil, &Staff{})
+	assert.ErrorIs(t, err, ErrForbiddenField)
+}
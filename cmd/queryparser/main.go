@@ -0,0 +1,407 @@
+// Command queryparser translates a filter and query options into the query a
+// backend runs, to debug filters and to check them in CI against golden files.
+//
+// Usage:
+//
+//	queryparser [flags]
+//
+// The filter is given with -filter, as JSON or, with -syntax rsql, as RSQL,
+// and the options as JSON with -options. Alternatively -query reads a URL
+// query string (filter=...&sort=-name&limit=20) in the -syntax of the
+// filter, like ParseQueryParams. "-filter -" reads the filter from stdin.
+//
+// -model names a JSON or YAML file describing the model, see modelFile.
+// Filters are validated against it. It is required by the SQL dialects,
+// which read the columns from it; Elasticsearch and MongoDB queries can be
+// built without one, and then accept any field.
+//
+// The output has the parsed filter and, separately, its normalized form, see
+// NormalizeModel, or Normalize without a model. Then comes the SQL and its arguments, the Elasticsearch request
+// body or the MongoDB find arguments, depending on the -dialect. They are
+// built from the parsed filter, not the normalized one, so they show what
+// the builders produce for it. With -json, the output is a single JSON
+// object. Invalid filters are reported with the path of each invalid
+// condition, e.g. "$or[1].price.$gt: ...", and exit with status 1.
+//
+// Examples:
+//
+//	queryparser -model item.yaml -filter '{"qty": {"$gt": 2}}' -options '{"sort": {"name": "asc"}}'
+//	queryparser -model item.yaml -dialect elastic -syntax rsql -filter 'qty=gt=2;name==Desk'
+//	queryparser -dialect mongo -syntax bracket -query 'filter[qty][$gt]=2&limit=20'
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/ready4god2513/queryparser"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// config holds the command-line flags
+type config struct {
+	filter  string
+	options string
+	query   string
+	syntax  string
+	dialect string
+	model   string
+	table   string
+	now     string
+	json    bool
+}
+
+// result is the output of a translation. Its JSON form is printed by -json.
+type result struct {
+	Filter     json.RawMessage `json:"filter,omitempty"`
+	Normalized json.RawMessage `json:"normalized,omitempty"`
+	NeverMatch bool            `json:"neverMatches,omitempty"`
+	SQL        string          `json:"sql,omitempty"`
+	Args       []any           `json:"args,omitempty"`
+	Query      map[string]any  `json:"query,omitempty"`
+	Errors     []pathError     `json:"errors,omitempty"`
+}
+
+// pathError is an error located at a condition of the filter, or at the
+// options. Path is empty when the error has no single location.
+type pathError struct {
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+// Error formats the error as path: message
+func (e pathError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// run runs the command and returns its exit status
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var cfg config
+	flags := flag.NewFlagSet("queryparser", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&cfg.filter, "filter", "", `filter in JSON, or in RSQL with -syntax rsql; "-" reads stdin`)
+	flags.StringVar(&cfg.options, "options", "", "query options in JSON")
+	flags.StringVar(&cfg.query, "query", "", "URL query string with the filter, sort, limit, offset and fields parameters")
+	flags.StringVar(&cfg.syntax, "syntax", "json", "filter syntax: json, bracket or rsql")
+	flags.StringVar(&cfg.dialect, "dialect", "postgres", "backend: postgres, mysql, sqlite, sqlserver, elastic or mongo")
+	flags.StringVar(&cfg.model, "model", "", "JSON or YAML file describing the model")
+	flags.StringVar(&cfg.table, "table", "", `table to select from (default the table of the model, or "items")`)
+	flags.StringVar(&cfg.now, "now", "", "resolve relative times against this RFC 3339 time instead of the current time")
+	flags.BoolVar(&cfg.json, "json", false, "print the output as a single JSON object")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: queryparser [flags]")
+		fmt.Fprintln(stderr, "Translates a filter into SQL, Elasticsearch or MongoDB queries.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(stderr, "queryparser: unexpected argument %q\n", flags.Arg(0))
+		return 2
+	}
+
+	out, err := translate(cfg, stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, "queryparser:", err)
+		return 2
+	}
+	if cfg.json {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(out); err != nil {
+			fmt.Fprintln(stderr, "queryparser:", err)
+			return 2
+		}
+	} else {
+		printText(stdout, out)
+	}
+	if len(out.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// translate parses the input and compiles it for the dialect. Errors in the
+// filter or options are returned in the result; errors in the flags and
+// model are returned as errors.
+func translate(cfg config, stdin io.Reader) (*result, error) {
+	var model any
+	table := "items"
+	if cfg.model != "" {
+		var modelTable string
+		var err error
+		if model, modelTable, err = loadModel(cfg.model); err != nil {
+			return nil, err
+		}
+		if modelTable != "" {
+			table = modelTable
+		}
+	}
+	if cfg.table != "" {
+		table = cfg.table
+	}
+
+	clock := time.Now
+	if cfg.now != "" {
+		now, err := time.Parse(time.RFC3339, cfg.now)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -now %q, expected an RFC 3339 time", cfg.now)
+		}
+		clock = func() time.Time { return now }
+	}
+
+	compile, err := compiler(cfg.dialect, table, clock)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := sqlDialects[cfg.dialect]; ok && model == nil {
+		return nil, fmt.Errorf("-model is required with -dialect %s", cfg.dialect)
+	}
+
+	filters, options, err := parseInput(cfg, stdin, model)
+	if err != nil {
+		var usage usageError
+		if errors.As(err, &usage) {
+			return nil, err
+		}
+		return &result{Errors: []pathError{{Message: err.Error()}}}, nil
+	}
+
+	out := &result{}
+	if out.Filter, err = queryparser.MarshalFilter(filters); err != nil {
+		return &result{Errors: []pathError{{Message: err.Error()}}}, nil
+	}
+	// Conditions are merged only on the fields that the model shows to hold
+	// a single value
+	normalized := queryparser.Normalize(filters)
+	if model != nil {
+		if normalized, err = queryparser.NormalizeModel(filters, model); err != nil {
+			return nil, err
+		}
+	}
+	if out.Normalized, err = queryparser.MarshalFilter(normalized); err != nil {
+		return &result{Errors: []pathError{{Message: err.Error()}}}, nil
+	}
+	out.NeverMatch = queryparser.IsAlwaysFalse(normalized)
+
+	// The normalized filter is only shown: the query is built from the
+	// filter as parsed, which keeps the order of its conditions
+	if err := compile(filters, options, model, out); err != nil {
+		out.Errors = locate(err, filters, options, model, compile)
+	}
+	return out, nil
+}
+
+// usageError is an error in the combination of flags
+type usageError struct{ error }
+
+// parseInput reads the filter and options given by the flags
+func parseInput(cfg config, stdin io.Reader, model any) ([]queryparser.Filter, *queryparser.QueryOptions, error) {
+	syntax := queryparser.Syntax(cfg.syntax)
+	switch syntax {
+	case queryparser.SyntaxJSON, queryparser.SyntaxBracket, queryparser.SyntaxRSQL:
+	default:
+		return nil, nil, usageError{fmt.Errorf("unknown syntax %q, expected json, bracket or rsql", cfg.syntax)}
+	}
+
+	if cfg.query != "" {
+		if cfg.filter != "" || cfg.options != "" {
+			return nil, nil, usageError{errors.New("-query cannot be combined with -filter or -options")}
+		}
+		values, err := url.ParseQuery(strings.TrimPrefix(cfg.query, "?"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid query string: %w", err)
+		}
+		return queryparser.ParseQueryParams(values, syntax, model)
+	}
+	if syntax == queryparser.SyntaxBracket {
+		return nil, nil, usageError{errors.New("bracket filters are read from -query")}
+	}
+
+	filter := cfg.filter
+	if filter == "-" {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, nil, usageError{fmt.Errorf("failed to read stdin: %w", err)}
+		}
+		filter = string(data)
+	}
+
+	var filters []queryparser.Filter
+	var err error
+	switch {
+	case strings.TrimSpace(filter) == "":
+	case syntax == queryparser.SyntaxRSQL:
+		filters, err = queryparser.ParseRSQL(filter, model)
+	default:
+		filters, err = queryparser.ParseFilter(filter)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	options := &queryparser.QueryOptions{}
+	if cfg.options != "" {
+		if options, err = queryparser.ParseQueryOptions(cfg.options); err != nil {
+			return nil, nil, err
+		}
+	}
+	return filters, options, nil
+}
+
+// sqlDialects are the SQL dialects and their placeholders
+var sqlDialects = map[string]struct {
+	dialect     queryparser.Dialect
+	placeholder squirrel.PlaceholderFormat
+}{
+	"postgres":  {queryparser.DialectPostgres, squirrel.Dollar},
+	"mysql":     {queryparser.DialectMySQL, squirrel.Question},
+	"sqlite":    {queryparser.DialectSQLite, squirrel.Question},
+	"sqlserver": {queryparser.DialectSQLServer, squirrel.AtP},
+}
+
+// compileFunc compiles filters and options into a result
+type compileFunc func(filters []queryparser.Filter, options *queryparser.QueryOptions, model any, out *result) error
+
+// compiler returns the compileFunc of a dialect
+func compiler(dialect, table string, clock func() time.Time) (compileFunc, error) {
+	if d, ok := sqlDialects[dialect]; ok {
+		return func(filters []queryparser.Filter, options *queryparser.QueryOptions, model any, out *result) error {
+			qb := queryparser.NewSqlBuilderWithPlaceholderFormat(context.Background(), d.placeholder).WithSelect(table)
+			qb.SetDialect(d.dialect)
+			qb.SetClock(clock)
+			qb, err := qb.Apply(filters, options, model)
+			if err != nil {
+				return err
+			}
+			out.SQL, out.Args, err = qb.ToSql()
+			return err
+		}, nil
+	}
+
+	switch dialect {
+	case "elastic":
+		return func(filters []queryparser.Filter, options *queryparser.QueryOptions, model any, out *result) error {
+			b := queryparser.NewDSLBuilder()
+			b.SetClock(clock)
+			var err error
+			out.Query, err = b.Source(filters, options, model)
+			return err
+		}, nil
+	case "mongo":
+		return func(filters []queryparser.Filter, options *queryparser.QueryOptions, model any, out *result) error {
+			b := queryparser.NewMongoBuilder()
+			b.SetClock(clock)
+			var err error
+			out.Query, err = b.Find(filters, options, model)
+			return err
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown dialect %q, expected postgres, mysql, sqlite, sqlserver, elastic or mongo", dialect)
+	}
+}
+
+// locate finds the conditions responsible for a compile error by compiling
+// each condition on its own, then the options alone. When neither fails,
+// the error is returned without a path.
+func locate(err error, filters []queryparser.Filter, options *queryparser.QueryOptions, model any, compile compileFunc) []pathError {
+	var located []pathError
+	var visit func(filters []queryparser.Filter, prefix string, indexed bool)
+	visit = func(filters []queryparser.Filter, prefix string, indexed bool) {
+		for i, f := range filters {
+			path := prefix
+			if indexed {
+				path += fmt.Sprintf("[%d]", i)
+			}
+			group := f.Operator == queryparser.OpOr || f.Operator == queryparser.OpAnd
+			if group && len(f.Filters) > 0 {
+				visit(f.Filters, join(path, string(f.Operator)), true)
+				continue
+			}
+			if compileErr := compile([]queryparser.Filter{f}, nil, model, &result{}); compileErr != nil {
+				located = append(located, pathError{Path: join(path, conditionPath(f)), Message: compileErr.Error()})
+			}
+		}
+	}
+	visit(filters, "", false)
+
+	if optionsErr := compile(nil, options, model, &result{}); optionsErr != nil {
+		located = append(located, pathError{Path: "options", Message: optionsErr.Error()})
+	}
+	if len(located) == 0 {
+		return []pathError{{Message: err.Error()}}
+	}
+	sort.SliceStable(located, func(i, j int) bool { return located[i].Path < located[j].Path })
+	return located
+}
+
+// conditionPath names a condition by its field and operator, as in JSON
+func conditionPath(f queryparser.Filter) string {
+	if f.Field == "" {
+		return string(f.Operator)
+	}
+	return join(f.Field, string(f.Operator))
+}
+
+// join joins path segments with dots, skipping empty ones
+func join(a, b string) string {
+	if a == "" {
+		return b
+	}
+	if b == "" {
+		return a
+	}
+	return a + "." + b
+}
+
+// printText prints a result for humans, one section per part
+func printText(w io.Writer, out *result) {
+	for _, e := range out.Errors {
+		fmt.Fprintln(w, "error:", e.Error())
+	}
+	if len(out.Errors) > 0 {
+		return
+	}
+
+	section := func(title string, body string) {
+		fmt.Fprintf(w, "-- %s\n%s\n", title, body)
+	}
+	section("filter", string(out.Filter))
+	title := "normalized"
+	if out.NeverMatch {
+		title += " (never matches)"
+	}
+	section(title, string(out.Normalized))
+	if out.SQL != "" {
+		section("sql", out.SQL)
+		args := []byte("[]")
+		if len(out.Args) > 0 {
+			args, _ = json.Marshal(out.Args)
+		}
+		section("args", string(args))
+	}
+	if out.Query != nil {
+		query, _ := json.MarshalIndent(out.Query, "", "  ")
+		section("query", string(query))
+	}
+}
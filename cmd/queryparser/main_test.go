@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	model := []string{"-model", "testdata/item.yaml", "-now", "2024-05-15T12:00:00Z"}
+	tests := []struct {
+		name       string
+		args       []string
+		stdin      string
+		want       string
+		wantStderr string
+		wantCode   int
+	}{
+		{
+			name: "postgres",
+			args: append(model, "-filter", `{"qty": {"$gt": 2}, "name": {"$in": ["Desk"]}}`, "-options", `{"sort": {"name": "desc"}, "limit": 5}`),
+			want: "-- filter\n" + `{"name":{"$in":["Desk"]},"qty":{"$gt":2}}` + "\n" +
+				"-- normalized\n" + `{"name":{"$eq":"Desk"},"qty":{"$gt":2}}` + "\n" +
+				"-- sql\nSELECT * FROM items WHERE (name IN ($1) AND qty > $2) ORDER BY name DESC LIMIT 5\n" +
+				"-- args\n[\"Desk\",2]\n",
+		},
+		{
+			name: "sqlserver with rsql",
+			args: append(model, "-dialect", "sqlserver", "-syntax", "rsql", "-filter", "qty==0,price>100"),
+			want: "-- filter\n" + `{"$or":[{"qty":{"$eq":0}},{"price":{"$gt":100}}]}` + "\n" +
+				"-- normalized\n" + `{"$or":[{"price":{"$gt":100}},{"qty":{"$eq":0}}]}` + "\n" +
+				"-- sql\nSELECT * FROM items WHERE ((qty = @p1 OR price > @p2))\n" +
+				"-- args\n[0,100]\n",
+		},
+		{
+			name: "relative times resolve against -now",
+			args: append(model, "-dialect", "sqlite", "-filter", `{"created": {"$gte": "now/d"}}`),
+			want: "-- filter\n" + `{"created":{"$gte":"now/d"}}` + "\n" +
+				"-- normalized\n" + `{"created":{"$gte":"now/d"}}` + "\n" +
+				"-- sql\nSELECT * FROM items WHERE (created >= ?)\n" +
+				"-- args\n[\"2024-05-15T00:00:00Z\"]\n",
+		},
+		{
+			name:  "filter from stdin that never matches",
+			args:  append(model, "-dialect", "mysql", "-filter", "-"),
+			stdin: `{"qty": {"$gt": 5, "$lt": 2}}`,
+			want: "-- filter\n" + `{"qty":{"$gt":5,"$lt":2}}` + "\n" +
+				"-- normalized (never matches)\n" + `{"qty":{"$in":[]}}` + "\n" +
+				"-- sql\nSELECT * FROM items WHERE (qty > ? AND qty < ?)\n" +
+				"-- args\n[5,2]\n",
+		},
+		{
+			name: "mongo with url parameters",
+			args: []string{"-dialect", "mongo", "-syntax", "bracket", "-query", "filter[qty][$gt]=2&sort=-qty&limit=20"},
+			want: "-- filter\n" + `{"qty":{"$gt":2}}` + "\n" +
+				"-- normalized\n" + `{"qty":{"$gt":2}}` + "\n" +
+				"-- query\n{\n  \"filter\": {\n    \"qty\": {\n      \"$gt\": 2\n    }\n  },\n  \"limit\": 20,\n  \"sort\": {\n    \"qty\": -1\n  }\n}\n",
+		},
+		{
+			name: "validation errors have paths",
+			args: append(model, "-filter", `{"$or": [{"qty": 1}, {"color": "red"}, {"$and": [{"price": {"$size": 2}}]}]}`, "-options", `{"sort": {"nope": "asc"}}`),
+			want: "error: $or[1].color.$eq: field \"color\" is not a valid JSON field\n" +
+				"error: $or[2].$and[0].price.$size: operator $size requires an array field, \"price\" is not one\n" +
+				"error: options: field \"nope\" is not a valid JSON field for sorting\n",
+			wantCode: 1,
+		},
+		{
+			name:     "parse errors",
+			args:     append(model, "-syntax", "rsql", "-filter", "qty=near=1"),
+			want:     "error: invalid RSQL filter at position 3: unsupported operator =near=\n",
+			wantCode: 1,
+		},
+		{name: "sql requires a model", args: []string{"-filter", "{}"}, wantStderr: "queryparser: -model is required with -dialect postgres\n", wantCode: 2},
+		{name: "unknown dialect", args: []string{"-dialect", "oracle"}, wantStderr: `queryparser: unknown dialect "oracle", expected postgres, mysql, sqlite, sqlserver, elastic or mongo` + "\n", wantCode: 2},
+		{name: "bracket requires a query", args: []string{"-dialect", "mongo", "-syntax", "bracket", "-filter", "{}"}, wantStderr: "queryparser: bracket filters are read from -query\n", wantCode: 2},
+		{name: "query and filter", args: []string{"-dialect", "mongo", "-query", "limit=1", "-filter", "{}"}, wantStderr: "queryparser: -query cannot be combined with -filter or -options\n", wantCode: 2},
+		{name: "unexpected argument", args: []string{"filter.json"}, wantStderr: "queryparser: unexpected argument \"filter.json\"\n", wantCode: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+			assert.Equal(t, tt.wantCode, code)
+			assert.Equal(t, tt.want, stdout.String())
+			assert.Equal(t, tt.wantStderr, stderr.String())
+		})
+	}
+}
+
+func TestRunJSON(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-model", "testdata/item.yaml", "-dialect", "elastic", "-json", "-filter", `{"parts": {"$elemMatch": {"sku": "A-1"}}}`, "-options", `{"limit": 1}`},
+		strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+
+	var got map[string]any
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), &got))
+	assert.Equal(t, map[string]any{"parts": map[string]any{"$elemMatch": map[string]any{"sku": map[string]any{"$eq": "A-1"}}}}, got["filter"])
+	assert.Equal(t, map[string]any{
+		"query": map[string]any{"bool": map[string]any{"must": map[string]any{"nested": map[string]any{
+			"path":  "parts",
+			"query": map[string]any{"bool": map[string]any{"must": map[string]any{"term": map[string]any{"parts.sku": "A-1"}}}},
+		}}}},
+		"size": float64(1),
+	}, got["query"])
+
+	stdout.Reset()
+	code = run([]string{"-model", "testdata/item.yaml", "-json", "-filter", `{"color": "red"}`}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.JSONEq(t, `{"filter": {"color": {"$eq": "red"}}, "normalized": {"color": {"$eq": "red"}}, "errors": [{"path": "color.$eq", "message": "field \"color\" is not a valid JSON field"}]}`, stdout.String())
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/ready4god2513/queryparser"
+	"gopkg.in/yaml.v3"
+)
+
+// modelFile describes a model in JSON or YAML: a queryparser.Schema and the
+// table it is stored in.
+//
+//	table: items
+//	name: Item
+//	fields:
+//	  - {name: id, type: integer, column: id}
+//	  - {name: name, type: string, capabilities: [filter, sort, search], es: "text,keyword"}
+//	  - {name: tags, type: "[]string"}
+//	  - name: parts
+//	    type: "[]object"
+//	    fields:
+//	      - {name: sku, type: string}
+type modelFile struct {
+	Table              string `yaml:"table"`
+	queryparser.Schema `yaml:",inline"`
+}
+
+// loadModel reads a model file and returns its schema and table. YAML is a
+// superset of JSON, so both are read the same way.
+func loadModel(path string) (*queryparser.Schema, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read model: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	var file modelFile
+	if err := decoder.Decode(&file); err != nil {
+		return nil, "", fmt.Errorf("failed to parse model %s: %w", path, err)
+	}
+	if err := file.Validate(); err != nil {
+		return nil, "", fmt.Errorf("invalid model %s: %w", path, err)
+	}
+	return &file.Schema, file.Table, nil
+}
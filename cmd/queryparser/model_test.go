@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ready4god2513/queryparser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadModel(t *testing.T) {
+	schema, table, err := loadModel("testdata/item.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, "items", table)
+	assert.Equal(t, "Item", schema.Name)
+	assert.Len(t, schema.Fields, 9)
+	assert.Equal(t, queryparser.SchemaField{Name: "category", Column: "category", Type: queryparser.TypeString, Nullable: true}, schema.Fields[4])
+	assert.Equal(t, []queryparser.Capability{queryparser.CapFilter, queryparser.CapSort, queryparser.CapSearch}, schema.Fields[1].Capabilities)
+	assert.Equal(t, queryparser.ArrayOf(queryparser.TypeObject), schema.Fields[8].Type)
+}
+
+func TestLoadModelErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "no fields", content: `{"table": "items"}`, wantErr: "schema has no fields"},
+		{name: "unnamed field", content: `{"fields": [{"type": "string"}]}`, wantErr: "field 0 of schema has no name"},
+		{
+			name:    "unknown type",
+			content: "fields:\n  - {name: parts, type: \"[]object\", fields: [{name: sku, type: text}]}",
+			wantErr: `field "parts.sku" has unknown type "text", expected string, integer, number, boolean, time, any or object, optionally prefixed by []`,
+		},
+		{name: "fields of a scalar", content: `{"fields": [{"name": "qty", "type": "integer", "fields": [{"name": "a", "type": "string"}]}]}`, wantErr: `field "qty" has fields but is not an object`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "model.json")
+			assert.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+			_, _, err := loadModel(path)
+			assert.EqualError(t, err, "invalid model "+path+": "+tt.wantErr)
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "model.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"table": "items", "fields": [{"name": "id", "type": "integer", "query": "nosort"}]}`), 0o600))
+	_, _, err := loadModel(path)
+	assert.EqualError(t, err, "failed to parse model "+path+": yaml: unmarshal errors:\n  line 1: field query not found in type queryparser.SchemaField")
+}
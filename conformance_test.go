@@ -0,0 +1,330 @@
+package queryparser
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/assert"
+)
+
+// conformanceCase is a filter of the conformance corpus with the ids it
+// selects from the items fixture. SQL and SliceBuilder must select want.
+// Elasticsearch selects want too, unless elastic is set: the filter then
+// means something else there, and difference says what.
+type conformanceCase struct {
+	name       string
+	filter     string
+	want       []int
+	elastic    []int
+	difference string
+}
+
+var conformanceCorpus = []conformanceCase{
+	{name: "equality", filter: `{"category": "lighting"}`, want: []int{1, 2}},
+	{name: "equality on a string is case-sensitive", filter: `{"name": "desk"}`, want: []int{5}},
+	{name: "null", filter: `{"category": null}`, want: []int{4}},
+	{name: "not null", filter: `{"category": {"$ne": null}}`, want: []int{1, 2, 3, 5}},
+	{name: "exists", filter: `{"category": {"$exists": true}}`, want: []int{1, 2, 3, 5}},
+	{name: "missing", filter: `{"category": {"$exists": false}}`, want: []int{4}},
+	{name: "range", filter: `{"qty": {"$gt": 2, "$lte": 12}}`, want: []int{1, 3}},
+	{name: "range on floats", filter: `{"price": {"$gte": 19.5, "$lt": 120}}`, want: []int{1, 2}},
+	{name: "between", filter: `{"price": {"$between": [19.5, 120]}}`, want: []int{1, 2, 3, 5}},
+	{name: "half-open between", filter: `{"qty": {"$between": {"from": 0, "to": 5, "bounds": "(]"}}}`, want: []int{1, 5}},
+	{name: "in", filter: `{"qty": {"$in": [0, 2, 7]}}`, want: []int{2, 5}},
+	{name: "boolean", filter: `{"active": false}`, want: []int{2, 5}},
+	{name: "time", filter: `{"created": {"$lt": "2024-05-01T00:00:00Z"}}`, want: []int{3, 5}},
+	{name: "or", filter: `{"$or": [{"qty": 0}, {"price": {"$gt": 100}}]}`, want: []int{2, 3, 5}},
+	{name: "and in or", filter: `{"$or": [{"qty": 0}, {"$and": [{"active": false}, {"price": 120}]}]}`, want: []int{2, 5}},
+	{name: "like with wildcards", filter: `{"name": {"$like": "Cable 5_"}}`, want: []int{4}},
+	{
+		name:       "not equal",
+		filter:     `{"category": {"$ne": "lighting"}}`,
+		want:       []int{3, 5},
+		elastic:    []int{3, 4, 5},
+		difference: "SQL compares NULL to nothing, Elasticsearch's must_not term matches documents without the field",
+	},
+	{
+		name:       "not in",
+		filter:     `{"category": {"$nin": ["furniture"]}}`,
+		want:       []int{1, 2},
+		elastic:    []int{1, 2, 4},
+		difference: "NOT IN is unknown on NULL in SQL, Elasticsearch's must_not terms matches documents without the field",
+	},
+	{
+		name:       "like",
+		filter:     `{"name": {"$like": "lamp"}}`,
+		want:       []int{1, 2},
+		elastic:    []int{2},
+		difference: "LIKE ignores case on SQLite and MySQL, Elasticsearch's wildcard query does not, like LIKE on PostgreSQL",
+	},
+	{
+		name:       "or with not equal",
+		filter:     `{"$or": [{"category": {"$ne": "furniture"}}, {"qty": 12}]}`,
+		want:       []int{1, 2, 3},
+		elastic:    []int{1, 2, 3, 4},
+		difference: "the $ne alternative matches the document without a category in Elasticsearch only",
+	},
+}
+
+func TestConformance(t *testing.T) {
+	documents := make([]map[string]any, len(items))
+	for i, item := range items {
+		documents[i] = jsonObject(t, item)
+	}
+
+	for _, tt := range conformanceCorpus {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := ParseFilter(tt.filter)
+			assert.NoError(t, err)
+
+			// SliceBuilder
+			got, err := NewSliceBuilder[Item]().Apply(items, filters, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, itemIDs(got), "SliceBuilder")
+
+			// SqlBuilder on SQLite
+			qb, err := NewSqlBuilderWithPlaceholderFormat(context.Background(), squirrel.Question).
+				WithSelect("items").Apply(filters, &QueryOptions{Fields: []string{"id"}}, &Item{})
+			assert.NoError(t, err)
+			query, args, err := qb.ToSql()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, sqliteIDs(t, itemsTable(), query+" ORDER BY id", args), "SqlBuilder: %s", query)
+
+			// ElasticBuilder, evaluated on the documents
+			q, err := NewElasticBuilder(nil).Apply(filters, nil, &Item{})
+			assert.NoError(t, err)
+			source, err := q.Source()
+			assert.NoError(t, err)
+			dsl := jsonObject(t, source)
+			ids := []int{}
+			for i, doc := range documents {
+				if esMatch(t, dsl, doc) {
+					ids = append(ids, items[i].ID)
+				}
+			}
+
+			want := tt.want
+			if tt.elastic != nil {
+				assert.NotEqual(t, tt.want, tt.elastic, "a difference must change the result")
+				assert.NotEmpty(t, tt.difference, "differences must be documented")
+				want = tt.elastic
+			}
+			assert.Equal(t, want, ids, "ElasticBuilder: %v", dsl)
+		})
+	}
+}
+
+// jsonObject returns the JSON representation of a value as plain maps
+func jsonObject(t *testing.T, v any) map[string]any {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("failed to encode %v: %v", v, err)
+	}
+	var object map[string]any
+	if err := json.Unmarshal(data, &object); err != nil {
+		t.Fatalf("failed to decode %s: %v", data, err)
+	}
+	return object
+}
+
+// esMatch evaluates the subset of the Elasticsearch query DSL produced by
+// ElasticBuilder on a document. Keyword subfields hold the value of their
+// text field, and missing and null fields have no value, as in an index.
+func esMatch(t *testing.T, query, doc map[string]any) bool {
+	t.Helper()
+	if len(query) != 1 {
+		t.Fatalf("expected one query type, got %v", query)
+	}
+	for kind, body := range query {
+		params, _ := body.(map[string]any)
+		switch kind {
+		case "match_all":
+			return true
+		case "bool":
+			for _, q := range esClauses(params["must"]) {
+				if !esMatch(t, q, doc) {
+					return false
+				}
+			}
+			for _, q := range esClauses(params["filter"]) {
+				if !esMatch(t, q, doc) {
+					return false
+				}
+			}
+			for _, q := range esClauses(params["must_not"]) {
+				if esMatch(t, q, doc) {
+					return false
+				}
+			}
+			should := esClauses(params["should"])
+			if len(should) == 0 {
+				return true
+			}
+			for _, q := range should {
+				if esMatch(t, q, doc) {
+					return true
+				}
+			}
+			return false
+		case "term":
+			field, value := esFieldParam(params, "value")
+			return esAny(doc, field, func(v any) bool { return esCompare(v, value) == 0 })
+		case "terms":
+			field, values := esFieldParam(params, "")
+			return esAny(doc, field, func(v any) bool {
+				for _, value := range values.([]any) {
+					if esCompare(v, value) == 0 {
+						return true
+					}
+				}
+				return false
+			})
+		case "range":
+			field, bounds := esFieldParam(params, "")
+			b := bounds.(map[string]any)
+			return esAny(doc, field, func(v any) bool {
+				for op, bound := range b {
+					c := esCompare(v, bound)
+					switch {
+					case op == "gt" && c <= 0, op == "gte" && c < 0, op == "lt" && c >= 0, op == "lte" && c > 0:
+						return false
+					}
+				}
+				return true
+			})
+		case "exists":
+			return esAny(doc, params["field"].(string), func(any) bool { return true })
+		case "wildcard":
+			field, pattern := esFieldParam(params, "value")
+			re := esWildcard(pattern.(string))
+			return esAny(doc, field, func(v any) bool {
+				s, ok := v.(string)
+				return ok && re.MatchString(s)
+			})
+		default:
+			t.Fatalf("unsupported query type %q", kind)
+		}
+	}
+	return false
+}
+
+// esClauses returns the clauses of a bool occurrence, a query or a list
+func esClauses(v any) []map[string]any {
+	switch v := v.(type) {
+	case map[string]any:
+		return []map[string]any{v}
+	case []any:
+		clauses := make([]map[string]any, len(v))
+		for i, clause := range v {
+			clauses[i] = clause.(map[string]any)
+		}
+		return clauses
+	default:
+		return nil
+	}
+}
+
+// esFieldParam returns the field of a leaf query and its parameter, read
+// from the long form {"field": {"value": x}} when key is set and present
+func esFieldParam(params map[string]any, key string) (string, any) {
+	for field, value := range params {
+		if object, ok := value.(map[string]any); ok && key != "" {
+			if v, ok := object[key]; ok {
+				return field, v
+			}
+		}
+		return field, value
+	}
+	return "", nil
+}
+
+// esAny reports whether one value of a document field satisfies match
+func esAny(doc map[string]any, field string, match func(any) bool) bool {
+	field = strings.TrimSuffix(field, ".keyword")
+	value := doc[field]
+	values, ok := value.([]any)
+	if !ok {
+		values = []any{value}
+	}
+	for _, v := range values {
+		if v != nil && match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// esCompare orders a document value and a query value: numbers by value,
+// and everything else by its string form, which orders RFC 3339 times
+func esCompare(a, b any) int {
+	fa, aok := esNumber(a)
+	fb, bok := esNumber(b)
+	if aok && bok {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// esNumber converts a JSON number or numeric string to float64
+func esNumber(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// esWildcard compiles a case-sensitive wildcard pattern
+func esWildcard(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '*':
+			b.WriteString(".*")
+		case r == '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+func TestConformanceCorpusIsSorted(t *testing.T) {
+	for _, tt := range conformanceCorpus {
+		assert.True(t, sort.IntsAreSorted(tt.want), tt.name)
+		assert.True(t, sort.IntsAreSorted(tt.elastic), tt.name)
+	}
+}
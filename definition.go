@@ -0,0 +1,493 @@
+package queryparser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Schema describes a model without a Go struct, such as a table configured
+// at runtime. It is accepted wherever a model is, as a Schema or *Schema, and
+// the builders validate and map filters against it like against a struct:
+//
+//	schema := &Schema{Name: "Ticket", Fields: []SchemaField{
+//		{Name: "id", Type: TypeInteger, Column: "id"},
+//		{Name: "title", Type: TypeString, Column: "title", Capabilities: []Capability{CapFilter, CapSearch}},
+//		{Name: "tags", Type: ArrayOf(TypeString), Column: "tags"},
+//		{Name: "owner", Type: TypeObject, Relation: &SchemaRelation{Table: "users", ForeignKey: "id", References: "owner_id"},
+//			Fields: []SchemaField{{Name: "name", Type: TypeString, Column: "name"}}},
+//	}}
+//	qb, err = qb.Apply(filters, options, schema)
+//
+// Schemas are usually loaded from JSON or YAML with ParseSchema. SchemaOf
+// describes a struct as a Schema.
+type Schema struct {
+	// Name names the model in generated documents such as FilterSchema, and
+	// in the default foreign key of relations
+	Name   string        `json:"name,omitempty" yaml:"name,omitempty"`
+	Fields []SchemaField `json:"fields" yaml:"fields"`
+}
+
+// SchemaField is a field of a Schema. Column, Capabilities, Storage,
+// ArrayType, Roles, ES and Relation stand for the db, query and es struct tags.
+type SchemaField struct {
+	// Name is the name of the field in filters, like the json tag
+	Name string `json:"name" yaml:"name"`
+	// Column is the database column. Without one, the column is Name, also
+	// for SqlBuilder.SetStrictColumns.
+	Column string `json:"column,omitempty" yaml:"column,omitempty"`
+	// Type is the type of the values. It is prefixed by [] for arrays.
+	Type FieldType `json:"type" yaml:"type"`
+	// Nullable fields may be null, like pointer fields of a struct
+	Nullable bool `json:"nullable,omitempty" yaml:"nullable,omitempty"`
+	// Capabilities lists what the field can be used for. When nil, the field
+	// can be filtered and, if it is a scalar, sorted.
+	Capabilities []Capability `json:"capabilities,omitempty" yaml:"capabilities,omitempty"`
+	// Storage is "json" or "jsonb" for values stored in a JSON column
+	Storage string `json:"storage,omitempty" yaml:"storage,omitempty"`
+	// ArrayType is the PostgreSQL element type of an array column
+	ArrayType string `json:"arrayType,omitempty" yaml:"arrayType,omitempty"`
+	// ES is the Elasticsearch mapping, as in the es tag, e.g. "text,keyword"
+	ES string `json:"es,omitempty" yaml:"es,omitempty"`
+	// Roles restricts the field to callers with one of the roles, as checked
+	// by SqlBuilder and Authorize. Any caller can use it when empty.
+	Roles []string `json:"roles,omitempty" yaml:"roles,omitempty"`
+	// Relation declares that an object is stored in a related table
+	Relation *SchemaRelation `json:"relation,omitempty" yaml:"relation,omitempty"`
+	// Fields are the fields of an object
+	Fields []SchemaField `json:"fields,omitempty" yaml:"fields,omitempty"`
+}
+
+// SchemaRelation is the related table of a field, see Related Tables in the
+// README. ForeignKey defaults to the snake_case name of the model followed
+// by _id and References to id.
+type SchemaRelation struct {
+	Table      string `json:"table,omitempty" yaml:"table,omitempty"`
+	ForeignKey string `json:"fk,omitempty" yaml:"fk,omitempty"`
+	References string `json:"ref,omitempty" yaml:"ref,omitempty"`
+}
+
+// FieldType is the type of the values of a SchemaField
+type FieldType string
+
+const (
+	TypeString  FieldType = "string"
+	TypeInteger FieldType = "integer"
+	TypeNumber  FieldType = "number"
+	TypeBoolean FieldType = "boolean"
+	TypeTime    FieldType = "time"
+	TypeAny     FieldType = "any" // free-form JSON
+	TypeObject  FieldType = "object"
+)
+
+// ArrayOf returns the type of arrays of t, e.g. "[]string"
+func ArrayOf(t FieldType) FieldType {
+	return "[]" + t
+}
+
+// Capability is a use of a SchemaField
+type Capability string
+
+const (
+	CapFilter Capability = "filter" // the field can be filtered
+	CapSort   Capability = "sort"   // the field can be sorted by
+	CapSearch Capability = "search" // the field is searched by $text and $search
+)
+
+// fieldGoTypes are the Go types of the scalar field types
+var fieldGoTypes = map[FieldType]reflect.Type{
+	TypeString:  reflect.TypeOf(""),
+	TypeInteger: reflect.TypeOf(int64(0)),
+	TypeNumber:  reflect.TypeOf(float64(0)),
+	TypeBoolean: reflect.TypeOf(false),
+	TypeTime:    timeType,
+	TypeAny:     reflect.TypeOf((*any)(nil)).Elem(),
+}
+
+// ParseSchema reads a Schema from JSON or YAML and validates it:
+//
+//	name: Ticket
+//	fields:
+//	  - {name: id, type: integer, column: id}
+//	  - {name: title, type: string, capabilities: [filter, search]}
+//	  - {name: tags, type: "[]string"}
+func ParseSchema(data []byte) (*Schema, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	var schema Schema
+	if err := decoder.Decode(&schema); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+	if err := schema.Validate(); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// Validate checks that the fields of a schema have unique names and known
+// types, and that only objects have fields and relations
+func (s Schema) Validate() error {
+	_, err := structOf(s.Fields, "")
+	return err
+}
+
+// maxCompiledSchemas bounds the compiled Schemas kept for reuse. A program
+// using more distinct Schemas compiles the oldest ones again.
+const maxCompiledSchemas = 256
+
+// compiledSchemas holds the model schemas of the Schemas compiled last, by
+// their JSON encoding, so that applying a Schema does not generate its struct
+// type again
+var compiledSchemas = struct {
+	sync.Mutex
+	schemas map[string]*modelSchema
+	keys    []string // oldest first
+}{schemas: make(map[string]*modelSchema)}
+
+// compile returns the model schema of a Schema. The fields become those of a
+// generated struct type, tagged like the struct the Schema stands for.
+func (s Schema) compile() (*modelSchema, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode schema: %w", err)
+	}
+	key := string(data)
+	compiledSchemas.Lock()
+	schema, ok := compiledSchemas.schemas[key]
+	compiledSchemas.Unlock()
+	if ok {
+		return schema, nil
+	}
+
+	typ, err := structOf(s.Fields, "")
+	if err != nil {
+		return nil, err
+	}
+	schema = buildNamedSchema(typ, s.Name)
+
+	compiledSchemas.Lock()
+	defer compiledSchemas.Unlock()
+	if cached, ok := compiledSchemas.schemas[key]; ok {
+		return cached, nil
+	}
+	if len(compiledSchemas.keys) == maxCompiledSchemas {
+		delete(compiledSchemas.schemas, compiledSchemas.keys[0])
+		compiledSchemas.keys = compiledSchemas.keys[1:]
+	}
+	compiledSchemas.schemas[key] = schema
+	compiledSchemas.keys = append(compiledSchemas.keys, key)
+	return schema, nil
+}
+
+// structOf generates the struct type of the fields of a schema or of an
+// object field at path
+func structOf(fields []SchemaField, path string) (reflect.Type, error) {
+	owner := "schema"
+	if path != "" {
+		owner = fmt.Sprintf("field %q", path)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%s has no fields", owner)
+	}
+
+	structFields := make([]reflect.StructField, 0, len(fields))
+	names := make(map[string]bool, len(fields))
+	goNames := make(map[string]bool, len(fields))
+	for i, f := range fields {
+		if f.Name == "" {
+			return nil, fmt.Errorf("field %d of %s has no name", i, owner)
+		}
+		fieldPath := f.Name
+		if path != "" {
+			fieldPath = path + "." + f.Name
+		}
+		if strings.Contains(f.Name, ".") {
+			return nil, fmt.Errorf("field %q cannot contain dots", fieldPath)
+		}
+		if strings.Contains(f.Name, ",") || f.Name == "-" {
+			return nil, fmt.Errorf("field %q is not a valid name", fieldPath)
+		}
+		if strings.Contains(f.Column, ",") || f.Column == "-" {
+			return nil, fmt.Errorf("field %q has invalid column %q", fieldPath, f.Column)
+		}
+		if names[f.Name] {
+			return nil, fmt.Errorf("duplicate field %q", fieldPath)
+		}
+		names[f.Name] = true
+
+		typ, err := f.goType(fieldPath)
+		if err != nil {
+			return nil, err
+		}
+		tag, err := f.tag(fieldPath)
+		if err != nil {
+			return nil, err
+		}
+
+		goName := goFieldName(f.Name)
+		for n := 2; goNames[goName]; n++ {
+			goName = goFieldName(f.Name) + strconv.Itoa(n)
+		}
+		goNames[goName] = true
+		structFields = append(structFields, reflect.StructField{Name: goName, Type: typ, Tag: tag})
+	}
+	return reflect.StructOf(structFields), nil
+}
+
+// goType returns the Go type of a field: a scalar type or a struct of its
+// fields, a pointer when nullable and a slice for arrays
+func (f SchemaField) goType(path string) (reflect.Type, error) {
+	name, repeated := strings.CutPrefix(string(f.Type), "[]")
+	var typ reflect.Type
+	if FieldType(name) == TypeObject {
+		var err error
+		if typ, err = structOf(f.Fields, path); err != nil {
+			return nil, err
+		}
+	} else {
+		var ok bool
+		if typ, ok = fieldGoTypes[FieldType(name)]; !ok {
+			return nil, fmt.Errorf("field %q has unknown type %q, expected string, integer, number, boolean, time, any or object, optionally prefixed by []", path, f.Type)
+		}
+		if len(f.Fields) > 0 {
+			return nil, fmt.Errorf("field %q has fields but is not an object", path)
+		}
+		if f.Relation != nil {
+			return nil, fmt.Errorf("field %q has a relation but is not an object", path)
+		}
+	}
+
+	if f.Nullable {
+		typ = reflect.PointerTo(typ)
+	}
+	if repeated {
+		typ = reflect.SliceOf(typ)
+	}
+	return typ, nil
+}
+
+// tag returns the struct tag of a field
+func (f SchemaField) tag(path string) (reflect.StructTag, error) {
+	var options []string
+	if f.Capabilities != nil {
+		capabilities := make(map[Capability]bool, len(f.Capabilities))
+		for _, c := range f.Capabilities {
+			switch c {
+			case CapFilter, CapSort, CapSearch:
+				capabilities[c] = true
+			default:
+				return "", fmt.Errorf("field %q has unknown capability %q, expected filter, sort or search", path, c)
+			}
+		}
+		if !capabilities[CapFilter] {
+			options = append(options, "nofilter")
+		}
+		if !capabilities[CapSort] {
+			options = append(options, "nosort")
+		}
+		if capabilities[CapSearch] {
+			options = append(options, "searchable")
+		}
+	}
+	switch f.Storage {
+	case "":
+	case "json", "jsonb":
+		options = append(options, f.Storage)
+	default:
+		return "", fmt.Errorf("field %q has unknown storage %q, expected json or jsonb", path, f.Storage)
+	}
+	if f.ArrayType != "" {
+		options = append(options, "array="+f.ArrayType)
+	}
+	if len(f.Roles) > 0 {
+		for _, role := range f.Roles {
+			if role == "" || strings.ContainsAny(role, ",|\"") {
+				return "", fmt.Errorf("field %q has invalid role %q", path, role)
+			}
+		}
+		options = append(options, "roles="+strings.Join(f.Roles, "|"))
+	}
+	if r := f.Relation; r != nil {
+		options = append(options, "relation")
+		for _, option := range []struct{ key, value string }{{"table", r.Table}, {"fk", r.ForeignKey}, {"ref", r.References}} {
+			if option.value != "" {
+				options = append(options, option.key+"="+option.value)
+			}
+		}
+	}
+
+	column := f.Column
+	if column == "" {
+		column = f.Name
+	}
+	tag := "json:" + strconv.Quote(f.Name) + " db:" + strconv.Quote(column)
+	if len(options) > 0 {
+		tag += " query:" + strconv.Quote(strings.Join(options, ","))
+	}
+	if f.ES != "" {
+		tag += " es:" + strconv.Quote(f.ES)
+	}
+	return reflect.StructTag(tag), nil
+}
+
+// goFieldName returns an exported Go identifier for a field name, e.g.
+// Created_at for created_at, which naming strategies such as SnakeCaseNaming
+// map back to the name
+func goFieldName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if i == 0 {
+			if upper := unicode.ToUpper(r); unicode.IsUpper(upper) {
+				b.WriteRune(upper)
+				continue
+			}
+			b.WriteString("F")
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// SchemaOf describes a model as a Schema: the json-tagged fields of a struct
+// with their columns, types, capabilities and relations, as the builders see
+// them. Recursive types cannot be described.
+func SchemaOf(model any) (*Schema, error) {
+	schema, err := schemaOf(model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get model schema: %w", err)
+	}
+	fields, err := describeFields(schema, "", map[*modelSchema]bool{schema: true})
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{Name: schema.name, Fields: fields}, nil
+}
+
+// describeFields returns the SchemaFields of a model schema
+func describeFields(schema *modelSchema, prefix string, seen map[*modelSchema]bool) ([]SchemaField, error) {
+	fields := make([]SchemaField, 0, len(schema.fields))
+	for _, f := range schema.fields {
+		path := prefix + f.JSON
+		typ, nullable := describeType(f.Type)
+		column := f.Column
+		if column == f.JSON {
+			// The default column of a SchemaField
+			column = ""
+		}
+		field := SchemaField{
+			Name:         f.JSON,
+			Column:       column,
+			Type:         typ,
+			Nullable:     nullable,
+			Capabilities: describeCapabilities(f),
+			ArrayType:    f.ArrayType,
+			Roles:        f.Roles,
+			ES:           describeES(f, typ),
+		}
+		if f.JSONColumn {
+			field.Storage = "json"
+			if f.JSONB {
+				field.Storage = "jsonb"
+			}
+		}
+		if f.Relation != nil {
+			field.Relation = &SchemaRelation{Table: f.Relation.Table, ForeignKey: f.Relation.ForeignKey, References: f.Relation.References}
+		}
+		if f.Children != nil {
+			if seen[f.Children] {
+				return nil, fmt.Errorf("field %q has a recursive type, which a Schema cannot describe", path)
+			}
+			seen[f.Children] = true
+			children, err := describeFields(f.Children, path+".", seen)
+			delete(seen, f.Children)
+			if err != nil {
+				return nil, err
+			}
+			field.Type = TypeObject
+			if f.Repeated {
+				field.Type = ArrayOf(TypeObject)
+			}
+			field.Fields = children
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// describeType returns the field type of a Go type and whether its values
+// are nullable. Types without a counterpart are free-form.
+func describeType(typ reflect.Type) (FieldType, bool) {
+	nullable := false
+	for typ.Kind() == reflect.Ptr {
+		nullable = true
+		typ = typ.Elem()
+	}
+	if (typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array) && typ.Elem().Kind() != reflect.Uint8 {
+		elem, elemNullable := describeType(typ.Elem())
+		return ArrayOf(elem), nullable || elemNullable
+	}
+	switch {
+	case typ == timeType:
+		return TypeTime, nullable
+	case typ.Kind() == reflect.String:
+		return TypeString, nullable
+	case typ.Kind() == reflect.Bool:
+		return TypeBoolean, nullable
+	case isNumeric(typ) && (typ.Kind() == reflect.Float32 || typ.Kind() == reflect.Float64):
+		return TypeNumber, nullable
+	case isNumeric(typ):
+		return TypeInteger, nullable
+	default:
+		return TypeAny, nullable
+	}
+}
+
+// describeES returns the es tag of a field, or the Elasticsearch type
+// inferred from its Go type when the field type alone would infer another,
+// e.g. "float" for a float32
+func describeES(f *schemaField, typ FieldType) string {
+	if tag := f.Struct.Tag.Get("es"); tag != "" || f.Children != nil {
+		return tag
+	}
+	goType, ok := fieldGoTypes[FieldType(strings.TrimPrefix(string(typ), "[]"))]
+	if !ok {
+		return ""
+	}
+	if inferred, _ := esTypeOf(reflect.StructField{}, goType, f.Searchable); f.ESType == inferred {
+		return ""
+	}
+	return f.ESType
+}
+
+// describeCapabilities returns the capabilities of a field, or nil when they
+// are the default ones
+func describeCapabilities(f *schemaField) []Capability {
+	filterable := f.Filterable || f.Relation != nil
+	// free-form fields are sortable by default in a Schema, unlike Go maps
+	scalar := f.Children == nil && !f.Repeated
+	if filterable && f.Sortable == scalar && !f.Searchable {
+		return nil
+	}
+	capabilities := []Capability{}
+	if filterable {
+		capabilities = append(capabilities, CapFilter)
+	}
+	if f.Sortable {
+		capabilities = append(capabilities, CapSort)
+	}
+	if f.Searchable {
+		capabilities = append(capabilities, CapSearch)
+	}
+	return capabilities
+}
@@ -0,0 +1,272 @@
+package queryparser
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/assert"
+)
+
+// modelSummary describes what the builders see of every path of a model
+func modelSummary(t *testing.T, model any) map[string]string {
+	t.Helper()
+	schema, err := schemaOf(model)
+	if err != nil {
+		t.Fatalf("failed to get model schema: %v", err)
+	}
+	summary := make(map[string]string)
+	for _, p := range schemaPaths(schema) {
+		f := p.chain[len(p.chain)-1]
+		esType := f.ESType
+		if isFreeForm(f.Type) {
+			// free-form Go types such as maps and interfaces are all "any"
+			esType = ""
+		}
+		column := f.Column
+		if column == "" {
+			// Untagged fields map to their JSON name, which a Schema makes
+			// explicit
+			column = f.JSON
+		}
+		summary[p.name] = fmt.Sprintf("ops=%v sortable=%v column=%q repeated=%v json=%v/%v nested=%v relation=%+v search=%v es=%s/%s array=%s",
+			fieldOperators(p.chain), f.Sortable, column, f.Repeated, f.JSONColumn, f.JSONB, f.Nested, f.Relation, f.Searchable,
+			esType, f.ESKeyword, f.ArrayType)
+	}
+	return summary
+}
+
+func TestSchemaOfRoundTrips(t *testing.T) {
+	for _, model := range []any{&Item{}, &Product{}, &RelUser{}, &JSONBAccount{}, &ArrayPost{}, &Article{}} {
+		schema, err := SchemaOf(model)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		t.Run(schema.Name, func(t *testing.T) {
+			assert.Equal(t, modelSummary(t, model), modelSummary(t, schema))
+		})
+	}
+
+	_, err := SchemaOf(&Customer{})
+	assert.EqualError(t, err, `field "parent" has a recursive type, which a Schema cannot describe`)
+}
+
+// itemSchema describes Item in YAML
+const itemSchema = `
+name: Item
+fields:
+  - {name: id, type: integer, column: id}
+  - {name: name, type: string, column: name, capabilities: [filter, sort, search]}
+  - {name: qty, type: integer, column: qty}
+  - {name: price, type: number, column: price}
+  - {name: category, type: string, column: category, nullable: true}
+  - {name: active, type: boolean, column: active}
+  - {name: tags, type: "[]string", column: tags}
+  - name: parts
+    type: "[]object"
+    column: parts
+    fields:
+      - {name: sku, type: string}
+      - {name: qty, type: integer}
+  - {name: created, type: time, column: created}
+`
+
+func TestSchemaSelectsLikeStruct(t *testing.T) {
+	schema, err := ParseSchema([]byte(itemSchema))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, tt := range conformanceCorpus {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := ParseFilter(tt.filter)
+			assert.NoError(t, err)
+			options := &QueryOptions{Fields: []string{"id"}, Sort: map[string]SortDirection{"id": SortAsc}}
+			build := func(model any) (string, []any) {
+				qb, err := NewSqlBuilderWithPlaceholderFormat(context.Background(), squirrel.Question).
+					WithSelect("items").Apply(filters, options, model)
+				if !assert.NoError(t, err) {
+					return "", nil
+				}
+				query, args, err := qb.ToSql()
+				assert.NoError(t, err)
+				return query, args
+			}
+			query, args := build(schema)
+			structQuery, structArgs := build(&Item{})
+			if assert.Equal(t, structQuery, query) && assert.Equal(t, structArgs, args) {
+				assert.Equal(t, tt.want, sqliteIDs(t, itemsTable(), query, args))
+			}
+		})
+	}
+}
+
+func TestSchemaCapabilities(t *testing.T) {
+	schema := Schema{Name: "Employee", Fields: []SchemaField{
+		{Name: "id", Type: TypeInteger},
+		{Name: "salary", Type: TypeNumber, Capabilities: []Capability{CapSort}},
+		{Name: "notes", Type: TypeString, Capabilities: []Capability{CapFilter, CapSearch}},
+		{Name: "team", Type: TypeObject, Relation: &SchemaRelation{Table: "teams", ForeignKey: "id", References: "team_id"},
+			Fields: []SchemaField{{Name: "name", Type: TypeString}}},
+	}}
+	apply := func(filters []Filter, options *QueryOptions) (string, error) {
+		qb, err := NewSqlBuilder(context.Background()).WithSelect("employees").Apply(filters, options, schema)
+		if err != nil {
+			return "", err
+		}
+		query, _, err := qb.ToSql()
+		return query, err
+	}
+
+	_, err := apply([]Filter{{Field: "salary", Operator: OpGt, Value: 1}}, nil)
+	assert.EqualError(t, err, `field "salary" is not a valid JSON field`)
+	_, err = apply(nil, &QueryOptions{Sort: map[string]SortDirection{"notes": SortAsc}})
+	assert.EqualError(t, err, `field "notes" cannot be used for sorting`)
+
+	query, err := apply([]Filter{{Field: "notes", Operator: OpSearch, Value: "remote"}, {Field: "team.name", Operator: OpEq, Value: "core"}},
+		&QueryOptions{Sort: map[string]SortDirection{"salary": SortDesc}})
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM employees WHERE (to_tsvector(notes) @@ websearch_to_tsquery($1) AND "+
+		"EXISTS (SELECT 1 FROM teams WHERE teams.id = employees.team_id AND teams.name = $2)) ORDER BY salary DESC", query)
+
+	described, err := SchemaOf(schema)
+	assert.NoError(t, err)
+	assert.Equal(t, &schema, described)
+
+	// Fields without a column map to their name in strict mode too
+	qb := NewSqlBuilder(context.Background()).WithSelect("employees")
+	qb.SetStrictColumns(true)
+	qb, err = qb.Apply([]Filter{{Field: "id", Operator: OpEq, Value: 1}, {Field: "team.name", Operator: OpEq, Value: "core"}},
+		&QueryOptions{Sort: map[string]SortDirection{"salary": SortDesc}}, schema)
+	if assert.NoError(t, err) {
+		query, _, err := qb.ToSql()
+		assert.NoError(t, err)
+		assert.Equal(t, "SELECT * FROM employees WHERE (id = $1 AND "+
+			"EXISTS (SELECT 1 FROM teams WHERE teams.id = employees.team_id AND teams.name = $2)) ORDER BY salary DESC", query)
+	}
+}
+
+func TestCapabilityTags(t *testing.T) {
+	type Employee struct {
+		ID     int     `json:"id" db:"id"`
+		Salary float64 `json:"salary" db:"salary" query:"nofilter"`
+		Email  string  `json:"email" db:"email" query:"nosort"`
+	}
+	err := validateFields([]Filter{{Field: "salary", Operator: OpEq, Value: 1}}, nil, mustSchema(t, &Employee{}))
+	assert.EqualError(t, err, `field "salary" is not a valid JSON field`)
+	err = validateFields(nil, &QueryOptions{Sort: map[string]SortDirection{"email": SortAsc}}, mustSchema(t, &Employee{}))
+	assert.EqualError(t, err, `field "email" cannot be used for sorting`)
+	assert.NoError(t, validateFields([]Filter{{Field: "email", Operator: OpEq, Value: "a"}}, &QueryOptions{Sort: map[string]SortDirection{"salary": SortAsc}}, mustSchema(t, &Employee{})))
+}
+
+// mustSchema returns the schema of a model
+func mustSchema(t *testing.T, model any) *modelSchema {
+	t.Helper()
+	schema, err := schemaOf(model)
+	if err != nil {
+		t.Fatalf("failed to get model schema: %v", err)
+	}
+	return schema
+}
+
+func TestParseSchemaErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr string
+	}{
+		{name: "syntax", schema: `{"fields": [`, wantErr: "failed to parse schema: yaml: line 1: did not find expected node content"},
+		{name: "unknown key", schema: `{"fields": [{"name": "id", "type": "integer", "colum": "id"}]}`, wantErr: "failed to parse schema: yaml: unmarshal errors:\n  line 1: field colum not found in type queryparser.SchemaField"},
+		{name: "no fields", schema: `{"name": "Empty"}`, wantErr: "schema has no fields"},
+		{name: "no name", schema: `{"fields": [{"type": "string"}]}`, wantErr: "field 0 of schema has no name"},
+		{name: "duplicate", schema: `{"fields": [{"name": "a", "type": "string"}, {"name": "a", "type": "integer"}]}`, wantErr: `duplicate field "a"`},
+		{name: "dots", schema: `{"fields": [{"name": "a.b", "type": "string"}]}`, wantErr: `field "a.b" cannot contain dots`},
+		{name: "comma", schema: `{"fields": [{"name": "a,omitempty", "type": "string"}]}`, wantErr: `field "a,omitempty" is not a valid name`},
+		{name: "dash", schema: `{"fields": [{"name": "-", "type": "string"}]}`, wantErr: `field "-" is not a valid name`},
+		{name: "column with a comma", schema: `{"fields": [{"name": "a", "type": "string", "column": "a,b"}]}`, wantErr: `field "a" has invalid column "a,b"`},
+		{name: "dash column", schema: `{"fields": [{"name": "a", "type": "string", "column": "-"}]}`, wantErr: `field "a" has invalid column "-"`},
+		{
+			name:    "unknown type",
+			schema:  `{"fields": [{"name": "lines", "type": "[]object", "fields": [{"name": "sku", "type": "text"}]}]}`,
+			wantErr: `field "lines.sku" has unknown type "text", expected string, integer, number, boolean, time, any or object, optionally prefixed by []`,
+		},
+		{name: "empty object", schema: `{"fields": [{"name": "address", "type": "object"}]}`, wantErr: `field "address" has no fields`},
+		{name: "fields of a scalar", schema: `{"fields": [{"name": "qty", "type": "integer", "fields": [{"name": "a", "type": "string"}]}]}`, wantErr: `field "qty" has fields but is not an object`},
+		{name: "relation of a scalar", schema: `{"fields": [{"name": "owner", "type": "integer", "relation": {"table": "users"}}]}`, wantErr: `field "owner" has a relation but is not an object`},
+		{name: "unknown capability", schema: `{"fields": [{"name": "a", "type": "string", "capabilities": ["group"]}]}`, wantErr: `field "a" has unknown capability "group", expected filter, sort or search`},
+		{name: "invalid role", schema: `{"fields": [{"name": "a", "type": "string", "roles": ["admin,hr"]}]}`, wantErr: `field "a" has invalid role "admin,hr"`},
+		{name: "unknown storage", schema: `{"fields": [{"name": "a", "type": "any", "storage": "hstore"}]}`, wantErr: `field "a" has unknown storage "hstore", expected json or jsonb`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSchema([]byte(tt.schema))
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestCompiledSchemas(t *testing.T) {
+	schema, err := ParseSchema([]byte(itemSchema))
+	if !assert.NoError(t, err) {
+		return
+	}
+	// Equal schemas share their compiled schema and struct type
+	first, err := schema.compile()
+	assert.NoError(t, err)
+	copied := *schema
+	second, err := copied.compile()
+	assert.NoError(t, err)
+	assert.Same(t, first, second)
+
+	for i := 0; i <= maxCompiledSchemas; i++ {
+		_, err := Schema{Fields: []SchemaField{{Name: fmt.Sprintf("f%d", i), Type: TypeString}}}.compile()
+		assert.NoError(t, err)
+	}
+	compiledSchemas.Lock()
+	assert.Len(t, compiledSchemas.schemas, maxCompiledSchemas)
+	assert.Len(t, compiledSchemas.keys, maxCompiledSchemas)
+	compiledSchemas.Unlock()
+
+	// Evicted schemas are compiled again
+	third, err := schema.compile()
+	assert.NoError(t, err)
+	assert.NotSame(t, first, third)
+	assert.Equal(t, first.typ, third.typ)
+}
+
+func TestGoFieldName(t *testing.T) {
+	for name, want := range map[string]string{"created_at": "Created_at", "id": "Id", "1st": "F1st", "first-name": "First_name", "_x": "F_x"} {
+		assert.Equal(t, want, goFieldName(name))
+	}
+}
+
+func TestSchemaBuilders(t *testing.T) {
+	schema, err := ParseSchema([]byte(itemSchema))
+	if !assert.NoError(t, err) {
+		return
+	}
+	filters := []Filter{{Field: "created", Operator: OpGte, Value: "2024-05-01T00:00:00Z"}, {Field: "parts.sku", Operator: OpEq, Value: "A-1"}}
+
+	for _, model := range []any{schema, *schema} {
+		query, err := NewMongoBuilder().Query(filters, nil, model)
+		assert.NoError(t, err)
+		structQuery, err := NewMongoBuilder().Query(filters, nil, &Item{})
+		assert.NoError(t, err)
+		assert.Equal(t, structQuery, query)
+
+		mapping, err := ElasticMapping(model)
+		assert.NoError(t, err)
+		structMapping, err := ElasticMapping(&Item{})
+		assert.NoError(t, err)
+		assert.Equal(t, structMapping, mapping)
+
+		_, err = NewMongoBuilder().Query([]Filter{{Field: "color", Operator: OpEq, Value: "red"}}, nil, model)
+		assert.EqualError(t, err, `field "color" is not a valid JSON field`)
+	}
+
+	var nilSchema *Schema
+	_, err = NewMongoBuilder().Query(filters, nil, nilSchema)
+	assert.EqualError(t, err, "failed to get model schema: expected struct or pointer to struct, got nil *Schema")
+}
@@ -0,0 +1,49 @@
+package queryparser
+
+// Dialect selects the SQL flavour used for constructs that differ between
+// databases, such as full-text search. Placeholders are configured separately
+// with SetPlaceholderFormat.
+type Dialect int
+
+const (
+	// DialectPostgres targets PostgreSQL and is the default
+	DialectPostgres Dialect = iota
+	// DialectMySQL targets MySQL and MariaDB
+	DialectMySQL
+	// DialectSQLite targets SQLite
+	DialectSQLite
+	// DialectSQLServer targets Microsoft SQL Server
+	DialectSQLServer
+)
+
+// String returns the lowercase name of the dialect
+func (d Dialect) String() string {
+	switch d {
+	case DialectPostgres:
+		return "postgres"
+	case DialectMySQL:
+		return "mysql"
+	case DialectSQLite:
+		return "sqlite"
+	case DialectSQLServer:
+		return "sqlserver"
+	default:
+		return "unknown"
+	}
+}
+
+// SetDialect sets the SQL dialect. The default is DialectPostgres.
+//
+// Example:
+//
+//	qb := NewSqlBuilderWithPlaceholderFormat(ctx, squirrel.Question)
+//	qb.SetDialect(DialectMySQL)
+//	// {"$text": {"$search": "go"}} now generates: MATCH (title, body) AGAINST (?)
+func (qb *SqlBuilder) SetDialect(dialect Dialect) {
+	qb.dialect = dialect
+}
+
+// GetDialect returns the current SQL dialect
+func (qb *SqlBuilder) GetDialect() Dialect {
+	return qb.dialect
+}
@@ -0,0 +1,257 @@
+package queryparser
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// DSLBuilder compiles filters and options to the Elasticsearch query DSL as
+// plain maps and JSON. It depends on no client: ElasticBuilder wraps its
+// output for olivere/elastic/v7, and the esv8 and opensearch packages send it
+// with go-elasticsearch v8 and opensearch-go. Range queries use gt, gte, lt
+// and lte, which Elasticsearch 7 and 8 and OpenSearch all accept.
+type DSLBuilder struct {
+	clock      func() time.Time
+	scoring    ScoringMode
+	ctx        context.Context
+	authorizer Authorizer
+}
+
+// NewDSLBuilder creates a DSLBuilder
+func NewDSLBuilder() *DSLBuilder {
+	return &DSLBuilder{ctx: context.Background()}
+}
+
+// SetContext sets the context whose roles, set with WithRoles, are checked
+// against the roles of fields, and which is passed to the Authorizer. Without
+// roles in the context, fields tagged with roles are forbidden.
+//
+// Example:
+//
+//	b := NewDSLBuilder()
+//	b.SetContext(WithRoles(r.Context(), "support"))
+func (b *DSLBuilder) SetContext(ctx context.Context) {
+	b.ctx = ctx
+}
+
+// SetAuthorizer sets the Authorizer that Query, Aggregations and Source
+// consult for every use of a field. It requires a model.
+func (b *DSLBuilder) SetAuthorizer(auth Authorizer) {
+	b.authorizer = auth
+}
+
+// SetClock sets the clock used to resolve relative times. Without a clock,
+// date math such as "now-7d" is passed to Elasticsearch, which evaluates it
+// with its own clock, and only keywords such as "startOfMonth" are resolved
+// locally. With a clock, every relative time is resolved to a timestamp.
+func (b *DSLBuilder) SetClock(clock func() time.Time) {
+	b.clock = clock
+}
+
+// SetScoringMode sets which conditions contribute to the score. The default
+// is ScoreAll; QueryOptions.Scoring overrides it for a single request.
+func (b *DSLBuilder) SetScoringMode(mode ScoringMode) {
+	b.scoring = mode
+}
+
+// Source returns a complete search request body: the query, hit sorting and
+// pagination, the aggregations, and the highlighting, facets and post filter.
+// Grouped queries only return buckets, so they request no hits.
+func (b *DSLBuilder) Source(filters []Filter, options *QueryOptions, model any) (map[string]any, error) {
+	body, err := b.searchBody(filters, options, model)
+	if err != nil {
+		return nil, err
+	}
+	return body.source(), nil
+}
+
+// Body returns the search request body as JSON, ready to pass to a client,
+// e.g. with go-elasticsearch:
+//
+//	body, err := NewDSLBuilder().Body(filters, options, &Product{})
+//	res, err := es.Search(es.Search.WithIndex("products"), es.Search.WithBody(body))
+func (b *DSLBuilder) Body(filters []Filter, options *QueryOptions, model any) (io.Reader, error) {
+	source, err := b.Source(filters, options, model)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode search body: %w", err)
+	}
+	return bytes.NewReader(body), nil
+}
+
+// searchBody holds the parts of a search request body
+type searchBody struct {
+	query        map[string]any
+	aggregations map[string]map[string]any
+	highlight    []string
+	postFilter   map[string]any
+	sort         []map[string]any
+	size, from   *int
+	fields       []string
+}
+
+// searchBody compiles the parts of a search request body
+func (b *DSLBuilder) searchBody(filters []Filter, options *QueryOptions, model any) (*searchBody, error) {
+	query, err := b.Query(filters, options, model)
+	if err != nil {
+		return nil, err
+	}
+	body := &searchBody{query: query}
+
+	aggregations, err := b.Aggregations(options, model)
+	if err != nil {
+		return nil, err
+	}
+	if len(aggregations) > 0 {
+		body.aggregations = aggregations
+	}
+	if err := b.applySearchOptions(body, options, model); err != nil {
+		return nil, err
+	}
+
+	if options == nil {
+		return body, nil
+	}
+	if options.grouped() {
+		size := 0
+		body.size = &size
+		return body, nil
+	}
+
+	var schema *modelSchema
+	if model != nil {
+		// Query has already checked the model
+		schema, _ = schemaOf(model)
+	}
+	for _, sortField := range options.sortFields() {
+		path, err := sortPath(schema, sortField.Field, "sorting")
+		if err != nil {
+			return nil, err
+		}
+		order := "asc"
+		if sortField.Direction == SortDesc {
+			order = "desc"
+		}
+		body.sort = append(body.sort, map[string]any{path: map[string]any{"order": order}})
+	}
+	body.size = options.Limit
+	body.from = options.Offset
+	body.fields = options.Fields
+	return body, nil
+}
+
+// source returns the request body
+func (s *searchBody) source() map[string]any {
+	source := map[string]any{"query": s.query}
+	if len(s.aggregations) > 0 {
+		source["aggregations"] = s.aggregations
+	}
+	if len(s.highlight) > 0 {
+		fields := make(map[string]any, len(s.highlight))
+		for _, name := range s.highlight {
+			fields[name] = map[string]any{}
+		}
+		source["highlight"] = map[string]any{"fields": fields}
+	}
+	if s.postFilter != nil {
+		source["post_filter"] = s.postFilter
+	}
+	if len(s.sort) > 0 {
+		source["sort"] = s.sort
+	}
+	if s.size != nil {
+		source["size"] = *s.size
+	}
+	if s.from != nil {
+		source["from"] = *s.from
+	}
+	if len(s.fields) > 0 {
+		source["_source"] = map[string]any{"includes": s.fields}
+	}
+	return source
+}
+
+// boolQuery is a bool query being built. Like in the request bodies of the
+// Elasticsearch clients, a clause with one query is an object and a clause
+// with several an array.
+type boolQuery struct {
+	must, filter, mustNot, should []any
+	minimumShouldMatch            string
+}
+
+// source returns the query
+func (q *boolQuery) source() map[string]any {
+	clauses := make(map[string]any)
+	for _, clause := range []struct {
+		name    string
+		queries []any
+	}{{"must", q.must}, {"filter", q.filter}, {"must_not", q.mustNot}, {"should", q.should}} {
+		switch len(clause.queries) {
+		case 0:
+		case 1:
+			clauses[clause.name] = clause.queries[0]
+		default:
+			clauses[clause.name] = clause.queries
+		}
+	}
+	if q.minimumShouldMatch != "" {
+		clauses["minimum_should_match"] = q.minimumShouldMatch
+	}
+	return map[string]any{"bool": clauses}
+}
+
+// notQuery negates a query
+func notQuery(query map[string]any) map[string]any {
+	return (&boolQuery{mustNot: []any{query}}).source()
+}
+
+// termQuery matches an exact value
+func termQuery(field string, value any) map[string]any {
+	return map[string]any{"term": map[string]any{field: value}}
+}
+
+// termsQuery matches any of the values
+func termsQuery(field string, values []any) map[string]any {
+	return map[string]any{"terms": map[string]any{field: values}}
+}
+
+// rangeQuery matches values within bounds keyed by gt, gte, lt and lte
+func rangeQuery(field string, bounds map[string]any) map[string]any {
+	return map[string]any{"range": map[string]any{field: bounds}}
+}
+
+// existsQuery matches documents with a value for the field
+func existsQuery(field string) map[string]any {
+	return map[string]any{"exists": map[string]any{"field": field}}
+}
+
+// matchPhraseQuery matches analyzed text containing the phrase
+func matchPhraseQuery(field string, value any) map[string]any {
+	return map[string]any{"match_phrase": map[string]any{field: map[string]any{"query": value}}}
+}
+
+// matchAllQuery matches every document
+func matchAllQuery() map[string]any {
+	return map[string]any{"match_all": map[string]any{}}
+}
+
+// nestedQuery runs a query on the objects of a nested field
+func nestedQuery(path string, query map[string]any) map[string]any {
+	return map[string]any{"nested": map[string]any{"path": path, "query": query}}
+}
+
+// scriptSource returns a Painless script, as the bare source when it has no
+// parameters
+func scriptSource(source string, params map[string]any) any {
+	if len(params) == 0 {
+		return source
+	}
+	return map[string]any{"source": source, "params": params}
+}
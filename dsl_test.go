@@ -0,0 +1,105 @@
+package queryparser
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDSLBuilderQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters []Filter
+		want    string
+	}{
+		{
+			name:    "term",
+			filters: []Filter{{Field: "sku", Operator: OpEq, Value: "A-1"}},
+			want:    `{"bool":{"must":{"term":{"sku":"A-1"}}}}`,
+		},
+		{
+			name: "ranges use gt, gte, lt and lte",
+			filters: []Filter{
+				{Field: "price", Operator: OpGt, Value: 10},
+				{Field: "stock", Operator: OpBetween, Value: Between{From: 1, To: 5, Bounds: "[)"}},
+			},
+			want: `{"bool":{"must":[{"range":{"price":{"gt":10}}},{"range":{"stock":{"gte":1,"lt":5}}}]}}`,
+		},
+		{
+			name: "ranges in nested queries",
+			filters: []Filter{
+				{Operator: OpOr, Filters: []Filter{
+					{Field: "variants.size", Operator: OpLte, Value: 3},
+					{Field: "name", Operator: OpGte, Value: "m"},
+				}},
+			},
+			want: `{"bool":{"must":{"bool":{"minimum_should_match":"1","should":[` +
+				`{"nested":{"path":"variants","query":{"range":{"variants.size":{"lte":3}}}}},` +
+				`{"range":{"name.keyword":{"gte":"m"}}}]}}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewDSLBuilder().Query(tt.filters, nil, &Product{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			body, err := json.Marshal(got)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(body))
+		})
+	}
+
+	_, err := NewDSLBuilder().Query([]Filter{{Field: "color", Operator: OpEq, Value: "red"}}, nil, &Product{})
+	assert.EqualError(t, err, `field "color" is not a valid JSON field`)
+}
+
+func TestDSLBuilderBody(t *testing.T) {
+	b := NewDSLBuilder()
+	b.SetClock(fixedClock)
+	b.SetScoringMode(ScoreText)
+
+	body, err := b.Body(
+		[]Filter{{Field: "released", Operator: OpGte, Value: "now/d"}},
+		&QueryOptions{Sort: map[string]SortDirection{"name": SortAsc}, Limit: intPtr(20), Offset: intPtr(40)},
+		&Product{},
+	)
+	assert.NoError(t, err)
+	data, err := io.ReadAll(body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"from":40,"query":{"bool":{"filter":{"range":{"released":{"gte":"2024-05-15T00:00:00Z"}}}}},`+
+		`"size":20,"sort":[{"name.keyword":{"order":"asc"}}]}`, string(data))
+}
+
+func TestElasticBuilderWrapsDSL(t *testing.T) {
+	filters := []Filter{
+		{Field: "price", Operator: OpBetween, Value: []any{10, 20}},
+		{Operator: OpOr, Filters: []Filter{{Field: "name", Operator: OpEq, Value: "Desk"}, {Field: "variants.color", Operator: OpEq, Value: "red"}}},
+	}
+	options := &QueryOptions{
+		Sort:       map[string]SortDirection{"name": SortAsc},
+		Limit:      intPtr(20),
+		Fields:     []string{"id", "name"},
+		Highlight:  []string{"name"},
+		Facets:     []Facet{{Field: "brand"}, {Field: "price", Ranges: []FacetRange{{From: 0, To: 10}}}},
+		PostFilter: []Filter{{Field: "brand", Operator: OpEq, Value: "Acme"}},
+	}
+
+	body, err := NewDSLBuilder().Body(filters, options, &Product{})
+	assert.NoError(t, err)
+	want, err := io.ReadAll(body)
+	assert.NoError(t, err)
+
+	source, err := NewElasticBuilder(nil).SearchSource(filters, options, &Product{})
+	assert.NoError(t, err)
+	wrapped, err := source.Source()
+	assert.NoError(t, err)
+	got, err := json.Marshal(wrapped)
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(want), string(got))
+	// Range aggregations keep their from and to
+	assert.Contains(t, string(got), `"ranges":[{"from":0,"to":10}]`)
+}
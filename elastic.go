@@ -1,81 +1,544 @@
 package queryparser
 
 import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
 	"github.com/olivere/elastic/v7"
 )
 
+// ScoringMode selects which conditions ElasticBuilder lets contribute to the
+// relevance score
+type ScoringMode string
+
+const (
+	// ScoreAll puts every condition in bool.must. It is the default.
+	ScoreAll ScoringMode = "all"
+	// ScoreText puts full-text conditions ($text, $search) in bool.must and
+	// every other condition in bool.filter, which does not score and can be
+	// cached by Elasticsearch
+	ScoreText ScoringMode = "text"
+)
+
+// ElasticBuilder builds queries and search sources for olivere/elastic/v7.
+// The DSL is compiled by a DSLBuilder and wrapped in the olivere interfaces,
+// so both builders return the same request bodies.
 type ElasticBuilder struct {
-	ss *elastic.SearchService
+	ss  *elastic.SearchService
+	dsl *DSLBuilder
 }
 
 func NewElasticBuilder(ss *elastic.SearchService) *ElasticBuilder {
-	return &ElasticBuilder{ss: ss}
+	return &ElasticBuilder{ss: ss, dsl: NewDSLBuilder()}
 }
 
 // Apply will create a bool query and apply the filters to it.  It will then
-// return the query which can be used to execute the search.
+// return the query which can be used to execute the search.  When a model is
+// given, the filter and sort fields are validated against its JSON fields
+// and checked against their roles and the Authorizer, see SetContext.
 func (eb *ElasticBuilder) Apply(filters []Filter, options *QueryOptions, model any) (elastic.Query, error) {
-	q := elastic.NewBoolQuery()
+	query, err := eb.dsl.Query(filters, options, model)
+	if err != nil {
+		return nil, err
+	}
+	return rawDSL(query), nil
+}
+
+// SetScoringMode sets which conditions contribute to the score. The default
+// is ScoreAll; QueryOptions.Scoring overrides it for a single request.
+//
+// Example:
+//
+//	eb := NewElasticBuilder(ss)
+//	eb.SetScoringMode(ScoreText)
+//	// {"status": "open", "$text": {"$search": "go"}} now generates:
+//	// {"bool":{"filter":{"term":{"status":"open"}},"must":{"multi_match":{...}}}}
+func (eb *ElasticBuilder) SetScoringMode(mode ScoringMode) {
+	eb.dsl.SetScoringMode(mode)
+}
+
+// SetContext sets the context whose roles are checked against the roles of
+// fields, see DSLBuilder.SetContext
+func (eb *ElasticBuilder) SetContext(ctx context.Context) {
+	eb.dsl.SetContext(ctx)
+}
+
+// SetAuthorizer sets the Authorizer consulted for every use of a field, see
+// DSLBuilder.SetAuthorizer
+func (eb *ElasticBuilder) SetAuthorizer(auth Authorizer) {
+	eb.dsl.SetAuthorizer(auth)
+}
+
+// Aggregations builds the aggregations of the grouping options, see
+// DSLBuilder.Aggregations
+func (eb *ElasticBuilder) Aggregations(options *QueryOptions, model any) (map[string]elastic.Aggregation, error) {
+	aggregations, err := eb.dsl.Aggregations(options, model)
+	if err != nil || aggregations == nil {
+		return nil, err
+	}
+	wrapped := make(map[string]elastic.Aggregation, len(aggregations))
+	for name, agg := range aggregations {
+		wrapped[name] = rawDSL(agg)
+	}
+	return wrapped, nil
+}
+
+// SearchSource builds a complete search request body: the query, hit sorting
+// and pagination, the aggregations, and the highlighting, facets and post
+// filter. Grouped queries only return buckets, so they request no hits.
+func (eb *ElasticBuilder) SearchSource(filters []Filter, options *QueryOptions, model any) (*elastic.SearchSource, error) {
+	body, err := eb.dsl.searchBody(filters, options, model)
+	if err != nil {
+		return nil, err
+	}
+	source := elastic.NewSearchSource().Query(rawDSL(body.query))
+
+	names := make([]string, 0, len(body.aggregations))
+	for name := range body.aggregations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		source.Aggregation(name, rawDSL(body.aggregations[name]))
+	}
+	if len(body.highlight) > 0 {
+		highlight := elastic.NewHighlight()
+		for _, name := range body.highlight {
+			highlight.Fields(elastic.NewHighlighterField(name))
+		}
+		source.Highlight(highlight)
+	}
+	if body.postFilter != nil {
+		source.PostFilter(rawDSL(body.postFilter))
+	}
+	for _, sorter := range body.sort {
+		source.SortBy(rawDSL(sorter))
+	}
+	if body.size != nil {
+		source.Size(*body.size)
+	}
+	if body.from != nil {
+		source.From(*body.from)
+	}
+	if len(body.fields) > 0 {
+		source.FetchSourceIncludeExclude(body.fields, nil)
+	}
+	return source, nil
+}
+
+// Search applies the filters and options to the builder's search service
+// and returns it, ready to Do
+func (eb *ElasticBuilder) Search(filters []Filter, options *QueryOptions, model any) (*elastic.SearchService, error) {
+	if eb.ss == nil {
+		return nil, fmt.Errorf("elastic builder has no search service")
+	}
+	source, err := eb.SearchSource(filters, options, model)
+	if err != nil {
+		return nil, err
+	}
+	return eb.ss.SearchSource(source), nil
+}
+
+// rawDSL is a DSL object compiled by DSLBuilder. It implements the query,
+// aggregation and sorter interfaces of olivere/elastic/v7.
+type rawDSL map[string]any
+
+// Source returns the object as it is
+func (r rawDSL) Source() (interface{}, error) {
+	return map[string]any(r), nil
+}
 
+// Query compiles the filters to a bool query, e.g.
+// {"bool":{"must":{"term":{"status":"open"}}}}. When a model is given, the
+// filter and sort fields are validated against its JSON fields and checked
+// against their roles and the Authorizer, see SetContext.
+func (b *DSLBuilder) Query(filters []Filter, options *QueryOptions, model any) (map[string]any, error) {
+	var schema *modelSchema
+	if model != nil {
+		var err error
+		schema, err = schemaOf(model)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get model schema: %w", err)
+		}
+		if err := validateFields(filters, options, schema); err != nil {
+			return nil, err
+		}
+	}
+	if err := authorizeModel(b.ctx, filters, options, schema, b.authorizer); err != nil {
+		return nil, err
+	}
+
+	// Resolve relative times, passing date math through unless a clock is set
+	filters, err := resolveTimes(filters, schema, clockNow(b.clock), b.clock == nil)
+	if err != nil {
+		return nil, err
+	}
+
+	scoring := b.scoring
+	if options != nil && options.Scoring != "" {
+		scoring = options.Scoring
+	}
+	switch scoring {
+	case "", ScoreAll, ScoreText:
+	default:
+		return nil, fmt.Errorf("unknown scoring mode %q", scoring)
+	}
+	// Non-text conditions go in bool.filter. The mode is passed down rather
+	// than stored, so that concurrent and nested calls do not share it.
+	filterContext := scoring == ScoreText
+
+	q := &boolQuery{}
 	for _, filter := range filters {
-		subQuery, err := eb.buildQuery(filter)
+		subQuery, err := b.buildQuery(filter, schema, filterContext)
 		if err != nil {
 			return nil, err
 		}
-		q.Must(subQuery)
+		addClause(q, filter, subQuery, filterContext)
 	}
+	return q.source(), nil
+}
 
-	return q, nil
+// addClause adds a condition to a conjunction, in bool.filter when it does
+// not need to score
+func addClause(q *boolQuery, filter Filter, query map[string]any, filterContext bool) {
+	if filterContext && !containsText(filter) {
+		q.filter = append(q.filter, query)
+		return
+	}
+	q.must = append(q.must, query)
+}
+
+// containsText reports whether a filter tree contains a full-text condition
+func containsText(filter Filter) bool {
+	found := false
+	_ = Walk([]Filter{filter}, func(c *Cursor) error {
+		op := c.Filter().Operator
+		found = found || op == OpText || op == OpSearch
+		return nil
+	}, nil)
+	return found
 }
 
-// buildQuery recursively builds elastic queries from filters
-func (eb *ElasticBuilder) buildQuery(filter Filter) (elastic.Query, error) {
+// buildQuery recursively builds elastic queries from filters. In filter
+// context, conditions without full-text search do not score.
+func (b *DSLBuilder) buildQuery(filter Filter, schema *modelSchema, filterContext bool) (map[string]any, error) {
 	// Handle $or operator with nested filters
 	if filter.Operator == OpOr {
-		orQuery := elastic.NewBoolQuery()
+		orQuery := &boolQuery{minimumShouldMatch: "1"}
+		scored := filterContext && containsText(filter)
 		for _, nestedFilter := range filter.Filters {
-			subQuery, err := eb.buildQuery(nestedFilter)
+			subQuery, err := b.buildQuery(nestedFilter, schema, filterContext)
 			if err != nil {
 				return nil, err
 			}
-			orQuery.Should(subQuery)
+			// Alternatives without text match without adding to the score
+			if scored && !containsText(nestedFilter) {
+				subQuery = (&boolQuery{filter: []any{subQuery}}).source()
+			}
+			orQuery.should = append(orQuery.should, subQuery)
 		}
-		orQuery.MinimumNumberShouldMatch(1)
-		return orQuery, nil
+		return orQuery.source(), nil
 	}
 
 	// Handle $and operator with nested filters
 	if filter.Operator == OpAnd {
-		andQuery := elastic.NewBoolQuery()
+		andQuery := &boolQuery{}
 		for _, nestedFilter := range filter.Filters {
-			subQuery, err := eb.buildQuery(nestedFilter)
+			subQuery, err := b.buildQuery(nestedFilter, schema, filterContext)
 			if err != nil {
 				return nil, err
 			}
-			andQuery.Must(subQuery)
+			addClause(andQuery, nestedFilter, subQuery, filterContext)
 		}
-		return andQuery, nil
+		return andQuery.source(), nil
+	}
+
+	if filter.Operator == OpElemMatch {
+		query, err := b.buildElemMatch(filter, schema, filterContext)
+		if err != nil {
+			return nil, err
+		}
+		return wrapNested(query, filter.Field, schema), nil
+	}
+
+	query, err := b.buildLeafQuery(filter, schema)
+	if err != nil {
+		return nil, err
+	}
+	return wrapNested(query, filter.Field, schema), nil
+}
+
+// buildLeafQuery builds the query for a single field condition. Exact
+// conditions on text fields use their keyword subfield, or match_phrase
+// queries when they have none.
+func (b *DSLBuilder) buildLeafQuery(filter Filter, schema *modelSchema) (map[string]any, error) {
+	// field is the queried field, filter.Field names the field in errors
+	field := filter.Field
+	switch filter.Operator {
+	case OpExists, OpText, OpSearch:
+	default:
+		path, exact := keywordPath(schema, filter.Field)
+		if !exact {
+			return b.phraseQuery(filter)
+		}
+		field = path
 	}
 
-	// Handle regular operators
 	switch filter.Operator {
 	case OpEq:
-		return elastic.NewTermQuery(filter.Field, filter.Value), nil
+		// Null values are not indexed, so null is the absence of a value
+		if filter.Value == nil {
+			return notQuery(existsQuery(field)), nil
+		}
+		return termQuery(field, filter.Value), nil
 	case OpNe:
-		return elastic.NewBoolQuery().MustNot(elastic.NewTermQuery(filter.Field, filter.Value)), nil
+		if filter.Value == nil {
+			return existsQuery(field), nil
+		}
+		return notQuery(termQuery(field, filter.Value)), nil
 	case OpLt:
-		return elastic.NewRangeQuery(filter.Field).Lt(filter.Value), nil
+		return rangeQuery(field, map[string]any{"lt": filter.Value}), nil
 	case OpLte:
-		return elastic.NewRangeQuery(filter.Field).Lte(filter.Value), nil
+		return rangeQuery(field, map[string]any{"lte": filter.Value}), nil
 	case OpGt:
-		return elastic.NewRangeQuery(filter.Field).Gt(filter.Value), nil
+		return rangeQuery(field, map[string]any{"gt": filter.Value}), nil
 	case OpGte:
-		return elastic.NewRangeQuery(filter.Field).Gte(filter.Value), nil
+		return rangeQuery(field, map[string]any{"gte": filter.Value}), nil
 	case OpIn:
-		return elastic.NewTermsQuery(filter.Field, filter.Value.([]any)...), nil
+		values, err := setValues(filter)
+		if err != nil {
+			return nil, err
+		}
+		return termsQuery(field, values), nil
 	case OpNin:
-		return elastic.NewBoolQuery().MustNot(elastic.NewTermsQuery(filter.Field, filter.Value.([]any)...)), nil
+		values, err := setValues(filter)
+		if err != nil {
+			return nil, err
+		}
+		return notQuery(termsQuery(field, values)), nil
+	case OpLike:
+		pattern, err := likeValue(filter)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"wildcard": map[string]any{field: map[string]any{"value": "*" + likeWildcard(pattern) + "*"}}}, nil
+	case OpExists:
+		exists, ok := filter.Value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("$exists operator requires a boolean value for field %q", filter.Field)
+		}
+		if exists {
+			return existsQuery(field), nil
+		}
+		return notQuery(existsQuery(field)), nil
+	case OpBetween:
+		between, err := betweenValue(filter)
+		if err != nil {
+			return nil, err
+		}
+		bounds := make(map[string]any, 2)
+		if between.lowerInclusive() {
+			bounds["gte"] = between.From
+		} else {
+			bounds["gt"] = between.From
+		}
+		if between.upperInclusive() {
+			bounds["lte"] = between.To
+		} else {
+			bounds["lt"] = between.To
+		}
+		return rangeQuery(field, bounds), nil
+	case OpText, OpSearch:
+		return buildTextQuery(filter, schema)
+	case OpAll:
+		values, err := arrayValues(filter)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"terms_set": map[string]any{field: map[string]any{
+			"terms":                       values,
+			"minimum_should_match_script": map[string]any{"source": "params.num_terms"},
+		}}}, nil
+	case OpContains:
+		values, err := arrayValues(filter)
+		if err != nil {
+			return nil, err
+		}
+		return termsQuery(field, values), nil
+	case OpSize:
+		size, err := sizeValue(filter)
+		if err != nil {
+			return nil, err
+		}
+		script := scriptSource("doc[params.field].size() == params.size", map[string]any{"field": field, "size": size})
+		return map[string]any{"script": map[string]any{"script": script}}, nil
 	default:
-		return nil, nil
+		return nil, fmt.Errorf("unsupported operator: %s", filter.Operator)
+	}
+}
+
+// buildElemMatch builds $elemMatch. Conditions on fields of the elements are
+// combined in a single nested query when the field is declared es:"nested";
+// object arrays that are not nested are flattened by Elasticsearch, so their
+// conditions may match different elements. Range conditions on scalar
+// elements are merged into one range query so they apply to the same value.
+func (b *DSLBuilder) buildElemMatch(filter Filter, schema *modelSchema, filterContext bool) (map[string]any, error) {
+	// Prefix element fields with the array field, leaving nested $elemMatch
+	// conditions relative to their own array
+	prefixed, err := Rewrite(filter.Filters, func(c *Cursor) error {
+		f := c.Filter()
+		if f.Operator == OpOr || f.Operator == OpAnd {
+			return nil
+		}
+		if f.Field == "" {
+			f.Field = filter.Field
+		} else {
+			f.Field = filter.Field + "." + f.Field
+		}
+		c.Replace(f)
+		if f.Operator == OpElemMatch {
+			return SkipChildren
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Element conditions resolve keyword subfields and nested arrays of the
+	// elements through the model, but are not wrapped again in the nested
+	// query of the array itself
+	elements := insideNested(schema, filter.Field)
+	query := &boolQuery{}
+	var bounds map[string]any
+	for _, f := range prefixed {
+		if f.Field == filter.Field && isRangeOperator(f.Operator) {
+			if bounds == nil {
+				bounds = make(map[string]any)
+				query.must = append(query.must, rangeQuery(f.Field, bounds))
+			}
+			bounds[strings.TrimPrefix(string(f.Operator), "$")] = f.Value
+			continue
+		}
+		// Nested wrapping is applied once around the whole element query
+		subQuery, err := b.buildQuery(f, elements, filterContext)
+		if err != nil {
+			return nil, err
+		}
+		query.must = append(query.must, subQuery)
+	}
+
+	if schema != nil {
+		if chain, ok := schema.lookup(filter.Field); ok && chain[len(chain)-1].Nested {
+			return nestedQuery(filter.Field, query.source()), nil
+		}
+	}
+	return query.source(), nil
+}
+
+// insideNested returns a copy of schema in which the fields along path are
+// not nested, for the conditions of a query already wrapped in a nested query
+// on path
+func insideNested(schema *modelSchema, path string) *modelSchema {
+	if schema == nil {
+		return nil
+	}
+	segment, rest, more := strings.Cut(path, ".")
+	f, ok := schema.byJSON[segment]
+	if !ok {
+		return schema
+	}
+	field := *f
+	field.Nested = false
+	if more {
+		field.Children = insideNested(f.Children, rest)
+	}
+	copied := *schema
+	copied.byJSON = make(map[string]*schemaField, len(schema.byJSON))
+	for name, other := range schema.byJSON {
+		copied.byJSON[name] = other
+	}
+	copied.byJSON[segment] = &field
+	return &copied
+}
+
+// phraseQuery builds equality conditions on a text field without a keyword
+// subfield as match_phrase queries, the closest to an exact match on
+// analyzed text. Other conditions use the field as it is.
+func (b *DSLBuilder) phraseQuery(filter Filter) (map[string]any, error) {
+	if filter.Value == nil {
+		return b.buildLeafQuery(filter, nil)
+	}
+	switch filter.Operator {
+	case OpEq:
+		return matchPhraseQuery(filter.Field, filter.Value), nil
+	case OpNe:
+		return notQuery(matchPhraseQuery(filter.Field, filter.Value)), nil
+	case OpIn, OpNin:
+		values, err := setValues(filter)
+		if err != nil {
+			return nil, err
+		}
+		query := &boolQuery{}
+		for _, v := range values {
+			query.should = append(query.should, matchPhraseQuery(filter.Field, v))
+		}
+		if filter.Operator == OpNin {
+			return notQuery(query.source()), nil
+		}
+		query.minimumShouldMatch = "1"
+		return query.source(), nil
+	default:
+		return b.buildLeafQuery(filter, nil)
+	}
+}
+
+// likeWildcard converts a LIKE pattern to a wildcard pattern: % and _ become
+// * and ?, and wildcard characters in the text are escaped
+func likeWildcard(pattern string) string {
+	var b strings.Builder
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteByte('*')
+		case '_':
+			b.WriteByte('?')
+		case '*', '?', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// isRangeOperator reports whether an operator is a range comparison
+func isRangeOperator(op Operator) bool {
+	return op == OpLt || op == OpLte || op == OpGt || op == OpGte
+}
+
+// wrapNested wraps a query on a dotted path in a nested query for every
+// enclosing field declared as an Elasticsearch nested type, innermost first
+func wrapNested(query map[string]any, path string, schema *modelSchema) map[string]any {
+	if schema == nil {
+		return query
+	}
+	chain, ok := schema.lookup(path)
+	if !ok {
+		return query
+	}
+
+	segments := strings.Split(path, ".")
+	if len(segments) != len(chain) {
+		return query
+	}
+	for i := len(chain) - 2; i >= 0; i-- {
+		if chain[i].Nested {
+			query = nestedQuery(strings.Join(segments[:i+1], "."), query)
+		}
 	}
+	return query
 }
@@ -2,6 +2,8 @@ package queryparser
 
 import (
 	"encoding/json"
+	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/olivere/elastic/v7"
@@ -37,7 +39,7 @@ func TestElasticBuilder(t *testing.T) {
 			filters: []Filter{
 				{Field: "age", Operator: OpLt, Value: 25},
 			},
-			want:    `{"bool":{"must":{"range":{"age":{"from":null,"include_lower":true,"include_upper":false,"to":25}}}}}`,
+			want:    `{"bool":{"must":{"range":{"age":{"lt":25}}}}}`,
 			wantErr: false,
 		},
 		{
@@ -45,7 +47,7 @@ func TestElasticBuilder(t *testing.T) {
 			filters: []Filter{
 				{Field: "age", Operator: OpLte, Value: 25},
 			},
-			want:    `{"bool":{"must":{"range":{"age":{"from":null,"include_lower":true,"include_upper":true,"to":25}}}}}`,
+			want:    `{"bool":{"must":{"range":{"age":{"lte":25}}}}}`,
 			wantErr: false,
 		},
 		{
@@ -53,7 +55,7 @@ func TestElasticBuilder(t *testing.T) {
 			filters: []Filter{
 				{Field: "age", Operator: OpGt, Value: 25},
 			},
-			want:    `{"bool":{"must":{"range":{"age":{"from":25,"include_lower":false,"include_upper":true,"to":null}}}}}`,
+			want:    `{"bool":{"must":{"range":{"age":{"gt":25}}}}}`,
 			wantErr: false,
 		},
 		{
@@ -61,7 +63,7 @@ func TestElasticBuilder(t *testing.T) {
 			filters: []Filter{
 				{Field: "age", Operator: OpGte, Value: 25},
 			},
-			want:    `{"bool":{"must":{"range":{"age":{"from":25,"include_lower":true,"include_upper":true,"to":null}}}}}`,
+			want:    `{"bool":{"must":{"range":{"age":{"gte":25}}}}}`,
 			wantErr: false,
 		},
 		{
@@ -80,6 +82,22 @@ func TestElasticBuilder(t *testing.T) {
 			want:    `{"bool":{"must":{"bool":{"must_not":{"terms":{"age":[25,30,35]}}}}}}`,
 			wantErr: false,
 		},
+		{
+			name: "exists filter",
+			filters: []Filter{
+				{Field: "email", Operator: OpExists, Value: true},
+			},
+			want:    `{"bool":{"must":{"exists":{"field":"email"}}}}`,
+			wantErr: false,
+		},
+		{
+			name: "not exists filter",
+			filters: []Filter{
+				{Field: "email", Operator: OpExists, Value: false},
+			},
+			want:    `{"bool":{"must":{"bool":{"must_not":{"exists":{"field":"email"}}}}}}`,
+			wantErr: false,
+		},
 		{
 			name: "or filter with nested conditions",
 			filters: []Filter{
@@ -91,7 +109,7 @@ func TestElasticBuilder(t *testing.T) {
 					},
 				},
 			},
-			want:    `{"bool":{"must":{"bool":{"minimum_should_match":"1","should":[{"range":{"age":{"from":30,"include_lower":false,"include_upper":true,"to":null}}},{"range":{"age":{"from":null,"include_lower":true,"include_upper":false,"to":20}}}]}}}}`,
+			want:    `{"bool":{"must":{"bool":{"minimum_should_match":"1","should":[{"range":{"age":{"gt":30}}},{"range":{"age":{"lt":20}}}]}}}}`,
 			wantErr: false,
 		},
 		{
@@ -105,7 +123,7 @@ func TestElasticBuilder(t *testing.T) {
 					},
 				},
 			},
-			want:    `{"bool":{"must":{"bool":{"must":[{"range":{"age":{"from":20,"include_lower":false,"include_upper":true,"to":null}}},{"range":{"age":{"from":null,"include_lower":true,"include_upper":false,"to":30}}}]}}}}`,
+			want:    `{"bool":{"must":{"bool":{"must":[{"range":{"age":{"gt":20}}},{"range":{"age":{"lt":30}}}]}}}}`,
 			wantErr: false,
 		},
 		{
@@ -114,9 +132,16 @@ func TestElasticBuilder(t *testing.T) {
 				{Field: "age", Operator: OpGt, Value: 25},
 				{Field: "name", Operator: OpEq, Value: "John"},
 			},
-			want:    `{"bool":{"must":[{"range":{"age":{"from":25,"include_lower":false,"include_upper":true,"to":null}}},{"term":{"name":"John"}}]}}`,
+			want:    `{"bool":{"must":[{"range":{"age":{"gt":25}}},{"term":{"name":"John"}}]}}`,
 			wantErr: false,
 		},
+		{
+			name: "invalid field without JSON tag",
+			filters: []Filter{
+				{Field: "password", Operator: OpEq, Value: "secret"},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -149,3 +174,185 @@ func TestElasticBuilder(t *testing.T) {
 		})
 	}
 }
+
+func TestElasticBuilderNestedPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters []Filter
+		want    string
+	}{
+		{
+			name:    "object path",
+			filters: []Filter{{Field: "address.city", Operator: OpEq, Value: "Paris"}},
+			want:    `{"bool":{"must":{"term":{"address.city":"Paris"}}}}`,
+		},
+		{
+			name:    "nested path",
+			filters: []Filter{{Field: "lines.sku", Operator: OpEq, Value: "a"}},
+			want:    `{"bool":{"must":{"nested":{"path":"lines","query":{"term":{"lines.sku":"a"}}}}}}`,
+		},
+		{
+			name: "nested path inside $or",
+			filters: []Filter{
+				{Operator: OpOr, Filters: []Filter{
+					{Field: "lines.qty", Operator: OpGt, Value: 2},
+					{Field: "id", Operator: OpEq, Value: 1},
+				}},
+			},
+			want: `{"bool":{"must":{"bool":{"minimum_should_match":"1","should":[{"nested":{"path":"lines","query":{"range":{"lines.qty":{"gt":2}}}}},{"term":{"id":1}}]}}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewElasticBuilder(nil).Apply(tt.filters, nil, &Customer{})
+			if err != nil {
+				t.Fatalf("ElasticBuilder.Apply() error = %v", err)
+			}
+			source, err := got.Source()
+			if err != nil {
+				t.Fatalf("Error getting query source: %v", err)
+			}
+			sourceStr, err := json.Marshal(source)
+			if err != nil {
+				t.Fatalf("Error marshaling query source: %v", err)
+			}
+			if string(sourceStr) != tt.want {
+				t.Errorf("want %v; got %v", tt.want, string(sourceStr))
+			}
+		})
+	}
+
+	if _, err := NewElasticBuilder(nil).Apply([]Filter{{Field: "lines.color", Operator: OpEq, Value: "red"}}, nil, &Customer{}); err == nil {
+		t.Error("expected error for unknown nested field")
+	}
+}
+
+func TestElasticBuilderScoringMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    ScoringMode
+		filters []Filter
+		options *QueryOptions
+		want    string
+	}{
+		{
+			name: "default scores everything",
+			filters: []Filter{
+				{Operator: OpText, Value: "go"},
+				{Field: "author", Operator: OpEq, Value: "ann"},
+			},
+			want: `{"bool":{"must":[{"multi_match":{"fields":["title","body"],"query":"go"}},{"term":{"author":"ann"}}]}}`,
+		},
+		{
+			name: "text mode filters exact conditions",
+			mode: ScoreText,
+			filters: []Filter{
+				{Operator: OpText, Value: "go"},
+				{Field: "author", Operator: OpEq, Value: "ann"},
+				{Field: "id", Operator: OpGt, Value: 10},
+			},
+			want: `{"bool":{"filter":[{"term":{"author":"ann"}},{"range":{"id":{"gt":10}}}],"must":{"multi_match":{"fields":["title","body"],"query":"go"}}}}`,
+		},
+		{
+			name: "options override the builder",
+			mode: ScoreAll,
+			filters: []Filter{
+				{Field: "author", Operator: OpEq, Value: "ann"},
+			},
+			options: &QueryOptions{Scoring: ScoreText},
+			want:    `{"bool":{"filter":{"term":{"author":"ann"}}}}`,
+		},
+		{
+			name: "nested $and and $or",
+			mode: ScoreText,
+			filters: []Filter{
+				{Operator: OpAnd, Filters: []Filter{
+					{Field: "title", Operator: OpSearch, Value: "go"},
+					{Field: "author", Operator: OpNe, Value: "bob"},
+				}},
+				{Operator: OpOr, Filters: []Filter{
+					{Field: "body", Operator: OpSearch, Value: "generics"},
+					{Field: "author", Operator: OpEq, Value: "ann"},
+				}},
+			},
+			want: `{"bool":{"must":[{"bool":{"filter":{"bool":{"must_not":{"term":{"author":"bob"}}}},"must":{"match":{"title":{"query":"go"}}}}},` +
+				`{"bool":{"minimum_should_match":"1","should":[{"match":{"body":{"query":"generics"}}},{"bool":{"filter":{"term":{"author":"ann"}}}}]}}]}}`,
+		},
+		{
+			name: "$or without text is a filter",
+			mode: ScoreText,
+			filters: []Filter{
+				{Operator: OpOr, Filters: []Filter{
+					{Field: "author", Operator: OpEq, Value: "ann"},
+					{Field: "id", Operator: OpEq, Value: 1},
+				}},
+			},
+			want: `{"bool":{"filter":{"bool":{"minimum_should_match":"1","should":[{"term":{"author":"ann"}},{"term":{"id":1}}]}}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eb := NewElasticBuilder(nil)
+			eb.SetScoringMode(tt.mode)
+			got, err := eb.Apply(tt.filters, tt.options, &Article{})
+			if err != nil {
+				t.Fatalf("ElasticBuilder.Apply() error = %v", err)
+			}
+			source, err := got.Source()
+			if err != nil {
+				t.Fatalf("Error getting query source: %v", err)
+			}
+			sourceStr, err := json.Marshal(source)
+			if err != nil {
+				t.Fatalf("Error marshaling query source: %v", err)
+			}
+			if string(sourceStr) != tt.want {
+				t.Errorf("want %v; got %v", tt.want, string(sourceStr))
+			}
+		})
+	}
+
+	_, err := NewElasticBuilder(nil).Apply(nil, &QueryOptions{Scoring: "some"}, &Article{})
+	if err == nil || err.Error() != `unknown scoring mode "some"` {
+		t.Errorf("expected unknown scoring mode error, got %v", err)
+	}
+}
+
+func TestElasticBuilderConcurrentScoringModes(t *testing.T) {
+	eb := NewElasticBuilder(nil)
+	filters := []Filter{{Field: "author", Operator: OpEq, Value: "ann"}}
+	want := map[ScoringMode]string{
+		ScoreAll:  `{"bool":{"must":{"term":{"author":"ann"}}}}`,
+		ScoreText: `{"bool":{"filter":{"term":{"author":"ann"}}}}`,
+	}
+
+	// Requests with different scoring modes share the builder; run with -race
+	var wg sync.WaitGroup
+	errs := make(chan string, 200)
+	for mode, expected := range want {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				query, err := eb.Apply(filters, &QueryOptions{Scoring: mode}, &Article{})
+				if err != nil {
+					errs <- err.Error()
+					return
+				}
+				source, _ := query.Source()
+				got, _ := json.Marshal(source)
+				if string(got) != expected {
+					errs <- fmt.Sprintf("%s: want %s; got %s", mode, expected, got)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,233 @@
+package queryparser
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Facet requests bucket counts over a field for a filter sidebar. Without
+// Ranges it is a terms facet, counting the most frequent values; with Ranges
+// it counts the documents in each range.
+//
+// Facets are computed over the query but not over the post filter, except for
+// post filter conditions on other fields, so a facet keeps counting the values
+// the user can still add to its own selection.
+//
+// Example:
+//
+//	{"field": "brand", "size": 20}
+//	{"field": "price", "ranges": [{"to": 10}, {"from": 10, "to": 50}, {"key": "50+", "from": 50}]}
+type Facet struct {
+	Field  string       `json:"field"`
+	Alias  string       `json:"alias,omitempty"`
+	Size   int          `json:"size,omitempty"`
+	Ranges []FacetRange `json:"ranges,omitempty"`
+}
+
+// FacetRange is a bucket of a range facet. From is inclusive and To is
+// exclusive; either may be omitted for an open range. Date fields accept
+// Elasticsearch date math, e.g. "now-7d/d", as a date_range aggregation.
+type FacetRange struct {
+	Key  string `json:"key,omitempty"`
+	From any    `json:"from,omitempty"`
+	To   any    `json:"to,omitempty"`
+}
+
+// Name returns the aggregation name of the facet: the alias, or the field
+// with dots replaced by underscores
+func (f Facet) Name() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return strings.ReplaceAll(f.Field, ".", "_")
+}
+
+// highlightable reports whether an Elasticsearch type holds strings
+func highlightable(esType string) bool {
+	return esType == "text" || esType == "keyword"
+}
+
+// validateSearchOptions checks the Elasticsearch search options: highlight
+// fields, facets and the post filter
+func validateSearchOptions(options *QueryOptions, schema *modelSchema) error {
+	if options == nil {
+		return nil
+	}
+
+	for _, name := range options.Highlight {
+		if schema == nil {
+			continue
+		}
+		f := esLeaf(schema, name)
+		if f == nil {
+			return unknownField("field %q is not a valid JSON field for highlighting", name)
+		}
+		if !highlightable(f.ESType) {
+			return fmt.Errorf("field %q cannot be highlighted", name)
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, agg := range options.Aggregates {
+		names[agg.Name()] = true
+	}
+	if len(options.GroupBy) > 0 {
+		names[options.GroupBy[0]] = true
+	}
+	for _, facet := range options.Facets {
+		if facet.Field == "" {
+			return fmt.Errorf("facet requires a field")
+		}
+		name := facet.Name()
+		if !aliasPattern.MatchString(name) {
+			return fmt.Errorf("invalid facet alias %q", name)
+		}
+		if names[name] {
+			return fmt.Errorf("duplicate aggregation name %q", name)
+		}
+		names[name] = true
+		if facet.Size < 0 {
+			return fmt.Errorf("facet %q size must not be negative", name)
+		}
+		for i, r := range facet.Ranges {
+			if r.From == nil && r.To == nil {
+				return fmt.Errorf("range %d of facet %q requires from or to", i, name)
+			}
+		}
+
+		if schema == nil {
+			continue
+		}
+		chain, ok := schema.lookup(facet.Field)
+		if !ok || !chain[len(chain)-1].Filterable || chain[len(chain)-1].Children != nil {
+			return unknownField("field %q is not a valid JSON field for a facet", facet.Field)
+		}
+		if len(facet.Ranges) > 0 && !isNumeric(chain[len(chain)-1].Type) && !isTimeField(schema, facet.Field) {
+			return fmt.Errorf("range facet requires a numeric or date field, %q is not one", facet.Field)
+		}
+		if _, err := sortPath(schema, facet.Field, "a facet"); err != nil {
+			return err
+		}
+	}
+
+	if len(options.PostFilter) > 0 && schema != nil {
+		if err := validateFields(options.PostFilter, nil, schema); err != nil {
+			return fmt.Errorf("invalid post filter: %w", err)
+		}
+	}
+	return nil
+}
+
+// applySearchOptions adds highlighting, facets and the post filter to a
+// search body
+func (b *DSLBuilder) applySearchOptions(body *searchBody, options *QueryOptions, model any) error {
+	var schema *modelSchema
+	if model != nil {
+		var err error
+		if schema, err = schemaOf(model); err != nil {
+			return fmt.Errorf("failed to get model schema: %w", err)
+		}
+	}
+	if err := validateSearchOptions(options, schema); err != nil {
+		return err
+	}
+	if options == nil {
+		return nil
+	}
+
+	body.highlight = options.Highlight
+
+	for _, facet := range options.Facets {
+		agg, err := b.facetAggregation(facet, options.PostFilter, schema, model)
+		if err != nil {
+			return err
+		}
+		if body.aggregations == nil {
+			body.aggregations = make(map[string]map[string]any)
+		}
+		body.aggregations[facet.Name()] = agg
+	}
+
+	if len(options.PostFilter) > 0 {
+		postFilter, err := b.filterQuery(options.PostFilter, model)
+		if err != nil {
+			return err
+		}
+		body.postFilter = postFilter
+	}
+	return nil
+}
+
+// facetAggregation builds a facet as a filter aggregation over the post
+// filter conditions on other fields, holding the terms or range aggregation
+// under the same name. Every facet has this shape, so responses are read
+// the same way: aggregations.<name>.<name>.buckets.
+func (b *DSLBuilder) facetAggregation(facet Facet, postFilter []Filter, schema *modelSchema, model any) (map[string]any, error) {
+	path, err := sortPath(schema, facet.Field, "a facet")
+	if err != nil {
+		return nil, err
+	}
+
+	var inner map[string]any
+	if len(facet.Ranges) > 0 {
+		ranges := make([]any, len(facet.Ranges))
+		for i, r := range facet.Ranges {
+			ranges[i] = facetRange(r)
+		}
+		// date_range resolves date math such as "now-7d/d" in its bounds
+		kind := "range"
+		if isTimeField(schema, facet.Field) {
+			kind = "date_range"
+		}
+		inner = map[string]any{kind: map[string]any{"field": path, "ranges": ranges}}
+	} else {
+		terms := map[string]any{"field": path}
+		if facet.Size > 0 {
+			terms["size"] = facet.Size
+		}
+		inner = map[string]any{"terms": terms}
+	}
+
+	var others []Filter
+	for _, f := range postFilter {
+		own := false
+		for _, field := range Fields([]Filter{f}) {
+			own = own || field == facet.Field
+		}
+		if !own {
+			others = append(others, f)
+		}
+	}
+	query := matchAllQuery()
+	if len(others) > 0 {
+		if query, err = b.filterQuery(others, model); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]any{"filter": query, "aggregations": map[string]any{facet.Name(): inner}}, nil
+}
+
+// facetRange returns a bucket of a range aggregation. Times are formatted as
+// RFC 3339.
+func facetRange(r FacetRange) map[string]any {
+	bucket := make(map[string]any, 3)
+	if r.Key != "" {
+		bucket["key"] = r.Key
+	}
+	for key, value := range map[string]any{"from": r.From, "to": r.To} {
+		switch v := value.(type) {
+		case nil:
+		case time.Time:
+			bucket[key] = v.Format(time.RFC3339)
+		default:
+			bucket[key] = v
+		}
+	}
+	return bucket
+}
+
+// filterQuery builds filters that never score, such as the post filter
+func (b *DSLBuilder) filterQuery(filters []Filter, model any) (map[string]any, error) {
+	return b.Query(filters, &QueryOptions{Scoring: ScoreText}, model)
+}
@@ -0,0 +1,150 @@
+package queryparser
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSearchOptions(t *testing.T) {
+	options, err := ParseQueryOptions(`{
+		"highlight": ["name"],
+		"facets": [{"field": "brand", "size": 5}, {"field": "price", "alias": "prices", "ranges": [{"to": 10}, {"key": "cheap", "from": 10, "to": 50}]}],
+		"postFilter": {"brand": {"$in": ["Acme"]}}
+	}`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"name"}, options.Highlight)
+	assert.Equal(t, []Facet{
+		{Field: "brand", Size: 5},
+		{Field: "price", Alias: "prices", Ranges: []FacetRange{{To: float64(10)}, {Key: "cheap", From: float64(10), To: float64(50)}}},
+	}, options.Facets)
+	assert.Equal(t, []Filter{{Field: "brand", Operator: OpIn, Value: []any{"Acme"}}}, options.PostFilter)
+}
+
+func TestElasticSearchOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters []Filter
+		options *QueryOptions
+		want    string
+	}{
+		{
+			name:    "highlight $like matches",
+			filters: []Filter{{Field: "description", Operator: OpLike, Value: "oak_"}},
+			options: &QueryOptions{Highlight: []string{"description", "name"}},
+			want: `{"highlight":{"fields":{"description":{},"name":{}}},` +
+				`"query":{"bool":{"must":{"wildcard":{"description":{"value":"*oak?*"}}}}}}`,
+		},
+		{
+			name: "facets ignore their own selection",
+			options: &QueryOptions{
+				Facets: []Facet{
+					{Field: "brand", Size: 5},
+					{Field: "price", Ranges: []FacetRange{{To: 10}, {Key: "high", From: 10}}},
+				},
+				PostFilter: []Filter{
+					{Field: "brand", Operator: OpIn, Value: []any{"Acme"}},
+					{Field: "price", Operator: OpLt, Value: 10},
+				},
+			},
+			want: `{"aggregations":{` +
+				`"brand":{"aggregations":{"brand":{"terms":{"field":"brand.raw","size":5}}},` +
+				`"filter":{"bool":{"filter":{"range":{"price":{"lt":10}}}}}},` +
+				`"price":{"aggregations":{"price":{"range":{"field":"price","ranges":[{"to":10},{"from":10,"key":"high"}]}}},` +
+				`"filter":{"bool":{"filter":{"terms":{"brand.raw":["Acme"]}}}}}},` +
+				`"post_filter":{"bool":{"filter":[{"terms":{"brand.raw":["Acme"]}},{"range":{"price":{"lt":10}}}]}},` +
+				`"query":{"bool":{}}}`,
+		},
+		{
+			name: "date range facet",
+			options: &QueryOptions{
+				Facets: []Facet{{Field: "released", Ranges: []FacetRange{
+					{Key: "week", From: "now-7d/d"},
+					{Key: "2024", From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), To: "2025-01-01"},
+				}}},
+			},
+			want: `{"aggregations":{"released":{"aggregations":{"released":{"date_range":{"field":"released","ranges":[` +
+				`{"from":"now-7d/d","key":"week"},{"from":"2024-01-01T00:00:00Z","key":"2024","to":"2025-01-01"}]}}},` +
+				`"filter":{"match_all":{}}}},"query":{"bool":{}}}`,
+		},
+		{
+			name: "facet without post filter",
+			options: &QueryOptions{
+				Facets: []Facet{{Field: "variants.color", Alias: "colors"}},
+			},
+			want: `{"aggregations":{"colors":{"aggregations":{"colors":{"terms":{"field":"variants.color.keyword"}}},"filter":{"match_all":{}}}},"query":{"bool":{}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := NewElasticBuilder(nil).SearchSource(tt.filters, tt.options, &Product{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			body, err := source.Source()
+			assert.NoError(t, err)
+			bodyStr, err := json.Marshal(body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(bodyStr))
+		})
+	}
+}
+
+func TestElasticSearchOptionsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *QueryOptions
+		wantErr string
+	}{
+		{
+			name:    "unknown highlight field",
+			options: &QueryOptions{Highlight: []string{"color"}},
+			wantErr: `field "color" is not a valid JSON field for highlighting`,
+		},
+		{
+			name:    "highlight a number",
+			options: &QueryOptions{Highlight: []string{"price"}},
+			wantErr: `field "price" cannot be highlighted`,
+		},
+		{
+			name:    "unknown facet field",
+			options: &QueryOptions{Facets: []Facet{{Field: "color"}}},
+			wantErr: `field "color" is not a valid JSON field for a facet`,
+		},
+		{
+			name:    "terms facet on analyzed text",
+			options: &QueryOptions{Facets: []Facet{{Field: "description"}}},
+			wantErr: `field "description" is a text field without a keyword subfield and cannot be used for a facet`,
+		},
+		{
+			name:    "range facet on a keyword",
+			options: &QueryOptions{Facets: []Facet{{Field: "sku", Ranges: []FacetRange{{From: "a"}}}}},
+			wantErr: `range facet requires a numeric or date field, "sku" is not one`,
+		},
+		{
+			name:    "empty range",
+			options: &QueryOptions{Facets: []Facet{{Field: "price", Ranges: []FacetRange{{Key: "all"}}}}},
+			wantErr: `range 0 of facet "price" requires from or to`,
+		},
+		{
+			name:    "duplicate facet",
+			options: &QueryOptions{Facets: []Facet{{Field: "sku"}, {Field: "brand", Alias: "sku"}}},
+			wantErr: `duplicate aggregation name "sku"`,
+		},
+		{
+			name:    "invalid post filter",
+			options: &QueryOptions{PostFilter: []Filter{{Field: "color", Operator: OpEq, Value: "red"}}},
+			wantErr: `invalid post filter: field "color" is not a valid JSON field`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewElasticBuilder(nil).SearchSource(nil, tt.options, &Product{})
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package queryparser
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fingerprint returns a stable key of a query, such as a cache key for a
+// list endpoint: the hex SHA-256 of the filters and the options. Queries
+// share a fingerprint regardless of the order of the keys of the JSON filter,
+// the order of the children of $and and $or and of the values of $in and
+// $nin, the Go types of numbers, and pointers, which share the fingerprint
+// of the value they point to:
+//
+//	a, _ := ParseFilter(`{"status": "open", "$or": [{"qty": 1}, {"qty": 2}]}`)
+//	b, _ := ParseFilter(`{"$or": [{"qty": 2.0}, {"qty": 1}], "status": "open"}`)
+//	Fingerprint(a, nil) == Fingerprint(b, nil) // true
+//
+// Filters are not normalized first: Normalize merges conditions, and
+// filters that it rewrites alike, such as two contradictory ranges and an
+// empty $in, keep different fingerprints. Build the fingerprint from the
+// filters the query runs with, after references are resolved and variables
+// bound, so that it covers every value.
+func Fingerprint(filters []Filter, options *QueryOptions) string {
+	return fingerprint(filters, options, true)
+}
+
+// ShapeFingerprint returns a stable key of the shape of a query: its fields,
+// operators and options, without the values of conditions, limit and
+// offset. Queries that differ only by values share a shape fingerprint,
+// which makes it suitable for grouping metrics such as slow queries.
+// Filters that differ only by the order of their keys and children share a
+// shape like in Fingerprint. Conditions are not merged, so
+// {"$or": [{"a": 1}, {"a": 2}]} and {"a": {"$in": [1, 2]}} have different
+// shapes.
+func ShapeFingerprint(filters []Filter, options *QueryOptions) string {
+	return fingerprint(filters, options, false)
+}
+
+// fingerprint hashes the canonical form of a query, with or without values
+func fingerprint(filters []Filter, options *QueryOptions, values bool) string {
+	hash := sha256.New()
+	hash.Write([]byte(canonicalFilters(filters, values)))
+	hash.Write([]byte{0})
+	hash.Write([]byte(canonicalOptions(options, values)))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// canonicalFilters returns the canonical form of a conjunction of filters.
+// Only the order of keys and children is canonical; unlike Normalize, no
+// conditions are merged. Unlike filterKey, strings are quoted so that no two
+// filters share a form.
+func canonicalFilters(filters []Filter, values bool) string {
+	return canonicalList(filters, values)
+}
+
+// canonicalList returns the canonical forms of filters in sorted order, as
+// the children of $and, $or and $elemMatch commute
+func canonicalList(filters []Filter, values bool) string {
+	keys := make([]string, len(filters))
+	for i, f := range filters {
+		keys[i] = canonicalFilter(f, values)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// canonicalFilter returns the canonical form of a filter
+func canonicalFilter(f Filter, values bool) string {
+	switch f.Operator {
+	case OpAnd, OpOr:
+		return string(f.Operator) + "(" + canonicalList(f.Filters, values) + ")"
+	case OpElemMatch:
+		return strconv.Quote(f.Field) + " " + string(f.Operator) + "(" + canonicalList(f.Filters, values) + ")"
+	}
+
+	value := "?"
+	switch {
+	case f.Operator == OpRef:
+		// The name of a reference is part of the shape
+		value = canonicalValue(f.Value)
+	case !values:
+	case f.Operator == OpIn || f.Operator == OpNin:
+		value = canonicalSet(f.Value)
+	case f.Operator == OpBetween:
+		if b, err := betweenValue(f); err == nil {
+			if b.Bounds == "" {
+				b.Bounds = "[]"
+			}
+			value = canonicalValue(b)
+		} else {
+			value = canonicalValue(f.Value)
+		}
+	default:
+		value = canonicalValue(f.Value)
+	}
+	return strconv.Quote(f.Field) + " " + strconv.Quote(string(f.Operator)) + " " + value
+}
+
+// canonicalSet returns the canonical form of the values of $in and $nin:
+// their distinct canonical forms in sorted order
+func canonicalSet(v any) string {
+	values, ok := sliceValues(v)
+	if !ok {
+		return canonicalValue(v)
+	}
+	seen := make(map[string]bool, len(values))
+	parts := make([]string, 0, len(values))
+	for _, item := range values {
+		part := canonicalValue(item)
+		if !seen[part] {
+			seen[part] = true
+			parts = append(parts, part)
+		}
+	}
+	sort.Strings(parts)
+	return "[" + strings.Join(parts, ",") + "]"
+}
+
+// canonicalValue returns the canonical form of a value. Numbers of different
+// Go types with the same value share a form, like in valueKey, and integers
+// are encoded exactly, beyond the 2^53 of float64.
+func canonicalValue(v any) string {
+	switch value := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "s:" + strconv.Quote(value)
+	case Variable:
+		return "v:" + strconv.Quote(string(value))
+	case bool:
+		return "b:" + strconv.FormatBool(value)
+	case time.Time:
+		return "t:" + value.UTC().Format(time.RFC3339Nano)
+	case Between:
+		return "between(" + canonicalValue(value.From) + "," + canonicalValue(value.To) + "," + strconv.Quote(value.Bounds) + ")"
+	case map[string]any:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		parts := make([]string, len(keys))
+		for i, key := range keys {
+			parts[i] = strconv.Quote(key) + ":" + canonicalValue(value[key])
+		}
+		return "{" + strings.Join(parts, ",") + "}"
+	}
+	if values, ok := sliceValues(v); ok {
+		parts := make([]string, len(values))
+		for i, item := range values {
+			parts[i] = canonicalValue(item)
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	}
+	if n, ok := numberKey(v); ok {
+		return "n:" + n
+	}
+	return canonicalOther(v)
+}
+
+// canonicalOther returns the canonical form of a value of any other type.
+// Pointers share the form of the value they point to, and named string and
+// bool types that of their underlying type. Other values, such as structs
+// and maps of other types, are encoded as JSON along with their type; a
+// value that cannot be encoded, such as a func or a chan, is never a valid
+// query value, and all values of such a type share a form.
+func canonicalOther(v any) string {
+	if n, ok := v.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return canonicalValue(i)
+		}
+		if f, err := n.Float64(); err == nil {
+			return canonicalValue(f)
+		}
+		return "s:" + strconv.Quote(string(n))
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return "null"
+		}
+		return canonicalValue(rv.Elem().Interface())
+	case reflect.String:
+		return "s:" + strconv.Quote(rv.String())
+	case reflect.Bool:
+		return "b:" + strconv.FormatBool(rv.Bool())
+	case reflect.Slice:
+		// []byte and its named types
+		return "x:" + hex.EncodeToString(rv.Bytes())
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%T:!", v)
+	}
+	return fmt.Sprintf("%T:%s", v, data)
+}
+
+// canonicalOptions returns the canonical form of query options. The options
+// are encoded as JSON, whose object keys are sorted, and the having and post
+// filters, which are not part of their JSON, in canonical form.
+func canonicalOptions(options *QueryOptions, values bool) string {
+	if options == nil {
+		options = &QueryOptions{}
+	}
+	copied := *options
+	if !values {
+		// Pages share a shape
+		zero := 0
+		if copied.Limit != nil {
+			copied.Limit = &zero
+		}
+		if copied.Offset != nil {
+			copied.Offset = &zero
+		}
+	}
+	data, err := json.Marshal(copied)
+	if err != nil {
+		// Options are plain data; fall back to their Go syntax
+		data = []byte(fmt.Sprintf("%#v", copied))
+	}
+	return string(data) + "\x00" + canonicalFilters(options.Having, values) + "\x00" + canonicalFilters(options.PostFilter, values)
+}
@@ -0,0 +1,274 @@
+package queryparser
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fingerprintStatus string
+
+type fingerprintPoint struct{ X, Y int }
+
+func ptrTo[T any](v T) *T { return &v }
+
+func TestFingerprint(t *testing.T) {
+	parse := func(filter string) []Filter {
+		filters, err := ParseFilter(filter)
+		assert.NoError(t, err)
+		return filters
+	}
+
+	tests := []struct {
+		name      string
+		a, b      []Filter
+		same      bool
+		sameShape bool
+	}{
+		{
+			name:      "key order",
+			a:         parse(`{"status": "open", "qty": {"$gt": 1, "$lt": 9}}`),
+			b:         parse(`{"qty": {"$lt": 9, "$gt": 1}, "status": "open"}`),
+			same:      true,
+			sameShape: true,
+		},
+		{
+			name:      "commutative groups",
+			a:         parse(`{"$or": [{"status": "open"}, {"$and": [{"qty": 1}, {"name": "a"}]}]}`),
+			b:         parse(`{"$or": [{"$and": [{"name": "a"}, {"qty": 1}]}, {"status": "open"}]}`),
+			same:      true,
+			sameShape: true,
+		},
+		{
+			name:      "number types",
+			a:         []Filter{{Field: "qty", Operator: OpEq, Value: 1}},
+			b:         []Filter{{Field: "qty", Operator: OpEq, Value: 1.0}},
+			same:      true,
+			sameShape: true,
+		},
+		{
+			name:      "set order",
+			a:         parse(`{"status": {"$in": ["open", "new"]}}`),
+			b:         parse(`{"status": {"$in": ["new", "open", "new"]}}`),
+			same:      true,
+			sameShape: true,
+		},
+		{
+			name:      "range forms",
+			a:         parse(`{"qty": {"$between": [1, 5]}}`),
+			b:         []Filter{{Field: "qty", Operator: OpBetween, Value: Between{From: 1, To: 5, Bounds: "[]"}}},
+			same:      true,
+			sameShape: true,
+		},
+		{
+			name:      "values",
+			a:         parse(`{"status": "open", "qty": {"$gt": 1}}`),
+			b:         parse(`{"status": "closed", "qty": {"$gt": 100}}`),
+			sameShape: true,
+		},
+		{
+			name:      "value types",
+			a:         []Filter{{Field: "qty", Operator: OpEq, Value: "1"}},
+			b:         []Filter{{Field: "qty", Operator: OpEq, Value: 1}},
+			sameShape: true,
+		},
+		{
+			name: "fields",
+			a:    parse(`{"status": "open"}`),
+			b:    parse(`{"name": "open"}`),
+		},
+		{
+			name: "operators",
+			a:    parse(`{"qty": {"$gt": 1}}`),
+			b:    parse(`{"qty": {"$gte": 1}}`),
+		},
+		{
+			name: "groups",
+			a:    parse(`{"$or": [{"status": "open"}, {"qty": 1}]}`),
+			b:    parse(`{"$and": [{"status": "open"}, {"qty": 1}]}`),
+		},
+		{
+			name:      "quoted strings",
+			a:         []Filter{{Field: "a", Operator: OpEq, Value: "x"}, {Field: "b", Operator: OpEq, Value: "y"}},
+			b:         []Filter{{Field: "a", Operator: OpEq, Value: `x","b" "$eq" s:"y`}},
+			sameShape: false,
+		},
+		{
+			name:      "references",
+			a:         parse(`{"$ref": "open_tickets"}`),
+			b:         parse(`{"$ref": "my_tickets"}`),
+			sameShape: false,
+		},
+		{
+			name:      "variables",
+			a:         parse(`{"owner": "$$currentUser"}`),
+			b:         parse(`{"owner": "$currentUser"}`),
+			sameShape: true,
+		},
+		{
+			name:      "relative ranges",
+			a:         parse(`{"created": {"$gte": "startOfMonth", "$lte": "now"}}`),
+			b:         parse(`{"created": {"$gte": "now-1w", "$lte": "now-1d"}}`),
+			sameShape: true,
+		},
+		{
+			name:      "relative range and an empty set",
+			a:         parse(`{"created": {"$gte": "startOfMonth", "$lte": "now"}}`),
+			b:         parse(`{"created": {"$in": []}}`),
+			sameShape: false,
+		},
+		{
+			name:      "other relative range and an empty set",
+			a:         parse(`{"created": {"$gte": "now-1w", "$lte": "now-1d"}}`),
+			b:         parse(`{"created": {"$in": []}}`),
+			sameShape: false,
+		},
+		{
+			name:      "contradictory ranges and an empty set",
+			a:         parse(`{"qty": {"$gt": 5, "$lt": 2}}`),
+			b:         parse(`{"qty": {"$in": []}}`),
+			sameShape: false,
+		},
+		{
+			name:      "overlapping bounds",
+			a:         parse(`{"$and": [{"created": {"$gt": "now-7d"}}, {"created": {"$gt": "now-1d"}}]}`),
+			b:         parse(`{"created": {"$gt": "now-7d"}}`),
+			sameShape: false,
+		},
+		{
+			name:      "merged conditions",
+			a:         parse(`{"$or": [{"qty": 1}, {"qty": 2}]}`),
+			b:         parse(`{"qty": {"$in": [1, 2]}}`),
+			sameShape: false,
+		},
+		{
+			name:      "integers beyond float64",
+			a:         []Filter{{Field: "id", Operator: OpEq, Value: int64(1<<53 + 1)}},
+			b:         []Filter{{Field: "id", Operator: OpEq, Value: int64(1 << 53)}},
+			sameShape: true,
+		},
+		{
+			name:      "integer sets beyond float64",
+			a:         []Filter{{Field: "id", Operator: OpIn, Value: []int64{1<<53 + 1, 1}}},
+			b:         []Filter{{Field: "id", Operator: OpIn, Value: []int64{1, 1 << 53}}},
+			sameShape: true,
+		},
+		{
+			name:      "pointers",
+			a:         []Filter{{Field: "qty", Operator: OpEq, Value: ptrTo(5)}},
+			b:         []Filter{{Field: "qty", Operator: OpEq, Value: ptrTo(5.0)}},
+			same:      true,
+			sameShape: true,
+		},
+		{
+			name:      "pointers to other values",
+			a:         []Filter{{Field: "qty", Operator: OpEq, Value: ptrTo(5)}},
+			b:         []Filter{{Field: "qty", Operator: OpEq, Value: ptrTo(6)}},
+			sameShape: true,
+		},
+		{
+			name:      "nil pointers",
+			a:         []Filter{{Field: "qty", Operator: OpEq, Value: (*int)(nil)}},
+			b:         []Filter{{Field: "qty", Operator: OpEq, Value: nil}},
+			same:      true,
+			sameShape: true,
+		},
+		{
+			name:      "named strings",
+			a:         []Filter{{Field: "status", Operator: OpIn, Value: []fingerprintStatus{"open", "new"}}},
+			b:         []Filter{{Field: "status", Operator: OpIn, Value: []string{"new", "open"}}},
+			same:      true,
+			sameShape: true,
+		},
+		{
+			name:      "structs",
+			a:         []Filter{{Field: "point", Operator: OpEq, Value: fingerprintPoint{X: 1, Y: 2}}},
+			b:         []Filter{{Field: "point", Operator: OpEq, Value: fingerprintPoint{X: 2, Y: 1}}},
+			sameShape: true,
+		},
+		{
+			name:      "json numbers",
+			a:         []Filter{{Field: "id", Operator: OpEq, Value: json.Number("9007199254740993")}},
+			b:         []Filter{{Field: "id", Operator: OpEq, Value: int64(1<<53 + 1)}},
+			same:      true,
+			sameShape: true,
+		},
+		{
+			name:      "times",
+			a:         []Filter{{Field: "created", Operator: OpGt, Value: time.Date(2024, 5, 15, 14, 0, 0, 0, time.FixedZone("CEST", 7200))}},
+			b:         []Filter{{Field: "created", Operator: OpGt, Value: time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)}},
+			same:      true,
+			sameShape: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.same, Fingerprint(tt.a, nil) == Fingerprint(tt.b, nil))
+			assert.Equal(t, tt.sameShape, ShapeFingerprint(tt.a, nil) == ShapeFingerprint(tt.b, nil))
+		})
+	}
+}
+
+func TestFingerprintOptions(t *testing.T) {
+	filters := []Filter{{Field: "status", Operator: OpEq, Value: "open"}}
+	limit, otherLimit, offset := 20, 50, 40
+	parse := func(options string) *QueryOptions {
+		parsed, err := ParseQueryOptions(options)
+		assert.NoError(t, err)
+		return parsed
+	}
+
+	tests := []struct {
+		name      string
+		a, b      *QueryOptions
+		same      bool
+		sameShape bool
+	}{
+		{name: "nil and empty", a: nil, b: &QueryOptions{}, same: true, sameShape: true},
+		{
+			name:      "sort order of keys",
+			a:         parse(`{"sort": {"name": "asc", "qty": "desc"}, "limit": 20}`),
+			b:         parse(`{"limit": 20, "sort": {"qty": "desc", "name": "asc"}}`),
+			same:      true,
+			sameShape: true,
+		},
+		{name: "sort direction", a: parse(`{"sort": {"name": "asc"}}`), b: parse(`{"sort": {"name": "desc"}}`)},
+		{name: "pages", a: &QueryOptions{Limit: &limit}, b: &QueryOptions{Limit: &otherLimit, Offset: &offset}, sameShape: false},
+		{name: "page sizes", a: &QueryOptions{Limit: &limit}, b: &QueryOptions{Limit: &otherLimit}, sameShape: true},
+		{name: "selection", a: parse(`{"fields": ["id"]}`), b: parse(`{"fields": ["id", "name"]}`)},
+		{
+			name:      "having",
+			a:         parse(`{"groupBy": ["category"], "having": {"count": {"$gt": 1}}}`),
+			b:         parse(`{"groupBy": ["category"], "having": {"count": {"$gt": 5}}}`),
+			sameShape: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.same, Fingerprint(filters, tt.a) == Fingerprint(filters, tt.b))
+			assert.Equal(t, tt.sameShape, ShapeFingerprint(filters, tt.a) == ShapeFingerprint(filters, tt.b))
+		})
+	}
+
+	assert.Len(t, Fingerprint(filters, nil), 64)
+	assert.NotEqual(t, Fingerprint(filters, nil), ShapeFingerprint(filters, nil))
+}
+
+func TestFingerprintConformance(t *testing.T) {
+	// Encoding a filter and parsing it back does not change its fingerprint
+	for _, tt := range conformanceCorpus {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := ParseFilter(tt.filter)
+			assert.NoError(t, err)
+			data, err := MarshalFilter(filters)
+			assert.NoError(t, err)
+			reparsed, err := ParseFilter(string(data))
+			assert.NoError(t, err)
+			assert.Equal(t, Fingerprint(filters, nil), Fingerprint(reparsed, nil))
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package queryparser
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// fuzzModels cover the features of the builders: arrays, searchable fields,
+// relations, JSON columns and nested documents
+var fuzzModels = []any{&Item{}, &RelUser{}, &Customer{}, &JSONBAccount{}}
+
+// fuzzSeeds are filters for the fuzz corpus, well-formed and malformed
+var fuzzSeeds = []string{
+	`{}`,
+	`{"name": "John", "qty": {"$gt": 2}}`,
+	`{"$or": [{"id": 1}, {"name": {"$like": "a'b"}}]}`,
+	`{"$and": [{"qty": {"$in": [1, 2]}}, {"category": null}]}`,
+	`{"name": {"$like": 5}}`,
+	`{"qty": {"$in": 3}}`,
+	`{"qty": {"$nin": "x"}}`,
+	`{"category": {"$exists": "yes"}}`,
+	`{"tags": {"$all": ["a"], "$size": 1.5}}`,
+	`{"parts": {"$elemMatch": {"sku": "x", "qty": {"$lt": 2}}}}`,
+	`{"tags": {"$elemMatch": "x"}}`,
+	`{"$text": {"$search": ""}}`,
+	`{"$text": 1}`,
+	`{"created": {"$between": ["now-7d", "now/d"]}}`,
+	`{"created": {"$between": {"from": 1}}}`,
+	`{"price": {"$between": [1, 2, 3]}}`,
+	`{"orders.total": {"$gt": 10}}`,
+	`{"orders": {"$elemMatch": {"total": 1, "status": "paid"}}}`,
+	`{"billing.city": "Paris", "lines.qty": 2}`,
+	`{"settings.theme": {"$exists": true}}`,
+	`{"$or": "x"}`,
+	`{"$or": [1, "x"]}`,
+	`{"name": {"$unknown": 1}}`,
+	`{"name": {"$eq": {"$eq": 1}}}`,
+	`{"name": [1, 2]}`,
+}
+
+func FuzzParseFilterSQL(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		filters, err := ParseFilter(input)
+		if err != nil {
+			return
+		}
+		_ = Normalize(filters)
+		for _, model := range fuzzModels {
+			qb, err := NewSqlBuilder(context.Background()).WithSelect("t").Apply(filters, nil, model)
+			if err != nil {
+				continue
+			}
+			sql, args, err := qb.ToSql()
+			if err != nil {
+				continue
+			}
+			assertParameterized(t, filters, sql, args)
+		}
+	})
+}
+
+func FuzzParseFilterElastic(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		filters, err := ParseFilter(input)
+		if err != nil {
+			return
+		}
+		for _, model := range append([]any{nil}, fuzzModels...) {
+			q, err := NewElasticBuilder(nil).Apply(filters, nil, model)
+			if err != nil {
+				continue
+			}
+			source, err := q.Source()
+			if err != nil {
+				continue
+			}
+			if _, err := json.Marshal(source); err != nil {
+				t.Errorf("query source of %s cannot be encoded: %v", input, err)
+			}
+		}
+		_, _ = NewSliceBuilder[Item]().Apply(items, filters, nil)
+	})
+}
+
+func FuzzParseQueryOptions(f *testing.F) {
+	f.Add(`{"sort": {"name": "asc"}, "limit": 10, "offset": 5}`)
+	f.Add(`{"groupBy": ["category"], "aggregates": [{"func": "count"}], "having": {"count": {"$gt": 1}}}`)
+	f.Add(`{"facets": [{"field": "qty", "ranges": [{"to": 1}]}], "postFilter": {"qty": 1}, "fields": ["id"]}`)
+	f.Add(`{"sort": {"name": "sideways"}, "limit": -1}`)
+	f.Fuzz(func(t *testing.T, input string) {
+		options, err := ParseQueryOptions(input)
+		if err != nil {
+			return
+		}
+		qb, err := NewSqlBuilder(context.Background()).WithSelect("t").Apply(nil, options, &Item{})
+		if err == nil {
+			_, _, _ = qb.ToSql()
+		}
+		if source, err := NewElasticBuilder(nil).SearchSource(nil, options, &Item{}); err == nil {
+			_, _ = source.Source()
+		}
+		_, _ = NewSliceBuilder[Item]().Apply(items, nil, options)
+	})
+}
+
+// placeholderPattern matches Dollar placeholders
+var placeholderPattern = regexp.MustCompile(`\$(\d+)`)
+
+// assertParameterized checks that values are passed as arguments: the
+// placeholders are numbered 1 to len(args), and string values that could
+// change the meaning of the SQL are not part of it
+func assertParameterized(t *testing.T, filters []Filter, sql string, args []any) {
+	t.Helper()
+	highest := 0
+	for _, match := range placeholderPattern.FindAllStringSubmatch(sql, -1) {
+		n, _ := strconv.Atoi(match[1])
+		highest = max(highest, n)
+	}
+	if highest != len(args) {
+		t.Errorf("%s has %d placeholders for %d arguments", sql, highest, len(args))
+	}
+
+	_ = Walk(filters, func(c *Cursor) error {
+		values, ok := sliceValues(c.Filter().Value)
+		if !ok {
+			values = []any{c.Filter().Value}
+		}
+		for _, v := range values {
+			s, ok := v.(string)
+			if ok && len(s) > 2 && strings.IndexFunc(s, isSQLSpecial) >= 0 && strings.Contains(sql, s) {
+				t.Errorf("value %q is part of %s", s, sql)
+			}
+		}
+		return nil
+	}, nil)
+}
+
+// isSQLSpecial reports whether a rune can end a literal or a statement
+func isSQLSpecial(r rune) bool {
+	return r == '\'' || r == '"' || r == ';' || r == '\\' || unicode.IsControl(r)
+}
+
+func FuzzNormalizePreservesResults(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Add(`{"qty": {"$gt": 1, "$gte": 2, "$in": [1, 2, 5]}, "$or": [{"qty": 2}, {"qty": 2}]}`)
+	f.Add(`{"$and": [{"price": {"$lt": 50}}, {"price": {"$lt": 20}}], "category": {"$nin": ["a"]}}`)
+	f.Add(`{"$and": [{"tags": "a"}, {"tags": "b"}, {"parts.qty": {"$gt": 5}}, {"parts.qty": {"$lt": 2}}]}`)
+	f.Fuzz(func(t *testing.T, input string) {
+		filters, err := ParseFilter(input)
+		if err != nil {
+			return
+		}
+		want, err := NewSliceBuilder[Item]().Apply(items, filters, nil)
+		if err != nil {
+			return
+		}
+		normalized, err := NormalizeModel(filters, &Item{})
+		if err != nil {
+			t.Fatalf("normalizing %s fails: %v", input, err)
+		}
+		got, err := NewSliceBuilder[Item]().Apply(items, normalized, nil)
+		if err != nil {
+			t.Fatalf("normalized %s fails: %v", input, err)
+		}
+		if !slices.Equal(itemIDs(want), itemIDs(got)) {
+			t.Errorf("normalizing %s selects %v instead of %v", input, itemIDs(got), itemIDs(want))
+		}
+	})
+}
+
+func TestMalformedValues(t *testing.T) {
+	tests := []struct {
+		filter  string
+		wantErr string
+	}{
+		{filter: `{"name": {"$like": 5}}`, wantErr: `$like operator requires a string value for field "name"`},
+		{filter: `{"qty": {"$in": 3}}`, wantErr: `$in operator requires an array for field "qty"`},
+		{filter: `{"qty": {"$nin": "x"}}`, wantErr: `$nin operator requires an array for field "qty"`},
+		{filter: `{"category": {"$exists": "yes"}}`, wantErr: `$exists operator requires a boolean value for field "category"`},
+		{filter: `{"name": {"$regex": "a"}}`, wantErr: "unsupported operator: $regex"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			filters, err := ParseFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("ParseFilter() error = %v", err)
+			}
+
+			_, err = NewSqlBuilder(context.Background()).WithSelect("items").Apply(filters, nil, &Item{})
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("SqlBuilder error = %v, want %s", err, tt.wantErr)
+			}
+			_, err = NewElasticBuilder(nil).Apply(filters, nil, &Item{})
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("ElasticBuilder error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
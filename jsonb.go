@@ -0,0 +1,212 @@
+package queryparser
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// jsonKeyPattern matches the keys allowed below a schemaless JSON column.
+// Keys are inlined as SQL literals, so anything else is rejected.
+var jsonKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// jsonPath is a location inside a JSON column
+type jsonPath struct {
+	column string       // column expression of the JSON column
+	keys   []string     // object keys below the column
+	leaf   reflect.Type // Go type of the value, nil when the path is schemaless
+	jsonb  bool         // the column is jsonb and supports @> and ?
+}
+
+// jsonPath resolves a field name or dotted path inside a JSON column
+func (r columnResolver) jsonPath(path string) (*jsonPath, bool) {
+	chain, ok := r.schema.lookup(path)
+	if !ok || !chain[0].JSONColumn || chain[0].Relation != nil {
+		return nil, false
+	}
+
+	column, ok := r.fieldColumn(chain[0])
+	if !ok {
+		column = chain[0].JSON
+	}
+	if r.table != "" {
+		column = r.table + "." + column
+	}
+
+	segments := strings.Split(path, ".")
+	if _, exact := r.schema.byJSON[path]; exact {
+		segments = []string{path}
+	}
+	jp := &jsonPath{
+		column: column,
+		keys:   segments[1:],
+		jsonb:  chain[0].JSONB,
+	}
+	if len(chain) == len(segments) {
+		jp.leaf = chain[len(chain)-1].Type
+	}
+	return jp, true
+}
+
+// container returns the expression of the JSON value at the first n keys
+func (jp *jsonPath) container(n int) string {
+	var b strings.Builder
+	b.WriteString(jp.column)
+	for _, key := range jp.keys[:n] {
+		b.WriteString("->")
+		b.WriteString(quoteLiteral(key))
+	}
+	return b.String()
+}
+
+// extract returns the text of the value at the path, cast to the given
+// Postgres type when cast is not empty
+func (jp *jsonPath) extract(cast string) string {
+	if len(jp.keys) == 0 {
+		return jp.column
+	}
+	expr := jp.container(len(jp.keys)-1) + "->>" + quoteLiteral(jp.keys[len(jp.keys)-1])
+	if cast == "" {
+		return expr
+	}
+	return "(" + expr + ")::" + cast
+}
+
+// validateJSONKeys checks the keys of a path below a schemaless JSON column
+func validateJSONKeys(path string, chain []*schemaField) error {
+	segments := strings.Split(path, ".")
+	for _, key := range segments[len(chain):] {
+		if !jsonKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid JSON key %q in field %q", key, path)
+		}
+	}
+	return nil
+}
+
+// buildJSONCondition converts a condition on a JSON column into a Squirrel
+// condition. Values are always passed as arguments.
+//
+//   - $exists checks for the key with the jsonb ? operator
+//   - $eq and $ne with an object value use jsonb containment (@>)
+//   - $exists false and $ne match rows where the column or a parent key is
+//     NULL
+//   - other operators compare the extracted text, cast to the type of the
+//     model field or, for schemaless paths, of the value
+func (qb *SqlBuilder) buildJSONCondition(filter Filter, jp *jsonPath) (squirrel.Sqlizer, error) {
+	switch {
+	case filter.Operator == OpExists:
+		exists, ok := filter.Value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("$exists operator requires a boolean value for field %q", filter.Field)
+		}
+		if len(jp.keys) == 0 {
+			return qb.buildFieldCondition(filter, jp.column)
+		}
+
+		if !jp.jsonb {
+			if !exists {
+				return squirrel.Expr(jp.container(len(jp.keys)) + " IS NULL"), nil
+			}
+			return squirrel.Expr(jp.container(len(jp.keys)) + " IS NOT NULL"), nil
+		}
+		// ?? is squirrel's escape for a literal ? operator
+		condition := squirrel.Expr(jp.container(len(jp.keys)-1)+" ?? ?", jp.keys[len(jp.keys)-1])
+		if !exists {
+			return notTrue(condition), nil
+		}
+		return condition, nil
+
+	case (filter.Operator == OpEq || filter.Operator == OpNe) && isJSONObject(filter.Value):
+		if !jp.jsonb {
+			return nil, fmt.Errorf("comparing objects in field %q requires a jsonb column", filter.Field)
+		}
+		doc, err := json.Marshal(filter.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode value for field %q: %w", filter.Field, err)
+		}
+		condition := squirrel.Expr(jp.container(len(jp.keys))+" @> ?::jsonb", string(doc))
+		if filter.Operator == OpNe {
+			return notTrue(condition), nil
+		}
+		return condition, nil
+
+	default:
+		cast := ""
+		if len(jp.keys) > 0 && filter.Operator != OpLike {
+			if jp.leaf != nil {
+				cast = sqlCast(jp.leaf)
+			} else {
+				cast = valueCast(filter.Value)
+			}
+		}
+		return qb.buildFieldCondition(filter, jp.extract(cast))
+	}
+}
+
+// notTrue negates a JSON condition. The ? and @> operators are NULL when the
+// column or a parent key is NULL, which NOT keeps NULL, so a missing value
+// counts as false.
+func notTrue(condition squirrel.Sqlizer) squirrel.Sqlizer {
+	return squirrel.Expr("NOT COALESCE(?, false)", condition)
+}
+
+// isJSONObject reports whether a filter value is an object that should be
+// compared as a JSON document
+func isJSONObject(v any) bool {
+	if v == nil {
+		return false
+	}
+	typ := reflect.TypeOf(v)
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ.Kind() == reflect.Map || (typ.Kind() == reflect.Struct && typ != timeType)
+}
+
+// sqlCast returns the Postgres type that extracted JSON text must be cast to
+// for comparisons with values of the given Go type
+func sqlCast(typ reflect.Type) string {
+	if typ == nil {
+		return ""
+	}
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == timeType {
+		return "timestamptz"
+	}
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "numeric"
+	case reflect.Bool:
+		return "boolean"
+	default:
+		return ""
+	}
+}
+
+// valueCast returns the Postgres cast for a schemaless JSON path based on the
+// filter value, using the first element for $in and $nin
+func valueCast(v any) string {
+	if values, ok := sliceValues(v); ok {
+		if len(values) == 0 {
+			return ""
+		}
+		v = values[0]
+	}
+	if v == nil {
+		return ""
+	}
+	return sqlCast(reflect.TypeOf(v))
+}
+
+// quoteLiteral quotes a string as a SQL literal
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,192 @@
+package queryparser
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Settings is a typed document stored in a jsonb column
+type Settings struct {
+	Theme string `json:"theme"`
+	Limit int    `json:"limit"`
+}
+
+// Account has schemaless and typed jsonb columns and a plain json column
+type JSONBAccount struct {
+	ID       int             `json:"id" db:"id"`
+	Metadata map[string]any  `json:"metadata" db:"metadata" query:"jsonb"`
+	Settings Settings        `json:"settings" db:"settings" query:"jsonb"`
+	Raw      json.RawMessage `json:"raw" db:"raw" query:"json"`
+	Name     string          `json:"name" db:"name"`
+}
+
+func TestJSONBFilters(t *testing.T) {
+	ctx := context.Background()
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		filters  []Filter
+		options  *QueryOptions
+		wantSQL  string
+		wantArgs []any
+		wantErr  string
+	}{
+		{
+			name:     "schemaless string path",
+			filters:  []Filter{{Field: "metadata.plan.tier", Operator: OpEq, Value: "pro"}},
+			wantSQL:  "SELECT * FROM accounts WHERE (metadata->'plan'->>'tier' = $1)",
+			wantArgs: []any{"pro"},
+		},
+		{
+			name:     "schemaless numeric comparison is cast",
+			filters:  []Filter{{Field: "metadata.seats", Operator: OpGte, Value: float64(10)}},
+			wantSQL:  "SELECT * FROM accounts WHERE ((metadata->>'seats')::numeric >= $1)",
+			wantArgs: []any{float64(10)},
+		},
+		{
+			name:     "schemaless boolean is cast",
+			filters:  []Filter{{Field: "metadata.trial", Operator: OpEq, Value: true}},
+			wantSQL:  "SELECT * FROM accounts WHERE ((metadata->>'trial')::boolean = $1)",
+			wantArgs: []any{true},
+		},
+		{
+			name:     "schemaless time is cast",
+			filters:  []Filter{{Field: "metadata.renewed_at", Operator: OpLt, Value: since}},
+			wantSQL:  "SELECT * FROM accounts WHERE ((metadata->>'renewed_at')::timestamptz < $1)",
+			wantArgs: []any{since},
+		},
+		{
+			name:     "schemaless $in is cast from its elements",
+			filters:  []Filter{{Field: "metadata.seats", Operator: OpIn, Value: []any{float64(1), float64(2)}}},
+			wantSQL:  "SELECT * FROM accounts WHERE ((metadata->>'seats')::numeric IN ($1,$2))",
+			wantArgs: []any{float64(1), float64(2)},
+		},
+		{
+			name:     "$like is never cast",
+			filters:  []Filter{{Field: "settings.limit", Operator: OpLike, Value: "1"}},
+			wantSQL:  "SELECT * FROM accounts WHERE (settings->>'limit' LIKE $1)",
+			wantArgs: []any{"%1%"},
+		},
+		{
+			name:     "typed path is cast from the model",
+			filters:  []Filter{{Field: "settings.limit", Operator: OpGt, Value: "5"}},
+			wantSQL:  "SELECT * FROM accounts WHERE ((settings->>'limit')::numeric > $1)",
+			wantArgs: []any{"5"},
+		},
+		{
+			name:     "object equality uses containment",
+			filters:  []Filter{{Field: "metadata.plan", Operator: OpEq, Value: map[string]any{"tier": "pro"}}},
+			wantSQL:  "SELECT * FROM accounts WHERE (metadata->'plan' @> $1::jsonb)",
+			wantArgs: []any{`{"tier":"pro"}`},
+		},
+		{
+			name:     "object inequality on the column",
+			filters:  []Filter{{Field: "settings", Operator: OpNe, Value: map[string]any{"theme": "dark"}}},
+			wantSQL:  "SELECT * FROM accounts WHERE (NOT COALESCE(settings @> $1::jsonb, false))",
+			wantArgs: []any{`{"theme":"dark"}`},
+		},
+		{
+			// metadata->'plan' is NULL when the plan key or the column is
+			// missing, which must count as not equal
+			name:     "object inequality under a missing parent key",
+			filters:  []Filter{{Field: "metadata.plan", Operator: OpNe, Value: map[string]any{"tier": "pro"}}},
+			wantSQL:  "SELECT * FROM accounts WHERE (NOT COALESCE(metadata->'plan' @> $1::jsonb, false))",
+			wantArgs: []any{`{"tier":"pro"}`},
+		},
+		{
+			name:     "$exists uses the ? operator",
+			filters:  []Filter{{Field: "metadata.plan.tier", Operator: OpExists, Value: true}},
+			wantSQL:  "SELECT * FROM accounts WHERE (metadata->'plan' ? $1)",
+			wantArgs: []any{"tier"},
+		},
+		{
+			// metadata ? 'trial' is NULL when the column is NULL
+			name:     "$exists false on a NULL column",
+			filters:  []Filter{{Field: "metadata.trial", Operator: OpExists, Value: false}},
+			wantSQL:  "SELECT * FROM accounts WHERE (NOT COALESCE(metadata ? $1, false))",
+			wantArgs: []any{"trial"},
+		},
+		{
+			// metadata->'plan' ? 'tier' is NULL when the plan key or the
+			// column is missing, in which case tier does not exist either
+			name:     "$exists false under a missing parent key",
+			filters:  []Filter{{Field: "metadata.plan.tier", Operator: OpExists, Value: false}},
+			wantSQL:  "SELECT * FROM accounts WHERE (NOT COALESCE(metadata->'plan' ? $1, false))",
+			wantArgs: []any{"tier"},
+		},
+		{
+			name:    "$exists false on a json column",
+			filters: []Filter{{Field: "raw.source", Operator: OpExists, Value: false}},
+			wantSQL: "SELECT * FROM accounts WHERE (raw->'source' IS NULL)",
+		},
+		{
+			name:    "$exists on a json column",
+			filters: []Filter{{Field: "raw.source", Operator: OpExists, Value: true}},
+			wantSQL: "SELECT * FROM accounts WHERE (raw->'source' IS NOT NULL)",
+		},
+		{
+			name:    "$exists on a plain column",
+			filters: []Filter{{Field: "name", Operator: OpExists, Value: false}},
+			wantSQL: "SELECT * FROM accounts WHERE (name IS NULL)",
+		},
+		{
+			name:    "sort by schemaless path",
+			options: &QueryOptions{Sort: map[string]SortDirection{"metadata.plan.tier": SortAsc}},
+			wantSQL: "SELECT * FROM accounts ORDER BY metadata->'plan'->>'tier' ASC",
+		},
+		{
+			name:    "object comparison requires jsonb",
+			filters: []Filter{{Field: "raw", Operator: OpEq, Value: map[string]any{"a": 1}}},
+			wantErr: `comparing objects in field "raw" requires a jsonb column`,
+		},
+		{
+			name:    "unsafe key is rejected",
+			filters: []Filter{{Field: "metadata.plan'); DROP TABLE accounts; --", Operator: OpEq, Value: 1}},
+			wantErr: `invalid JSON key "plan'); DROP TABLE accounts; --" in field "metadata.plan'); DROP TABLE accounts; --"`,
+		},
+		{
+			name:    "typed path is validated",
+			filters: []Filter{{Field: "settings.color", Operator: OpEq, Value: "red"}},
+			wantErr: `field "settings.color" is not a valid JSON field`,
+		},
+		{
+			name:    "$exists requires a boolean",
+			filters: []Filter{{Field: "metadata.plan", Operator: OpExists, Value: "yes"}},
+			wantErr: `$exists operator requires a boolean value for field "metadata.plan"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qb, err := NewSqlBuilder(ctx).WithSelect("accounts").Apply(tt.filters, tt.options, &JSONBAccount{})
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+
+			sql, args, err := qb.ToSql()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantSQL, sql)
+			assert.Equal(t, tt.wantArgs, args)
+		})
+	}
+}
+
+func TestJSONBParsedFilter(t *testing.T) {
+	filters, err := ParseFilter(`{"metadata.plan": {"$eq": {"tier": "pro"}}, "metadata.seats": {"$gt": 5}}`)
+	assert.NoError(t, err)
+
+	qb, err := NewSqlBuilder(context.Background()).WithSelect("accounts").Apply(Normalize(filters), nil, &JSONBAccount{})
+	assert.NoError(t, err)
+
+	sql, args, err := qb.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM accounts WHERE (metadata->'plan' @> $1::jsonb AND (metadata->>'seats')::numeric > $2)", sql)
+	assert.Equal(t, []any{`{"tier":"pro"}`, float64(5)}, args)
+}
@@ -0,0 +1,542 @@
+package queryparser
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+)
+
+// jsonSchemaDialect is the JSON Schema version produced by FilterSchema
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// relativeTimePattern matches the relative times accepted by time fields,
+// see resolveRelativeTime
+const relativeTimePattern = `^(now([+-][0-9]+[yMwdhHms]|/[yMwdhHms])*|startOf(Day|Week|Month|Year))$`
+
+// schemaPath is a dotted path resolved by modelSchema.lookup
+type schemaPath struct {
+	name  string
+	chain []*schemaField
+	open  bool // any JSON keys are accepted below the path
+}
+
+// repeatedPrefix reports whether the path goes through a slice, which
+// validateFields rejects for sorting
+func (p schemaPath) repeatedPrefix() bool {
+	for _, field := range p.chain[:len(p.chain)-1] {
+		if field.Repeated {
+			return true
+		}
+	}
+	return false
+}
+
+// schemaPaths lists the paths of a schema, descending into nested structs.
+// Recursive types are listed once along each path.
+func schemaPaths(schema *modelSchema) []schemaPath {
+	var paths []schemaPath
+	seen := make(map[*modelSchema]bool)
+	var visit func(s *modelSchema, prefix string, chain []*schemaField)
+	visit = func(s *modelSchema, prefix string, chain []*schemaField) {
+		seen[s] = true
+		defer delete(seen, s)
+		for _, f := range s.fields {
+			c := append(append([]*schemaField(nil), chain...), f)
+			name := prefix + f.JSON
+			open := f.Children == nil && c[0].JSONColumn && (len(c) == 1 || isFreeForm(f.Type))
+			paths = append(paths, schemaPath{name: name, chain: c, open: open})
+			if f.Children != nil && !seen[f.Children] {
+				visit(f.Children, name+".", c)
+			}
+		}
+	}
+	visit(schema, "", nil)
+	return paths
+}
+
+// FilterSchema returns a JSON Schema (draft 2020-12) of the filters accepted
+// for a model. It lists every field and nested path, the operators each one
+// accepts and the type of their values, derived from the Go field types.
+// The shape of QueryOptions for the model, with the fields that can be
+// sorted, grouped, aggregated, highlighted and faceted, is in
+// $defs.options, which can be referenced as "#/$defs/options".
+//
+// The schema is generated from the metadata used by the builders to validate
+// filters, so a filter that matches it passes their field validation.
+// Recursive types are described once along each path.
+//
+// Example:
+//
+//	schema, err := FilterSchema(&Product{})
+//	// {"$schema": "https://json-schema.org/draft/2020-12/schema", "$ref": "#/$defs/filter", "$defs": {...}}
+func FilterSchema(model any) (map[string]any, error) {
+	schema, err := schemaOf(model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get model schema: %w", err)
+	}
+
+	g := &schemaGenerator{
+		defs:  make(map[string]any),
+		names: map[*modelSchema]string{schema: "filter"},
+	}
+	g.defs["filter"] = g.filterSchema(schema, "filter")
+	g.defs["options"] = g.optionsSchema(schema)
+	if g.times {
+		g.defs["time"] = map[string]any{
+			"type": "string",
+			"anyOf": []any{
+				map[string]any{"format": "date-time"},
+				map[string]any{"pattern": relativeTimePattern},
+			},
+		}
+	}
+
+	return map[string]any{
+		"$schema": jsonSchemaDialect,
+		"title":   schema.name + " filter",
+		"$ref":    "#/$defs/filter",
+		"$defs":   g.defs,
+	}, nil
+}
+
+// schemaGenerator accumulates the definitions of a FilterSchema
+type schemaGenerator struct {
+	defs  map[string]any
+	names map[*modelSchema]string // definition of the filter of each schema
+	times bool                    // the time definition is referenced
+}
+
+// ref returns a reference to a definition
+func ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/$defs/" + name}
+}
+
+// elementFilter returns a reference to the filter of the elements of a
+// slice of structs, generating its definition on first use
+func (g *schemaGenerator) elementFilter(schema *modelSchema) map[string]any {
+	if name, ok := g.names[schema]; ok {
+		return ref(name)
+	}
+	base := "filter." + schema.name
+	if schema.name == "" {
+		base = "filter.element"
+	}
+	name := base
+	for i := 2; g.defs[name] != nil; i++ {
+		name = base + strconv.Itoa(i)
+	}
+	g.names[schema] = name
+	g.defs[name] = true // reserve the name while the definition is built
+	g.defs[name] = g.filterSchema(schema, name)
+	return ref(name)
+}
+
+// filterSchema returns the schema of a filter object on a model schema
+func (g *schemaGenerator) filterSchema(schema *modelSchema, name string) map[string]any {
+	properties := make(map[string]any)
+	patterns := make(map[string]any)
+	for _, p := range schemaPaths(schema) {
+		if ops := fieldOperators(p.chain); len(ops) > 0 {
+			properties[p.name] = g.conditionSchema(p, ops)
+		}
+		if p.open {
+			patterns[openPattern(p.name)] = g.openConditionSchema(p)
+		}
+	}
+
+	nested := map[string]any{"type": "array", "items": ref(name)}
+	properties[string(OpOr)] = nested
+	properties[string(OpAnd)] = nested
+	if len(schema.searchable()) > 0 {
+		search := map[string]any{"type": "string"}
+		properties[string(OpText)] = map[string]any{
+			"anyOf": []any{
+				search,
+				map[string]any{
+					"type":                 "object",
+					"properties":           map[string]any{string(OpSearch): search},
+					"required":             []any{string(OpSearch)},
+					"additionalProperties": false,
+				},
+			},
+		}
+	}
+
+	filter := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(patterns) > 0 {
+		filter["patternProperties"] = patterns
+	}
+	return filter
+}
+
+// openPattern matches the paths below a path that accepts any JSON keys
+func openPattern(path string) string {
+	return "^" + regexp.QuoteMeta(path) + `(\.[^.]+)+$`
+}
+
+// conditionSchema returns the schema of the conditions on a field: a value,
+// compared for equality, or an object of operators
+func (g *schemaGenerator) conditionSchema(p schemaPath, ops []Operator) map[string]any {
+	field := p.chain[len(p.chain)-1]
+	value := g.valueSchema(field.Type)
+	elem := g.valueSchema(arrayElemType(field.Type))
+
+	var elemMatch map[string]any
+	switch {
+	case !acceptsOperator(p.chain, OpElemMatch):
+	case field.Children != nil:
+		elemMatch = g.elementFilter(field.Children)
+	default:
+		elemMatch = g.operatorsSchema(scalarOperators, elem, elem, nil)
+		elemMatch["minProperties"] = 1
+	}
+	return g.withEquality(g.operatorsSchema(ops, value, elem, elemMatch), value, ops)
+}
+
+// openConditionSchema returns the schema of the conditions on the paths below
+// a schemaless JSON column, which hold any JSON value and accept the array
+// operators
+func (g *schemaGenerator) openConditionSchema(p schemaPath) map[string]any {
+	ops := append(fieldOperators(p.chain), OpAll, OpContains, OpSize, OpElemMatch)
+	value := map[string]any{}
+	elemMatch := map[string]any{"type": "object", "minProperties": 1}
+	return g.withEquality(g.operatorsSchema(ops, value, value, elemMatch), value, ops)
+}
+
+// withEquality adds the implicit equality, {"field": value}, to an object of
+// operators. Objects are always read as operators, so values that are
+// objects cannot be compared this way.
+func (g *schemaGenerator) withEquality(operators, value map[string]any, ops []Operator) map[string]any {
+	if !hasOperator(ops, OpEq) || value["type"] == "object" {
+		return operators
+	}
+	equality := nullable(value)
+	if len(value) == 0 {
+		equality = map[string]any{"not": map[string]any{"type": "object"}}
+	}
+	return map[string]any{"anyOf": []any{equality, operators}}
+}
+
+// operatorsSchema returns the schema of an object of operators
+func (g *schemaGenerator) operatorsSchema(ops []Operator, value, elem, elemMatch map[string]any) map[string]any {
+	properties := make(map[string]any, len(ops))
+	for _, op := range ops {
+		var s map[string]any
+		switch op {
+		case OpEq, OpNe:
+			s = nullable(value)
+		case OpLt, OpLte, OpGt, OpGte:
+			s = value
+		case OpIn, OpNin:
+			s = map[string]any{"type": "array", "items": value}
+		case OpLike, OpSearch:
+			s = map[string]any{"type": "string"}
+		case OpExists:
+			s = map[string]any{"type": "boolean"}
+		case OpBetween:
+			s = map[string]any{"anyOf": []any{
+				map[string]any{
+					"type":        "array",
+					"prefixItems": []any{value, value},
+					"minItems":    2,
+					"maxItems":    2,
+				},
+				map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"from":   value,
+						"to":     value,
+						"bounds": map[string]any{"enum": []any{"[]", "[)", "(]", "()"}},
+					},
+					"required":             []any{"from", "to"},
+					"additionalProperties": false,
+				},
+			}}
+		case OpAll, OpContains:
+			s = map[string]any{"type": "array", "items": elem, "minItems": 1}
+		case OpSize:
+			s = map[string]any{"type": "integer", "minimum": 0}
+		case OpElemMatch:
+			s = elemMatch
+		}
+		properties[string(op)] = s
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// nullable allows null in addition to a value, as $eq and $ne compare with
+// null to match missing values
+func nullable(value map[string]any) map[string]any {
+	if len(value) == 0 {
+		return value
+	}
+	return map[string]any{"anyOf": []any{value, map[string]any{"type": "null"}}}
+}
+
+// valueSchema returns the schema of the JSON values of a Go type. Times are
+// RFC 3339 strings or relative times, and types without a fixed JSON form
+// accept any value.
+func (g *schemaGenerator) valueSchema(typ reflect.Type) map[string]any {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == timeType {
+		g.times = true
+		return ref("time")
+	}
+	switch typ.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			if typ.Name() == "RawMessage" {
+				return map[string]any{}
+			}
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": g.valueSchema(typ.Elem())}
+	case reflect.Map, reflect.Struct:
+		return map[string]any{"type": "object"}
+	default:
+		return map[string]any{}
+	}
+}
+
+// optionPaths are the names and patterns of the paths accepted by each
+// query option, following validateFields, validateAggregation and
+// validateSearchOptions
+type optionPaths struct {
+	sortable, groupable, numeric, selectable, highlighted, facetable, rangeable []string
+	sortPatterns, groupPatterns, selectPatterns, facetPatterns                  []string
+}
+
+// optionPathsOf lists the paths of a schema accepted by each query option
+func optionPathsOf(schema *modelSchema) optionPaths {
+	var o optionPaths
+	for _, p := range schemaPaths(schema) {
+		leaf := p.chain[len(p.chain)-1]
+		o.selectable = append(o.selectable, p.name)
+		if !p.repeatedPrefix() && leaf.Sortable {
+			o.sortable = append(o.sortable, p.name)
+		}
+		if isScalarPath(p.name, p.chain) {
+			o.groupable = append(o.groupable, p.name)
+			if isNumeric(leaf.Type) {
+				o.numeric = append(o.numeric, p.name)
+			}
+		}
+		if f := esLeaf(schema, p.name); f != nil && highlightable(f.ESType) {
+			o.highlighted = append(o.highlighted, p.name)
+		}
+		if leaf.Filterable && leaf.Children == nil {
+			if _, err := sortPath(schema, p.name, "a facet"); err == nil {
+				o.facetable = append(o.facetable, p.name)
+				if isNumeric(leaf.Type) || isTimeField(schema, p.name) {
+					o.rangeable = append(o.rangeable, p.name)
+				}
+			}
+		}
+
+		if !p.open {
+			continue
+		}
+		// Paths below the column are schemaless: they sort and group by the
+		// extracted value
+		pattern := openPattern(p.name)
+		o.selectPatterns = append(o.selectPatterns, pattern)
+		if !p.repeatedPrefix() {
+			o.sortPatterns = append(o.sortPatterns, pattern)
+		}
+		if isScalarPath(p.name+".key", p.chain) {
+			o.groupPatterns = append(o.groupPatterns, pattern)
+		}
+		if leaf.Filterable {
+			o.facetPatterns = append(o.facetPatterns, pattern)
+		}
+	}
+	return o
+}
+
+// optionsSchema returns the schema of the query options for a model
+func (g *schemaGenerator) optionsSchema(schema *modelSchema) map[string]any {
+	paths := optionPathsOf(schema)
+	sortNames := nameSchema(paths.sortable, paths.sortPatterns)
+	groupNames := nameSchema(paths.groupable, paths.groupPatterns)
+	alias := map[string]any{"type": "string", "pattern": aliasPattern.String()}
+	funcs := []any{string(AggCount), string(AggCountDistinct), string(AggSum), string(AggAvg), string(AggMin), string(AggMax)}
+
+	aggregate := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"func":  map[string]any{"enum": funcs},
+			"field": groupNames,
+			"alias": alias,
+		},
+		"required":             []any{"func"},
+		"additionalProperties": false,
+		"allOf": []any{
+			map[string]any{
+				"if":   map[string]any{"properties": map[string]any{"func": map[string]any{"enum": []any{string(AggSum), string(AggAvg)}}}},
+				"then": map[string]any{"properties": map[string]any{"field": nameSchema(paths.numeric, nil)}},
+			},
+			map[string]any{
+				"if":   map[string]any{"properties": map[string]any{"func": map[string]any{"not": map[string]any{"const": string(AggCount)}}}},
+				"then": map[string]any{"required": []any{"field"}},
+			},
+		},
+	}
+
+	facet := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"field": nameSchema(paths.facetable, paths.facetPatterns),
+			"alias": alias,
+			"size":  map[string]any{"type": "integer", "minimum": 0},
+			"ranges": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"key":  map[string]any{"type": "string"},
+						"from": map[string]any{},
+						"to":   map[string]any{},
+					},
+					"anyOf":                []any{map[string]any{"required": []any{"from"}}, map[string]any{"required": []any{"to"}}},
+					"additionalProperties": false,
+				},
+			},
+		},
+		"required":             []any{"field"},
+		"additionalProperties": false,
+		"if":                   map[string]any{"required": []any{"ranges"}},
+		"then":                 map[string]any{"properties": map[string]any{"field": nameSchema(paths.rangeable, nil)}},
+	}
+
+	// Having is a filter over aggregate aliases, whose values are numbers,
+	// or any value of the field for min and max
+	var havingOps []Operator
+	for _, op := range scalarOperators {
+		if havingOperators[op] {
+			havingOps = append(havingOps, op)
+		}
+	}
+	value := map[string]any{}
+	nestedHaving := map[string]any{"type": "array", "items": ref("having")}
+	g.defs["having"] = map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			string(OpOr):  nestedHaving,
+			string(OpAnd): nestedHaving,
+		},
+		"patternProperties": map[string]any{
+			aliasPattern.String(): g.withEquality(g.operatorsSchema(havingOps, value, nil, nil), value, havingOps),
+		},
+		"additionalProperties": false,
+	}
+
+	direction := map[string]any{"enum": []any{string(SortAsc), string(SortDesc)}}
+	sortField := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"field":     map[string]any{"type": "string"},
+			"direction": direction,
+		},
+		"required":             []any{"field"},
+		"additionalProperties": false,
+	}
+	// sortFieldNames restricts the fields of sortBy
+	sortFieldNames := func(names any) map[string]any {
+		return map[string]any{"items": map[string]any{"properties": map[string]any{"field": names}}}
+	}
+	grouped := map[string]any{"anyOf": []any{nonEmpty("groupBy"), nonEmpty("aggregates")}}
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"sortBy":     map[string]any{"type": "array", "items": sortField},
+			"sort":       map[string]any{"type": "object", "additionalProperties": direction},
+			"limit":      map[string]any{"type": "integer", "minimum": 0},
+			"offset":     map[string]any{"type": "integer", "minimum": 0},
+			"relevance":  map[string]any{"type": "boolean"},
+			"groupBy":    map[string]any{"type": "array", "items": groupNames},
+			"aggregates": map[string]any{"type": "array", "items": aggregate},
+			"having":     ref("having"),
+			"scoring":    map[string]any{"enum": []any{string(ScoreAll), string(ScoreText)}},
+			"highlight":  map[string]any{"type": "array", "items": nameSchema(paths.highlighted, nil)},
+			"facets":     map[string]any{"type": "array", "items": facet},
+			"postFilter": ref("filter"),
+			"fields":     map[string]any{"type": "array", "items": nameSchema(paths.selectable, paths.selectPatterns)},
+		},
+		"additionalProperties": false,
+		"allOf": []any{
+			// Sort accepts aggregate aliases as well as fields
+			map[string]any{
+				"if": nonEmpty("aggregates"),
+				"then": map[string]any{"properties": map[string]any{
+					"sortBy": sortFieldNames(map[string]any{"anyOf": []any{sortNames, alias}}),
+					"sort":   map[string]any{"propertyNames": map[string]any{"anyOf": []any{sortNames, alias}}},
+				}},
+				"else": map[string]any{"properties": map[string]any{
+					"sortBy": sortFieldNames(sortNames),
+					"sort":   map[string]any{"propertyNames": sortNames},
+				}},
+			},
+			map[string]any{
+				"if":   map[string]any{"required": []any{"having"}},
+				"then": grouped,
+			},
+			map[string]any{
+				"if":   nonEmpty("fields"),
+				"then": map[string]any{"not": grouped},
+			},
+		},
+	}
+}
+
+// nonEmpty matches objects with a non-empty array under key
+func nonEmpty(key string) map[string]any {
+	return map[string]any{
+		"required":   []any{key},
+		"properties": map[string]any{key: map[string]any{"minItems": 1}},
+	}
+}
+
+// nameSchema returns the schema of a string that is one of names or matches
+// one of patterns
+func nameSchema(names, patterns []string) map[string]any {
+	var alternatives []any
+	if len(names) > 0 {
+		enum := make([]any, len(names))
+		for i, name := range names {
+			enum[i] = name
+		}
+		alternatives = append(alternatives, map[string]any{"enum": enum})
+	}
+	for _, pattern := range patterns {
+		alternatives = append(alternatives, map[string]any{"type": "string", "pattern": pattern})
+	}
+	switch len(alternatives) {
+	case 0:
+		return map[string]any{"not": map[string]any{}}
+	case 1:
+		return alternatives[0].(map[string]any)
+	default:
+		return map[string]any{"anyOf": alternatives}
+	}
+}
@@ -0,0 +1,174 @@
+package queryparser
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// schemaDefs returns the definitions of a filter schema
+func schemaDefs(t *testing.T, schema map[string]any) map[string]any {
+	t.Helper()
+	defs, ok := schema["$defs"].(map[string]any)
+	if !ok {
+		t.Fatalf("schema has no $defs: %v", schema)
+	}
+	return defs
+}
+
+// schemaProperty returns a property of an object schema
+func schemaProperty(t *testing.T, object any, name string) map[string]any {
+	t.Helper()
+	properties, _ := object.(map[string]any)["properties"].(map[string]any)
+	property, ok := properties[name].(map[string]any)
+	if !ok {
+		t.Fatalf("schema has no property %q", name)
+	}
+	return property
+}
+
+// conditionOperators returns the operators listed in the schema of the
+// conditions on a field, sorted
+func conditionOperators(condition map[string]any) []string {
+	if alternatives, ok := condition["anyOf"].([]any); ok {
+		condition = alternatives[len(alternatives)-1].(map[string]any)
+	}
+	var ops []string
+	for op := range condition["properties"].(map[string]any) {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+func TestFilterSchema(t *testing.T) {
+	schema, err := FilterSchema(&Item{})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://json-schema.org/draft/2020-12/schema", schema["$schema"])
+	assert.Equal(t, "Item filter", schema["title"])
+	assert.Equal(t, "#/$defs/filter", schema["$ref"])
+
+	_, err = json.Marshal(schema)
+	assert.NoError(t, err)
+
+	defs := schemaDefs(t, schema)
+	filter := defs["filter"]
+	scalar := []string{"$between", "$eq", "$exists", "$gt", "$gte", "$in", "$like", "$lt", "$lte", "$ne", "$nin"}
+
+	// Values are typed after the Go fields and equality accepts null
+	qty := schemaProperty(t, filter, "qty")
+	assert.Equal(t, scalar, conditionOperators(qty))
+	assert.Equal(t, map[string]any{"anyOf": []any{map[string]any{"type": "integer"}, map[string]any{"type": "null"}}}, qty["anyOf"].([]any)[0])
+	assert.Equal(t, map[string]any{"type": "integer"}, schemaProperty(t, qty["anyOf"].([]any)[1], "$gt"))
+	assert.Equal(t, map[string]any{"$ref": "#/$defs/time"}, schemaProperty(t, schemaProperty(t, filter, "created")["anyOf"].([]any)[1], "$lt"))
+	assert.Equal(t, map[string]any{"type": "boolean"}, schemaProperty(t, schemaProperty(t, filter, "active")["anyOf"].([]any)[1], "$ne")["anyOf"].([]any)[0])
+	assert.Contains(t, defs, "time")
+
+	// Searchable fields accept $search and the model accepts $text
+	assert.Equal(t, append(scalar, "$search"), conditionOperators(schemaProperty(t, filter, "name")))
+	assert.Contains(t, filter.(map[string]any)["properties"], "$text")
+
+	// Slices accept the array operators, on their element type
+	tags := schemaProperty(t, filter, "tags")["anyOf"].([]any)[1]
+	assert.Equal(t, []string{"$all", "$between", "$contains", "$elemMatch", "$eq", "$exists", "$gt", "$gte", "$in", "$like", "$lt", "$lte", "$ne", "$nin", "$size"}, conditionOperators(tags.(map[string]any)))
+	assert.Equal(t, map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "minItems": 1}, schemaProperty(t, tags, "$all"))
+	assert.Equal(t, map[string]any{"type": "string"}, schemaProperty(t, schemaProperty(t, tags, "$elemMatch"), "$gte"))
+
+	// $elemMatch on slices of structs takes a filter on the elements
+	parts := schemaProperty(t, filter, "parts")["anyOf"].([]any)[1]
+	assert.Equal(t, map[string]any{"$ref": "#/$defs/filter.ItemPart"}, schemaProperty(t, parts, "$elemMatch"))
+	assert.Contains(t, defs["filter.ItemPart"].(map[string]any)["properties"], "sku")
+	assert.Contains(t, filter.(map[string]any)["properties"], "parts.sku")
+
+	_, err = FilterSchema("item")
+	assert.EqualError(t, err, "failed to get model schema: expected struct or pointer to struct, got string")
+}
+
+func TestFilterSchemaRelationsAndJSONColumns(t *testing.T) {
+	schema, err := FilterSchema(&RelUser{})
+	assert.NoError(t, err)
+	defs := schemaDefs(t, schema)
+	properties := defs["filter"].(map[string]any)["properties"].(map[string]any)
+
+	// Has-many relations are queried through $elemMatch or their fields,
+	// belongs-to relations through their fields only
+	assert.Equal(t, []string{"$elemMatch"}, conditionOperators(properties["orders"].(map[string]any)))
+	assert.Equal(t, map[string]any{"$ref": "#/$defs/filter.RelOrder"}, schemaProperty(t, properties["orders"], "$elemMatch"))
+	assert.Contains(t, properties, "orders.status")
+	assert.NotContains(t, properties, "company")
+	assert.Contains(t, properties, "company.name")
+	assert.NotContains(t, properties, "orders.items")
+	assert.Contains(t, properties, "orders.items.sku")
+
+	// Schemaless JSON columns accept any key below them
+	schema, err = FilterSchema(&JSONBAccount{})
+	assert.NoError(t, err)
+	filter := schemaDefs(t, schema)["filter"].(map[string]any)
+	patterns := filter["patternProperties"].(map[string]any)
+	assert.Contains(t, patterns, `^metadata(\.[^.]+)+$`)
+	assert.Contains(t, patterns, `^raw(\.[^.]+)+$`)
+	assert.NotContains(t, patterns, `^settings(\.[^.]+)+$`)
+	assert.Contains(t, conditionOperators(patterns[`^metadata(\.[^.]+)+$`].(map[string]any)), "$contains")
+}
+
+func TestFilterSchemaOptions(t *testing.T) {
+	schema, err := FilterSchema(&Customer{})
+	assert.NoError(t, err)
+	options := schemaDefs(t, schema)["options"]
+
+	// Sorting excludes paths through slices and non-scalar fields, and
+	// recursive types are described once along each path
+	sortNames := options.(map[string]any)["allOf"].([]any)[0].(map[string]any)["else"]
+	assert.Equal(t,
+		map[string]any{"enum": []any{"id", "address.city", "address.zip", "address.geo.lat", "billing.city", "billing.zip", "billing.geo.lat", "created"}},
+		schemaProperty(t, sortNames, "sort")["propertyNames"])
+	assert.Equal(t,
+		map[string]any{"enum": []any{"id", "address.city", "address.zip", "address.geo.lat", "billing.city", "billing.zip", "billing.geo.lat", "created"}},
+		schemaProperty(t, schemaProperty(t, sortNames, "sortBy")["items"], "field"))
+	assert.Equal(t, map[string]any{"type": "array", "items": map[string]any{"enum": []any{"id", "address.city", "address.zip", "address.geo.lat", "billing.city", "billing.zip", "billing.geo.lat", "created"}}}, schemaProperty(t, options, "groupBy"))
+	assert.Equal(t, map[string]any{"enum": []any{"all", "text"}}, schemaProperty(t, options, "scoring"))
+	assert.Equal(t, map[string]any{"$ref": "#/$defs/filter"}, schemaProperty(t, options, "postFilter"))
+
+	schema, err = FilterSchema(&Product{})
+	assert.NoError(t, err)
+	options = schemaDefs(t, schema)["options"]
+	assert.Equal(t, map[string]any{"type": "array", "items": map[string]any{"enum": []any{"sku", "name", "description", "brand", "summary", "tags", "variants.color"}}}, schemaProperty(t, options, "highlight"))
+	facet := schemaProperty(t, options, "facets")["items"]
+	assert.NotContains(t, schemaProperty(t, facet, "field")["enum"], "description")
+	assert.Equal(t, map[string]any{"enum": []any{"id", "price", "stock", "released", "variants.size"}}, schemaProperty(t, facet.(map[string]any)["then"], "field"))
+}
+
+// TestFilterSchemaMatchesValidation checks that FilterSchema lists exactly the
+// operators validateFields accepts on each path
+func TestFilterSchemaMatchesValidation(t *testing.T) {
+	models := []any{&Item{}, &Customer{}, &RelUser{}, &JSONBAccount{}, &ArrayPost{}, &Article{}, &Product{}}
+	for _, model := range models {
+		modelSchema, err := schemaOf(model)
+		assert.NoError(t, err)
+		schema, err := FilterSchema(model)
+		assert.NoError(t, err)
+		properties := schemaDefs(t, schema)["filter"].(map[string]any)["properties"].(map[string]any)
+
+		for _, p := range schemaPaths(modelSchema) {
+			listed := map[string]bool{}
+			if condition, ok := properties[p.name].(map[string]any); ok {
+				for _, op := range conditionOperators(condition) {
+					listed[op] = true
+				}
+			}
+			for op := range operators {
+				if op == OpOr || op == OpAnd || op == OpText {
+					continue
+				}
+				filter := Filter{Field: p.name, Operator: op}
+				if op == OpElemMatch {
+					filter.Filters = []Filter{{Operator: OpAnd}}
+				}
+				err := validateFields([]Filter{filter}, nil, modelSchema)
+				assert.Equal(t, listed[string(op)], err == nil, "%T %s %s: %v", model, p.name, op, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package queryparser
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// esTypeOf returns the Elasticsearch type of a field and the name of its
+// keyword subfield. The type is declared first in the es tag, e.g.
+// `es:"keyword"`, `es:"date"` or `es:"text,keyword"`, where the keyword
+// option adds a keyword subfield, named "keyword" unless given as
+// keyword=<name>. Undeclared types are inferred from the Go type: strings
+// are keyword, or text with a keyword subfield when tagged
+// query:"searchable". Unlike Elasticsearch's dynamic mapping, which maps
+// every string to text with a keyword subfield, strings that are not
+// searchable are only keyword.
+func esTypeOf(field reflect.StructField, elem reflect.Type, searchable bool) (string, string) {
+	tag := field.Tag.Get("es")
+	options := parseQueryTag(tag)
+	esType := tagName(tag)
+	if esType == "nested" {
+		esType = ""
+	}
+	if esType == "" {
+		esType = inferESType(elem)
+		if esType == "keyword" && searchable {
+			return "text", "keyword"
+		}
+		return esType, ""
+	}
+	if esType != "text" || !options.has("keyword") {
+		return esType, ""
+	}
+	if name := options["keyword"]; name != "" {
+		return esType, name
+	}
+	return esType, "keyword"
+}
+
+// inferESType maps a Go type to an Elasticsearch field type. It returns an
+// empty string for types without an obvious mapping, such as interfaces.
+func inferESType(typ reflect.Type) string {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == timeType {
+		return "date"
+	}
+	switch typ.Kind() {
+	case reflect.String:
+		return "keyword"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int8:
+		return "byte"
+	case reflect.Int16:
+		return "short"
+	case reflect.Int32:
+		return "integer"
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return "long"
+	case reflect.Uint64:
+		return "unsigned_long"
+	case reflect.Float32:
+		return "float"
+	case reflect.Float64:
+		return "double"
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return "binary"
+		}
+		return ""
+	case reflect.Map, reflect.Struct:
+		return "object"
+	default:
+		return ""
+	}
+}
+
+// esLeaf returns the field a dotted path ends on, or nil when the path is not
+// fully described by the model
+func esLeaf(schema *modelSchema, path string) *schemaField {
+	if schema == nil {
+		return nil
+	}
+	chain, ok := schema.lookup(path)
+	if !ok || len(chain) != strings.Count(path, ".")+1 {
+		return nil
+	}
+	return chain[len(chain)-1]
+}
+
+// keywordPath returns the path to use for exact matching, sorting and terms
+// aggregations: the keyword subfield of text fields, or the path itself. The
+// second return value is false for text fields without a keyword subfield,
+// which have no exact value.
+func keywordPath(schema *modelSchema, path string) (string, bool) {
+	f := esLeaf(schema, path)
+	if f == nil || f.ESType != "text" {
+		return path, true
+	}
+	if f.ESKeyword == "" {
+		return path, false
+	}
+	return path + "." + f.ESKeyword, true
+}
+
+// sortPath returns the path to sort or group on, or an error for text fields
+// without a keyword subfield
+func sortPath(schema *modelSchema, path, use string) (string, error) {
+	p, ok := keywordPath(schema, path)
+	if !ok {
+		return "", fmt.Errorf("field %q is a text field without a keyword subfield and cannot be used for %s", path, use)
+	}
+	return p, nil
+}
+
+// ElasticMapping returns the Elasticsearch mapping of a model, to be used as
+// the body of an index creation request. Fields are named after their json
+// tag and typed as declared in their es tag or inferred from their Go type;
+// see the README for the rules. Nested structs are objects, or nested types
+// when tagged es:"nested".
+//
+// Example:
+//
+//	body, err := ElasticMapping(&Article{})
+//	// {"mappings":{"properties":{"title":{"type":"text","fields":{"keyword":{"type":"keyword"}}}, ...}}}
+//	_, err = client.CreateIndex("articles").BodyJson(body).Do(ctx)
+func ElasticMapping(model any) (map[string]any, error) {
+	schema, err := schemaOf(model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get model schema: %w", err)
+	}
+	return map[string]any{
+		"mappings": map[string]any{"properties": mappingProperties(schema, make(map[*modelSchema]bool))},
+	}, nil
+}
+
+// mappingProperties returns the properties of a schema. Recursive types are
+// mapped once along each path.
+func mappingProperties(schema *modelSchema, seen map[*modelSchema]bool) map[string]any {
+	seen[schema] = true
+	defer delete(seen, schema)
+
+	properties := make(map[string]any, len(schema.fields))
+	for _, f := range schema.fields {
+		if f.Children != nil {
+			if seen[f.Children] {
+				continue
+			}
+			property := map[string]any{"properties": mappingProperties(f.Children, seen)}
+			if f.Nested {
+				property["type"] = "nested"
+			}
+			properties[f.JSON] = property
+			continue
+		}
+		if f.ESType == "" {
+			continue
+		}
+		property := map[string]any{"type": f.ESType}
+		if f.ESKeyword != "" {
+			property["fields"] = map[string]any{f.ESKeyword: map[string]any{"type": "keyword"}}
+		}
+		properties[f.JSON] = property
+	}
+	return properties
+}
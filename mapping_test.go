@@ -0,0 +1,167 @@
+package queryparser
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Product declares Elasticsearch field types in es tags
+type Product struct {
+	ID          int               `json:"id"`
+	SKU         string            `json:"sku" es:"keyword"`
+	Name        string            `json:"name" es:"text,keyword"`
+	Description string            `json:"description" es:"text"`
+	Brand       string            `json:"brand" es:"text,keyword=raw"`
+	Summary     string            `json:"summary" query:"searchable"`
+	Price       float32           `json:"price"`
+	Stock       int32             `json:"stock" es:"long"`
+	Released    time.Time         `json:"released"`
+	OnSale      bool              `json:"onSale"`
+	Tags        []string          `json:"tags"`
+	Attributes  map[string]string `json:"attributes"`
+	Variants    []ProductVariant  `json:"variants" es:"nested"`
+	Payload     any               `json:"payload"`
+}
+
+type ProductVariant struct {
+	Color string `json:"color" es:"text,keyword"`
+	Size  int8   `json:"size"`
+}
+
+func TestElasticMapping(t *testing.T) {
+	mapping, err := ElasticMapping(&Product{})
+	assert.NoError(t, err)
+
+	body, err := json.Marshal(mapping)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"mappings":{"properties":{
+		"id":{"type":"long"},
+		"sku":{"type":"keyword"},
+		"name":{"type":"text","fields":{"keyword":{"type":"keyword"}}},
+		"description":{"type":"text"},
+		"brand":{"type":"text","fields":{"raw":{"type":"keyword"}}},
+		"summary":{"type":"text","fields":{"keyword":{"type":"keyword"}}},
+		"price":{"type":"float"},
+		"stock":{"type":"long"},
+		"released":{"type":"date"},
+		"onSale":{"type":"boolean"},
+		"tags":{"type":"keyword"},
+		"attributes":{"type":"object"},
+		"variants":{"type":"nested","properties":{
+			"color":{"type":"text","fields":{"keyword":{"type":"keyword"}}},
+			"size":{"type":"byte"}
+		}}
+	}}}`, string(body))
+
+	_, err = ElasticMapping("product")
+	assert.EqualError(t, err, "failed to get model schema: expected struct or pointer to struct, got string")
+}
+
+func TestElasticFieldTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters []Filter
+		want    string
+	}{
+		{
+			name:    "keyword field uses term",
+			filters: []Filter{{Field: "sku", Operator: OpEq, Value: "A-1"}},
+			want:    `{"bool":{"must":{"term":{"sku":"A-1"}}}}`,
+		},
+		{
+			name: "text field uses its keyword subfield",
+			filters: []Filter{
+				{Field: "name", Operator: OpEq, Value: "Desk Lamp"},
+				{Field: "brand", Operator: OpIn, Value: []any{"Acme", "Globex"}},
+			},
+			want: `{"bool":{"must":[{"term":{"name.keyword":"Desk Lamp"}},{"terms":{"brand.raw":["Acme","Globex"]}}]}}`,
+		},
+		{
+			name: "text field without subfield uses match_phrase",
+			filters: []Filter{
+				{Field: "description", Operator: OpEq, Value: "solid oak"},
+				{Field: "description", Operator: OpNin, Value: []any{"plastic"}},
+			},
+			want: `{"bool":{"must":[{"match_phrase":{"description":{"query":"solid oak"}}},` +
+				`{"bool":{"must_not":{"bool":{"should":{"match_phrase":{"description":{"query":"plastic"}}}}}}}]}}`,
+		},
+		{
+			name: "full-text and exists keep the text field",
+			filters: []Filter{
+				{Field: "summary", Operator: OpSearch, Value: "lamp"},
+				{Field: "summary", Operator: OpExists, Value: true},
+			},
+			want: `{"bool":{"must":[{"match":{"summary":{"query":"lamp"}}},{"exists":{"field":"summary"}}]}}`,
+		},
+		{
+			name:    "nested text field",
+			filters: []Filter{{Field: "variants.color", Operator: OpNe, Value: "red"}},
+			want:    `{"bool":{"must":{"nested":{"path":"variants","query":{"bool":{"must_not":{"term":{"variants.color.keyword":"red"}}}}}}}}`,
+		},
+		{
+			name: "text field with a subfield in $elemMatch",
+			filters: []Filter{{Field: "variants", Operator: OpElemMatch, Filters: []Filter{
+				{Field: "color", Operator: OpEq, Value: "red"},
+				{Field: "size", Operator: OpGte, Value: 2},
+			}}},
+			want: `{"bool":{"must":{"nested":{"path":"variants","query":{"bool":{"must":[` +
+				`{"term":{"variants.color.keyword":"red"}},{"range":{"variants.size":{"gte":2}}}]}}}}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewElasticBuilder(nil).Apply(tt.filters, nil, &Product{})
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			source, err := got.Source()
+			assert.NoError(t, err)
+			sourceStr, err := json.Marshal(source)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(sourceStr))
+		})
+	}
+}
+
+func TestElasticKeywordSorting(t *testing.T) {
+	source, err := NewElasticBuilder(nil).SearchSource(nil, &QueryOptions{
+		Sort: map[string]SortDirection{"name": SortAsc, "price": SortDesc},
+	}, &Product{})
+	assert.NoError(t, err)
+	body, err := source.Source()
+	assert.NoError(t, err)
+	bodyStr, err := json.Marshal(body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"query":{"bool":{}},"sort":[{"name.keyword":{"order":"asc"}},{"price":{"order":"desc"}}]}`, string(bodyStr))
+
+	source, err = NewElasticBuilder(nil).SearchSource(nil, &QueryOptions{
+		SortBy: []SortField{{Field: "price", Direction: SortDesc}, {Field: "name"}},
+	}, &Product{})
+	assert.NoError(t, err)
+	body, err = source.Source()
+	assert.NoError(t, err)
+	bodyStr, err = json.Marshal(body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"query":{"bool":{}},"sort":[{"price":{"order":"desc"}},{"name.keyword":{"order":"asc"}}]}`, string(bodyStr))
+
+	aggregations, err := NewElasticBuilder(nil).Aggregations(&QueryOptions{
+		GroupBy:    []string{"brand"},
+		Aggregates: []Aggregate{{Func: AggCountDistinct, Field: "name"}},
+	}, &Product{})
+	assert.NoError(t, err)
+	body, err = aggregations["brand"].Source()
+	assert.NoError(t, err)
+	bodyStr, err = json.Marshal(body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"aggregations":{"countDistinct_name":{"cardinality":{"field":"name.keyword"}}},"terms":{"field":"brand.raw"}}`, string(bodyStr))
+
+	_, err = NewElasticBuilder(nil).SearchSource(nil, &QueryOptions{
+		Sort: map[string]SortDirection{"description": SortAsc},
+	}, &Product{})
+	assert.EqualError(t, err, `field "description" is a text field without a keyword subfield and cannot be used for sorting`)
+}
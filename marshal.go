@@ -0,0 +1,162 @@
+package queryparser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// MarshalFilter encodes filters as a JSON filter, the inverse of ParseFilter.
+// Conditions on the same level are combined in one object when their fields
+// and operators do not overlap, and in an explicit $and otherwise, so that
+// parsing the result gives back equivalent filters:
+//
+//	filters, _ := ParseFilter(`{"age": {"$gte": 18, "$lt": 65}, "state": "open"}`)
+//	data, _ := MarshalFilter(filters)
+//	// {"age":{"$gte":18,"$lt":65},"state":{"$eq":"open"}}
+//
+// Keys are sorted, so equal filters in the same order always encode the same.
+func MarshalFilter(filters []Filter) ([]byte, error) {
+	document, err := filterDocument(filters, func(f Filter) (string, map[string]any, error) {
+		switch f.Operator {
+		case OpText:
+			return string(OpText), map[string]any{string(OpSearch): escapeLiterals(f.Value)}, nil
+		case OpRef:
+			// Merged into the document as {"$ref": "name"}
+			return "", map[string]any{string(OpRef): f.Value}, nil
+		}
+		value := f.Value
+		if f.Operator == OpBetween {
+			b, err := betweenValue(f)
+			if err != nil {
+				return "", nil, err
+			}
+			value = betweenDocument(b)
+		}
+		return f.Field, map[string]any{string(f.Operator): escapeLiterals(value)}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode filter: %w", err)
+	}
+	return data, nil
+}
+
+// documentLeaf compiles a condition into the key of a document, a field or
+// an operator such as $text, and its operator object. Conditions with an
+// empty key, on the elements of an $elemMatch, are merged into the document.
+type documentLeaf func(f Filter) (string, map[string]any, error)
+
+// filterDocument builds a MongoDB-style document from a conjunction of
+// filters. $or, $and and $elemMatch are rendered as such and leaf conditions
+// are compiled by leaf. Used by MarshalFilter and MongoBuilder.
+func filterDocument(filters []Filter, leaf documentLeaf) (map[string]any, error) {
+	parts := make([]map[string]any, 0, len(filters))
+	for _, f := range filters {
+		part, err := filterPart(f, leaf)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 1 {
+		return parts[0], nil
+	}
+	if document, ok := mergeParts(parts); ok {
+		return document, nil
+	}
+	and := make([]any, len(parts))
+	for i, part := range parts {
+		and[i] = part
+	}
+	return map[string]any{string(OpAnd): and}, nil
+}
+
+// filterPart builds the document of a single filter
+func filterPart(f Filter, leaf documentLeaf) (map[string]any, error) {
+	switch f.Operator {
+	case OpOr, OpAnd:
+		children := make([]any, 0, len(f.Filters))
+		for _, child := range f.Filters {
+			part, err := filterPart(child, leaf)
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, part)
+		}
+		return map[string]any{string(f.Operator): children}, nil
+	case OpElemMatch:
+		elements, err := filterDocument(f.Filters, leaf)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{f.Field: map[string]any{string(OpElemMatch): elements}}, nil
+	}
+
+	key, condition, err := leaf(f)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return condition, nil
+	}
+	return map[string]any{key: condition}, nil
+}
+
+// mergeParts combines documents into one when none of them is a group and
+// the operators of conditions on the same field do not overlap. Groups are
+// kept apart because ParseFilter ignores the other keys of an object that
+// has $or or $and.
+func mergeParts(parts []map[string]any) (map[string]any, bool) {
+	document := make(map[string]any)
+	for _, part := range parts {
+		for key, value := range part {
+			if key == string(OpOr) || key == string(OpAnd) {
+				return nil, false
+			}
+			existing, ok := document[key]
+			if !ok {
+				document[key] = value
+				continue
+			}
+			merged, ok := mergeOperators(key, existing, value)
+			if !ok {
+				return nil, false
+			}
+			document[key] = merged
+		}
+	}
+	return document, true
+}
+
+// mergeOperators combines two operator objects of the same field
+func mergeOperators(key string, a, b any) (map[string]any, bool) {
+	left, ok := a.(map[string]any)
+	right, ok2 := b.(map[string]any)
+	if !ok || !ok2 || strings.HasPrefix(key, "$") {
+		return nil, false
+	}
+	merged := make(map[string]any, len(left)+len(right))
+	for op, value := range left {
+		merged[op] = value
+	}
+	for op, value := range right {
+		if _, exists := merged[op]; exists || !strings.HasPrefix(op, "$") {
+			return nil, false
+		}
+		merged[op] = value
+	}
+	return merged, true
+}
+
+// betweenDocument returns the JSON value of a $between range: a two-element
+// array when both bounds are included, or an object with its bounds
+func betweenDocument(b Between) any {
+	if b.Bounds == "" || b.Bounds == "[]" {
+		return []any{b.From, b.To}
+	}
+	return map[string]any{"from": b.From, "to": b.To, "bounds": b.Bounds}
+}
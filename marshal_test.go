@@ -0,0 +1,86 @@
+package queryparser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters []Filter
+		want    string
+	}{
+		{
+			name: "conditions on different fields share an object",
+			filters: []Filter{
+				{Field: "age", Operator: OpGte, Value: 18},
+				{Field: "age", Operator: OpLt, Value: 65},
+				{Field: "state", Operator: OpEq, Value: "open"},
+			},
+			want: `{"age":{"$gte":18,"$lt":65},"state":{"$eq":"open"}}`,
+		},
+		{
+			name: "repeated operators use $and",
+			filters: []Filter{
+				{Field: "tags", Operator: OpContains, Value: []any{"a"}},
+				{Field: "tags", Operator: OpContains, Value: []any{"b"}},
+			},
+			want: `{"$and":[{"tags":{"$contains":["a"]}},{"tags":{"$contains":["b"]}}]}`,
+		},
+		{
+			name: "groups are kept apart from other conditions",
+			filters: []Filter{
+				{Operator: OpOr, Filters: []Filter{
+					{Field: "qty", Operator: OpEq, Value: 0},
+					{Operator: OpAnd, Filters: []Filter{
+						{Field: "active", Operator: OpEq, Value: false},
+						{Field: "price", Operator: OpGt, Value: 100},
+					}},
+				}},
+				{Field: "name", Operator: OpLike, Value: "Desk%"},
+			},
+			want: `{"$and":[{"$or":[{"qty":{"$eq":0}},{"$and":[{"active":{"$eq":false}},{"price":{"$gt":100}}]}]},{"name":{"$like":"Desk%"}}]}`,
+		},
+		{
+			name: "elemMatch, text and between",
+			filters: []Filter{
+				{Field: "parts", Operator: OpElemMatch, Filters: []Filter{{Field: "qty", Operator: OpGt, Value: 1}}},
+				{Field: "tags", Operator: OpElemMatch, Filters: []Filter{{Operator: OpGte, Value: "m"}, {Operator: OpLt, Value: "p"}}},
+				{Operator: OpText, Value: "desk lamp"},
+				{Field: "created", Operator: OpBetween, Value: Between{From: "now-7d", To: "now", Bounds: "[)"}},
+				{Field: "price", Operator: OpBetween, Value: []any{1, 2}},
+			},
+			want: `{"$text":{"$search":"desk lamp"},"created":{"$between":{"bounds":"[)","from":"now-7d","to":"now"}},` +
+				`"parts":{"$elemMatch":{"qty":{"$gt":1}}},"price":{"$between":[1,2]},"tags":{"$elemMatch":{"$gte":"m","$lt":"p"}}}`,
+		},
+		{name: "no filters", want: `{}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MarshalFilter(tt.filters)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+
+	_, err := MarshalFilter([]Filter{{Field: "qty", Operator: OpBetween, Value: 1}})
+	assert.EqualError(t, err, `$between operator requires two values for field "qty"`)
+}
+
+func TestMarshalFilterRoundTrips(t *testing.T) {
+	for _, tt := range conformanceCorpus {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := ParseFilter(tt.filter)
+			assert.NoError(t, err)
+			data, err := MarshalFilter(filters)
+			assert.NoError(t, err)
+			parsed, err := ParseFilter(string(data))
+			if assert.NoError(t, err, string(data)) {
+				assert.Equal(t, sortedFilters(filters), sortedFilters(parsed), string(data))
+			}
+		})
+	}
+}
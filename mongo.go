@@ -0,0 +1,297 @@
+package queryparser
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MongoBuilder compiles filters and options to MongoDB queries as plain maps,
+// without depending on a driver. Convert them with bson.M, or marshal them to
+// JSON for the shell.
+//
+// Conditions are kept as close to the other builders as MongoDB allows:
+//   - $exists checks that a field is set and not null, like IS NOT NULL
+//   - $like becomes a case-insensitive $regex, with % and _ as wildcards
+//   - $between becomes a range, $contains an $in and $size, $all and
+//     $elemMatch are used as such
+//   - $text and $search both become a $text query, which searches the text
+//     index of the collection
+//   - relative times and timestamps of time.Time fields become time.Time
+//     values, which drivers encode as dates
+//
+// Relations, grouping, aggregates and relevance sorting are not supported.
+type MongoBuilder struct {
+	clock      func() time.Time
+	ctx        context.Context
+	authorizer Authorizer
+}
+
+// NewMongoBuilder creates a MongoBuilder
+func NewMongoBuilder() *MongoBuilder {
+	return &MongoBuilder{ctx: context.Background()}
+}
+
+// SetContext sets the context of the caller, whose roles are required by
+// restricted fields, see DSLBuilder.SetContext
+func (b *MongoBuilder) SetContext(ctx context.Context) {
+	b.ctx = ctx
+}
+
+// SetAuthorizer sets the Authorizer that Query and Find consult for every
+// use of a field. It requires a model.
+func (b *MongoBuilder) SetAuthorizer(auth Authorizer) {
+	b.authorizer = auth
+}
+
+// SetClock sets the clock used to resolve relative times such as "now-7d".
+// The default is time.Now.
+func (b *MongoBuilder) SetClock(clock func() time.Time) {
+	b.clock = clock
+}
+
+// Query returns the query document of the filters, e.g.
+// {"status": {"$eq": "open"}, "age": {"$gte": 18}}. When a model is given,
+// the filter and sort fields are validated against its JSON fields and
+// checked against their roles and the Authorizer, see SetContext.
+func (b *MongoBuilder) Query(filters []Filter, options *QueryOptions, model any) (map[string]any, error) {
+	var schema *modelSchema
+	if model != nil {
+		var err error
+		schema, err = schemaOf(model)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get model schema: %w", err)
+		}
+		if err := validateFields(filters, options, schema); err != nil {
+			return nil, err
+		}
+		if err := validateMongoPaths(filters, options, schema); err != nil {
+			return nil, err
+		}
+	}
+	if err := authorizeModel(b.ctx, filters, options, schema, b.authorizer); err != nil {
+		return nil, err
+	}
+	if options != nil && options.grouped() {
+		return nil, fmt.Errorf("MongoBuilder does not support groupBy or aggregates")
+	}
+	if options != nil && options.Relevance {
+		return nil, fmt.Errorf("relevance sorting is not supported by MongoBuilder")
+	}
+
+	filters, err := resolveTimes(filters, schema, clockNow(b.clock), false)
+	if err != nil {
+		return nil, err
+	}
+	if len(filters) == 0 {
+		return map[string]any{}, nil
+	}
+	return filterDocument(filters, func(f Filter) (string, map[string]any, error) {
+		return mongoCondition(f, schema)
+	})
+}
+
+// MongoSort is a sort document, whose keys apply in order. Its keys have the
+// fields of the driver's bson.E, so it converts to a bson.D:
+//
+//	var sort bson.D
+//	for _, key := range find["sort"].(MongoSort) {
+//		sort = append(sort, bson.E{Key: key.Key, Value: key.Value})
+//	}
+//
+// It is encoded to JSON as an object with the keys in order.
+type MongoSort []MongoSortKey
+
+// MongoSortKey sorts by a field, in ascending order for 1 and descending
+// order for -1
+type MongoSortKey struct {
+	Key   string
+	Value int
+}
+
+// MarshalJSON encodes the sort as an object, e.g. {"created": -1, "name": 1}
+func (s MongoSort) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range s {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, err := json.Marshal(key.Key)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		b.WriteString(strconv.Itoa(key.Value))
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// Find returns the arguments of a find command: the filter and the sort,
+// skip, limit and projection set in the options, e.g.
+//
+//	{"filter": {"age": {"$gte": 18}}, "sort": {"name": 1}, "limit": 20}
+//
+// The sort is a MongoSort, which keeps the order of the sort fields.
+func (b *MongoBuilder) Find(filters []Filter, options *QueryOptions, model any) (map[string]any, error) {
+	query, err := b.Query(filters, options, model)
+	if err != nil {
+		return nil, err
+	}
+	find := map[string]any{"filter": query}
+	if options == nil {
+		return find, nil
+	}
+	if sortFields := options.sortFields(); len(sortFields) > 0 {
+		sort := make(MongoSort, len(sortFields))
+		for i, field := range sortFields {
+			sort[i] = MongoSortKey{Key: field.Field, Value: 1}
+			if field.Direction == SortDesc {
+				sort[i].Value = -1
+			}
+		}
+		find["sort"] = sort
+	}
+	if options.Offset != nil {
+		find["skip"] = *options.Offset
+	}
+	if options.Limit != nil {
+		find["limit"] = *options.Limit
+	}
+	if len(options.Fields) > 0 {
+		projection := make(map[string]any, len(options.Fields))
+		for _, field := range options.Fields {
+			projection[field] = 1
+		}
+		find["projection"] = projection
+	}
+	return find, nil
+}
+
+// validateMongoPaths rejects relations, which have no MongoDB equivalent
+func validateMongoPaths(filters []Filter, options *QueryOptions, schema *modelSchema) error {
+	check := func(path string) error {
+		chain, ok := schema.lookup(path)
+		if ok && chain[0].Relation != nil {
+			return fmt.Errorf("field %q is a relation, which MongoBuilder does not support", path)
+		}
+		return nil
+	}
+	err := Walk(filters, func(c *Cursor) error {
+		filter := c.Filter()
+		if filter.Operator == OpOr || filter.Operator == OpAnd || filter.Operator == OpText {
+			return nil
+		}
+		if err := check(filter.Field); err != nil {
+			return err
+		}
+		if filter.Operator == OpElemMatch {
+			return SkipChildren
+		}
+		return nil
+	}, nil)
+	if err != nil || options == nil {
+		return err
+	}
+	for _, field := range options.Fields {
+		if err := check(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mongoCondition compiles a leaf condition into its field and operator object
+func mongoCondition(f Filter, schema *modelSchema) (string, map[string]any, error) {
+	value := func(v any) any {
+		return mongoValue(v, schema, f.Field)
+	}
+
+	switch f.Operator {
+	case OpEq, OpNe, OpLt, OpLte, OpGt, OpGte:
+		return f.Field, map[string]any{string(f.Operator): value(f.Value)}, nil
+	case OpIn, OpNin:
+		values, err := setValues(f)
+		if err != nil {
+			return "", nil, err
+		}
+		converted := make([]any, len(values))
+		for i, v := range values {
+			converted[i] = value(v)
+		}
+		return f.Field, map[string]any{string(f.Operator): converted}, nil
+	case OpLike:
+		pattern, err := likeValue(f)
+		if err != nil {
+			return "", nil, err
+		}
+		regex := strings.TrimPrefix(likePattern(pattern).String(), "(?is)")
+		return f.Field, map[string]any{"$regex": regex, "$options": "is"}, nil
+	case OpExists:
+		exists, ok := f.Value.(bool)
+		if !ok {
+			return "", nil, fmt.Errorf("$exists operator requires a boolean value for field %q", f.Field)
+		}
+		if exists {
+			return f.Field, map[string]any{string(OpNe): nil}, nil
+		}
+		return f.Field, map[string]any{string(OpEq): nil}, nil
+	case OpBetween:
+		b, err := betweenValue(f)
+		if err != nil {
+			return "", nil, err
+		}
+		condition := map[string]any{string(OpGte): value(b.From), string(OpLte): value(b.To)}
+		if !b.lowerInclusive() {
+			condition = map[string]any{string(OpGt): value(b.From), string(OpLte): value(b.To)}
+		}
+		if !b.upperInclusive() {
+			delete(condition, string(OpLte))
+			condition[string(OpLt)] = value(b.To)
+		}
+		return f.Field, condition, nil
+	case OpAll, OpContains:
+		values, err := arrayValues(f)
+		if err != nil {
+			return "", nil, err
+		}
+		op := OpAll
+		if f.Operator == OpContains {
+			op = OpIn
+		}
+		return f.Field, map[string]any{string(op): values}, nil
+	case OpSize:
+		size, err := sizeValue(f)
+		if err != nil {
+			return "", nil, err
+		}
+		return f.Field, map[string]any{string(OpSize): size}, nil
+	case OpText, OpSearch:
+		text, err := searchText(f)
+		if err != nil {
+			return "", nil, err
+		}
+		return string(OpText), map[string]any{string(OpSearch): text}, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported operator: %s", f.Operator)
+	}
+}
+
+// mongoValue converts timestamps compared with time.Time fields to
+// time.Time, as MongoDB does not compare dates with strings
+func mongoValue(v any, schema *modelSchema, field string) any {
+	s, ok := v.(string)
+	if !ok || !isTimeField(schema, field) {
+		return v
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t
+	}
+	return v
+}
@@ -0,0 +1,84 @@
+package queryparser
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMongoBuilderQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		want    string
+		wantErr string
+	}{
+		{name: "comparisons", filter: `{"qty": {"$gt": 2, "$lte": 12}, "name": "Desk"}`, want: `{"name":{"$eq":"Desk"},"qty":{"$gt":2,"$lte":12}}`},
+		{name: "or", filter: `{"$or": [{"qty": 0}, {"price": {"$gt": 100}}]}`, want: `{"$or":[{"qty":{"$eq":0}},{"price":{"$gt":100}}]}`},
+		{name: "like", filter: `{"name": {"$like": "Cable 5_"}}`, want: `{"name":{"$options":"is","$regex":"^Cable 5.$"}}`},
+		{name: "exists", filter: `{"category": {"$exists": true}}`, want: `{"category":{"$ne":null}}`},
+		{name: "missing", filter: `{"category": {"$exists": false}}`, want: `{"category":{"$eq":null}}`},
+		{name: "between", filter: `{"qty": {"$between": {"from": 0, "to": 5, "bounds": "(]"}}}`, want: `{"qty":{"$gt":0,"$lte":5}}`},
+		{name: "relative time", filter: `{"created": {"$gte": "now/d"}}`, want: `{"created":{"$gte":"2024-05-15T00:00:00Z"}}`},
+		{name: "timestamp", filter: `{"created": {"$in": ["2024-05-01T00:00:00Z"]}}`, want: `{"created":{"$in":["2024-05-01T00:00:00Z"]}}`},
+		{name: "arrays", filter: `{"tags": {"$contains": ["home"], "$size": 2}}`, want: `{"tags":{"$in":["home"],"$size":2}}`},
+		{name: "elemMatch", filter: `{"parts": {"$elemMatch": {"qty": {"$gt": 1}}}}`, want: `{"parts":{"$elemMatch":{"qty":{"$gt":1}}}}`},
+		{name: "text", filter: `{"$text": {"$search": "desk"}}`, want: `{"$text":{"$search":"desk"}}`},
+		{name: "search", filter: `{"name": {"$search": "desk"}}`, want: `{"$text":{"$search":"desk"}}`},
+		{name: "no filter", filter: `{}`, want: `{}`},
+		{name: "unknown field", filter: `{"color": "red"}`, wantErr: `field "color" is not a valid JSON field`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := ParseFilter(tt.filter)
+			assert.NoError(t, err)
+			b := NewMongoBuilder()
+			b.SetClock(fixedClock)
+			got, err := b.Query(filters, nil, &Item{})
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			data, err := json.Marshal(got)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(data))
+		})
+	}
+}
+
+func TestMongoBuilderFind(t *testing.T) {
+	filters := []Filter{{Field: "qty", Operator: OpGt, Value: 1}}
+	options := &QueryOptions{
+		Sort:   map[string]SortDirection{"name": SortAsc, "price": SortDesc},
+		Limit:  intPtr(20),
+		Offset: intPtr(40),
+		Fields: []string{"id", "name"},
+	}
+	got, err := NewMongoBuilder().Find(filters, options, &Item{})
+	assert.NoError(t, err)
+	data, err := json.Marshal(got)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"filter":{"qty":{"$gt":1}},"limit":20,"projection":{"id":1,"name":1},"skip":40,"sort":{"name":1,"price":-1}}`, string(data))
+
+	query, err := NewMongoBuilder().Query([]Filter{{Field: "created", Operator: OpLt, Value: "2024-05-01T00:00:00Z"}}, nil, &Item{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{"created": map[string]any{"$lt": time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)}}, query)
+
+	// Ordered sort fields keep their order
+	got, err = NewMongoBuilder().Find(nil, &QueryOptions{SortBy: []SortField{{Field: "price", Direction: SortDesc}, {Field: "name"}}}, &Item{})
+	assert.NoError(t, err)
+	assert.Equal(t, MongoSort{{Key: "price", Value: -1}, {Key: "name", Value: 1}}, got["sort"])
+	data, err = json.Marshal(got)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"filter":{},"sort":{"price":-1,"name":1}}`, string(data))
+
+	_, err = NewMongoBuilder().Find(nil, &QueryOptions{GroupBy: []string{"category"}}, &Item{})
+	assert.EqualError(t, err, "MongoBuilder does not support groupBy or aggregates")
+
+	_, err = NewMongoBuilder().Query([]Filter{{Field: "orders.total", Operator: OpGt, Value: 1}}, nil, &RelUser{})
+	assert.EqualError(t, err, `field "orders.total" is a relation, which MongoBuilder does not support`)
+}
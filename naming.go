@@ -0,0 +1,87 @@
+package queryparser
+
+import (
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// NamingStrategy resolves the database column for a struct field. It returns
+// an empty string when the field has no column.
+type NamingStrategy func(field reflect.StructField) string
+
+// DBTagNaming uses the field's db tag as the column name. This is the default
+// strategy used by SqlBuilder.
+func DBTagNaming(field reflect.StructField) string {
+	return tagName(field.Tag.Get("db"))
+}
+
+// JSONTagNaming uses the field's json tag as the column name
+func JSONTagNaming(field reflect.StructField) string {
+	return tagName(field.Tag.Get("json"))
+}
+
+// SnakeCaseNaming derives the column name from the Go field name, e.g.
+// CreatedAt becomes created_at and UserID becomes user_id
+func SnakeCaseNaming(field reflect.StructField) string {
+	return toSnakeCase(field.Name)
+}
+
+// GormTagNaming reads the column from a gorm tag (e.g. `gorm:"column:name"`)
+// and falls back to snake_case like gorm does
+func GormTagNaming(field reflect.StructField) string {
+	tag := field.Tag.Get("gorm")
+	if tag == "-" {
+		return ""
+	}
+	for _, part := range strings.Split(tag, ";") {
+		if column, ok := strings.CutPrefix(strings.TrimSpace(part), "column:"); ok {
+			return column
+		}
+	}
+	return toSnakeCase(field.Name)
+}
+
+// BunTagNaming reads the column from a bun tag (e.g. `bun:"name,pk"`) and
+// falls back to snake_case like bun does
+func BunTagNaming(field reflect.StructField) string {
+	tag := field.Tag.Get("bun")
+	if tag == "-" {
+		return ""
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name
+	}
+	return toSnakeCase(field.Name)
+}
+
+// tagName returns the name part of a tag such as "name,omitempty"
+func tagName(tag string) string {
+	name := strings.Split(tag, ",")[0]
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// toSnakeCase converts a Go identifier to snake_case, keeping acronyms
+// together (HTTPServer becomes http_server)
+func toSnakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				prev := runes[i-1]
+				nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+				if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+					b.WriteByte('_')
+				}
+			}
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
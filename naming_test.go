@@ -0,0 +1,82 @@
+package queryparser
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// NamingModel has fields tagged for several ORMs
+type NamingModel struct {
+	ID        int    `json:"id" db:"user_id" gorm:"column:gorm_id;primaryKey" bun:"bun_id,pk"`
+	FullName  string `json:"full_name" gorm:"type:varchar(100)"`
+	HTTPCode  int    `json:"http_code" db:"-" gorm:"-" bun:"-"`
+	Internal  string `json:"-"`
+	CreatedAt string `json:"created_at,omitempty" bun:",nullzero"`
+}
+
+func TestNamingStrategies(t *testing.T) {
+	typ := reflect.TypeOf(NamingModel{})
+	field := func(name string) reflect.StructField {
+		f, ok := typ.FieldByName(name)
+		assert.True(t, ok)
+		return f
+	}
+
+	tests := []struct {
+		name   string
+		naming NamingStrategy
+		want   map[string]string
+	}{
+		{
+			name:   "db tag",
+			naming: DBTagNaming,
+			want:   map[string]string{"ID": "user_id", "FullName": "", "HTTPCode": "", "Internal": "", "CreatedAt": ""},
+		},
+		{
+			name:   "json tag",
+			naming: JSONTagNaming,
+			want:   map[string]string{"ID": "id", "FullName": "full_name", "HTTPCode": "http_code", "Internal": "", "CreatedAt": "created_at"},
+		},
+		{
+			name:   "snake case",
+			naming: SnakeCaseNaming,
+			want:   map[string]string{"ID": "id", "FullName": "full_name", "HTTPCode": "http_code", "Internal": "internal", "CreatedAt": "created_at"},
+		},
+		{
+			name:   "gorm tag",
+			naming: GormTagNaming,
+			want:   map[string]string{"ID": "gorm_id", "FullName": "full_name", "HTTPCode": "", "Internal": "internal", "CreatedAt": "created_at"},
+		},
+		{
+			name:   "bun tag",
+			naming: BunTagNaming,
+			want:   map[string]string{"ID": "bun_id", "FullName": "full_name", "HTTPCode": "", "Internal": "internal", "CreatedAt": "created_at"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, want := range tt.want {
+				assert.Equal(t, want, tt.naming(field(name)), name)
+			}
+		})
+	}
+}
+
+func TestToSnakeCase(t *testing.T) {
+	tests := map[string]string{
+		"ID":         "id",
+		"Name":       "name",
+		"CreatedAt":  "created_at",
+		"UserID":     "user_id",
+		"HTTPServer": "http_server",
+		"Address2":   "address2",
+		"V2Config":   "v2_config",
+		"already_ok": "already_ok",
+	}
+	for in, want := range tests {
+		assert.Equal(t, want, toSnakeCase(in), in)
+	}
+}
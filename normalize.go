@@ -0,0 +1,635 @@
+package queryparser
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Normalize rewrites a filter tree into an equivalent canonical form.
+//
+// The pass flattens nested $and/$or groups, collapses single-child groups,
+// removes duplicate conditions, folds $eq conditions on the same field inside
+// an $or into a single $in, drops always-false branches and sorts every level
+// into a stable order. The input is never modified.
+//
+// Conditions combined with $and are not merged: without the model, a field
+// may hold several values, such as an array, a relation or a JSON document,
+// and {"tags": "a"} AND {"tags": "b"} can match. Use NormalizeModel to also
+// merge them on the fields of a model that hold a single value.
+//
+// Example:
+//
+//	filters, _ := ParseFilter(`{"$or": [{"state": "open"}, {"state": "new"}]}`)
+//	filters = Normalize(filters)
+//	// filters is now [{Field: "state", Operator: OpIn, Value: []any{"new", "open"}}]
+func Normalize(filters []Filter) []Filter {
+	return normalizer{}.and(filters)
+}
+
+// NormalizeModel normalizes filters like Normalize, and also intersects
+// $eq/$in conditions and merges range bounds on the same field inside an
+// $and, for fields of the model that hold a single scalar value. Fields of
+// arrays, relations and JSON columns are left unmerged, as their conditions
+// may match different elements. Only numbers and times are compared: string
+// bounds such as "now-7d" are kept as they are.
+//
+// A tree that can never match (e.g. {"age": {"$gt": 30, "$lt": 20}}) is
+// reduced to a single empty $in on one of the conflicting fields, which every
+// builder renders as an always-false condition. Use IsAlwaysFalse to detect it.
+func NormalizeModel(filters []Filter, model any) ([]Filter, error) {
+	schema, err := schemaOf(model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get model schema: %w", err)
+	}
+	return normalizer{schema: schema}.and(filters), nil
+}
+
+// IsAlwaysFalse reports whether a normalized filter list can never match
+func IsAlwaysFalse(filters []Filter) bool {
+	return len(filters) == 1 && isFalseFilter(filters[0])
+}
+
+// falseFilter returns the canonical always-false condition for a field
+func falseFilter(field string) Filter {
+	return Filter{Field: field, Operator: OpIn, Value: []any{}}
+}
+
+// isFalseFilter reports whether a filter is the canonical always-false condition
+func isFalseFilter(f Filter) bool {
+	if f.Operator != OpIn {
+		return false
+	}
+	values, ok := sliceValues(f.Value)
+	return ok && len(values) == 0
+}
+
+// normalizer normalizes filters on the fields of a schema. Conditions under
+// $and are merged only when the schema is known.
+type normalizer struct {
+	schema *modelSchema
+}
+
+// singleValued reports whether a field holds a single scalar value, so that
+// all its conditions under $and apply to that value
+func (n normalizer) singleValued(field string) bool {
+	if n.schema == nil {
+		return false
+	}
+	chain, ok := n.schema.lookup(field)
+	if !ok {
+		return false
+	}
+	for _, f := range chain {
+		if f.Repeated || f.Relation != nil || f.JSONColumn {
+			return false
+		}
+	}
+	leaf := chain[len(chain)-1]
+	return leaf.Children == nil && !isFreeForm(leaf.Type)
+}
+
+// elements returns the normalizer of the conditions of an $elemMatch, which
+// apply to one element of the array
+func (n normalizer) elements(field string) normalizer {
+	if n.schema == nil {
+		return normalizer{}
+	}
+	chain, ok := n.schema.lookup(field)
+	if !ok {
+		return normalizer{}
+	}
+	return normalizer{schema: chain[len(chain)-1].Children}
+}
+
+// filter normalizes a single filter node
+func (n normalizer) filter(f Filter) Filter {
+	switch f.Operator {
+	case OpAnd:
+		if len(f.Filters) == 0 {
+			return f
+		}
+		children := n.and(f.Filters)
+		if len(children) == 1 {
+			return children[0]
+		}
+		return Filter{Operator: OpAnd, Filters: children}
+	case OpOr:
+		if len(f.Filters) == 0 {
+			return f
+		}
+		children := n.or(f.Filters)
+		if len(children) == 1 {
+			return children[0]
+		}
+		return Filter{Operator: OpOr, Filters: children}
+	case OpIn, OpNin:
+		values, ok := sliceValues(f.Value)
+		if !ok {
+			return f
+		}
+		return Filter{Field: f.Field, Operator: f.Operator, Value: uniqueSortedValues(values)}
+	case OpElemMatch:
+		// Conditions on the same element are a conjunction
+		return Filter{Field: f.Field, Operator: OpElemMatch, Filters: n.elements(f.Field).and(f.Filters)}
+	default:
+		return f
+	}
+}
+
+// and normalizes the children of a conjunction
+func (n normalizer) and(filters []Filter) []Filter {
+	var flat []Filter
+	for _, f := range filters {
+		c := n.filter(f)
+		if c.Operator == OpAnd && len(c.Filters) > 0 {
+			flat = append(flat, c.Filters...)
+			continue
+		}
+		flat = append(flat, c)
+	}
+
+	for _, f := range flat {
+		if isFalseFilter(f) {
+			return []Filter{f}
+		}
+	}
+
+	// Group mergeable leaf conditions by field, keeping first-seen order
+	groups := make(map[string][]Filter)
+	var fields []string
+	var rest []Filter
+	for _, f := range flat {
+		switch f.Operator {
+		case OpEq, OpIn, OpGt, OpGte, OpLt, OpLte:
+			if !n.singleValued(f.Field) {
+				rest = append(rest, f)
+				continue
+			}
+			if _, seen := groups[f.Field]; !seen {
+				fields = append(fields, f.Field)
+			}
+			groups[f.Field] = append(groups[f.Field], f)
+		default:
+			rest = append(rest, f)
+		}
+	}
+
+	result := rest
+	for _, field := range fields {
+		merged := mergeAndGroup(field, groups[field])
+		if len(merged) == 1 && isFalseFilter(merged[0]) {
+			return merged
+		}
+		result = append(result, merged...)
+	}
+
+	return sortFilters(dedupeFilters(result))
+}
+
+// or normalizes the children of a disjunction
+func (n normalizer) or(filters []Filter) []Filter {
+	var flat []Filter
+	for _, f := range filters {
+		c := n.filter(f)
+		if c.Operator == OpOr && len(c.Filters) > 0 {
+			flat = append(flat, c.Filters...)
+			continue
+		}
+		flat = append(flat, c)
+	}
+
+	var live []Filter
+	for _, f := range flat {
+		if !isFalseFilter(f) {
+			live = append(live, f)
+		}
+	}
+	if len(live) == 0 {
+		return []Filter{flat[0]}
+	}
+
+	// Fold $eq and $in on the same field into a single $in. Objects and
+	// arrays are kept out of it, as $eq on them may mean containment, such
+	// as @> on a jsonb column.
+	sets := make(map[string][]any)
+	var fields []string
+	var rest []Filter
+	for _, f := range live {
+		var values []any
+		switch f.Operator {
+		case OpEq:
+			if f.Value == nil || !scalarValue(f.Value) {
+				// IS NULL cannot be expressed as an $in member
+				rest = append(rest, f)
+				continue
+			}
+			values = []any{f.Value}
+		case OpIn:
+			v, ok := sliceValues(f.Value)
+			if !ok || !scalarValues(v) {
+				rest = append(rest, f)
+				continue
+			}
+			values = v
+		default:
+			rest = append(rest, f)
+			continue
+		}
+		if _, seen := sets[f.Field]; !seen {
+			fields = append(fields, f.Field)
+		}
+		sets[f.Field] = append(sets[f.Field], values...)
+	}
+
+	result := rest
+	for _, field := range fields {
+		result = append(result, setFilter(field, uniqueSortedValues(sets[field])))
+	}
+
+	return sortFilters(dedupeFilters(result))
+}
+
+// scalarValue reports whether a value is neither an object nor an array
+func scalarValue(v any) bool {
+	if _, ok := sliceValues(v); ok {
+		return false
+	}
+	return reflect.ValueOf(v).Kind() != reflect.Map
+}
+
+// scalarValues reports whether every value is a scalar
+func scalarValues(values []any) bool {
+	for _, v := range values {
+		if !scalarValue(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// bound is one side of a range condition
+type bound struct {
+	value     any
+	inclusive bool
+}
+
+// mergeAndGroup merges $eq, $in and range conditions on a single field that
+// are combined with AND. Conditions whose values cannot be compared, such as
+// strings and relative times, are kept unchanged, see compareBounds.
+func mergeAndGroup(field string, group []Filter) []Filter {
+	var lower, upper *bound
+	var allowed []any
+	restricted := false
+
+	for _, f := range group {
+		switch f.Operator {
+		case OpEq, OpIn:
+			values := []any{f.Value}
+			if f.Operator == OpIn {
+				v, ok := sliceValues(f.Value)
+				if !ok {
+					return group
+				}
+				values = v
+			}
+			if !scalarValues(values) {
+				return group
+			}
+			if !restricted {
+				allowed = uniqueSortedValues(values)
+				restricted = true
+				continue
+			}
+			allowed = intersectValues(allowed, values)
+		case OpGt, OpGte:
+			b := &bound{value: f.Value, inclusive: f.Operator == OpGte}
+			if lower == nil {
+				lower = b
+				continue
+			}
+			cmp, ok := compareBounds(b.value, lower.value)
+			if !ok {
+				return group
+			}
+			if cmp > 0 || (cmp == 0 && !b.inclusive) {
+				lower = b
+			}
+		case OpLt, OpLte:
+			b := &bound{value: f.Value, inclusive: f.Operator == OpLte}
+			if upper == nil {
+				upper = b
+				continue
+			}
+			cmp, ok := compareBounds(b.value, upper.value)
+			if !ok {
+				return group
+			}
+			if cmp < 0 || (cmp == 0 && !b.inclusive) {
+				upper = b
+			}
+		}
+	}
+
+	if restricted {
+		var kept []any
+		for _, v := range allowed {
+			inRange, ok := withinBounds(v, lower, upper)
+			if !ok {
+				return group
+			}
+			if inRange {
+				kept = append(kept, v)
+			}
+		}
+		return []Filter{setFilter(field, kept)}
+	}
+
+	if lower != nil && upper != nil {
+		cmp, ok := compareBounds(lower.value, upper.value)
+		if !ok {
+			return group
+		}
+		if cmp > 0 || (cmp == 0 && !(lower.inclusive && upper.inclusive)) {
+			return []Filter{falseFilter(field)}
+		}
+		if cmp == 0 {
+			return []Filter{{Field: field, Operator: OpEq, Value: lower.value}}
+		}
+	}
+
+	var result []Filter
+	if lower != nil {
+		op := OpGt
+		if lower.inclusive {
+			op = OpGte
+		}
+		result = append(result, Filter{Field: field, Operator: op, Value: lower.value})
+	}
+	if upper != nil {
+		op := OpLt
+		if upper.inclusive {
+			op = OpLte
+		}
+		result = append(result, Filter{Field: field, Operator: op, Value: upper.value})
+	}
+	return result
+}
+
+// withinBounds reports whether v satisfies the given lower and upper bounds
+func withinBounds(v any, lower, upper *bound) (bool, bool) {
+	if lower != nil {
+		cmp, ok := compareBounds(v, lower.value)
+		if !ok {
+			return false, false
+		}
+		if cmp < 0 || (cmp == 0 && !lower.inclusive) {
+			return false, true
+		}
+	}
+	if upper != nil {
+		cmp, ok := compareBounds(v, upper.value)
+		if !ok {
+			return false, false
+		}
+		if cmp > 0 || (cmp == 0 && !upper.inclusive) {
+			return false, true
+		}
+	}
+	return true, true
+}
+
+// setFilter builds the simplest filter matching any of the given values
+func setFilter(field string, values []any) Filter {
+	if len(values) == 0 {
+		return falseFilter(field)
+	}
+	if len(values) == 1 {
+		return Filter{Field: field, Operator: OpEq, Value: values[0]}
+	}
+	return Filter{Field: field, Operator: OpIn, Value: values}
+}
+
+// dedupeFilters removes filters with identical canonical keys
+func dedupeFilters(filters []Filter) []Filter {
+	seen := make(map[string]bool, len(filters))
+	result := make([]Filter, 0, len(filters))
+	for _, f := range filters {
+		key := filterKey(f)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, f)
+	}
+	return result
+}
+
+// sortFilters orders filters by their canonical key
+func sortFilters(filters []Filter) []Filter {
+	sort.SliceStable(filters, func(i, j int) bool {
+		return filterKey(filters[i]) < filterKey(filters[j])
+	})
+	return filters
+}
+
+// filterKey returns a canonical string representation of a filter
+func filterKey(f Filter) string {
+	if f.Operator == OpAnd || f.Operator == OpOr || f.Operator == OpElemMatch {
+		keys := make([]string, len(f.Filters))
+		for i, child := range f.Filters {
+			keys[i] = filterKey(child)
+		}
+		key := string(f.Operator) + "(" + strings.Join(keys, ",") + ")"
+		if f.Operator == OpElemMatch {
+			key = f.Field + " " + key
+		}
+		return key
+	}
+	return f.Field + " " + string(f.Operator) + " " + valueKey(f.Value)
+}
+
+// valueKey returns a canonical string representation of a filter value.
+// Numbers of different Go types with the same value share a key.
+func valueKey(v any) string {
+	if values, ok := sliceValues(v); ok {
+		keys := make([]string, len(values))
+		for i, value := range values {
+			keys[i] = valueKey(value)
+		}
+		return "[" + strings.Join(keys, ",") + "]"
+	}
+	if n, ok := numberKey(v); ok {
+		return "n:" + n
+	}
+	if t, ok := v.(time.Time); ok {
+		return "t:" + t.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprintf("%T:%v", v, v)
+}
+
+// uniqueSortedValues returns the distinct values in canonical order
+func uniqueSortedValues(values []any) []any {
+	seen := make(map[string]bool, len(values))
+	result := make([]any, 0, len(values))
+	for _, v := range values {
+		key := valueKey(v)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, v)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if cmp, ok := compareValues(result[i], result[j]); ok {
+			return cmp < 0
+		}
+		return valueKey(result[i]) < valueKey(result[j])
+	})
+	return result
+}
+
+// intersectValues returns the values of a that also appear in b
+func intersectValues(a, b []any) []any {
+	keys := make(map[string]bool, len(b))
+	for _, v := range b {
+		keys[valueKey(v)] = true
+	}
+	var result []any
+	for _, v := range a {
+		if keys[valueKey(v)] {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// sliceValues converts any slice or array value into a []any
+func sliceValues(v any) ([]any, bool) {
+	if values, ok := v.([]any); ok {
+		return values, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+	if rv.Type().Elem().Kind() == reflect.Uint8 {
+		// []byte is a scalar value, not a set
+		return nil, false
+	}
+	values := make([]any, rv.Len())
+	for i := range values {
+		values[i] = rv.Index(i).Interface()
+	}
+	return values, true
+}
+
+// numberKey returns the exact decimal form of a number. Integral floats
+// have the form of the equal integer, so 1 and 1.0 share a key, and integers
+// beyond the precision of a float64 keep every digit.
+func numberKey(v any) (string, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(rv.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+			return strconv.FormatInt(int64(f), 10), true
+		}
+		if f == math.Trunc(f) && f >= 0 && f < math.MaxUint64 {
+			return strconv.FormatUint(uint64(f), 10), true
+		}
+		return strconv.FormatFloat(f, 'g', -1, 64), true
+	default:
+		return "", false
+	}
+}
+
+// compareBounds orders two range bounds. Only numbers and times have an
+// order known before the query runs: strings may be relative times such as
+// "now-7d" or "startOfMonth", and are ordered by the collation of the backend.
+func compareBounds(a, b any) (int, bool) {
+	if !orderedBound(a) || !orderedBound(b) {
+		return 0, false
+	}
+	return compareValues(a, b)
+}
+
+// orderedBound reports whether a value is a number or a time
+func orderedBound(v any) bool {
+	if _, ok := v.(time.Time); ok {
+		return true
+	}
+	_, ok := numberKey(v)
+	return ok
+}
+
+// compareValues orders two scalar values. The second return value is false
+// when the values are not of comparable types.
+func compareValues(a, b any) (int, bool) {
+	if na, ok := bigNumber(a); ok {
+		nb, ok := bigNumber(b)
+		if !ok {
+			return 0, false
+		}
+		return na.Cmp(nb), true
+	}
+	switch av := a.(type) {
+	case string:
+		bv, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(av, bv), true
+	case time.Time:
+		bv, ok := b.(time.Time)
+		if !ok {
+			return 0, false
+		}
+		return av.Compare(bv), true
+	}
+	return 0, false
+}
+
+// bigNumber converts any numeric value other than NaN to an exact big.Float,
+// so that integers beyond the precision of a float64 compare exactly
+func bigNumber(v any) (*big.Float, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Float).SetInt64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return new(big.Float).SetUint64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if math.IsNaN(f) {
+			return nil, false
+		}
+		return new(big.Float).SetFloat64(f), true
+	default:
+		return nil, false
+	}
+}
+
+// toFloat converts any numeric value to float64
+func toFloat(v any) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	default:
+		return 0, false
+	}
+}
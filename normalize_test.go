@@ -0,0 +1,461 @@
+package queryparser
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// NormalizedUser has the single-valued fields that NormalizeModel merges
+type NormalizedUser struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Age       int       `json:"age"`
+	Email     string    `json:"email"`
+	State     string    `json:"state"`
+	Qty       int       `json:"qty"`
+	Created   time.Time `json:"created"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// normalizeModel normalizes filters on the fields of NormalizedUser
+func normalizeModel(t *testing.T, filters []Filter) []Filter {
+	t.Helper()
+	normalized, err := NormalizeModel(filters, &NormalizedUser{})
+	assert.NoError(t, err)
+	return normalized
+}
+
+func TestNormalize(t *testing.T) {
+	jan := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb := time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		input []Filter
+		want  []Filter
+	}{
+		{
+			name:  "empty filter",
+			input: nil,
+			want:  []Filter{},
+		},
+		{
+			name: "flattens nested $and",
+			input: []Filter{
+				{Field: "name", Operator: OpEq, Value: "mike"},
+				{Operator: OpAnd, Filters: []Filter{
+					{Field: "age", Operator: OpGt, Value: 20},
+					{Operator: OpAnd, Filters: []Filter{
+						{Field: "email", Operator: OpLike, Value: "example"},
+					}},
+				}},
+			},
+			want: []Filter{
+				{Field: "age", Operator: OpGt, Value: 20},
+				{Field: "email", Operator: OpLike, Value: "example"},
+				{Field: "name", Operator: OpEq, Value: "mike"},
+			},
+		},
+		{
+			name: "collapses single child $or",
+			input: []Filter{
+				{Operator: OpOr, Filters: []Filter{
+					{Field: "age", Operator: OpGt, Value: 20},
+				}},
+			},
+			want: []Filter{
+				{Field: "age", Operator: OpGt, Value: 20},
+			},
+		},
+		{
+			name: "removes duplicate conditions",
+			input: []Filter{
+				{Field: "name", Operator: OpLike, Value: "mi"},
+				{Field: "name", Operator: OpLike, Value: "mi"},
+				{Field: "age", Operator: OpNe, Value: 20},
+				{Field: "age", Operator: OpNe, Value: float64(20)},
+			},
+			want: []Filter{
+				{Field: "age", Operator: OpNe, Value: 20},
+				{Field: "name", Operator: OpLike, Value: "mi"},
+			},
+		},
+		{
+			name: "folds $eq inside $or into $in",
+			input: []Filter{
+				{Operator: OpOr, Filters: []Filter{
+					{Field: "name", Operator: OpEq, Value: "mike"},
+					{Field: "name", Operator: OpEq, Value: "anna"},
+					{Operator: OpOr, Filters: []Filter{
+						{Field: "name", Operator: OpIn, Value: []any{"zoe", "mike"}},
+					}},
+				}},
+			},
+			want: []Filter{
+				{Field: "name", Operator: OpIn, Value: []any{"anna", "mike", "zoe"}},
+			},
+		},
+		{
+			name: "keeps null equality out of $in",
+			input: []Filter{
+				{Operator: OpOr, Filters: []Filter{
+					{Field: "name", Operator: OpEq, Value: nil},
+					{Field: "name", Operator: OpEq, Value: "mike"},
+				}},
+			},
+			want: []Filter{
+				{Operator: OpOr, Filters: []Filter{
+					{Field: "name", Operator: OpEq, Value: nil},
+					{Field: "name", Operator: OpEq, Value: "mike"},
+				}},
+			},
+		},
+		{
+			name: "merges range bounds",
+			input: []Filter{
+				{Field: "age", Operator: OpGt, Value: 18},
+				{Field: "age", Operator: OpGte, Value: 21},
+				{Field: "age", Operator: OpLt, Value: 65},
+				{Field: "age", Operator: OpLte, Value: 65},
+			},
+			want: []Filter{
+				{Field: "age", Operator: OpGte, Value: 21},
+				{Field: "age", Operator: OpLt, Value: 65},
+			},
+		},
+		{
+			name: "merges time range bounds",
+			input: []Filter{
+				{Field: "created_at", Operator: OpGte, Value: jan},
+				{Field: "created_at", Operator: OpGt, Value: feb},
+			},
+			want: []Filter{
+				{Field: "created_at", Operator: OpGt, Value: feb},
+			},
+		},
+		{
+			name: "collapses equal inclusive bounds into $eq",
+			input: []Filter{
+				{Field: "age", Operator: OpGte, Value: 30},
+				{Field: "age", Operator: OpLte, Value: 30},
+			},
+			want: []Filter{
+				{Field: "age", Operator: OpEq, Value: 30},
+			},
+		},
+		{
+			name: "intersects $in sets and applies ranges",
+			input: []Filter{
+				{Field: "age", Operator: OpIn, Value: []any{10, 20, 30, 40}},
+				{Field: "age", Operator: OpIn, Value: []int{20, 30, 40, 50}},
+				{Field: "age", Operator: OpLt, Value: 40},
+			},
+			want: []Filter{
+				{Field: "age", Operator: OpIn, Value: []any{20, 30}},
+			},
+		},
+		{
+			name: "keeps incomparable values unchanged",
+			input: []Filter{
+				{Field: "age", Operator: OpGt, Value: 20},
+				{Field: "age", Operator: OpGt, Value: "twenty"},
+			},
+			want: []Filter{
+				{Field: "age", Operator: OpGt, Value: 20},
+				{Field: "age", Operator: OpGt, Value: "twenty"},
+			},
+		},
+		{
+			name: "sorts children of logical operators",
+			input: []Filter{
+				{Operator: OpOr, Filters: []Filter{
+					{Field: "state", Operator: OpNe, Value: "closed"},
+					{Field: "age", Operator: OpGt, Value: 20},
+				}},
+				{Field: "name", Operator: OpLike, Value: "mi"},
+			},
+			want: []Filter{
+				{Operator: OpOr, Filters: []Filter{
+					{Field: "age", Operator: OpGt, Value: 20},
+					{Field: "state", Operator: OpNe, Value: "closed"},
+				}},
+				{Field: "name", Operator: OpLike, Value: "mi"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, normalizeModel(t, tt.input))
+		})
+	}
+}
+
+func TestNormalizeWithoutModel(t *testing.T) {
+	// Fields may hold several values, so conditions under $and are kept
+	input := []Filter{
+		{Field: "age", Operator: OpLt, Value: 20},
+		{Field: "age", Operator: OpGt, Value: 30},
+		{Operator: OpAnd, Filters: []Filter{{Field: "age", Operator: OpGt, Value: 30}}},
+	}
+	assert.Equal(t, []Filter{
+		{Field: "age", Operator: OpGt, Value: 30},
+		{Field: "age", Operator: OpLt, Value: 20},
+	}, Normalize(input))
+
+	// $eq on the same field inside an $or still folds into $in
+	got := Normalize([]Filter{{Operator: OpOr, Filters: []Filter{
+		{Field: "tags", Operator: OpEq, Value: "b"},
+		{Field: "tags", Operator: OpEq, Value: "a"},
+	}}})
+	assert.Equal(t, []Filter{{Field: "tags", Operator: OpIn, Value: []any{"a", "b"}}}, got)
+
+	_, err := NormalizeModel(nil, "users")
+	assert.EqualError(t, err, "failed to get model schema: expected struct or pointer to struct, got string")
+}
+
+func TestNormalizeKeepsMultiValuedFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		model  any
+		want   []Filter
+	}{
+		{
+			name:   "objects in a jsonb column",
+			filter: `{"$and": [{"metadata": {"$eq": {"a": 1}}}, {"metadata": {"$eq": {"b": 2}}}]}`,
+			model:  &JSONBAccount{},
+			want: []Filter{
+				{Field: "metadata", Operator: OpEq, Value: map[string]any{"a": float64(1)}},
+				{Field: "metadata", Operator: OpEq, Value: map[string]any{"b": float64(2)}},
+			},
+		},
+		{
+			name:   "elements of an array",
+			filter: `{"$and": [{"tags": "a"}, {"tags": "b"}]}`,
+			model:  &Item{},
+			want: []Filter{
+				{Field: "tags", Operator: OpEq, Value: "a"},
+				{Field: "tags", Operator: OpEq, Value: "b"},
+			},
+		},
+		{
+			name:   "rows of a relation",
+			filter: `{"$and": [{"orders.total": {"$gt": 100}}, {"orders.total": {"$lt": 50}}]}`,
+			model:  &RelUser{},
+			want: []Filter{
+				{Field: "orders.total", Operator: OpGt, Value: float64(100)},
+				{Field: "orders.total", Operator: OpLt, Value: float64(50)},
+			},
+		},
+		{
+			name:   "objects inside $or",
+			filter: `{"$or": [{"metadata": {"$eq": {"a": 1}}}, {"metadata": {"$eq": {"b": 2}}}]}`,
+			model:  &JSONBAccount{},
+			want: []Filter{{Operator: OpOr, Filters: []Filter{
+				{Field: "metadata", Operator: OpEq, Value: map[string]any{"a": float64(1)}},
+				{Field: "metadata", Operator: OpEq, Value: map[string]any{"b": float64(2)}},
+			}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := ParseFilter(tt.filter)
+			assert.NoError(t, err)
+			got, err := NormalizeModel(filters, tt.model)
+			assert.NoError(t, err)
+			assert.False(t, IsAlwaysFalse(got))
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.want, Normalize(filters))
+		})
+	}
+
+	// The conditions on the jsonb column are still both contained
+	filters, err := ParseFilter(`{"$and": [{"metadata": {"$eq": {"a": 1}}}, {"metadata": {"$eq": {"b": 2}}}]}`)
+	assert.NoError(t, err)
+	filters, err = NormalizeModel(filters, &JSONBAccount{})
+	assert.NoError(t, err)
+	qb, err := NewSqlBuilder(context.Background()).WithSelect("accounts").Apply(filters, nil, &JSONBAccount{})
+	assert.NoError(t, err)
+	sql, _, err := qb.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM accounts WHERE (metadata @> $1::jsonb AND metadata @> $2::jsonb)", sql)
+}
+
+func TestNormalizeAlwaysFalse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []Filter
+	}{
+		{
+			name: "contradictory range",
+			input: []Filter{
+				{Field: "age", Operator: OpGt, Value: 30},
+				{Field: "age", Operator: OpLt, Value: 20},
+			},
+		},
+		{
+			name: "exclusive bounds on the same value",
+			input: []Filter{
+				{Field: "age", Operator: OpGt, Value: 30},
+				{Field: "age", Operator: OpLte, Value: 30},
+			},
+		},
+		{
+			name: "conflicting equality",
+			input: []Filter{
+				{Field: "name", Operator: OpEq, Value: "mike"},
+				{Field: "name", Operator: OpEq, Value: "anna"},
+			},
+		},
+		{
+			name: "equality outside range",
+			input: []Filter{
+				{Field: "age", Operator: OpEq, Value: 10},
+				{Field: "age", Operator: OpGte, Value: 18},
+			},
+		},
+		{
+			name: "false branch inside $and",
+			input: []Filter{
+				{Field: "name", Operator: OpEq, Value: "mike"},
+				{Operator: OpAnd, Filters: []Filter{
+					{Field: "age", Operator: OpIn, Value: []any{1, 2}},
+					{Field: "age", Operator: OpIn, Value: []any{3, 4}},
+				}},
+			},
+		},
+		{
+			name: "every $or branch false",
+			input: []Filter{
+				{Operator: OpOr, Filters: []Filter{
+					{Field: "age", Operator: OpIn, Value: []any{}},
+					{Operator: OpAnd, Filters: []Filter{
+						{Field: "age", Operator: OpGt, Value: 5},
+						{Field: "age", Operator: OpLt, Value: 1},
+					}},
+				}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := normalizeModel(t, tt.input)
+			assert.True(t, IsAlwaysFalse(got), "got %v", got)
+		})
+	}
+
+	t.Run("false branch dropped from $or", func(t *testing.T) {
+		got := normalizeModel(t, []Filter{
+			{Operator: OpOr, Filters: []Filter{
+				{Field: "name", Operator: OpLike, Value: "mi"},
+				{Operator: OpAnd, Filters: []Filter{
+					{Field: "age", Operator: OpGt, Value: 5},
+					{Field: "age", Operator: OpLt, Value: 1},
+				}},
+			}},
+		})
+		assert.Equal(t, []Filter{{Field: "name", Operator: OpLike, Value: "mi"}}, got)
+	})
+}
+
+func TestNormalizeStableOrder(t *testing.T) {
+	// Map iteration order in ParseFilter is random; normalization must hide it
+	input := `{"name": "mike", "age": {"$gte": 20, "$lt": 65}, "email": {"$like": "example"}}`
+	first, err := ParseFilter(input)
+	assert.NoError(t, err)
+	want := Normalize(first)
+
+	for i := 0; i < 20; i++ {
+		filters, err := ParseFilter(input)
+		assert.NoError(t, err)
+		assert.Equal(t, want, Normalize(filters))
+	}
+}
+
+func TestNormalizeAlwaysFalseSql(t *testing.T) {
+	filters, err := NormalizeModel([]Filter{
+		{Field: "age", Operator: OpGt, Value: 30},
+		{Field: "age", Operator: OpLt, Value: 20},
+	}, &TestUser{})
+	assert.NoError(t, err)
+
+	qb, err := NewSqlBuilder(context.Background()).WithSelect("users").Apply(filters, nil, &TestUser{})
+	assert.NoError(t, err)
+
+	sql, args, err := qb.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM users WHERE ((1=0))", sql)
+	assert.Empty(t, args)
+}
+
+func TestNormalizeKeepsUnorderedBounds(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		want   []Filter
+	}{
+		{
+			name:   "named and relative times",
+			filter: `{"created": {"$gte": "startOfMonth", "$lte": "now"}}`,
+			want: []Filter{
+				{Field: "created", Operator: OpGte, Value: "startOfMonth"},
+				{Field: "created", Operator: OpLte, Value: "now"},
+			},
+		},
+		{
+			name:   "relative times",
+			filter: `{"created": {"$gte": "now-1w", "$lte": "now-1d"}}`,
+			want: []Filter{
+				{Field: "created", Operator: OpGte, Value: "now-1w"},
+				{Field: "created", Operator: OpLte, Value: "now-1d"},
+			},
+		},
+		{
+			name:   "lower relative bounds",
+			filter: `{"$and": [{"created": {"$gt": "now-7d"}}, {"created": {"$gt": "now-1d"}}]}`,
+			want: []Filter{
+				{Field: "created", Operator: OpGt, Value: "now-1d"},
+				{Field: "created", Operator: OpGt, Value: "now-7d"},
+			},
+		},
+		{
+			name:   "equality within string bounds",
+			filter: `{"$and": [{"name": "b"}, {"name": {"$gt": "a"}}]}`,
+			want: []Filter{
+				{Field: "name", Operator: OpEq, Value: "b"},
+				{Field: "name", Operator: OpGt, Value: "a"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := ParseFilter(tt.filter)
+			assert.NoError(t, err)
+			got := normalizeModel(t, filters)
+			assert.False(t, IsAlwaysFalse(got))
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNormalizeLargeIntegers(t *testing.T) {
+	got := Normalize([]Filter{{Field: "id", Operator: OpIn, Value: []any{int64(9007199254740993), int64(9007199254740992)}}})
+	assert.Equal(t, []Filter{{Field: "id", Operator: OpIn, Value: []any{int64(9007199254740992), int64(9007199254740993)}}}, got)
+
+	got = normalizeModel(t, []Filter{
+		{Field: "id", Operator: OpGt, Value: int64(9007199254740992)},
+		{Field: "id", Operator: OpGt, Value: uint64(9007199254740993)},
+	})
+	assert.Equal(t, []Filter{{Field: "id", Operator: OpGt, Value: uint64(9007199254740993)}}, got)
+
+	// Integral floats still match the equal integer
+	got = normalizeModel(t, []Filter{{Field: "qty", Operator: OpIn, Value: []any{2, 2.0, float32(2)}}})
+	assert.Equal(t, []Filter{{Field: "qty", Operator: OpEq, Value: 2}}, got)
+}
@@ -0,0 +1,303 @@
+package queryparser
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// OpenAPIComponents returns OpenAPI 3.1 components documenting the list
+// parameters of a model, as read by ParseQueryParams: the filter in the
+// given syntax, sort, limit, offset and fields. The result is a document
+// fragment to merge into a spec:
+//
+//	{"components": {"parameters": {...}, "schemas": {...}}}
+//
+// Parameters and schemas are named after the model, e.g. Product.filter and
+// Product.sort, except limit and offset, which are shared. Reference them from
+// an operation with the values of OpenAPIParameterRefs.
+//
+// The fields and operators are those the builders accept for the model, see
+// FilterSchema. Pagination is by offset; there is no cursor parameter.
+func OpenAPIComponents(model any, syntax Syntax) (map[string]any, error) {
+	schema, err := schemaOf(model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get model schema: %w", err)
+	}
+	filterSchema, err := FilterSchema(model)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := schema.name
+	schemas := make(map[string]any)
+	for name, def := range filterSchema["$defs"].(map[string]any) {
+		schemas[prefix+"."+name] = componentRefs(def, prefix)
+	}
+
+	example := filterExamples(schema)
+	filter := map[string]any{"name": "filter", "in": "query"}
+	switch syntax {
+	case SyntaxJSON:
+		filter["description"] = "Filter in JSON, e.g. `" + example.json + "`."
+		filter["content"] = map[string]any{
+			"application/json": map[string]any{
+				"schema":  map[string]any{"$ref": "#/components/schemas/" + prefix + ".filter"},
+				"example": example.value,
+			},
+		}
+	case SyntaxBracket:
+		schemas[prefix+".bracketFilter"] = bracketSchema(schema, prefix)
+		filter["description"] = "Filter as bracket parameters, e.g. `" + example.bracket + "`. " +
+			"Lists are comma-separated, and `$or` and `$and` take indexed filters: `filter[$or][0][field]=value`."
+		filter["style"] = "deepObject"
+		filter["explode"] = true
+		filter["schema"] = map[string]any{"$ref": "#/components/schemas/" + prefix + ".bracketFilter"}
+		filter["example"] = example.bracketValue
+	case SyntaxRSQL:
+		filter["description"] = rsqlDescription(schema, example.rsql)
+		filter["schema"] = map[string]any{"type": "string"}
+		filter["example"] = example.rsql
+	default:
+		return nil, fmt.Errorf("unknown filter syntax %q", syntax)
+	}
+
+	paths := optionPathsOf(schema)
+	var directions []string
+	for _, name := range paths.sortable {
+		directions = append(directions, name, "-"+name)
+	}
+	var sortPatterns []string
+	for _, pattern := range paths.sortPatterns {
+		sortPatterns = append(sortPatterns, "^-?"+pattern[1:])
+	}
+
+	parameters := map[string]any{
+		prefix + ".filter": filter,
+		prefix + ".sort": map[string]any{
+			"name":        "sort",
+			"in":          "query",
+			"description": "Fields to sort by, comma-separated, in order: by the first field, then by the next one for equal values. A leading `-` sorts in descending order.",
+			"style":       "form",
+			"explode":     false,
+			"schema":      map[string]any{"type": "array", "items": nameSchema(directions, sortPatterns)},
+		},
+		prefix + ".fields": map[string]any{
+			"name":        "fields",
+			"in":          "query",
+			"description": "Fields to return, comma-separated. All fields are returned by default.",
+			"style":       "form",
+			"explode":     false,
+			"schema":      map[string]any{"type": "array", "items": nameSchema(paths.selectable, paths.selectPatterns)},
+		},
+		"limit": map[string]any{
+			"name":        "limit",
+			"in":          "query",
+			"description": "Maximum number of results.",
+			"schema":      map[string]any{"type": "integer", "minimum": 0},
+		},
+		"offset": map[string]any{
+			"name":        "offset",
+			"in":          "query",
+			"description": "Number of results to skip.",
+			"schema":      map[string]any{"type": "integer", "minimum": 0},
+		},
+	}
+
+	return map[string]any{
+		"components": map[string]any{
+			"parameters": parameters,
+			"schemas":    schemas,
+		},
+	}, nil
+}
+
+// OpenAPIParameterRefs returns references to the parameters of
+// OpenAPIComponents, for the parameters of a list operation
+func OpenAPIParameterRefs(model any) ([]any, error) {
+	schema, err := schemaOf(model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get model schema: %w", err)
+	}
+	prefix := schema.name
+	var refs []any
+	for _, name := range []string{prefix + ".filter", prefix + ".sort", "limit", "offset", prefix + ".fields"} {
+		refs = append(refs, map[string]any{"$ref": "#/components/parameters/" + name})
+	}
+	return refs, nil
+}
+
+// componentRefs copies a schema of FilterSchema, pointing its references to
+// the definitions at the component schemas named after the model
+func componentRefs(v any, prefix string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, value := range v {
+			if s, ok := value.(string); ok && key == "$ref" {
+				out[key] = "#/components/schemas/" + prefix + "." + strings.TrimPrefix(s, "#/$defs/")
+				continue
+			}
+			out[key] = componentRefs(value, prefix)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, value := range v {
+			out[i] = componentRefs(value, prefix)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// bracketSchema returns the schema of the filter parameter object in the
+// bracket syntax. Values are strings, read by ParseBracketFilter.
+func bracketSchema(schema *modelSchema, prefix string) map[string]any {
+	value := map[string]any{"type": "string"}
+	condition := func(ops []Operator) map[string]any {
+		properties := make(map[string]any, len(ops))
+		for _, op := range ops {
+			properties[string(op)] = value
+			if op == OpElemMatch {
+				properties[string(op)] = map[string]any{"type": "object"}
+			}
+		}
+		operators := map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+		if !hasOperator(ops, OpEq) {
+			return operators
+		}
+		return map[string]any{"anyOf": []any{value, operators}}
+	}
+
+	properties := make(map[string]any)
+	patterns := make(map[string]any)
+	for _, p := range schemaPaths(schema) {
+		if ops := fieldOperators(p.chain); len(ops) > 0 {
+			properties[p.name] = condition(ops)
+		}
+		if p.open {
+			patterns[openPattern(p.name)] = condition(append(fieldOperators(p.chain), OpAll, OpContains, OpSize, OpElemMatch))
+		}
+	}
+	indexed := map[string]any{
+		"type":                 "object",
+		"patternProperties":    map[string]any{"^(0|[1-9][0-9]*)$": map[string]any{"$ref": "#/components/schemas/" + prefix + ".bracketFilter"}},
+		"additionalProperties": false,
+	}
+	properties[string(OpOr)] = indexed
+	properties[string(OpAnd)] = indexed
+	if len(schema.searchable()) > 0 {
+		properties[string(OpText)] = value
+	}
+
+	bracket := map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+	if len(patterns) > 0 {
+		bracket["patternProperties"] = patterns
+	}
+	return bracket
+}
+
+// rsqlDescription documents the RSQL filter of a model with a table of its
+// fields and operators
+func rsqlDescription(schema *modelSchema, example string) string {
+	var b strings.Builder
+	b.WriteString("RSQL filter, e.g. `" + example + "`. `;` is and, `,` is or and parentheses group conditions. ")
+	b.WriteString("Lists are written `(a,b)` and values with reserved characters are quoted.\n\n")
+	b.WriteString("| Field | Operators |\n| --- | --- |\n")
+	row := func(name string, ops []Operator) {
+		var symbols []string
+		for _, op := range ops {
+			if symbol := rsqlSymbols[op]; symbol != "" {
+				symbols = append(symbols, "`"+symbol+"`")
+			}
+		}
+		if len(symbols) > 0 {
+			b.WriteString("| `" + name + "` | " + strings.Join(symbols, " ") + " |\n")
+		}
+	}
+	for _, p := range schemaPaths(schema) {
+		row(p.name, fieldOperators(p.chain))
+		if p.open {
+			row(p.name+".*", append(fieldOperators(p.chain), OpAll, OpContains, OpSize))
+		}
+	}
+	return b.String()
+}
+
+// filterExample is an example filter of a model in every syntax
+type filterExample struct {
+	value        map[string]any
+	json         string
+	bracket      string
+	bracketValue map[string]any
+	rsql         string
+}
+
+// filterExamples builds an example filter on up to two scalar fields of a
+// model: an equality and a range when a number or time field exists
+func filterExamples(schema *modelSchema) filterExample {
+	example := filterExample{value: map[string]any{}, bracketValue: map[string]any{}}
+	var jsonParts, bracket, rsql []string
+	var equality, ranged bool
+	for _, p := range schemaPaths(schema) {
+		f := p.chain[len(p.chain)-1]
+		if len(p.chain) > 1 || !f.Filterable || f.Repeated || f.Children != nil {
+			continue
+		}
+		value, text := exampleValue(f.Type)
+		if value == nil {
+			continue
+		}
+		orderable := isNumeric(f.Type) || isTimeField(schema, p.name)
+		switch {
+		case orderable && !ranged:
+			ranged = true
+			example.value[p.name] = map[string]any{string(OpGte): value}
+			example.bracketValue[p.name] = map[string]any{string(OpGte): text}
+			jsonParts = append(jsonParts, fmt.Sprintf(`"%s":{"$gte":%s}`, p.name, jsonLiteral(value, text)))
+			bracket = append(bracket, "filter["+p.name+"][$gte]="+text)
+			rsql = append(rsql, p.name+">="+text)
+		case !orderable && !equality:
+			equality = true
+			example.value[p.name] = value
+			example.bracketValue[p.name] = text
+			jsonParts = append(jsonParts, fmt.Sprintf(`"%s":%s`, p.name, jsonLiteral(value, text)))
+			bracket = append(bracket, "filter["+p.name+"]="+text)
+			rsql = append(rsql, p.name+"=="+text)
+		}
+	}
+	example.json = "{" + strings.Join(jsonParts, ",") + "}"
+	example.bracket = strings.Join(bracket, "&")
+	example.rsql = strings.Join(rsql, ";")
+	return example
+}
+
+// exampleValue returns an example value of a Go type and its text in URL
+// parameters, or nil for types without one
+func exampleValue(typ reflect.Type) (any, string) {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch {
+	case typ == timeType:
+		return "2024-01-01T00:00:00Z", "2024-01-01T00:00:00Z"
+	case typ.Kind() == reflect.String:
+		return "example", "example"
+	case typ.Kind() == reflect.Bool:
+		return true, "true"
+	case isNumeric(typ):
+		return 1, "1"
+	default:
+		return nil, ""
+	}
+}
+
+// jsonLiteral returns the JSON form of an example value
+func jsonLiteral(value any, text string) string {
+	if _, ok := value.(string); ok {
+		return `"` + text + `"`
+	}
+	return text
+}
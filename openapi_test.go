@@ -0,0 +1,140 @@
+package queryparser
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// collectRefs returns the references of a document
+func collectRefs(v any, refs map[string]bool) {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if s, ok := value.(string); ok && key == "$ref" {
+				refs[s] = true
+			}
+			collectRefs(value, refs)
+		}
+	case []any:
+		for _, value := range v {
+			collectRefs(value, refs)
+		}
+	}
+}
+
+func TestOpenAPIComponents(t *testing.T) {
+	filterSchema, err := FilterSchema(&Item{})
+	assert.NoError(t, err)
+	fields := filterSchema["$defs"].(map[string]any)["filter"].(map[string]any)["properties"].(map[string]any)
+
+	for _, syntax := range []Syntax{SyntaxJSON, SyntaxBracket, SyntaxRSQL} {
+		t.Run(string(syntax), func(t *testing.T) {
+			doc, err := OpenAPIComponents(&Item{}, syntax)
+			assert.NoError(t, err)
+			_, err = json.Marshal(doc)
+			assert.NoError(t, err)
+
+			components := doc["components"].(map[string]any)
+			parameters := components["parameters"].(map[string]any)
+			schemas := components["schemas"].(map[string]any)
+			assert.Contains(t, schemas, "Item.filter")
+			assert.Contains(t, schemas, "Item.options")
+			assert.Contains(t, schemas, "Item.filter.ItemPart")
+
+			// Every reference points to a component
+			refs := map[string]bool{}
+			collectRefs(doc, refs)
+			operationRefs, err := OpenAPIParameterRefs(&Item{})
+			assert.NoError(t, err)
+			collectRefs(operationRefs, refs)
+			for ref := range refs {
+				name := strings.TrimPrefix(strings.TrimPrefix(ref, "#/components/schemas/"), "#/components/parameters/")
+				_, isSchema := schemas[name]
+				_, isParameter := parameters[name]
+				assert.True(t, isSchema || isParameter, "unresolved reference %s", ref)
+			}
+
+			sorting := parameters["Item.sort"].(map[string]any)
+			assert.Equal(t, "sort", sorting["name"])
+			assert.Equal(t, false, sorting["explode"])
+			assert.Contains(t, sorting["schema"].(map[string]any)["items"].(map[string]any)["enum"], "-qty")
+			assert.NotContains(t, sorting["schema"].(map[string]any)["items"].(map[string]any)["enum"], "tags")
+			assert.Equal(t, map[string]any{"type": "integer", "minimum": 0}, parameters["limit"].(map[string]any)["schema"])
+
+			// The documented fields are those of FilterSchema
+			filter := parameters["Item.filter"].(map[string]any)
+			assert.Equal(t, "filter", filter["name"])
+			switch syntax {
+			case SyntaxBracket:
+				bracket := schemas["Item.bracketFilter"].(map[string]any)["properties"].(map[string]any)
+				assert.Equal(t, keys(fields), keys(bracket))
+			case SyntaxRSQL:
+				description := filter["description"].(string)
+				for name := range fields {
+					if !strings.HasPrefix(name, "$") {
+						assert.Contains(t, description, "| `"+name+"` |")
+					}
+				}
+			}
+
+			// The example is accepted by the runtime
+			values := url.Values{}
+			switch syntax {
+			case SyntaxJSON:
+				body, err := json.Marshal(filter["content"].(map[string]any)["application/json"].(map[string]any)["example"])
+				assert.NoError(t, err)
+				values.Set("filter", string(body))
+			case SyntaxBracket:
+				for field, condition := range filter["example"].(map[string]any) {
+					if ops, ok := condition.(map[string]any); ok {
+						for op, value := range ops {
+							values.Set("filter["+field+"]["+op+"]", value.(string))
+						}
+					} else {
+						values.Set("filter["+field+"]", condition.(string))
+					}
+				}
+			case SyntaxRSQL:
+				values.Set("filter", filter["example"].(string))
+			}
+			filters, options, err := ParseQueryParams(values, syntax, &Item{})
+			assert.NoError(t, err)
+			assert.Len(t, filters, 2)
+			_, err = NewSqlBuilder(context.Background()).WithSelect("items").Apply(filters, options, &Item{})
+			assert.NoError(t, err)
+		})
+	}
+
+	_, err = OpenAPIComponents(&Item{}, "xml")
+	assert.EqualError(t, err, `unknown filter syntax "xml"`)
+	_, err = OpenAPIComponents("item", SyntaxJSON)
+	assert.EqualError(t, err, "failed to get model schema: expected struct or pointer to struct, got string")
+}
+
+func TestOpenAPIParameterRefs(t *testing.T) {
+	refs, err := OpenAPIParameterRefs(&Item{})
+	assert.NoError(t, err)
+	assert.Equal(t, []any{
+		map[string]any{"$ref": "#/components/parameters/Item.filter"},
+		map[string]any{"$ref": "#/components/parameters/Item.sort"},
+		map[string]any{"$ref": "#/components/parameters/limit"},
+		map[string]any{"$ref": "#/components/parameters/offset"},
+		map[string]any{"$ref": "#/components/parameters/Item.fields"},
+	}, refs)
+}
+
+// keys returns the sorted keys of a map
+func keys(m map[string]any) []string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,50 @@
+// Package opensearch sends the queries compiled by queryparser.DSLBuilder with the
+// opensearch-go client.
+package opensearch
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/opensearch-project/opensearch-go/v2"
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"github.com/ready4god2513/queryparser"
+)
+
+// Builder builds search requests for opensearch-go. The clock and
+// scoring mode are set on the embedded DSLBuilder.
+type Builder struct {
+	*queryparser.DSLBuilder
+	client *opensearch.Client
+}
+
+// NewBuilder creates a Builder sending requests with the client
+func NewBuilder(client *opensearch.Client) *Builder {
+	return &Builder{DSLBuilder: queryparser.NewDSLBuilder(), client: client}
+}
+
+// Request compiles the filters and options to a search request on the indices
+func (b *Builder) Request(filters []queryparser.Filter, options *queryparser.QueryOptions, model any, index ...string) (*opensearchapi.SearchRequest, error) {
+	body, err := b.Body(filters, options, model)
+	if err != nil {
+		return nil, err
+	}
+	return &opensearchapi.SearchRequest{Index: index, Body: body}, nil
+}
+
+// Search runs the search on the indices. The caller closes the body of the
+// response, and checks it for errors with IsError.
+func (b *Builder) Search(ctx context.Context, filters []queryparser.Filter, options *queryparser.QueryOptions, model any, index ...string) (*opensearchapi.Response, error) {
+	if b.client == nil {
+		return nil, fmt.Errorf("opensearch builder has no client")
+	}
+	req, err := b.Request(filters, options, model, index...)
+	if err != nil {
+		return nil, err
+	}
+	res, err := req.Do(ctx, b.client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+	return res, nil
+}
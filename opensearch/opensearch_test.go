@@ -0,0 +1,65 @@
+package opensearch
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opensearch-project/opensearch-go/v2"
+	"github.com/ready4god2513/queryparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type product struct {
+	Name  string  `json:"name"`
+	Price float64 `json:"price"`
+	Cost  float64 `json:"cost" query:"roles=admin"`
+}
+
+func TestBuilderSearch(t *testing.T) {
+	var path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"hits":{"hits":[]}}`))
+	}))
+	defer server.Close()
+
+	client, err := opensearch.NewClient(opensearch.Config{Addresses: []string{server.URL}})
+	require.NoError(t, err)
+
+	filters, err := queryparser.ParseFilter(`{"price":{"$lt":10}}`)
+	require.NoError(t, err)
+	limit := 5
+	res, err := NewBuilder(client).Search(context.Background(), filters, &queryparser.QueryOptions{Limit: &limit}, &product{}, "products")
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	assert.False(t, res.IsError())
+	assert.Equal(t, "/products/_search", path)
+	assert.JSONEq(t, `{"query":{"bool":{"must":{"range":{"price":{"lt":10}}}}},"size":5}`, body)
+}
+
+func TestBuilderErrors(t *testing.T) {
+	filters, err := queryparser.ParseFilter(`{"missing":1}`)
+	require.NoError(t, err)
+	_, err = NewBuilder(nil).Request(filters, nil, &product{}, "products")
+	assert.Error(t, err)
+
+	filters, err = queryparser.ParseFilter(`{"cost":{"$gt":1}}`)
+	require.NoError(t, err)
+	_, err = NewBuilder(nil).Request(filters, nil, &product{}, "products")
+	assert.ErrorIs(t, err, queryparser.ErrForbiddenField)
+	b := NewBuilder(nil)
+	b.SetContext(queryparser.WithRoles(context.Background(), "admin"))
+	_, err = b.Request(filters, nil, &product{}, "products")
+	assert.NoError(t, err)
+
+	_, err = NewBuilder(nil).Search(context.Background(), nil, nil, nil, "products")
+	assert.EqualError(t, err, "opensearch builder has no client")
+}
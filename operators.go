@@ -3,7 +3,7 @@ package queryparser
 import (
 	"encoding/json"
 	"fmt"
-	"reflect"
+	"sort"
 	"strings"
 )
 
@@ -15,39 +15,167 @@ const (
 	SortDesc SortDirection = "desc"
 )
 
+// SortField is one key of a sort: a field, or an aggregate alias when
+// grouping, and its direction. An empty direction sorts in ascending order.
+type SortField struct {
+	Field     string        `json:"field"`
+	Direction SortDirection `json:"direction,omitempty"`
+}
+
 // QueryOptions represents additional query options like sorting and pagination
 type QueryOptions struct {
+	// SortBy sorts by the listed fields in order: by the first field, then
+	// by the next one for equal values, and so on
+	SortBy []SortField `json:"sortBy,omitempty"`
+	// Sort sorts by the fields of a map, which has no order, so they apply
+	// in field name order after the fields of SortBy.
+	//
+	// Deprecated: Use SortBy, which keeps the order of the fields. Sort is
+	// kept for compatibility.
 	Sort   map[string]SortDirection `json:"sort,omitempty"`
 	Limit  *int                     `json:"limit,omitempty"`
 	Offset *int                     `json:"offset,omitempty"`
+	// Relevance orders results by full-text relevance, best match first,
+	// before the Sort fields. It requires a $text or $search filter.
+	Relevance bool `json:"relevance,omitempty"`
+
+	// GroupBy, Aggregates and Having turn the query into an aggregation,
+	// see Aggregate. Having is a filter over aggregate aliases and uses the
+	// same syntax as filters in JSON.
+	GroupBy    []string    `json:"groupBy,omitempty"`
+	Aggregates []Aggregate `json:"aggregates,omitempty"`
+	Having     []Filter    `json:"-"`
+
+	// Scoring overrides the ElasticBuilder scoring mode for one request
+	Scoring ScoringMode `json:"scoring,omitempty"`
+
+	// Highlight, Facets and PostFilter are used by ElasticBuilder.SearchSource.
+	// Highlight lists the fields to return highlighted fragments for, and
+	// PostFilter narrows the hits after facets are counted, see Facet. It uses
+	// the same syntax as filters in JSON.
+	Highlight  []string `json:"highlight,omitempty"`
+	Facets     []Facet  `json:"facets,omitempty"`
+	PostFilter []Filter `json:"-"`
+
+	// Fields projects the results onto the listed fields: the selected
+	// columns in SQL, the _source fields in Elasticsearch and the keys of
+	// SliceBuilder.Project. All fields are returned when it is empty.
+	Fields []string `json:"fields,omitempty"`
+}
+
+// sortFields returns the keys to sort by in order: the fields of SortBy, then
+// the fields of Sort that SortBy does not list, in field name order. Empty
+// directions are ascending.
+func (o *QueryOptions) sortFields() []SortField {
+	if o == nil {
+		return nil
+	}
+	fields := make([]SortField, 0, len(o.SortBy)+len(o.Sort))
+	listed := make(map[string]bool, len(o.SortBy))
+	for _, field := range o.SortBy {
+		if field.Direction == "" {
+			field.Direction = SortAsc
+		}
+		listed[field.Field] = true
+		fields = append(fields, field)
+	}
+
+	names := make([]string, 0, len(o.Sort))
+	for name := range o.Sort {
+		if !listed[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		direction := SortAsc
+		if o.Sort[name] == SortDesc {
+			direction = SortDesc
+		}
+		fields = append(fields, SortField{Field: name, Direction: direction})
+	}
+	return fields
+}
+
+// UnmarshalJSON decodes query options, parsing "having" and "postFilter" with
+// the filter syntax
+func (o *QueryOptions) UnmarshalJSON(data []byte) error {
+	type plain QueryOptions
+	aux := struct {
+		*plain
+		Having     map[string]any `json:"having,omitempty"`
+		PostFilter map[string]any `json:"postFilter,omitempty"`
+	}{plain: (*plain)(o)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.Having != nil {
+		having, err := parseFilters(aux.Having)
+		if err != nil {
+			return fmt.Errorf("failed to parse having: %w", err)
+		}
+		o.Having = having
+	}
+	if aux.PostFilter != nil {
+		postFilter, err := parseFilters(aux.PostFilter)
+		if err != nil {
+			return fmt.Errorf("failed to parse postFilter: %w", err)
+		}
+		o.PostFilter = postFilter
+	}
+	return nil
 }
 
 // Operator represents MongoDB-style operators
 type Operator string
 
 const (
-	OpEq   Operator = "$eq"
-	OpNe   Operator = "$ne"
-	OpLt   Operator = "$lt"
-	OpLte  Operator = "$lte"
-	OpGt   Operator = "$gt"
-	OpGte  Operator = "$gte"
-	OpIn   Operator = "$in"
-	OpNin  Operator = "$nin"
-	OpAnd  Operator = "$and"
-	OpOr   Operator = "$or"
-	OpLike Operator = "$like"
+	OpEq     Operator = "$eq"
+	OpNe     Operator = "$ne"
+	OpLt     Operator = "$lt"
+	OpLte    Operator = "$lte"
+	OpGt     Operator = "$gt"
+	OpGte    Operator = "$gte"
+	OpIn     Operator = "$in"
+	OpNin    Operator = "$nin"
+	OpAnd    Operator = "$and"
+	OpOr     Operator = "$or"
+	OpLike   Operator = "$like"
+	OpExists Operator = "$exists"
+
+	// OpBetween matches values within a range, see Between
+	OpBetween Operator = "$between"
+
+	// Array operators apply to slice fields
+	OpAll       Operator = "$all"       // contains every value
+	OpContains  Operator = "$contains"  // contains at least one value (overlap)
+	OpSize      Operator = "$size"      // has exactly n elements
+	OpElemMatch Operator = "$elemMatch" // has an element matching all nested filters
+
+	// Full-text operators
+	OpText   Operator = "$text"   // searches every field tagged query:"searchable"
+	OpSearch Operator = "$search" // searches a single searchable field
 )
 
+// operators is the set of supported operators
+var operators = map[Operator]bool{
+	OpEq: true, OpNe: true, OpLt: true, OpLte: true, OpGt: true, OpGte: true,
+	OpIn: true, OpNin: true, OpAnd: true, OpOr: true, OpLike: true, OpExists: true,
+	OpBetween: true, OpAll: true, OpContains: true, OpSize: true, OpElemMatch: true,
+	OpText: true, OpSearch: true,
+}
+
 // Filter represents a MongoDB-style filter
 type Filter struct {
 	Field    string
 	Operator Operator
 	Value    any
-	Filters  []Filter // For nested filters like $or and $and
+	Filters  []Filter // For nested filters like $or, $and and $elemMatch
 }
 
-// ParseFilter parses a JSON string into a Filter
+// ParseFilter parses a JSON string into a Filter. The keys of JSON objects
+// have no order, so their conditions are returned in key order, while the
+// elements of $and and $or arrays keep theirs.
 func ParseFilter(jsonStr string) ([]Filter, error) {
 	var rawFilter map[string]any
 	if err := json.Unmarshal([]byte(jsonStr), &rawFilter); err != nil {
@@ -98,20 +226,49 @@ func parseFilters(filter map[string]any) ([]Filter, error) {
 	}
 
 	// Handle regular field filters
-	for field, value := range filter {
+	for _, field := range sortedKeys(filter) {
+		value := filter[field]
 		if field == string(OpOr) || field == string(OpAnd) {
 			continue
 		}
 
+		// Model-level full-text search: {"$text": {"$search": "..."}}
+		if field == string(OpText) {
+			if v, ok := value.(map[string]any); ok {
+				value = v[string(OpSearch)]
+			}
+			filters = append(filters, Filter{Operator: OpText, Value: parseVariables(value)})
+			continue
+		}
+
+		// Reference to a named filter: {"$ref": "open_tickets"}
+		if field == string(OpRef) {
+			name, ok := value.(string)
+			if !ok || name == "" {
+				return nil, fmt.Errorf("$ref operator requires a filter name")
+			}
+			filters = append(filters, Filter{Operator: OpRef, Value: name})
+			continue
+		}
+
 		switch v := value.(type) {
 		case map[string]any:
 			// Handle operators like $eq, $gt, etc.
-			for op, val := range v {
+			for _, op := range sortedKeys(v) {
+				val := v[op]
 				operator := Operator(op)
+				if operator == OpElemMatch {
+					elemMatch, err := parseElemMatch(field, val)
+					if err != nil {
+						return nil, err
+					}
+					filters = append(filters, elemMatch)
+					continue
+				}
 				filters = append(filters, Filter{
 					Field:    field,
 					Operator: operator,
-					Value:    val,
+					Value:    parseVariables(val),
 				})
 			}
 		default:
@@ -119,7 +276,7 @@ func parseFilters(filter map[string]any) ([]Filter, error) {
 			filters = append(filters, Filter{
 				Field:    field,
 				Operator: OpEq,
-				Value:    v,
+				Value:    parseVariables(v),
 			})
 		}
 	}
@@ -127,6 +284,43 @@ func parseFilters(filter map[string]any) ([]Filter, error) {
 	return filters, nil
 }
 
+// sortedKeys returns the keys of a JSON object in order
+func sortedKeys(object map[string]any) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parseElemMatch parses the object of an $elemMatch operator. Its keys are
+// either fields of the array elements, e.g. {"sku": "A1", "qty": {"$gt": 1}},
+// or operators applying to scalar elements, e.g. {"$gte": 80, "$lt": 90}.
+// Conditions on scalar elements have an empty Field.
+func parseElemMatch(field string, value any) (Filter, error) {
+	object, ok := value.(map[string]any)
+	if !ok || len(object) == 0 {
+		return Filter{}, fmt.Errorf("$elemMatch operator requires an object for field %q", field)
+	}
+
+	scalar := true
+	for key := range object {
+		if !strings.HasPrefix(key, "$") || key == string(OpOr) || key == string(OpAnd) {
+			scalar = false
+		}
+	}
+
+	if scalar {
+		object = map[string]any{"": object}
+	}
+	nested, err := parseFilters(object)
+	if err != nil {
+		return Filter{}, err
+	}
+	return Filter{Field: field, Operator: OpElemMatch, Filters: nested}, nil
+}
+
 // ParseQueryOptions parses a JSON string into QueryOptions
 func ParseQueryOptions(jsonStr string) (*QueryOptions, error) {
 	if jsonStr == "" {
@@ -141,130 +335,141 @@ func ParseQueryOptions(jsonStr string) (*QueryOptions, error) {
 	return &options, nil
 }
 
-// getJSONTags returns a map of field names to their JSON tags
-func getJSONTags(v any) (map[string]string, error) {
-	val := reflect.ValueOf(v)
-	if val.Kind() == reflect.Ptr {
-		val = val.Elem()
-	}
-	if val.Kind() != reflect.Struct {
-		return nil, fmt.Errorf("expected struct or pointer to struct, got %v", val.Kind())
+// validateFields validates that all fields in filters and options exist in the model's schema
+func validateFields(filters []Filter, options *QueryOptions, schema *modelSchema) error {
+	// References and variables must be resolved and bound first
+	err := Walk(filters, func(c *Cursor) error {
+		return validateTemplate(c.Filter())
+	}, nil)
+	if err != nil {
+		return err
 	}
 
-	tags := make(map[string]string)
-	return getJSONTagsRecursive(val, tags), nil
-}
-
-// getJSONTagsRecursive recursively extracts JSON tags from a struct and its embedded structs
-func getJSONTagsRecursive(val reflect.Value, tags map[string]string) map[string]string {
-	typ := val.Type()
-	for i := 0; i < typ.NumField(); i++ {
-		field := typ.Field(i)
-		fieldValue := val.Field(i)
+	// Validate filter fields, including those nested in $or and $and
+	err = Walk(filters, func(c *Cursor) error {
+		filter := c.Filter()
+		if filter.Operator == OpOr || filter.Operator == OpAnd {
+			return nil
+		}
+		if !operators[filter.Operator] {
+			return fmt.Errorf("unsupported operator: %s", filter.Operator)
+		}
+		if isArrayOperator(filter.Operator) {
+			if err := validateArrayFilter(filter, schema); err != nil {
+				return err
+			}
+			// $elemMatch conditions were validated against the elements
+			return SkipChildren
+		}
+		if filter.Operator == OpText || filter.Operator == OpSearch {
+			return validateTextFilter(filter, schema)
+		}
 
-		// Handle embedded structs
-		if field.Anonymous && fieldValue.Kind() == reflect.Struct {
-			getJSONTagsRecursive(fieldValue, tags)
-			continue
+		chain, ok := schema.lookup(filter.Field)
+		if !ok || !acceptsOperator(chain, filter.Operator) {
+			return unknownField("field %q is not a valid JSON field", filter.Field)
 		}
+		return nil
+	}, nil)
+	if err != nil {
+		return err
+	}
 
-		tag := field.Tag.Get("json")
-		if tag == "" {
-			continue
+	// Validate sort fields
+	for _, sortField := range options.sortFields() {
+		name := sortField.Field
+		if sortField.Direction != SortAsc && sortField.Direction != SortDesc {
+			return fmt.Errorf("invalid sort direction %q for field %q", sortField.Direction, name)
 		}
-		// Handle json tag with options (e.g., "name,omitempty")
-		parts := strings.Split(tag, ",")
-		jsonName := parts[0]
-		if jsonName == "-" {
+		if options.isAggregateAlias(name) {
 			continue
 		}
-		tags[field.Name] = jsonName
+		chain, ok := schema.lookup(name)
+		if !ok {
+			return unknownField("field %q is not a valid JSON field for sorting", name)
+		}
+		for _, field := range chain[:len(chain)-1] {
+			if field.Repeated {
+				return fmt.Errorf("field %q cannot be used for sorting", name)
+			}
+		}
+		// Paths below a schemaless JSON column sort by the extracted value
+		schemaless := len(chain) < strings.Count(name, ".")+1
+		if !schemaless && !chain[len(chain)-1].Sortable {
+			return fmt.Errorf("field %q cannot be used for sorting", name)
+		}
 	}
-	return tags
-}
 
-// getDBTags returns a map of field names to their DB tags
-func getDBTags(v any) (map[string]string, error) {
-	val := reflect.ValueOf(v)
-	if val.Kind() == reflect.Ptr {
-		val = val.Elem()
-	}
-	if val.Kind() != reflect.Struct {
-		return nil, fmt.Errorf("expected struct or pointer to struct, got %v", val.Kind())
+	if options != nil && len(options.Fields) > 0 {
+		if options.grouped() {
+			return fmt.Errorf("fields cannot be combined with groupBy or aggregates")
+		}
+		for _, name := range options.Fields {
+			if _, ok := schema.lookup(name); !ok {
+				return unknownField("field %q is not a valid JSON field for selection", name)
+			}
+		}
 	}
 
-	tags := make(map[string]string)
-	return getDBTagsRecursive(val, tags), nil
+	return validateAggregation(options, schema)
 }
 
-// getDBTagsRecursive recursively extracts DB tags from a struct and its embedded structs
-func getDBTagsRecursive(val reflect.Value, tags map[string]string) map[string]string {
-	typ := val.Type()
-	for i := 0; i < typ.NumField(); i++ {
-		field := typ.Field(i)
-		fieldValue := val.Field(i)
-
-		// Handle embedded structs
-		if field.Anonymous && fieldValue.Kind() == reflect.Struct {
-			getDBTagsRecursive(fieldValue, tags)
-			continue
-		}
+// scalarOperators are the operators accepted by every filterable field
+var scalarOperators = []Operator{
+	OpEq, OpNe, OpLt, OpLte, OpGt, OpGte, OpIn, OpNin, OpLike, OpExists, OpBetween,
+}
 
-		tag := field.Tag.Get("db")
-		if tag == "" {
-			continue
-		}
-		// Handle db tag with options (e.g., "name,omitempty")
-		parts := strings.Split(tag, ",")
-		dbName := parts[0]
-		if dbName == "-" {
-			continue
+// fieldOperators returns the operators accepted on the field a lookup chain
+// ends on. It states the rules validateFields enforces, and FilterSchema
+// publishes them, so the two describe the same filters.
+func fieldOperators(chain []*schemaField) []Operator {
+	field := chain[len(chain)-1]
+	if !field.Filterable {
+		// Related tables are only queried through $elemMatch or their fields
+		if field.Repeated && len(chain) == 1 {
+			return []Operator{OpElemMatch}
 		}
-		tags[field.Name] = dbName
+		return nil
+	}
+	ops := append([]Operator(nil), scalarOperators...)
+	if field.Searchable {
+		ops = append(ops, OpSearch)
+	}
+	if field.Repeated {
+		ops = append(ops, OpAll, OpContains, OpSize, OpElemMatch)
 	}
-	return tags
+	return ops
 }
 
-// validateFields validates that all fields in filters and options exist in the struct's JSON tags
-func validateFields(filters []Filter, options *QueryOptions, tags map[string]string) error {
-	// Validate filter fields
-	for _, filter := range filters {
-		// Handle nested filters for $or and $and operators
-		if filter.Operator == OpOr || filter.Operator == OpAnd {
-			if err := validateFields(filter.Filters, nil, tags); err != nil {
-				return err
-			}
-			continue
-		}
+// acceptsOperator reports whether fieldOperators lists an operator
+func acceptsOperator(chain []*schemaField, op Operator) bool {
+	return hasOperator(fieldOperators(chain), op)
+}
 
-		// Check if the field exists in the JSON tags
-		found := false
-		for _, jsonTag := range tags {
-			if jsonTag == filter.Field {
-				found = true
-				break
-			}
-		}
-		if !found {
-			return fmt.Errorf("field %q is not a valid JSON field", filter.Field)
+// hasOperator reports whether ops contains op
+func hasOperator(ops []Operator, op Operator) bool {
+	for _, o := range ops {
+		if o == op {
+			return true
 		}
 	}
+	return false
+}
 
-	// Validate sort fields
-	if options != nil && len(options.Sort) > 0 {
-		for field := range options.Sort {
-			found := false
-			for _, jsonTag := range tags {
-				if jsonTag == field {
-					found = true
-					break
-				}
-			}
-			if !found {
-				return fmt.Errorf("field %q is not a valid JSON field for sorting", field)
-			}
-		}
+// setValues returns the values of an $in or $nin filter
+func setValues(filter Filter) ([]any, error) {
+	values, ok := sliceValues(filter.Value)
+	if !ok {
+		return nil, fmt.Errorf("%s operator requires an array for field %q", filter.Operator, filter.Field)
 	}
+	return values, nil
+}
 
-	return nil
+// likeValue returns the pattern of a $like filter
+func likeValue(filter Filter) (string, error) {
+	pattern, ok := filter.Value.(string)
+	if !ok {
+		return "", fmt.Errorf("$like operator requires a string value for field %q", filter.Field)
+	}
+	return pattern, nil
 }
@@ -0,0 +1,431 @@
+package queryparser
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Syntax is a wire syntax for filters in URL query parameters
+type Syntax string
+
+const (
+	// SyntaxJSON is a JSON filter in the filter parameter:
+	// ?filter={"age":{"$gte":18}}
+	SyntaxJSON Syntax = "json"
+	// SyntaxBracket spreads the filter over bracket parameters:
+	// ?filter[age][$gte]=18&filter[status]=active
+	SyntaxBracket Syntax = "bracket"
+	// SyntaxRSQL is an RSQL expression in the filter parameter:
+	// ?filter=age>=18;status==active
+	SyntaxRSQL Syntax = "rsql"
+)
+
+// numberPattern matches JSON numbers
+var numberPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// listOperators take a list of values in URL parameters
+var listOperators = map[Operator]bool{
+	OpIn: true, OpNin: true, OpAll: true, OpContains: true, OpBetween: true,
+}
+
+// ParseQueryParams parses the filter and query options of URL query
+// parameters. The filter is read in the given syntax and the options from
+// parameters shared by every syntax:
+//
+//	sort=-created,name  sort by created descending, then name, in SortBy
+//	limit=20            page size
+//	offset=40           position of the page
+//	fields=id,name      fields to return
+//
+// URL parameters are strings, so values are converted to the type of the
+// model field they are compared with. The model may be nil, in which case
+// numbers, booleans and null are recognized by their form.
+//
+// The result is validated by the builders, like filters parsed from JSON.
+func ParseQueryParams(values url.Values, syntax Syntax, model any) ([]Filter, *QueryOptions, error) {
+	var schema *modelSchema
+	if model != nil {
+		var err error
+		if schema, err = schemaOf(model); err != nil {
+			return nil, nil, fmt.Errorf("failed to get model schema: %w", err)
+		}
+	}
+
+	var filters []Filter
+	var err error
+	switch syntax {
+	case SyntaxJSON:
+		if filter := values.Get("filter"); filter != "" {
+			filters, err = ParseFilter(filter)
+		}
+	case SyntaxBracket:
+		filters, err = ParseBracketFilter(values, model)
+	case SyntaxRSQL:
+		if filter := values.Get("filter"); filter != "" {
+			filters, err = parseRSQL(filter, schema)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown filter syntax %q", syntax)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	options, err := parseOptionParams(values)
+	if err != nil {
+		return nil, nil, err
+	}
+	return filters, options, nil
+}
+
+// parseOptionParams reads the sort, limit, offset and fields parameters
+func parseOptionParams(values url.Values) (*QueryOptions, error) {
+	options := &QueryOptions{}
+	if sorting := values.Get("sort"); sorting != "" {
+		sorted := make(map[string]bool)
+		for _, name := range strings.Split(sorting, ",") {
+			direction := SortAsc
+			if strings.HasPrefix(name, "-") {
+				name, direction = name[1:], SortDesc
+			}
+			if name == "" || sorted[name] {
+				return nil, fmt.Errorf("invalid sort %q", sorting)
+			}
+			sorted[name] = true
+			options.SortBy = append(options.SortBy, SortField{Field: name, Direction: direction})
+		}
+	}
+	for _, param := range []struct {
+		name  string
+		value **int
+	}{{"limit", &options.Limit}, {"offset", &options.Offset}} {
+		raw := values.Get(param.name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s %q, expected a non-negative integer", param.name, raw)
+		}
+		*param.value = &n
+	}
+	if fields := values.Get("fields"); fields != "" {
+		options.Fields = strings.Split(fields, ",")
+	}
+	return options, nil
+}
+
+// ParseBracketFilter parses a filter spread over bracket parameters, the
+// nested keys of a JSON filter:
+//
+//	filter[status]=active                  {"status": "active"}
+//	filter[age][$gte]=18                   {"age": {"$gte": 18}}
+//	filter[tags][$in]=go,sql               {"tags": {"$in": ["go", "sql"]}}
+//	filter[$or][0][status]=active          {"$or": [{"status": "active"}, ...]}
+//	filter[lines][$elemMatch][sku]=A1      {"lines": {"$elemMatch": {"sku": "A1"}}}
+//
+// The operators taking lists, $in, $nin, $all, $contains and $between, read
+// comma-separated values, or repeated parameters; a comma inside a value is
+// escaped with a backslash. The model may be nil, see ParseQueryParams.
+func ParseBracketFilter(values url.Values, model any) ([]Filter, error) {
+	var schema *modelSchema
+	if model != nil {
+		var err error
+		if schema, err = schemaOf(model); err != nil {
+			return nil, fmt.Errorf("failed to get model schema: %w", err)
+		}
+	}
+
+	// Parameters are read in order, so errors do not depend on map order
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		if key == "filter" || strings.HasPrefix(key, "filter[") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	root := make(map[string]any)
+	for _, key := range keys {
+		path, err := bracketPath(key)
+		if err != nil {
+			return nil, err
+		}
+		node := root
+		for i, segment := range path {
+			if i == len(path)-1 {
+				if _, exists := node[segment]; exists {
+					return nil, fmt.Errorf("conflicting filter parameters for %q", key)
+				}
+				node[segment] = bracketValue(values[key])
+				break
+			}
+			child, exists := node[segment]
+			if !exists {
+				child = make(map[string]any)
+				node[segment] = child
+			}
+			next, ok := child.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("conflicting filter parameters for %q", key)
+			}
+			node = next
+		}
+	}
+
+	tree, err := bracketArrays(root)
+	if err != nil {
+		return nil, err
+	}
+	filters, err := parseFilters(tree.(map[string]any))
+	if err != nil {
+		return nil, err
+	}
+	if err := coerceFilters(filters, schema, nil); err != nil {
+		return nil, err
+	}
+	return filters, nil
+}
+
+// bracketValue holds the raw values of a bracket parameter until they are
+// converted to the type of their field
+type bracketValue []string
+
+// bracketPath splits a parameter such as filter[age][$gte] into its keys
+func bracketPath(key string) ([]string, error) {
+	rest := strings.TrimPrefix(key, "filter")
+	var path []string
+	for rest != "" {
+		end := strings.IndexByte(rest, ']')
+		if rest[0] != '[' || end < 2 {
+			return nil, fmt.Errorf("invalid filter parameter %q", key)
+		}
+		path = append(path, rest[1:end])
+		rest = rest[end+1:]
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("invalid filter parameter %q, use filter[field]=value", key)
+	}
+	return path, nil
+}
+
+// bracketArrays converts the objects under $or and $and, indexed by
+// position, into arrays. Objects combining $or or $and with other keys are
+// rewritten as one $and, as parseFilters reads only the logical operator.
+func bracketArrays(node any) (any, error) {
+	object, ok := node.(map[string]any)
+	if !ok {
+		return node, nil
+	}
+	for key, value := range object {
+		converted, err := bracketArrays(value)
+		if err != nil {
+			return nil, err
+		}
+		object[key] = converted
+		if key != string(OpOr) && key != string(OpAnd) {
+			continue
+		}
+		items, ok := converted.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s requires indexed filters, e.g. filter[%s][0][field]=value", key, key)
+		}
+		indexes := make([]int, 0, len(items))
+		for index := range items {
+			i, err := strconv.Atoi(index)
+			if err != nil || i < 0 || strconv.Itoa(i) != index {
+				return nil, fmt.Errorf("invalid %s index %q", key, index)
+			}
+			indexes = append(indexes, i)
+		}
+		sort.Ints(indexes)
+		list := make([]any, len(indexes))
+		for i, index := range indexes {
+			list[i] = items[strconv.Itoa(index)]
+		}
+		object[key] = list
+	}
+
+	_, or := object[string(OpOr)]
+	_, and := object[string(OpAnd)]
+	if !or && !and || len(object) == 1 {
+		return object, nil
+	}
+	var conditions []any
+	if and {
+		conditions = append(conditions, object[string(OpAnd)].([]any)...)
+	}
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key != string(OpAnd) {
+			conditions = append(conditions, map[string]any{key: object[key]})
+		}
+	}
+	return map[string]any{string(OpAnd): conditions}, nil
+}
+
+// coerceFilters converts the raw values of bracket parameters to the types
+// of the fields they are compared with. Conditions of $elemMatch on scalar
+// elements use the element type elem.
+func coerceFilters(filters []Filter, schema *modelSchema, elem reflect.Type) error {
+	for i := range filters {
+		f := &filters[i]
+		switch {
+		case f.Operator == OpOr || f.Operator == OpAnd:
+			if err := coerceFilters(f.Filters, schema, elem); err != nil {
+				return err
+			}
+			continue
+		case f.Operator == OpElemMatch:
+			children, typ := elemMatchSchema(schema, f.Field)
+			if err := coerceFilters(f.Filters, children, typ); err != nil {
+				return err
+			}
+			continue
+		}
+
+		raws, ok := f.Value.(bracketValue)
+		if !ok {
+			return fmt.Errorf("operator %s of field %q requires a value", f.Operator, f.Field)
+		}
+		typ := elem
+		if f.Field != "" {
+			typ = valueType(schema, f.Field)
+		}
+		value, err := paramValue(f.Operator, f.Field, raws, typ)
+		if err != nil {
+			return err
+		}
+		f.Value = value
+	}
+	return nil
+}
+
+// elemMatchSchema returns the schema of the elements of a slice field, or
+// the element type when they are scalars. Both are nil when unknown.
+func elemMatchSchema(schema *modelSchema, path string) (*modelSchema, reflect.Type) {
+	f := esLeaf(schema, path)
+	if f == nil {
+		return nil, nil
+	}
+	if f.Children != nil {
+		return f.Children, nil
+	}
+	return nil, arrayElemType(f.Type)
+}
+
+// valueType returns the Go type of the values of a field, the element type
+// for slices, or nil when the path is not described by the model
+func valueType(schema *modelSchema, path string) reflect.Type {
+	f := esLeaf(schema, path)
+	if f == nil || f.Children != nil {
+		return nil
+	}
+	return arrayElemType(f.Type)
+}
+
+// paramValue converts the raw values of an operator to the type of its field
+func paramValue(op Operator, field string, raws []string, typ reflect.Type) (any, error) {
+	if listOperators[op] {
+		var values []any
+		for _, raw := range raws {
+			for _, item := range splitList(raw) {
+				value, err := convertParam(op, field, item, false, typ)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, value)
+			}
+		}
+		return values, nil
+	}
+	if len(raws) != 1 {
+		return nil, fmt.Errorf("operator %s of field %q takes a single value", op, field)
+	}
+	return convertParam(op, field, raws[0], false, typ)
+}
+
+// splitList splits comma-separated values, where \, is a literal comma
+func splitList(raw string) []string {
+	var values []string
+	var b strings.Builder
+	for i := 0; i < len(raw); i++ {
+		switch {
+		case raw[i] == '\\' && i+1 < len(raw) && (raw[i+1] == ',' || raw[i+1] == '\\'):
+			i++
+			b.WriteByte(raw[i])
+		case raw[i] == ',':
+			values = append(values, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(raw[i])
+		}
+	}
+	return append(values, b.String())
+}
+
+// convertParam converts a value from a URL parameter. Quoted values are
+// strings unless their field holds numbers or booleans.
+func convertParam(op Operator, field, raw string, quoted bool, typ reflect.Type) (any, error) {
+	switch op {
+	case OpLike, OpSearch, OpText:
+		return raw, nil
+	case OpExists:
+		exists, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("$exists operator requires true or false for field %q, got %q", field, raw)
+		}
+		return exists, nil
+	case OpSize:
+		size, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("$size operator requires a non-negative integer for field %q, got %q", field, raw)
+		}
+		return float64(size), nil
+	}
+
+	if raw == "null" && !quoted {
+		return nil, nil
+	}
+	if typ != nil {
+		for typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+	}
+	switch {
+	case typ != nil && typ.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid boolean %q for field %q", raw, field)
+		}
+		return b, nil
+	case typ != nil && isNumeric(typ):
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil || !numberPattern.MatchString(raw) {
+			return nil, fmt.Errorf("invalid number %q for field %q", raw, field)
+		}
+		return n, nil
+	case typ != nil && typ.Kind() == reflect.String, typ == timeType, quoted:
+		return raw, nil
+	}
+
+	// Unknown types: recognize the JSON literals
+	if raw == "true" || raw == "false" {
+		return raw == "true", nil
+	}
+	if numberPattern.MatchString(raw) {
+		if n, err := strconv.ParseFloat(raw, 64); err == nil {
+			return n, nil
+		}
+	}
+	return raw, nil
+}
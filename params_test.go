@@ -0,0 +1,190 @@
+package queryparser
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBracketFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		model   any
+		want    []Filter
+		wantErr string
+	}{
+		{
+			name:  "equality and operators",
+			query: "filter[name]=Desk&filter[qty][$gte]=2",
+			model: &Item{},
+			want: []Filter{
+				{Field: "name", Operator: OpEq, Value: "Desk"},
+				{Field: "qty", Operator: OpGte, Value: float64(2)},
+			},
+		},
+		{
+			name:  "lists",
+			query: `filter[tags][$in]=home,a\,b&filter[qty][$nin]=1&filter[qty][$nin]=2`,
+			model: &Item{},
+			want: []Filter{
+				{Field: "qty", Operator: OpNin, Value: []any{float64(1), float64(2)}},
+				{Field: "tags", Operator: OpIn, Value: []any{"home", "a,b"}},
+			},
+		},
+		{
+			name:  "values follow the field type",
+			query: "filter[name]=12&filter[active]=false&filter[category]=null&filter[created][$lt]=now-1d",
+			model: &Item{},
+			want: []Filter{
+				{Field: "active", Operator: OpEq, Value: false},
+				{Field: "category", Operator: OpEq, Value: nil},
+				{Field: "created", Operator: OpLt, Value: "now-1d"},
+				{Field: "name", Operator: OpEq, Value: "12"},
+			},
+		},
+		{
+			name:  "or with other conditions",
+			query: "filter[$or][0][qty]=0&filter[$or][1][price][$gt]=100&filter[active]=true",
+			model: &Item{},
+			want: []Filter{{Operator: OpAnd, Filters: []Filter{
+				{Operator: OpOr, Filters: []Filter{
+					{Field: "qty", Operator: OpEq, Value: float64(0)},
+					{Field: "price", Operator: OpGt, Value: float64(100)},
+				}},
+				{Field: "active", Operator: OpEq, Value: true},
+			}}},
+		},
+		{
+			name:  "elemMatch",
+			query: "filter[parts][$elemMatch][qty][$gt]=1&filter[tags][$elemMatch][$gte]=m",
+			model: &Item{},
+			want: []Filter{
+				{Field: "parts", Operator: OpElemMatch, Filters: []Filter{{Field: "qty", Operator: OpGt, Value: float64(1)}}},
+				{Field: "tags", Operator: OpElemMatch, Filters: []Filter{{Operator: OpGte, Value: "m"}}},
+			},
+		},
+		{
+			name:  "values are inferred without a model",
+			query: "filter[a]=1&filter[b]=true&filter[c]=NaN",
+			want: []Filter{
+				{Field: "a", Operator: OpEq, Value: float64(1)},
+				{Field: "b", Operator: OpEq, Value: true},
+				{Field: "c", Operator: OpEq, Value: "NaN"},
+			},
+		},
+		{name: "conflicting parameters", query: "filter[qty]=1&filter[qty][$gt]=0", wantErr: `conflicting filter parameters for "filter[qty][$gt]"`},
+		{name: "missing field", query: "filter=1", wantErr: `invalid filter parameter "filter", use filter[field]=value`},
+		{name: "malformed brackets", query: "filter[qty", wantErr: `invalid filter parameter "filter[qty"`},
+		{name: "or without indexes", query: "filter[$or]=1", wantErr: "$or requires indexed filters, e.g. filter[$or][0][field]=value"},
+		{name: "invalid index", query: "filter[$or][01][qty]=1", wantErr: `invalid $or index "01"`},
+		{name: "repeated single value", query: "filter[qty]=1&filter[qty]=2", wantErr: `operator $eq of field "qty" takes a single value`},
+		{name: "invalid number", query: "filter[qty][$gt]=many", model: &Item{}, wantErr: `invalid number "many" for field "qty"`},
+		{name: "invalid exists", query: "filter[qty][$exists]=maybe", wantErr: `$exists operator requires true or false for field "qty", got "maybe"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			assert.NoError(t, err)
+			got, err := ParseBracketFilter(values, tt.model)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, sortedFilters(tt.want), sortedFilters(got))
+		})
+	}
+}
+
+// sortedFilters orders filters parsed from a map canonically, recursively
+func sortedFilters(filters []Filter) []Filter {
+	for i := range filters {
+		filters[i].Filters = sortedFilters(filters[i].Filters)
+	}
+	return sortFilters(filters)
+}
+
+func TestParseQueryParams(t *testing.T) {
+	limit, offset := 20, 40
+	tests := []struct {
+		name        string
+		query       string
+		syntax      Syntax
+		wantFilters []Filter
+		wantOptions *QueryOptions
+		wantErr     string
+	}{
+		{
+			name:        "json",
+			query:       `filter={"qty":{"$gt":1}}&sort=-qty,name&limit=20&offset=40&fields=id,name`,
+			syntax:      SyntaxJSON,
+			wantFilters: []Filter{{Field: "qty", Operator: OpGt, Value: float64(1)}},
+			wantOptions: &QueryOptions{
+				SortBy: []SortField{{Field: "qty", Direction: SortDesc}, {Field: "name", Direction: SortAsc}},
+				Limit:  &limit,
+				Offset: &offset,
+				Fields: []string{"id", "name"},
+			},
+		},
+		{
+			name:        "bracket",
+			query:       "filter[qty][$gt]=1&limit=20",
+			syntax:      SyntaxBracket,
+			wantFilters: []Filter{{Field: "qty", Operator: OpGt, Value: float64(1)}},
+			wantOptions: &QueryOptions{Limit: &limit},
+		},
+		{
+			name:        "rsql",
+			query:       "filter=qty%3E1",
+			syntax:      SyntaxRSQL,
+			wantFilters: []Filter{{Field: "qty", Operator: OpGt, Value: float64(1)}},
+			wantOptions: &QueryOptions{},
+		},
+		{name: "no filter", query: "", syntax: SyntaxRSQL, wantOptions: &QueryOptions{}},
+		{name: "unknown syntax", query: "", syntax: "xml", wantErr: `unknown filter syntax "xml"`},
+		{name: "negative limit", query: "limit=-1", syntax: SyntaxJSON, wantErr: `invalid limit "-1", expected a non-negative integer`},
+		{name: "invalid offset", query: "offset=x", syntax: SyntaxJSON, wantErr: `invalid offset "x", expected a non-negative integer`},
+		{
+			name:   "sort keeps the order of the fields",
+			query:  "sort=price,-created,id",
+			syntax: SyntaxJSON,
+			wantOptions: &QueryOptions{SortBy: []SortField{
+				{Field: "price", Direction: SortAsc},
+				{Field: "created", Direction: SortDesc},
+				{Field: "id", Direction: SortAsc},
+			}},
+		},
+		{name: "empty sort field", query: "sort=name,-", syntax: SyntaxJSON, wantErr: `invalid sort "name,-"`},
+		{name: "field sorted twice", query: "sort=name,-name", syntax: SyntaxJSON, wantErr: `invalid sort "name,-name"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			assert.NoError(t, err)
+			filters, options, err := ParseQueryParams(values, tt.syntax, &Item{})
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantFilters, filters)
+			assert.Equal(t, tt.wantOptions, options)
+		})
+	}
+
+	// The builders sort in the order of the parameter, not by field name
+	values, err := url.ParseQuery("sort=price,-created,id")
+	assert.NoError(t, err)
+	_, options, err := ParseQueryParams(values, SyntaxJSON, &Item{})
+	assert.NoError(t, err)
+	qb, err := NewSqlBuilder(context.Background()).WithSelect("items").Apply(nil, options, &Item{})
+	assert.NoError(t, err)
+	sql, _, err := qb.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM items ORDER BY price ASC, created DESC, id ASC", sql)
+}
@@ -0,0 +1,126 @@
+package queryparser
+
+import (
+	"fmt"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// RelationMode selects how SqlBuilder filters on fields of related tables
+type RelationMode int
+
+const (
+	// RelationExists emits a correlated EXISTS (SELECT 1 ...) subquery per
+	// relation of each $and group, so a single related row has to match the
+	// conditions of the group. It never duplicates rows of the main table.
+	RelationExists RelationMode = iota
+	// RelationJoin emits a LEFT JOIN per relation and selects DISTINCT rows
+	// of the main table, qualifying its columns with the table name.
+	RelationJoin
+)
+
+// relation describes a related table declared on a model field, e.g.
+//
+//	Orders []Order `json:"orders" query:"relation,table=orders,fk=user_id,ref=id"`
+//
+// ForeignKey is the column of the related table that matches References, the
+// column of the main table. Both can point either way, so a belongs-to
+// relation is declared as fk=id,ref=company_id.
+type relation struct {
+	Table      string
+	ForeignKey string
+	References string
+}
+
+// newRelation builds a relation from the options of a query tag. The table
+// defaults to the field's column or JSON name, the foreign key to the
+// snake_case owner model name followed by _id, and the reference to id.
+func newRelation(owner string, field *schemaField, options queryTag) *relation {
+	rel := &relation{
+		Table:      options["table"],
+		ForeignKey: options["fk"],
+		References: options["ref"],
+	}
+	if rel.Table == "" {
+		rel.Table = field.Column
+	}
+	if rel.Table == "" {
+		rel.Table = field.JSON
+	}
+	if rel.ForeignKey == "" {
+		rel.ForeignKey = toSnakeCase(owner) + "_id"
+	}
+	if rel.References == "" {
+		rel.References = "id"
+	}
+	return rel
+}
+
+// relationOf returns the relation a dotted path starts with, if any
+func relationOf(schema *modelSchema, path string) *relation {
+	chain, ok := schema.lookup(path)
+	if !ok || len(chain) < 2 {
+		return nil
+	}
+	return chain[0].Relation
+}
+
+// validateRelationPath checks that a path uses at most one relation and that
+// it starts with it
+func validateRelationPath(path string, chain []*schemaField) error {
+	for i, field := range chain {
+		if field.Relation == nil {
+			continue
+		}
+		if i > 0 {
+			return fmt.Errorf("field %q crosses more than one relation", path)
+		}
+		if len(chain) == 1 {
+			return fmt.Errorf("field %q is a relation, filter on one of its fields", path)
+		}
+	}
+	return nil
+}
+
+// SetRelationMode sets how conditions on related tables are compiled. The
+// default is RelationExists.
+//
+// Example:
+//
+//	qb := NewSqlBuilder(ctx)
+//	qb.SetRelationMode(RelationJoin)
+//	qb.WithSelect("users")
+//	// {"orders.total": {"$gt": 100}} now generates:
+//	// SELECT DISTINCT users.* FROM users LEFT JOIN orders ON orders.user_id = users.id WHERE (orders.total > $1)
+func (qb *SqlBuilder) SetRelationMode(mode RelationMode) {
+	qb.relationMode = mode
+}
+
+// GetRelationMode returns the current relation mode
+func (qb *SqlBuilder) GetRelationMode() RelationMode {
+	return qb.relationMode
+}
+
+// relationCondition wraps a condition on a related table according to the
+// relation mode
+func (qb *SqlBuilder) relationCondition(rel *relation, condition squirrel.Sqlizer) squirrel.Sqlizer {
+	on := fmt.Sprintf("%s.%s = %s.%s", rel.Table, rel.ForeignKey, qb.table, rel.References)
+
+	if qb.relationMode == RelationJoin {
+		if qb.joined == nil {
+			qb.joined = make(map[string]bool)
+			qb.selectBuilder = qb.selectBuilder.
+				Distinct().
+				RemoveColumns().
+				Columns(qb.table + ".*")
+		}
+		if !qb.joined[rel.Table] {
+			qb.joined[rel.Table] = true
+			qb.selectBuilder = qb.selectBuilder.LeftJoin(rel.Table + " ON " + on)
+		}
+		return condition
+	}
+
+	subquery := squirrel.Select("1").From(rel.Table).Where(on).Where(condition)
+	return squirrel.Expr("EXISTS (?)", subquery)
+}
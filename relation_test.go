@@ -0,0 +1,257 @@
+package queryparser
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/assert"
+)
+
+// RelOrder is the related model of RelUser.Orders
+type RelOrder struct {
+	ID     int       `json:"id" db:"id"`
+	Total  float64   `json:"total" db:"total"`
+	Status string    `json:"status" db:"status"`
+	Items  []RelItem `json:"items" query:"relation,table=order_items,fk=order_id"`
+}
+
+// RelItem is related to RelOrder
+type RelItem struct {
+	SKU string `json:"sku" db:"sku"`
+}
+
+// RelCompany is the related model of RelUser.Company
+type RelCompany struct {
+	ID   int    `json:"id" db:"id"`
+	Name string `json:"name" db:"name"`
+}
+
+// RelUser declares a has-many and a belongs-to relation
+type RelUser struct {
+	ID        int         `json:"id" db:"id"`
+	Name      string      `json:"name" db:"name"`
+	CompanyID int         `json:"company_id" db:"company_id"`
+	Orders    []RelOrder  `json:"orders" query:"relation,table=orders,fk=user_id"`
+	Company   *RelCompany `json:"company" query:"relation,table=companies,fk=id,ref=company_id"`
+}
+
+func TestRelationExists(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		filters  []Filter
+		wantSQL  string
+		wantArgs []any
+	}{
+		{
+			name:     "has-many relation",
+			filters:  []Filter{{Field: "orders.total", Operator: OpGt, Value: 100}},
+			wantSQL:  "SELECT * FROM users WHERE (EXISTS (SELECT 1 FROM orders WHERE orders.user_id = users.id AND orders.total > $1))",
+			wantArgs: []any{100},
+		},
+		{
+			name:     "belongs-to relation",
+			filters:  []Filter{{Field: "company.name", Operator: OpLike, Value: "acme"}},
+			wantSQL:  "SELECT * FROM users WHERE (EXISTS (SELECT 1 FROM companies WHERE companies.id = users.company_id AND companies.name LIKE $1))",
+			wantArgs: []any{"%acme%"},
+		},
+		{
+			name: "relation mixed with local fields",
+			filters: []Filter{
+				{Field: "name", Operator: OpEq, Value: "mike"},
+				{Operator: OpOr, Filters: []Filter{
+					{Field: "orders.status", Operator: OpEq, Value: "open"},
+					{Field: "company.id", Operator: OpIn, Value: []any{1, 2}},
+				}},
+			},
+			wantSQL: "SELECT * FROM users WHERE (name = $1 AND " +
+				"(EXISTS (SELECT 1 FROM orders WHERE orders.user_id = users.id AND orders.status = $2) OR " +
+				"EXISTS (SELECT 1 FROM companies WHERE companies.id = users.company_id AND companies.id IN ($3,$4))))",
+			wantArgs: []any{"mike", "open", 1, 2},
+		},
+		{
+			name: "conditions on one relation share a subquery",
+			filters: []Filter{
+				{Field: "orders.total", Operator: OpGt, Value: 100},
+				{Field: "name", Operator: OpEq, Value: "mike"},
+				{Operator: OpOr, Filters: []Filter{
+					{Field: "orders.status", Operator: OpEq, Value: "open"},
+					{Field: "orders.status", Operator: OpEq, Value: "paid"},
+				}},
+				{Field: "company.name", Operator: OpEq, Value: "acme"},
+			},
+			wantSQL: "SELECT * FROM users WHERE (" +
+				"EXISTS (SELECT 1 FROM orders WHERE orders.user_id = users.id AND (orders.total > $1 AND (orders.status = $2 OR orders.status = $3))) AND " +
+				"name = $4 AND " +
+				"EXISTS (SELECT 1 FROM companies WHERE companies.id = users.company_id AND companies.name = $5))",
+			wantArgs: []any{100, "open", "paid", "mike", "acme"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qb, err := NewSqlBuilder(ctx).WithSelect("users").Apply(tt.filters, nil, &RelUser{})
+			assert.NoError(t, err)
+
+			sql, args, err := qb.ToSql()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantSQL, sql)
+			assert.Equal(t, tt.wantArgs, args)
+		})
+	}
+}
+
+func TestRelationJoin(t *testing.T) {
+	qb := NewSqlBuilder(context.Background())
+	qb.SetRelationMode(RelationJoin)
+	assert.Equal(t, RelationJoin, qb.GetRelationMode())
+	qb.WithSelect("users")
+
+	filters := []Filter{
+		{Field: "orders.total", Operator: OpGt, Value: 100},
+		{Field: "orders.status", Operator: OpEq, Value: "paid"},
+		{Field: "company.name", Operator: OpEq, Value: "acme"},
+		{Field: "id", Operator: OpGt, Value: 1},
+	}
+	qb, err := qb.Apply(filters, nil, &RelUser{})
+	assert.NoError(t, err)
+
+	sql, args, err := qb.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT DISTINCT users.* FROM users "+
+		"LEFT JOIN orders ON orders.user_id = users.id "+
+		"LEFT JOIN companies ON companies.id = users.company_id "+
+		"WHERE (orders.total > $1 AND orders.status = $2 AND companies.name = $3 AND users.id > $4)", sql)
+	assert.Equal(t, []any{100, "paid", "acme", 1}, args)
+
+	// Columns of the main table are qualified in the projection and sorting
+	qb = NewSqlBuilder(context.Background())
+	qb.SetRelationMode(RelationJoin)
+	qb.WithSelect("users")
+	options := &QueryOptions{
+		Fields: []string{"id", "name"},
+		Sort:   map[string]SortDirection{"id": SortDesc},
+	}
+	qb, err = qb.Apply([]Filter{{Field: "company.id", Operator: OpEq, Value: 1}}, options, &RelUser{})
+	assert.NoError(t, err)
+
+	sql, _, err = qb.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT DISTINCT users.id AS id, users.name AS name FROM users "+
+		"LEFT JOIN companies ON companies.id = users.company_id "+
+		"WHERE (companies.id = $1) ORDER BY users.id DESC", sql)
+}
+
+// relationTables creates the tables of RelUser in SQLite
+const relationTables = `CREATE TABLE users (id INTEGER, name TEXT, company_id INTEGER);
+CREATE TABLE orders (id INTEGER, user_id INTEGER, total REAL, status TEXT);
+CREATE TABLE companies (id INTEGER, name TEXT);
+INSERT INTO users VALUES (1, 'mike', 1), (2, 'anna', 2), (3, 'bob', 1);
+INSERT INTO orders VALUES (1, 1, 150, 'open'), (2, 1, 50, 'paid'), (3, 2, 150, 'paid'), (4, 2, 20, 'open');
+INSERT INTO companies VALUES (1, 'acme'), (2, 'globex');
+`
+
+func TestRelationModesMatchSameRows(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		wantIDs []int
+	}{
+		{
+			name:    "one related row matches every condition",
+			filter:  `{"orders.total": {"$gt": 100}, "orders.status": "paid"}`,
+			wantIDs: []int{2},
+		},
+		{
+			name:    "main table columns",
+			filter:  `{"orders.total": {"$gt": 40}, "id": {"$lt": 3}}`,
+			wantIDs: []int{2, 1},
+		},
+		{
+			name:    "$or on one relation",
+			filter:  `{"$and": [{"$or": [{"orders.total": {"$gt": 100}}, {"orders.status": "paid"}]}, {"orders.status": "open"}]}`,
+			wantIDs: []int{1},
+		},
+		{
+			name:    "two relations",
+			filter:  `{"company.name": "acme", "orders.status": "open"}`,
+			wantIDs: []int{1},
+		},
+	}
+
+	options := &QueryOptions{
+		Fields: []string{"id"},
+		Sort:   map[string]SortDirection{"id": SortDesc},
+	}
+	modes := map[string]RelationMode{"exists": RelationExists, "join": RelationJoin}
+	for _, tt := range tests {
+		for modeName, mode := range modes {
+			t.Run(tt.name+"/"+modeName, func(t *testing.T) {
+				filters, err := ParseFilter(tt.filter)
+				assert.NoError(t, err)
+
+				qb := NewSqlBuilderWithPlaceholderFormat(context.Background(), squirrel.Question)
+				qb.SetRelationMode(mode)
+				qb, err = qb.WithSelect("users").Apply(filters, options, &RelUser{})
+				assert.NoError(t, err)
+
+				query, args, err := qb.ToSql()
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantIDs, sqliteIDs(t, relationTables, query, args), query)
+			})
+		}
+	}
+}
+
+func TestRelationDefaults(t *testing.T) {
+	type Post struct {
+		Title string `json:"title" db:"title"`
+	}
+	type Author struct {
+		Posts []Post `json:"posts" db:"posts" query:"relation"`
+	}
+
+	schema, err := schemaOf(&Author{})
+	assert.NoError(t, err)
+	posts, _ := schema.field("posts")
+	assert.Equal(t, &relation{Table: "posts", ForeignKey: "author_id", References: "id"}, posts.Relation)
+}
+
+func TestRelationErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters []Filter
+		options *QueryOptions
+		wantErr string
+	}{
+		{
+			name:    "relation itself",
+			filters: []Filter{{Field: "orders", Operator: OpEq, Value: 1}},
+			wantErr: `field "orders" is not a valid JSON field`,
+		},
+		{
+			name:    "unknown related field",
+			filters: []Filter{{Field: "orders.discount", Operator: OpEq, Value: 1}},
+			wantErr: `field "orders.discount" is not a valid JSON field`,
+		},
+		{
+			name:    "more than one relation",
+			filters: []Filter{{Field: "orders.items.sku", Operator: OpEq, Value: "a"}},
+			wantErr: `field "orders.items.sku" crosses more than one relation`,
+		},
+		{
+			name:    "sort by related field",
+			options: &QueryOptions{Sort: map[string]SortDirection{"company.name": SortAsc}},
+			wantErr: `field "company.name" cannot be used for sorting`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSqlBuilder(context.Background()).WithSelect("users").Apply(tt.filters, tt.options, &RelUser{})
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
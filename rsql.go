@@ -0,0 +1,263 @@
+package queryparser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// rsqlOperators maps the comparison operators of RSQL to filter operators.
+// The FIQL forms are standard; the others extend RSQL with the operators of
+// the JSON syntax.
+var rsqlOperators = map[string]Operator{
+	"==": OpEq, "!=": OpNe,
+	"=lt=": OpLt, "<": OpLt, "=le=": OpLte, "<=": OpLte,
+	"=gt=": OpGt, ">": OpGt, "=ge=": OpGte, ">=": OpGte,
+	"=in=": OpIn, "=out=": OpNin,
+	"=like=": OpLike, "=exists=": OpExists, "=between=": OpBetween,
+	"=all=": OpAll, "=contains=": OpContains, "=size=": OpSize,
+	"=search=": OpSearch,
+}
+
+// rsqlSymbols are the RSQL operators documented for each filter operator.
+// $elemMatch and $text have none.
+var rsqlSymbols = map[Operator]string{
+	OpEq: "==", OpNe: "!=", OpLt: "<", OpLte: "<=", OpGt: ">", OpGte: ">=",
+	OpIn: "=in=", OpNin: "=out=", OpLike: "=like=", OpExists: "=exists=",
+	OpBetween: "=between=", OpAll: "=all=", OpContains: "=contains=",
+	OpSize: "=size=", OpSearch: "=search=",
+}
+
+// ParseRSQL parses an RSQL filter, such as
+//
+//	status==active;(age>=18,vip==true);tags=in=(go,sql)
+//
+// where ; is and, , is or and parentheses group conditions. Besides the
+// FIQL comparisons ==, !=, <, <=, >, >= (or =lt=, =le=, =gt=, =ge=), =in=
+// and =out=, it accepts =like=, =exists=, =between=, =all=, =contains=,
+// =size= and =search=. Values containing reserved characters are quoted
+// with ' or ", and a backslash escapes the next character.
+//
+// Values are converted to the type of the model field they are compared
+// with. The model may be nil, see ParseQueryParams.
+func ParseRSQL(query string, model any) ([]Filter, error) {
+	var schema *modelSchema
+	if model != nil {
+		var err error
+		if schema, err = schemaOf(model); err != nil {
+			return nil, fmt.Errorf("failed to get model schema: %w", err)
+		}
+	}
+	return parseRSQL(query, schema)
+}
+
+// parseRSQL parses an RSQL filter against a schema, which may be nil
+func parseRSQL(query string, schema *modelSchema) ([]Filter, error) {
+	p := &rsqlParser{input: query, schema: schema}
+	filters, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	p.space()
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos])
+	}
+	return filters, nil
+}
+
+// rsqlParser is a recursive descent parser of RSQL
+type rsqlParser struct {
+	input  string
+	pos    int
+	schema *modelSchema
+}
+
+// errorf returns a parse error at the current position
+func (p *rsqlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("invalid RSQL filter at position %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// space skips whitespace
+func (p *rsqlParser) space() {
+	for p.pos < len(p.input) && strings.IndexByte(" \t\r\n", p.input[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// consume skips whitespace and the given character when it is next
+func (p *rsqlParser) consume(c byte) bool {
+	p.space()
+	if p.pos < len(p.input) && p.input[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// or parses alternatives separated by commas
+func (p *rsqlParser) or() ([]Filter, error) {
+	var alternatives [][]Filter
+	for {
+		and, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, and)
+		if !p.consume(',') {
+			break
+		}
+	}
+	if len(alternatives) == 1 {
+		return alternatives[0], nil
+	}
+
+	or := Filter{Operator: OpOr}
+	for _, and := range alternatives {
+		if len(and) == 1 {
+			or.Filters = append(or.Filters, and[0])
+		} else {
+			or.Filters = append(or.Filters, Filter{Operator: OpAnd, Filters: and})
+		}
+	}
+	return []Filter{or}, nil
+}
+
+// and parses constraints separated by semicolons
+func (p *rsqlParser) and() ([]Filter, error) {
+	var filters []Filter
+	for {
+		constraint, err := p.constraint()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, constraint...)
+		if !p.consume(';') {
+			return filters, nil
+		}
+	}
+}
+
+// constraint parses a group in parentheses or a comparison
+func (p *rsqlParser) constraint() ([]Filter, error) {
+	if p.consume('(') {
+		filters, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(')') {
+			return nil, p.errorf("expected )")
+		}
+		return filters, nil
+	}
+
+	p.space()
+	field := p.unreserved()
+	if field == "" {
+		return nil, p.errorf("expected a field")
+	}
+	op, err := p.operator()
+	if err != nil {
+		return nil, err
+	}
+	p.space()
+
+	var raws []string
+	var quoted []bool
+	if p.consume('(') {
+		for {
+			raw, q, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			raws, quoted = append(raws, raw), append(quoted, q)
+			if !p.consume(',') {
+				break
+			}
+		}
+		if !p.consume(')') {
+			return nil, p.errorf("expected )")
+		}
+		if !listOperators[op] {
+			return nil, p.errorf("operator %s of field %q takes a single value", op, field)
+		}
+	} else {
+		raw, q, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		raws, quoted = []string{raw}, []bool{q}
+	}
+
+	typ := valueType(p.schema, field)
+	values := make([]any, len(raws))
+	for i, raw := range raws {
+		if values[i], err = convertParam(op, field, raw, quoted[i], typ); err != nil {
+			return nil, err
+		}
+	}
+	filter := Filter{Field: field, Operator: op, Value: values[0]}
+	if listOperators[op] {
+		filter.Value = values
+	}
+	return []Filter{filter}, nil
+}
+
+// operator parses a comparison operator
+func (p *rsqlParser) operator() (Operator, error) {
+	p.space()
+	rest := p.input[p.pos:]
+	for _, symbol := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if strings.HasPrefix(rest, symbol) {
+			p.pos += len(symbol)
+			return rsqlOperators[symbol], nil
+		}
+	}
+	if strings.HasPrefix(rest, "=") {
+		if end := strings.IndexByte(rest[1:], '='); end >= 0 {
+			if op, ok := rsqlOperators[rest[:end+2]]; ok {
+				p.pos += end + 2
+				return op, nil
+			}
+			return "", p.errorf("unsupported operator %s", rest[:end+2])
+		}
+	}
+	return "", p.errorf("expected an operator")
+}
+
+// value parses an unquoted or quoted value. The second return value reports
+// whether it was quoted.
+func (p *rsqlParser) value() (string, bool, error) {
+	p.space()
+	if p.pos < len(p.input) && (p.input[p.pos] == '\'' || p.input[p.pos] == '"') {
+		quote := p.input[p.pos]
+		p.pos++
+		var b strings.Builder
+		for p.pos < len(p.input) {
+			c := p.input[p.pos]
+			p.pos++
+			switch {
+			case c == '\\' && p.pos < len(p.input):
+				b.WriteByte(p.input[p.pos])
+				p.pos++
+			case c == quote:
+				return b.String(), true, nil
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", false, p.errorf("unterminated string")
+	}
+	value := p.unreserved()
+	if value == "" {
+		return "", false, p.errorf("expected a value")
+	}
+	return value, false, nil
+}
+
+// unreserved parses a run of characters that are not reserved by RSQL
+func (p *rsqlParser) unreserved() string {
+	start := p.pos
+	for p.pos < len(p.input) && strings.IndexByte("\"'();,=!~<> \t\r\n", p.input[p.pos]) < 0 {
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
@@ -0,0 +1,160 @@
+package queryparser
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRSQL(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		model   any
+		want    []Filter
+		wantErr string
+	}{
+		{
+			name:  "and",
+			query: "name==Desk;qty>=2",
+			model: &Item{},
+			want: []Filter{
+				{Field: "name", Operator: OpEq, Value: "Desk"},
+				{Field: "qty", Operator: OpGte, Value: float64(2)},
+			},
+		},
+		{
+			name:  "or binds looser than and",
+			query: "qty==0,active==false;price>100",
+			model: &Item{},
+			want: []Filter{{Operator: OpOr, Filters: []Filter{
+				{Field: "qty", Operator: OpEq, Value: float64(0)},
+				{Operator: OpAnd, Filters: []Filter{
+					{Field: "active", Operator: OpEq, Value: false},
+					{Field: "price", Operator: OpGt, Value: float64(100)},
+				}},
+			}}},
+		},
+		{
+			name:  "groups",
+			query: "category==lighting;(qty=lt=1,qty=gt=10)",
+			model: &Item{},
+			want: []Filter{
+				{Field: "category", Operator: OpEq, Value: "lighting"},
+				{Operator: OpOr, Filters: []Filter{
+					{Field: "qty", Operator: OpLt, Value: float64(1)},
+					{Field: "qty", Operator: OpGt, Value: float64(10)},
+				}},
+			},
+		},
+		{
+			name:  "lists and quoted values",
+			query: `tags=in=(home,'a,b');name=out=("Desk \"Lamp\"")`,
+			model: &Item{},
+			want: []Filter{
+				{Field: "tags", Operator: OpIn, Value: []any{"home", "a,b"}},
+				{Field: "name", Operator: OpNin, Value: []any{`Desk "Lamp"`}},
+			},
+		},
+		{
+			name:  "values follow the field type",
+			query: "name==12;qty=='12';category==null;name=='null'",
+			model: &Item{},
+			want: []Filter{
+				{Field: "name", Operator: OpEq, Value: "12"},
+				{Field: "qty", Operator: OpEq, Value: float64(12)},
+				{Field: "category", Operator: OpEq, Value: nil},
+				{Field: "name", Operator: OpEq, Value: "null"},
+			},
+		},
+		{
+			name:  "extended operators",
+			query: "name=like=lamp;category=exists=true;created=between=(2024-01-01T00:00:00Z,now);tags=size=2;name=search=desk",
+			model: &Item{},
+			want: []Filter{
+				{Field: "name", Operator: OpLike, Value: "lamp"},
+				{Field: "category", Operator: OpExists, Value: true},
+				{Field: "created", Operator: OpBetween, Value: []any{"2024-01-01T00:00:00Z", "now"}},
+				{Field: "tags", Operator: OpSize, Value: float64(2)},
+				{Field: "name", Operator: OpSearch, Value: "desk"},
+			},
+		},
+		{
+			name:  "values are inferred without a model",
+			query: "a==1;b==true;c==x;d=='1'",
+			want: []Filter{
+				{Field: "a", Operator: OpEq, Value: float64(1)},
+				{Field: "b", Operator: OpEq, Value: true},
+				{Field: "c", Operator: OpEq, Value: "x"},
+				{Field: "d", Operator: OpEq, Value: "1"},
+			},
+		},
+		{
+			name:  "whitespace",
+			query: " name == Desk ; ( qty > 1 , qty < 0 ) ",
+			model: &Item{},
+			want: []Filter{
+				{Field: "name", Operator: OpEq, Value: "Desk"},
+				{Operator: OpOr, Filters: []Filter{
+					{Field: "qty", Operator: OpGt, Value: float64(1)},
+					{Field: "qty", Operator: OpLt, Value: float64(0)},
+				}},
+			},
+		},
+		{name: "unknown operator", query: "qty=near=1", wantErr: "invalid RSQL filter at position 3: unsupported operator =near="},
+		{name: "missing value", query: "qty==", wantErr: "invalid RSQL filter at position 5: expected a value"},
+		{name: "missing field", query: "==1", wantErr: "invalid RSQL filter at position 0: expected a field"},
+		{name: "unclosed group", query: "(qty==1", wantErr: "invalid RSQL filter at position 7: expected )"},
+		{name: "unterminated string", query: "name=='Desk", wantErr: "invalid RSQL filter at position 11: unterminated string"},
+		{name: "trailing input", query: "qty==1)", wantErr: "invalid RSQL filter at position 6: unexpected ')'"},
+		{name: "list on a single value operator", query: "qty==(1,2)", wantErr: "invalid RSQL filter at position 10: operator $eq of field \"qty\" takes a single value"},
+		{name: "invalid number", query: "qty==many", model: &Item{}, wantErr: `invalid number "many" for field "qty"`},
+		{name: "invalid boolean", query: "active==yes", model: &Item{}, wantErr: `invalid boolean "yes" for field "active"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRSQL(tt.query, tt.model)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseRSQLSelectsLikeJSON(t *testing.T) {
+	tests := []struct {
+		rsql string
+		json string
+	}{
+		{rsql: "category==lighting", json: `{"category": "lighting"}`},
+		{rsql: "qty>2;qty<=12", json: `{"qty": {"$gt": 2, "$lte": 12}}`},
+		{rsql: "qty==0,price>100", json: `{"$or": [{"qty": 0}, {"price": {"$gt": 100}}]}`},
+		{rsql: "category==null", json: `{"category": null}`},
+		{rsql: "name=like='Cable 5_'", json: `{"name": {"$like": "Cable 5_"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rsql, func(t *testing.T) {
+			fromRSQL, err := ParseRSQL(tt.rsql, &Item{})
+			assert.NoError(t, err)
+			fromJSON, err := ParseFilter(tt.json)
+			assert.NoError(t, err)
+
+			ids := func(filters []Filter) []int {
+				qb, err := NewSqlBuilderWithPlaceholderFormat(context.Background(), squirrel.Question).
+					WithSelect("items").Apply(filters, &QueryOptions{Fields: []string{"id"}}, &Item{})
+				assert.NoError(t, err)
+				query, args, err := qb.ToSql()
+				assert.NoError(t, err)
+				return sqliteIDs(t, itemsTable(), query+" ORDER BY id", args)
+			}
+			assert.Equal(t, ids(fromJSON), ids(fromRSQL))
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package queryparser
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// schemaCache holds the modelSchema of every model type seen so far
+var schemaCache sync.Map // map[reflect.Type]*modelSchema
+
+// timeType is the reflect.Type of time.Time
+var timeType = reflect.TypeOf(time.Time{})
+
+// modelSchema is the reflection metadata of a model type. It is built once
+// per type and shared by every builder.
+type modelSchema struct {
+	typ    reflect.Type
+	name   string // name of the model, the struct type name or Schema.Name
+	fields []*schemaField
+	byJSON map[string]*schemaField
+}
+
+// schemaField describes a model field exposed through a json tag
+type schemaField struct {
+	Name       string              // Go field name
+	JSON       string              // JSON field name used in filters
+	Column     string              // column from the db tag, empty when untagged
+	Type       reflect.Type        // Go type of the field
+	Index      []int               // index sequence for reflect.Value.FieldByIndex
+	Struct     reflect.StructField // original struct field, used by naming strategies
+	Filterable bool
+	Sortable   bool
+	Children   *modelSchema // schema of a nested struct or slice of structs
+	Repeated   bool         // field is a slice or array
+	JSONColumn bool         // nested fields are stored in a JSON column (query:"json" or query:"jsonb")
+	JSONB      bool         // the JSON column is a Postgres jsonb column (query:"jsonb")
+	Nested     bool         // slice elements are an Elasticsearch nested type (es:"nested")
+	Relation   *relation    // related table declared with query:"relation"
+	ArrayType  string       // Postgres element type of an array column (query:"array=integer")
+	Searchable bool         // included in full-text search (query:"searchable")
+	ESType     string       // Elasticsearch field type, declared (es:"text") or inferred
+	ESKeyword  string       // keyword subfield of a text field (es:"text,keyword")
+	Roles      []string     // roles allowed to use the field (query:"roles=admin|hr"), any when empty
+}
+
+// schemaOf returns the cached schema for a struct or pointer to struct, or
+// for a Schema
+func schemaOf(model any) (*modelSchema, error) {
+	switch m := model.(type) {
+	case *Schema:
+		if m == nil {
+			return nil, fmt.Errorf("expected struct or pointer to struct, got nil *Schema")
+		}
+		return m.compile()
+	case Schema:
+		return m.compile()
+	}
+
+	typ := reflect.TypeOf(model)
+	if typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		kind := reflect.Invalid
+		if typ != nil {
+			kind = typ.Kind()
+		}
+		return nil, fmt.Errorf("expected struct or pointer to struct, got %v", kind)
+	}
+
+	if cached, ok := schemaCache.Load(typ); ok {
+		return cached.(*modelSchema), nil
+	}
+	schema, _ := schemaCache.LoadOrStore(typ, buildSchema(typ))
+	return schema.(*modelSchema), nil
+}
+
+// buildSchema walks a struct type, its embedded structs and nested structs
+func buildSchema(typ reflect.Type) *modelSchema {
+	return buildNamedSchema(typ, typ.Name())
+}
+
+// buildNamedSchema builds the schema of a struct type under the given model
+// name, which names generated struct types of a Schema
+func buildNamedSchema(typ reflect.Type, name string) *modelSchema {
+	seen := make(map[reflect.Type]*modelSchema)
+	schema := &modelSchema{typ: typ, name: name, byJSON: make(map[string]*schemaField)}
+	seen[typ] = schema
+	schema.addFields(typ, nil, seen)
+	return schema
+}
+
+// buildSchemaSeen builds a schema, reusing schemas of types already seen so
+// that recursive types terminate
+func buildSchemaSeen(typ reflect.Type, seen map[reflect.Type]*modelSchema) *modelSchema {
+	if schema, ok := seen[typ]; ok {
+		return schema
+	}
+	schema := &modelSchema{
+		typ:    typ,
+		name:   typ.Name(),
+		byJSON: make(map[string]*schemaField),
+	}
+	seen[typ] = schema
+	schema.addFields(typ, nil, seen)
+	return schema
+}
+
+// addFields adds the json-tagged fields of typ, descending into embedded structs
+func (s *modelSchema) addFields(typ reflect.Type, index []int, seen map[reflect.Type]*modelSchema) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		fieldIndex := append(append([]int(nil), index...), i)
+
+		// Handle embedded structs
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			s.addFields(field.Type, fieldIndex, seen)
+			continue
+		}
+
+		jsonName := tagName(field.Tag.Get("json"))
+		if jsonName == "" {
+			continue
+		}
+		// The first field declared with a JSON name wins
+		if _, exists := s.byJSON[jsonName]; exists {
+			continue
+		}
+
+		elem, repeated := elemType(field.Type)
+		options := parseQueryTag(field.Tag.Get("query"))
+		sf := &schemaField{
+			Name:       field.Name,
+			JSON:       jsonName,
+			Column:     DBTagNaming(field),
+			Type:       field.Type,
+			Index:      fieldIndex,
+			Struct:     field,
+			Filterable: !options.has("nofilter"),
+			Repeated:   repeated,
+			JSONColumn: options.has("json") || options.has("jsonb"),
+			JSONB:      options.has("jsonb"),
+			Nested:     parseQueryTag(field.Tag.Get("es")).has("nested"),
+			ArrayType:  options["array"],
+			Searchable: options.has("searchable"),
+			Roles:      parseRoles(options["roles"]),
+		}
+		sf.ESType, sf.ESKeyword = esTypeOf(field, elem, sf.Searchable)
+		if elem.Kind() == reflect.Struct && hasJSONFields(elem) {
+			sf.Children = buildSchemaSeen(elem, seen)
+			if options.has("relation") {
+				sf.Relation = newRelation(s.name, sf, options)
+				sf.Filterable = false
+			}
+		}
+		kind := field.Type.Kind()
+		sf.Sortable = sf.Children == nil && !repeated && kind != reflect.Map && !options.has("nosort")
+		s.fields = append(s.fields, sf)
+		s.byJSON[jsonName] = sf
+	}
+}
+
+// elemType returns the struct-like element type of a field type, looking
+// through pointers, slices and arrays. Byte slices are scalar values.
+func elemType(typ reflect.Type) (reflect.Type, bool) {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	repeated := false
+	if (typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array) && typ.Elem().Kind() != reflect.Uint8 {
+		repeated = true
+		typ = typ.Elem()
+		for typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+	}
+	return typ, repeated
+}
+
+// hasJSONFields reports whether a struct type exposes any json-tagged field,
+// which distinguishes nested documents from value types such as time.Time
+func hasJSONFields(typ reflect.Type) bool {
+	if typ == timeType {
+		return false
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && hasJSONFields(field.Type) {
+			return true
+		}
+		if tagName(field.Tag.Get("json")) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// field returns the field with the given JSON name
+func (s *modelSchema) field(jsonName string) (*schemaField, bool) {
+	f, ok := s.byJSON[jsonName]
+	return f, ok
+}
+
+// lookup resolves a dotted path such as "address.city" into the chain of
+// fields it traverses. Segments below a schemaless JSON column (e.g. a
+// map[string]any tagged query:"jsonb") are not part of the model and are
+// accepted without being added to the chain.
+func (s *modelSchema) lookup(path string) ([]*schemaField, bool) {
+	if f, ok := s.byJSON[path]; ok {
+		return []*schemaField{f}, true
+	}
+
+	var chain []*schemaField
+	current := s
+	for _, segment := range strings.Split(path, ".") {
+		if current == nil {
+			if chain[0].JSONColumn && (len(chain) == 1 || isFreeForm(chain[len(chain)-1].Type)) {
+				return chain, true
+			}
+			return nil, false
+		}
+		f, ok := current.byJSON[segment]
+		if !ok {
+			return nil, false
+		}
+		chain = append(chain, f)
+		current = f.Children
+	}
+	return chain, true
+}
+
+// isFreeForm reports whether values of a type can hold arbitrary JSON, such as
+// map[string]any, any or json.RawMessage
+func isFreeForm(typ reflect.Type) bool {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.Map, reflect.Interface:
+		return true
+	case reflect.Slice:
+		return typ.Elem().Kind() == reflect.Uint8
+	default:
+		return false
+	}
+}
+
+// queryTag holds the options of a query struct tag, e.g. `query:"json"`
+type queryTag map[string]string
+
+// parseQueryTag parses comma separated flags and key=value options
+func parseQueryTag(tag string) queryTag {
+	options := make(queryTag)
+	for _, part := range strings.Split(tag, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(part, "=")
+		options[key] = value
+	}
+	return options
+}
+
+// parseRoles splits the roles of a query tag, e.g. "admin|hr"
+func parseRoles(value string) []string {
+	var roles []string
+	for _, role := range strings.Split(value, "|") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// has reports whether the tag contains the given flag or option
+func (t queryTag) has(key string) bool {
+	_, ok := t[key]
+	return ok
+}
+
+// columnResolver maps JSON field names to database columns for one query
+type columnResolver struct {
+	schema    *modelSchema
+	naming    NamingStrategy
+	columnMap map[string]string
+	table     string // qualifies top-level columns, used for related tables
+}
+
+// column returns the column expression for a JSON field name or dotted path.
+// The second return value is false when neither the column map nor the
+// naming strategy provide one.
+//
+// Fields of a nested struct map to "<alias>.<column>", where the alias is the
+// column of the enclosing struct field, so callers can JOIN the related table
+// under that alias. Fields nested in a JSON column (query:"json") map to
+// Postgres JSON extraction, e.g. address->>'city', cast to the Go type of the
+// leaf field. Fields of a relation
+// (query:"relation") map to columns of the related table, e.g. orders.total.
+func (r columnResolver) column(path string) (string, bool) {
+	if column, ok := r.columnMap[path]; ok {
+		return column, true
+	}
+	chain, ok := r.schema.lookup(path)
+	if !ok {
+		return "", false
+	}
+
+	if rel := chain[0].Relation; rel != nil && len(chain) > 1 {
+		related := columnResolver{schema: chain[0].Children, naming: r.naming, table: rel.Table}
+		return related.column(strings.SplitN(path, ".", 2)[1])
+	}
+
+	root, ok := r.fieldColumn(chain[0])
+	if !ok {
+		return "", false
+	}
+	if r.table != "" {
+		root = r.table + "." + root
+	}
+	if chain[0].JSONColumn && path != chain[0].JSON {
+		jp, _ := r.jsonPath(path)
+		return jp.extract(sqlCast(jp.leaf)), true
+	}
+	if len(chain) == 1 {
+		return root, true
+	}
+
+	alias, ok := r.fieldColumn(chain[len(chain)-2])
+	if !ok {
+		return "", false
+	}
+	leaf, ok := r.fieldColumn(chain[len(chain)-1])
+	if !ok {
+		return "", false
+	}
+	return alias + "." + leaf, true
+}
+
+// fieldColumn returns the column of a single field
+func (r columnResolver) fieldColumn(field *schemaField) (string, bool) {
+	column := field.Column
+	if r.naming != nil {
+		column = r.naming(field.Struct)
+	}
+	return column, column != ""
+}
+
+// columnOrField returns the column for a JSON field name, falling back to the
+// JSON field name itself
+func (r columnResolver) columnOrField(jsonName string) string {
+	if column, ok := r.column(jsonName); ok {
+		return column
+	}
+	return jsonName
+}
@@ -0,0 +1,232 @@
+package queryparser
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Audit is embedded in SchemaModel
+type Audit struct {
+	CreatedAt string `json:"created_at" db:"created_at"`
+	Name      string `json:"name" db:"audit_name"`
+}
+
+// SchemaModel exercises the schema builder
+type SchemaModel struct {
+	ID    int               `json:"id" db:"id"`
+	Name  string            `json:"name,omitempty"`
+	Tags  []string          `json:"tags"`
+	Raw   []byte            `json:"raw"`
+	Attrs map[string]string `json:"attrs"`
+	Audit
+	Secret   string `json:"-"`
+	Untagged string
+}
+
+func TestSchemaOf(t *testing.T) {
+	schema, err := schemaOf(&SchemaModel{})
+	assert.NoError(t, err)
+
+	var names []string
+	for _, f := range schema.fields {
+		names = append(names, f.JSON)
+	}
+	assert.Equal(t, []string{"id", "name", "tags", "raw", "attrs", "created_at"}, names)
+
+	id, ok := schema.field("id")
+	assert.True(t, ok)
+	assert.Equal(t, "ID", id.Name)
+	assert.Equal(t, "id", id.Column)
+	assert.Equal(t, reflect.TypeOf(0), id.Type)
+	assert.True(t, id.Filterable)
+	assert.True(t, id.Sortable)
+
+	// The outer field wins over the embedded one
+	name, _ := schema.field("name")
+	assert.Equal(t, "", name.Column)
+	assert.Equal(t, []int{1}, name.Index)
+
+	createdAt, _ := schema.field("created_at")
+	assert.Equal(t, []int{5, 0}, createdAt.Index)
+	assert.Equal(t, "created_at", createdAt.Column)
+
+	tags, _ := schema.field("tags")
+	assert.False(t, tags.Sortable)
+	raw, _ := schema.field("raw")
+	assert.True(t, raw.Sortable)
+	attrs, _ := schema.field("attrs")
+	assert.False(t, attrs.Sortable)
+
+	_, ok = schema.field("secret")
+	assert.False(t, ok)
+	_, ok = schema.field("Untagged")
+	assert.False(t, ok)
+}
+
+func TestSchemaOfErrors(t *testing.T) {
+	_, err := schemaOf(nil)
+	assert.EqualError(t, err, "expected struct or pointer to struct, got invalid")
+
+	_, err = schemaOf(42)
+	assert.EqualError(t, err, "expected struct or pointer to struct, got int")
+}
+
+func TestSchemaCache(t *testing.T) {
+	first, err := schemaOf(SchemaModel{})
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	schemas := make([]*modelSchema, 16)
+	for i := range schemas {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			schemas[i], _ = schemaOf(&SchemaModel{})
+		}(i)
+	}
+	wg.Wait()
+
+	for _, s := range schemas {
+		assert.Same(t, first, s)
+	}
+}
+
+func TestSortableCapability(t *testing.T) {
+	qb := NewSqlBuilder(context.Background()).WithSelect("models")
+	_, err := qb.Apply(nil, &QueryOptions{Sort: map[string]SortDirection{"tags": SortAsc}}, &SchemaModel{})
+	assert.EqualError(t, err, `field "tags" cannot be used for sorting`)
+}
+
+// wideModel returns a pointer to a struct with n json and db tagged fields
+func wideModel(n int) any {
+	fields := make([]reflect.StructField, n)
+	for i := range fields {
+		fields[i] = reflect.StructField{
+			Name: fmt.Sprintf("Field%d", i),
+			Type: reflect.TypeOf(""),
+			Tag:  reflect.StructTag(fmt.Sprintf(`json:"field_%d" db:"column_%d"`, i, i)),
+		}
+	}
+	return reflect.New(reflect.StructOf(fields)).Interface()
+}
+
+func BenchmarkSchema(b *testing.B) {
+	for _, width := range []int{10, 100, 500} {
+		model := wideModel(width)
+		typ := reflect.TypeOf(model).Elem()
+
+		b.Run(fmt.Sprintf("uncached/%d", width), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				buildSchema(typ)
+			}
+		})
+		b.Run(fmt.Sprintf("cached/%d", width), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, _ = schemaOf(model)
+			}
+		})
+	}
+}
+
+func BenchmarkApplyWideStruct(b *testing.B) {
+	ctx := context.Background()
+	for _, width := range []int{10, 100, 500} {
+		model := wideModel(width)
+		filters := make([]Filter, 0, 10)
+		for i := 0; i < 10; i++ {
+			filters = append(filters, Filter{Field: fmt.Sprintf("field_%d", width-1-i), Operator: OpEq, Value: "x"})
+		}
+		options := &QueryOptions{Sort: map[string]SortDirection{fmt.Sprintf("field_%d", width-1): SortAsc}}
+
+		b.Run(fmt.Sprintf("%d", width), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				qb := NewSqlBuilder(ctx).WithSelect("wide")
+				if _, err := qb.Apply(filters, options, model); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// Geo is nested two levels deep in Customer
+type Geo struct {
+	Lat float64 `json:"lat" db:"lat"`
+}
+
+// Address is a nested struct of Customer
+type Address struct {
+	City string `json:"city" db:"city"`
+	Zip  int    `json:"zip" db:"zip"`
+	Geo  Geo    `json:"geo" db:"geo"`
+}
+
+// OrderLine is a slice element of Customer
+type OrderLine struct {
+	SKU string `json:"sku" db:"sku"`
+	Qty int    `json:"qty" db:"qty"`
+}
+
+// Customer has nested structs, a JSON column and a nested slice
+type Customer struct {
+	ID      int         `json:"id" db:"id"`
+	Address Address     `json:"address" db:"addr"`
+	Billing *Address    `json:"billing" db:"billing" query:"json"`
+	Lines   []OrderLine `json:"lines" db:"lines" es:"nested"`
+	Items   []OrderLine `json:"items" db:"items" query:"json"`
+	Parent  *Customer   `json:"parent" db:"parent"`
+	Created time.Time   `json:"created" db:"created"`
+}
+
+func TestSchemaLookup(t *testing.T) {
+	schema, err := schemaOf(&Customer{})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{path: "id", want: []string{"ID"}},
+		{path: "created", want: []string{"Created"}},
+		{path: "address.city", want: []string{"Address", "City"}},
+		{path: "address.geo.lat", want: []string{"Address", "Geo", "Lat"}},
+		{path: "billing.zip", want: []string{"Billing", "Zip"}},
+		{path: "lines.sku", want: []string{"Lines", "SKU"}},
+		{path: "parent.parent.address.city", want: []string{"Parent", "Parent", "Address", "City"}},
+		{path: "created.year"},
+		{path: "address.street"},
+		{path: "id.value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			chain, ok := schema.lookup(tt.path)
+			if tt.want == nil {
+				assert.False(t, ok)
+				return
+			}
+			assert.True(t, ok)
+			var names []string
+			for _, f := range chain {
+				names = append(names, f.Name)
+			}
+			assert.Equal(t, tt.want, names)
+		})
+	}
+
+	lines, _ := schema.field("lines")
+	assert.True(t, lines.Repeated)
+	assert.True(t, lines.Nested)
+	assert.False(t, lines.Sortable)
+
+	created, _ := schema.field("created")
+	assert.Nil(t, created.Children, "time.Time is a value, not a nested document")
+	assert.True(t, created.Sortable)
+}
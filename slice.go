@@ -0,0 +1,724 @@
+package queryparser
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SliceBuilder applies filters and options to a slice of models in memory.
+// It is a stand-in for SqlBuilder in unit tests and a backend for small
+// reference tables. Fields are resolved through json tags, like in the other
+// builders.
+//
+// Conditions follow SQL semantics, as on SQLite:
+//   - nil values match no comparison, $ne and $nin included, and only match
+//     {"field": null} and {"$exists": false}
+//   - $like matches substrings case-insensitively, with % and _ as wildcards
+//   - conditions through slices of structs, such as relations, hold when one
+//     element matches, and the conditions of an $and group on the same
+//     relation or slice have to match the same element, like the EXISTS
+//     subqueries of SqlBuilder
+//   - nil values sort first in ascending order and last in descending order
+//
+// $text and $search match when every word of the search occurs in the
+// searched fields, ignoring case, which approximates full-text search.
+// Grouping, aggregates and relevance sorting are not supported.
+type SliceBuilder[T any] struct {
+	clock      func() time.Time
+	ctx        context.Context
+	authorizer Authorizer
+}
+
+// NewSliceBuilder creates a SliceBuilder for a struct type or pointer to
+// struct type
+//
+// Example:
+//
+//	users, err := NewSliceBuilder[User]().Apply(allUsers, filters, options)
+func NewSliceBuilder[T any]() *SliceBuilder[T] {
+	return &SliceBuilder[T]{ctx: context.Background()}
+}
+
+// SetContext sets the context of the caller, whose roles are required by
+// restricted fields, see DSLBuilder.SetContext
+func (b *SliceBuilder[T]) SetContext(ctx context.Context) {
+	b.ctx = ctx
+}
+
+// SetAuthorizer sets the Authorizer that Apply and Project consult for every
+// use of a field
+func (b *SliceBuilder[T]) SetAuthorizer(auth Authorizer) {
+	b.authorizer = auth
+}
+
+// SetClock sets the clock used to resolve relative times such as "now-7d".
+// The default is time.Now.
+func (b *SliceBuilder[T]) SetClock(clock func() time.Time) {
+	b.clock = clock
+}
+
+// schema returns the schema of T
+func (b *SliceBuilder[T]) schema() (*modelSchema, error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("failed to get model schema: expected struct or pointer to struct, got %v", typ.Kind())
+	}
+	schema, err := schemaOf(reflect.New(typ).Interface())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get model schema: %w", err)
+	}
+	return schema, nil
+}
+
+// Apply returns the items matching the filters, sorted and paginated as set
+// in the options. The input slice is not modified. Fields are checked
+// against their roles and the Authorizer, see SetContext.
+func (b *SliceBuilder[T]) Apply(items []T, filters []Filter, options *QueryOptions) ([]T, error) {
+	schema, err := b.schema()
+	if err != nil {
+		return nil, err
+	}
+	if err := validateFields(filters, options, schema); err != nil {
+		return nil, err
+	}
+	if err := authorizeModel(b.ctx, filters, options, schema, b.authorizer); err != nil {
+		return nil, err
+	}
+	if options != nil && options.grouped() {
+		return nil, fmt.Errorf("SliceBuilder does not support groupBy or aggregates")
+	}
+	if options != nil && options.Relevance {
+		return nil, fmt.Errorf("relevance sorting is not supported by SliceBuilder")
+	}
+
+	filters, err = resolveTimes(filters, schema, clockNow(b.clock), false)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkValues(filters); err != nil {
+		return nil, err
+	}
+
+	m := newMatcher(filters)
+	result := make([]T, 0, len(items))
+	for _, item := range items {
+		ok, err := m.matchAll(reflect.ValueOf(item), filters, schema)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			result = append(result, item)
+		}
+	}
+	if options == nil {
+		return result, nil
+	}
+
+	sortItems(result, options.sortFields(), schema)
+
+	if options.Offset != nil {
+		offset := min(max(*options.Offset, 0), len(result))
+		result = result[offset:]
+	}
+	if options.Limit != nil {
+		result = result[:min(max(*options.Limit, 0), len(result))]
+	}
+	return result, nil
+}
+
+// Project returns the items as JSON objects holding only options.Fields, or
+// every field when it is empty. Objects are built from the JSON encoding of
+// the items, so json tags, omitempty and custom marshalers apply, and
+// numbers are json.Number values.
+func (b *SliceBuilder[T]) Project(items []T, options *QueryOptions) ([]map[string]any, error) {
+	schema, err := b.schema()
+	if err != nil {
+		return nil, err
+	}
+	var fields []string
+	if options != nil {
+		fields = options.Fields
+	}
+	if err := validateFields(nil, &QueryOptions{Fields: fields}, schema); err != nil {
+		return nil, err
+	}
+	if err := authorizeModel(b.ctx, nil, &QueryOptions{Fields: fields}, schema, b.authorizer); err != nil {
+		return nil, err
+	}
+
+	result := make([]map[string]any, len(items))
+	for i, item := range items {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode item %d: %w", i, err)
+		}
+		var object map[string]any
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&object); err != nil {
+			return nil, fmt.Errorf("failed to decode item %d: %w", i, err)
+		}
+		if len(fields) == 0 {
+			result[i] = object
+			continue
+		}
+
+		projected := make(map[string]any, len(fields))
+		for _, field := range fields {
+			project(projected, object, strings.Split(field, "."))
+		}
+		result[i] = projected
+	}
+	return result, nil
+}
+
+// project copies the value at a path of src into dst, keeping the enclosing
+// objects. Arrays along the path are projected element by element.
+func project(dst, src map[string]any, path []string) {
+	value, ok := src[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		dst[path[0]] = value
+		return
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		child, _ := dst[path[0]].(map[string]any)
+		if child == nil {
+			child = make(map[string]any)
+			dst[path[0]] = child
+		}
+		project(child, v, path[1:])
+	case []any:
+		children, _ := dst[path[0]].([]any)
+		if children == nil {
+			children = make([]any, len(v))
+			dst[path[0]] = children
+		}
+		for i, element := range v {
+			object, ok := element.(map[string]any)
+			if !ok {
+				continue
+			}
+			child, _ := children[i].(map[string]any)
+			if child == nil {
+				child = make(map[string]any)
+				children[i] = child
+			}
+			project(child, object, path[1:])
+		}
+	default:
+		dst[path[0]] = value
+	}
+}
+
+// checkValues rejects malformed filter values up front, so that errors do not
+// depend on the items
+func checkValues(filters []Filter) error {
+	return Walk(filters, func(c *Cursor) error {
+		f := c.Filter()
+		switch f.Operator {
+		case OpAnd, OpOr, OpElemMatch:
+			return nil
+		case OpText, OpSearch:
+			_, err := searchText(f)
+			return err
+		default:
+			_, err := (&matcher{}).matchValue(nil, f)
+			return err
+		}
+	}, nil)
+}
+
+// matcher evaluates filters on items. It holds the regexps of the $like
+// conditions, which are compiled once for all items.
+type matcher struct {
+	likes map[string]*regexp.Regexp
+}
+
+// newMatcher compiles the $like patterns of checked filters
+func newMatcher(filters []Filter) *matcher {
+	m := &matcher{likes: make(map[string]*regexp.Regexp)}
+	_ = Walk(filters, func(c *Cursor) error {
+		if f := c.Filter(); f.Operator == OpLike {
+			if pattern, err := likeValue(f); err == nil && m.likes[pattern] == nil {
+				m.likes[pattern] = likePattern("%" + pattern + "%")
+			}
+		}
+		return nil
+	}, nil)
+	return m
+}
+
+// like returns the regexp of a $like pattern
+func (m *matcher) like(pattern string) *regexp.Regexp {
+	if re, ok := m.likes[pattern]; ok {
+		return re
+	}
+	return likePattern("%" + pattern + "%")
+}
+
+// matchAll reports whether an item matches every filter. Like the EXISTS
+// subqueries of SqlBuilder, the conditions on the same relation or slice of
+// structs have to hold for one of its elements.
+func (m *matcher) matchAll(item reflect.Value, filters []Filter, schema *modelSchema) (bool, error) {
+	var prefixes []string
+	grouped := make(map[string][]Filter)
+	for _, f := range filters {
+		if prefix, ok := elementPrefix(f, schema); ok {
+			if _, seen := grouped[prefix]; !seen {
+				prefixes = append(prefixes, prefix)
+			}
+			grouped[prefix] = append(grouped[prefix], stripPrefix(f, prefix))
+			continue
+		}
+		ok, err := m.matchFilter(item, f, schema)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	for _, prefix := range prefixes {
+		ok, err := m.matchElement(item, prefix, grouped[prefix], schema)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// matchElement reports whether one element of the relation or slice of
+// structs at prefix matches all filters, whose paths are relative to it
+func (m *matcher) matchElement(item reflect.Value, prefix string, filters []Filter, schema *modelSchema) (bool, error) {
+	chain, _ := schema.lookup(prefix)
+	elemSchema := chain[len(chain)-1].Children
+	for _, value := range pathValues(item, schema, splitPath(prefix)) {
+		elements, ok := sliceValues(value)
+		if !ok {
+			// A belongs-to relation
+			elements = []any{value}
+		}
+		for _, element := range elements {
+			ok, err := m.matchAll(reflect.ValueOf(element), filters, elemSchema)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// elementPrefix returns the path of the relation or slice of structs that
+// every condition of a filter tree goes through, such as "orders" for
+// {"orders.total": {"$gt": 100}}. Paths into JSON columns have none.
+func elementPrefix(filter Filter, schema *modelSchema) (string, bool) {
+	switch filter.Operator {
+	case OpAnd, OpOr:
+		prefix := ""
+		for i, f := range filter.Filters {
+			nested, ok := elementPrefix(f, schema)
+			if !ok || (i > 0 && nested != prefix) {
+				return "", false
+			}
+			prefix = nested
+		}
+		return prefix, len(filter.Filters) > 0
+	case OpText:
+		return "", false
+	}
+	if schema == nil {
+		return "", false
+	}
+	chain, ok := schema.lookup(filter.Field)
+	if !ok {
+		return "", false
+	}
+	segments := splitPath(filter.Field)
+	for i := 0; i < len(chain)-1 && i < len(segments)-1; i++ {
+		f := chain[i]
+		if f.JSONColumn {
+			return "", false
+		}
+		if f.Relation != nil || (f.Repeated && f.Children != nil) {
+			return strings.Join(segments[:i+1], "."), true
+		}
+	}
+	return "", false
+}
+
+// stripPrefix makes the paths of a filter tree relative to prefix
+func stripPrefix(filter Filter, prefix string) Filter {
+	if filter.Operator == OpAnd || filter.Operator == OpOr {
+		children := make([]Filter, len(filter.Filters))
+		for i, f := range filter.Filters {
+			children[i] = stripPrefix(f, prefix)
+		}
+		filter.Filters = children
+		return filter
+	}
+	filter.Field = strings.TrimPrefix(filter.Field, prefix+".")
+	return filter
+}
+
+// matchFilter reports whether an item matches one filter
+func (m *matcher) matchFilter(item reflect.Value, filter Filter, schema *modelSchema) (bool, error) {
+	switch filter.Operator {
+	case OpAnd:
+		return m.matchAll(item, filter.Filters, schema)
+	case OpOr:
+		for _, f := range filter.Filters {
+			ok, err := m.matchFilter(item, f, schema)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	case OpText:
+		text, err := searchText(filter)
+		if err != nil {
+			return false, err
+		}
+		var values []any
+		for _, f := range schema.searchable() {
+			values = append(values, pathValues(item, schema, []string{f.JSON})...)
+		}
+		return containsWords(values, text), nil
+	case OpSearch:
+		text, err := searchText(filter)
+		if err != nil {
+			return false, err
+		}
+		return containsWords(pathValues(item, schema, splitPath(filter.Field)), text), nil
+	case OpElemMatch:
+		return m.matchElements(item, filter, schema)
+	}
+
+	for _, v := range pathValues(item, schema, splitPath(filter.Field)) {
+		ok, err := m.matchValue(v, filter)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// matchElements reports whether one element of an array field matches all
+// conditions of an $elemMatch filter
+func (m *matcher) matchElements(item reflect.Value, filter Filter, schema *modelSchema) (bool, error) {
+	var elemSchema *modelSchema
+	if chain, ok := schema.lookup(filter.Field); ok && len(chain) == len(splitPath(filter.Field)) {
+		elemSchema = chain[len(chain)-1].Children
+	}
+	for _, array := range pathValues(item, schema, splitPath(filter.Field)) {
+		elements, ok := sliceValues(array)
+		if !ok {
+			continue
+		}
+		for _, element := range elements {
+			ok, err := m.matchAll(reflect.ValueOf(element), filter.Filters, elemSchema)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// splitPath splits a dotted path into segments. The empty path, used by
+// conditions on scalar array elements, has no segments.
+func splitPath(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+// pathValues returns the values at a path. Slices met before the end of the
+// path contribute one value per element, so a slice without elements has no
+// values; nil pointers yield nil. Paths into maps, such as schemaless JSON
+// columns, are followed by key.
+func pathValues(v reflect.Value, schema *modelSchema, path []string) []any {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return []any{nil}
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return []any{nil}
+	}
+	if len(path) == 0 {
+		return []any{v.Interface()}
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if schema == nil {
+			return []any{nil}
+		}
+		f, ok := schema.field(path[0])
+		if !ok {
+			return []any{nil}
+		}
+		return pathValues(v.FieldByIndex(f.Index), f.Children, path[1:])
+	case reflect.Slice, reflect.Array:
+		var values []any
+		for i := 0; i < v.Len(); i++ {
+			values = append(values, pathValues(v.Index(i), schema, path)...)
+		}
+		return values
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return []any{nil}
+		}
+		value := v.MapIndex(reflect.ValueOf(path[0]).Convert(v.Type().Key()))
+		if !value.IsValid() {
+			return []any{nil}
+		}
+		return pathValues(value, nil, path[1:])
+	default:
+		return []any{nil}
+	}
+}
+
+// matchValue evaluates a field condition on one value
+func (m *matcher) matchValue(v any, filter Filter) (bool, error) {
+	null := v == nil
+	switch filter.Operator {
+	case OpEq:
+		if filter.Value == nil {
+			return null, nil
+		}
+		return !null && equalValues(v, filter.Value), nil
+	case OpNe:
+		if filter.Value == nil {
+			return !null, nil
+		}
+		return !null && !equalValues(v, filter.Value), nil
+	case OpLt, OpLte, OpGt, OpGte:
+		if null {
+			return false, nil
+		}
+		c, ok := orderValues(v, filter.Value)
+		if !ok {
+			return false, nil
+		}
+		switch filter.Operator {
+		case OpLt:
+			return c < 0, nil
+		case OpLte:
+			return c <= 0, nil
+		case OpGt:
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	case OpIn, OpNin:
+		values, err := setValues(filter)
+		if err != nil {
+			return false, err
+		}
+		// Empty sets compile to (1=0) and (1=1) in SQL, which hold for nil too
+		if len(values) == 0 {
+			return filter.Operator == OpNin, nil
+		}
+		if null {
+			return false, nil
+		}
+		found := false
+		for _, value := range values {
+			found = found || equalValues(v, value)
+		}
+		return found == (filter.Operator == OpIn), nil
+	case OpLike:
+		pattern, err := likeValue(filter)
+		if err != nil {
+			return false, err
+		}
+		if null {
+			return false, nil
+		}
+		return m.like(pattern).MatchString(fmt.Sprint(v)), nil
+	case OpExists:
+		exists, ok := filter.Value.(bool)
+		if !ok {
+			return false, fmt.Errorf("$exists operator requires a boolean value for field %q", filter.Field)
+		}
+		return exists != null, nil
+	case OpBetween:
+		b, err := betweenValue(filter)
+		if err != nil {
+			return false, err
+		}
+		if null {
+			return false, nil
+		}
+		lower, ok := orderValues(v, b.From)
+		if !ok {
+			return false, nil
+		}
+		upper, ok := orderValues(v, b.To)
+		if !ok {
+			return false, nil
+		}
+		return (lower > 0 || (lower == 0 && b.lowerInclusive())) &&
+			(upper < 0 || (upper == 0 && b.upperInclusive())), nil
+	case OpAll, OpContains:
+		values, err := arrayValues(filter)
+		if err != nil {
+			return false, err
+		}
+		elements, _ := sliceValues(v)
+		matched := 0
+		for _, value := range values {
+			for _, element := range elements {
+				if equalValues(element, value) {
+					matched++
+					break
+				}
+			}
+		}
+		if filter.Operator == OpAll {
+			return matched == len(values), nil
+		}
+		return matched > 0, nil
+	case OpSize:
+		size, err := sizeValue(filter)
+		if err != nil {
+			return false, err
+		}
+		elements, ok := sliceValues(v)
+		return ok && len(elements) == size, nil
+	default:
+		return false, fmt.Errorf("unsupported operator %s for field %q", filter.Operator, filter.Field)
+	}
+}
+
+// equalValues reports whether two values are equal, comparing numbers by
+// value and times parsed from RFC 3339 strings
+func equalValues(a, b any) bool {
+	if c, ok := orderValues(a, b); ok {
+		return c == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// orderValues orders a model value and a filter value. Booleans order false
+// first and strings are parsed as times when compared to a time.
+func orderValues(a, b any) (int, bool) {
+	if ab, ok := a.(bool); ok {
+		bb, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case ab == bb:
+			return 0, true
+		case !ab:
+			return -1, true
+		default:
+			return 1, true
+		}
+	}
+	if _, ok := a.(time.Time); ok {
+		if s, ok := b.(string); ok {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return 0, false
+			}
+			b = t
+		}
+	}
+	return compareValues(a, b)
+}
+
+// likePattern compiles a LIKE pattern into a case-insensitive regexp
+func likePattern(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// containsWords reports whether every word of text occurs in the values,
+// ignoring case
+func containsWords(values []any, text string) bool {
+	var haystack strings.Builder
+	for _, v := range values {
+		if v != nil {
+			haystack.WriteString(strings.ToLower(fmt.Sprint(v)))
+			haystack.WriteByte(' ')
+		}
+	}
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		if !strings.Contains(haystack.String(), word) {
+			return false
+		}
+	}
+	return true
+}
+
+// sortItems sorts items by the sort fields in order, like SqlBuilder. The
+// sort is stable, so equal items keep their order.
+func sortItems[T any](items []T, fields []SortField, schema *modelSchema) {
+	if len(fields) == 0 {
+		return
+	}
+
+	sortValue := func(item T, field string) any {
+		values := pathValues(reflect.ValueOf(item), schema, splitPath(field))
+		if len(values) == 0 {
+			return nil
+		}
+		return values[0]
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		for _, field := range fields {
+			c := compareForSort(sortValue(items[i], field.Field), sortValue(items[j], field.Field))
+			if c == 0 {
+				continue
+			}
+			if field.Direction == SortDesc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+// compareForSort orders two model values, nil first
+func compareForSort(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	c, _ := orderValues(a, b)
+	return c
+}
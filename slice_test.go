@@ -0,0 +1,407 @@
+package queryparser
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/assert"
+)
+
+// Item is the model of the in-memory and SQLite tests
+type Item struct {
+	ID       int        `json:"id" db:"id"`
+	Name     string     `json:"name" db:"name" query:"searchable"`
+	Qty      int        `json:"qty" db:"qty"`
+	Price    float64    `json:"price" db:"price"`
+	Category *string    `json:"category" db:"category"`
+	Active   bool       `json:"active" db:"active"`
+	Tags     []string   `json:"tags" db:"tags"`
+	Parts    []ItemPart `json:"parts" db:"parts"`
+	Created  time.Time  `json:"created" db:"created"`
+}
+
+type ItemPart struct {
+	SKU string `json:"sku"`
+	Qty int    `json:"qty"`
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+var items = []Item{
+	{ID: 1, Name: "Desk Lamp", Qty: 5, Price: 19.5, Category: strPtr("lighting"), Active: true, Tags: []string{"home", "office"},
+		Parts: []ItemPart{{SKU: "bulb", Qty: 1}}, Created: time.Date(2024, 5, 14, 9, 0, 0, 0, time.UTC)},
+	{ID: 2, Name: "Floor lamp", Qty: 0, Price: 49, Category: strPtr("lighting"), Active: false, Tags: []string{"home"},
+		Parts: []ItemPart{{SKU: "bulb", Qty: 3}, {SKU: "shade", Qty: 1}}, Created: time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)},
+	{ID: 3, Name: "Office Chair", Qty: 12, Price: 120, Category: strPtr("furniture"), Active: true, Tags: []string{"office"},
+		Created: time.Date(2024, 4, 20, 9, 0, 0, 0, time.UTC)},
+	{ID: 4, Name: "Cable 50%", Qty: 40, Price: 4.25, Category: nil, Active: true,
+		Created: time.Date(2024, 5, 15, 9, 0, 0, 0, time.UTC)},
+	{ID: 5, Name: "desk", Qty: 2, Price: 120, Category: strPtr("furniture"), Active: false, Tags: []string{},
+		Created: time.Date(2023, 12, 31, 9, 0, 0, 0, time.UTC)},
+}
+
+// itemsTable creates and fills the items table in SQLite, with the scalar
+// columns of Item
+func itemsTable() string {
+	var b strings.Builder
+	b.WriteString("CREATE TABLE items (id INTEGER, name TEXT, qty INTEGER, price REAL, category TEXT, active INTEGER, created TEXT);\n")
+	for _, item := range items {
+		var category any
+		if item.Category != nil {
+			category = *item.Category
+		}
+		fmt.Fprintf(&b, "INSERT INTO items VALUES (%s, %s, %s, %s, %s, %s, %s);\n",
+			sqlLiteral(item.ID), sqlLiteral(item.Name), sqlLiteral(item.Qty), sqlLiteral(item.Price),
+			sqlLiteral(category), sqlLiteral(item.Active), sqlLiteral(item.Created))
+	}
+	return b.String()
+}
+
+// sqlLiteral formats a value as a SQLite literal
+func sqlLiteral(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case time.Time:
+		return "'" + v.UTC().Format(time.RFC3339) + "'"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// sqliteIDs runs a query returning ids on the SQLite shell after the setup
+// statements, with the ? placeholders replaced by literals. The test fails
+// when the sqlite3 shell is not installed, unless run with -short.
+func sqliteIDs(t *testing.T, setup, query string, args []any) []int {
+	t.Helper()
+	shell, err := exec.LookPath("sqlite3")
+	if err != nil {
+		if testing.Short() {
+			t.Skip("sqlite3 is not installed")
+		}
+		t.Fatal("sqlite3 is not installed; install it or run the tests with -short")
+	}
+
+	var inlined strings.Builder
+	for _, r := range query {
+		if r == '?' && len(args) > 0 {
+			inlined.WriteString(sqlLiteral(args[0]))
+			args = args[1:]
+			continue
+		}
+		inlined.WriteRune(r)
+	}
+
+	cmd := exec.Command(shell, ":memory:")
+	cmd.Stdin = strings.NewReader(setup + inlined.String() + ";\n")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3 failed: %v\n%s", err, out)
+	}
+
+	ids := []int{}
+	for _, line := range strings.Fields(string(out)) {
+		id, err := strconv.Atoi(line)
+		if err != nil {
+			t.Fatalf("unexpected sqlite3 output %q for %s", out, inlined.String())
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// itemIDs returns the ids of items
+func itemIDs(items []Item) []int {
+	ids := []int{}
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+func TestSliceBuilderMatchesSQLite(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		options *QueryOptions
+		want    []int
+	}{
+		{name: "equality", filter: `{"category": "lighting"}`, want: []int{1, 2}},
+		{name: "not equal skips nulls", filter: `{"category": {"$ne": "lighting"}}`, want: []int{3, 5}},
+		{name: "null", filter: `{"category": null}`, want: []int{4}},
+		{name: "not null", filter: `{"category": {"$ne": null}}`, want: []int{1, 2, 3, 5}},
+		{name: "exists", filter: `{"category": {"$exists": false}}`, want: []int{4}},
+		{name: "ranges", filter: `{"qty": {"$gt": 2, "$lte": 12}}`, want: []int{1, 3}},
+		{name: "between", filter: `{"price": {"$between": [19.5, 120]}}`, want: []int{1, 2, 3, 5}},
+		{name: "in", filter: `{"qty": {"$in": [0, 2, 7]}}`, want: []int{2, 5}},
+		{name: "not in skips nulls", filter: `{"category": {"$nin": ["furniture"]}}`, want: []int{1, 2}},
+		{name: "like ignores case", filter: `{"name": {"$like": "LAMP"}}`, want: []int{1, 2}},
+		{name: "like wildcards", filter: `{"name": {"$like": "d_sk"}}`, want: []int{1, 5}},
+		{name: "boolean", filter: `{"active": true, "price": {"$lt": 100}}`, want: []int{1, 4}},
+		{name: "or with and", filter: `{"$or": [{"qty": 0}, {"$and": [{"active": false}, {"price": 120}]}]}`, want: []int{2, 5}},
+		{name: "time", filter: `{"created": {"$gte": "2024-05-01T00:00:00Z"}}`, want: []int{1, 2, 4}},
+		{
+			name:    "multi-key sort",
+			options: &QueryOptions{Sort: map[string]SortDirection{"price": SortDesc, "qty": SortAsc}},
+			want:    []int{5, 3, 2, 1, 4},
+		},
+		{
+			name:    "ordered sort fields",
+			options: &QueryOptions{SortBy: []SortField{{Field: "price", Direction: SortDesc}, {Field: "category"}, {Field: "id"}}},
+			want:    []int{3, 5, 2, 1, 4},
+		},
+		{
+			name:    "nulls sort first",
+			options: &QueryOptions{Sort: map[string]SortDirection{"category": SortAsc, "id": SortDesc}},
+			want:    []int{4, 5, 3, 2, 1},
+		},
+		{
+			name:    "pagination",
+			filter:  `{"qty": {"$gte": 2}}`,
+			options: &QueryOptions{Sort: map[string]SortDirection{"name": SortAsc}, Limit: intPtr(2), Offset: intPtr(1)},
+			want:    []int{1, 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var filters []Filter
+			if tt.filter != "" {
+				var err error
+				filters, err = ParseFilter(tt.filter)
+				assert.NoError(t, err)
+			}
+
+			got, err := NewSliceBuilder[Item]().Apply(items, filters, tt.options)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, itemIDs(got))
+
+			options := QueryOptions{Fields: []string{"id"}}
+			if tt.options != nil {
+				options = *tt.options
+				options.Fields = []string{"id"}
+			}
+			qb, err := NewSqlBuilderWithPlaceholderFormat(context.Background(), squirrel.Question).
+				WithSelect("items").Apply(filters, &options, &Item{})
+			assert.NoError(t, err)
+			query, args, err := qb.ToSql()
+			assert.NoError(t, err)
+			if tt.options == nil {
+				query += " ORDER BY id"
+			}
+			assert.Equal(t, tt.want, sqliteIDs(t, itemsTable(), query, args), query)
+		})
+	}
+
+	relationTests := []struct {
+		name   string
+		filter string
+		want   []int
+	}{
+		{name: "relation", filter: `{"orders.total": {"$gt": 100}}`, want: []int{1, 2}},
+		{name: "conditions on the same row", filter: `{"orders.total": {"$gt": 100}, "orders.status": "paid"}`, want: []int{2}},
+		{name: "or on one relation", filter: `{"$or": [{"orders.status": "open"}, {"orders.total": {"$lt": 20}}]}`, want: []int{1}},
+		{
+			name:   "nested group on one relation",
+			filter: `{"$and": [{"orders.status": "paid"}, {"$or": [{"orders.total": {"$gt": 100}}, {"orders.total": {"$lt": 5}}]}]}`,
+			want:   []int{2},
+		},
+		{name: "or across relation and table", filter: `{"$or": [{"orders.status": "open"}, {"name": "carol"}]}`, want: []int{1, 3}},
+		{name: "belongs to", filter: `{"company.name": "Acme", "name": {"$ne": "carol"}}`, want: []int{1}},
+		{name: "two relations", filter: `{"company.name": "Globex", "orders.status": "paid"}`, want: []int{2}},
+	}
+
+	for _, tt := range relationTests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := ParseFilter(tt.filter)
+			assert.NoError(t, err)
+
+			got, err := NewSliceBuilder[RelUser]().Apply(relUsers, filters, nil)
+			assert.NoError(t, err)
+			ids := []int{}
+			for _, user := range got {
+				ids = append(ids, user.ID)
+			}
+			assert.Equal(t, tt.want, ids)
+
+			qb, err := NewSqlBuilderWithPlaceholderFormat(context.Background(), squirrel.Question).
+				WithSelect("users").Apply(filters, &QueryOptions{Fields: []string{"id"}}, &RelUser{})
+			assert.NoError(t, err)
+			query, args, err := qb.ToSql()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, sqliteIDs(t, relUsersTables(), query+" ORDER BY id", args), query)
+		})
+	}
+}
+
+var relUsers = []RelUser{
+	{ID: 1, Name: "alice", CompanyID: 1, Orders: []RelOrder{{ID: 10, Total: 200, Status: "open"}, {ID: 11, Total: 10, Status: "paid"}},
+		Company: &RelCompany{ID: 1, Name: "Acme"}},
+	{ID: 2, Name: "bob", CompanyID: 2, Orders: []RelOrder{{ID: 20, Total: 150, Status: "paid"}}, Company: &RelCompany{ID: 2, Name: "Globex"}},
+	{ID: 3, Name: "carol", CompanyID: 1, Company: &RelCompany{ID: 1, Name: "Acme"}},
+}
+
+// relUsersTables creates and fills the users, orders and companies tables in
+// SQLite
+func relUsersTables() string {
+	var b strings.Builder
+	b.WriteString("CREATE TABLE users (id INTEGER, name TEXT, company_id INTEGER);\n")
+	b.WriteString("CREATE TABLE orders (id INTEGER, user_id INTEGER, total REAL, status TEXT);\n")
+	b.WriteString("CREATE TABLE companies (id INTEGER, name TEXT);\n")
+	companies := make(map[int]string)
+	for _, user := range relUsers {
+		fmt.Fprintf(&b, "INSERT INTO users VALUES (%s, %s, %s);\n", sqlLiteral(user.ID), sqlLiteral(user.Name), sqlLiteral(user.CompanyID))
+		for _, order := range user.Orders {
+			fmt.Fprintf(&b, "INSERT INTO orders VALUES (%s, %s, %s, %s);\n",
+				sqlLiteral(order.ID), sqlLiteral(user.ID), sqlLiteral(order.Total), sqlLiteral(order.Status))
+		}
+		companies[user.Company.ID] = user.Company.Name
+	}
+	for id, name := range companies {
+		fmt.Fprintf(&b, "INSERT INTO companies VALUES (%s, %s);\n", sqlLiteral(id), sqlLiteral(name))
+	}
+	return b.String()
+}
+
+func TestSliceBuilderInMemoryOperators(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		want   []int
+	}{
+		{name: "$all", filter: `{"tags": {"$all": ["home", "office"]}}`, want: []int{1}},
+		{name: "$contains", filter: `{"tags": {"$contains": ["office"]}}`, want: []int{1, 3}},
+		{name: "$size", filter: `{"tags": {"$size": 0}}`, want: []int{4, 5}},
+		{name: "$elemMatch on structs", filter: `{"parts": {"$elemMatch": {"sku": "bulb", "qty": {"$gt": 1}}}}`, want: []int{2}},
+		{name: "$elemMatch on scalars", filter: `{"tags": {"$elemMatch": {"$like": "off"}}}`, want: []int{1, 3}},
+		{name: "path through a slice", filter: `{"parts.sku": "shade"}`, want: []int{2}},
+		{name: "paths through the same element", filter: `{"parts.sku": "bulb", "parts.qty": 1}`, want: []int{1}},
+		{name: "paths through any element", filter: `{"$and": [{"$or": [{"parts.sku": "shade"}, {"qty": 5}]}, {"parts.qty": 3}]}`, want: []int{2}},
+		{name: "$text", filter: `{"$text": "DESK lamp"}`, want: []int{1}},
+		{name: "relative time", filter: `{"created": {"$gte": "now-1d/d"}}`, want: []int{1, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := ParseFilter(tt.filter)
+			assert.NoError(t, err)
+
+			b := NewSliceBuilder[*Item]()
+			b.SetClock(fixedClock)
+			pointers := make([]*Item, len(items))
+			for i := range items {
+				pointers[i] = &items[i]
+			}
+			got, err := b.Apply(pointers, filters, nil)
+			assert.NoError(t, err)
+			ids := []int{}
+			for _, item := range got {
+				ids = append(ids, item.ID)
+			}
+			assert.Equal(t, tt.want, ids)
+		})
+	}
+}
+
+func TestSliceBuilderProject(t *testing.T) {
+	got, err := NewSliceBuilder[Item]().Project(items[:2], &QueryOptions{Fields: []string{"name", "parts.sku"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]any{
+		{"name": "Desk Lamp", "parts": []any{map[string]any{"sku": "bulb"}}},
+		{"name": "Floor lamp", "parts": []any{map[string]any{"sku": "bulb"}, map[string]any{"sku": "shade"}}},
+	}, got)
+
+	got, err = NewSliceBuilder[Item]().Project(items[3:4], nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"id": json.Number("4"), "name": "Cable 50%", "qty": json.Number("40"), "price": json.Number("4.25"),
+		"category": nil, "active": true, "tags": nil, "parts": nil, "created": "2024-05-15T09:00:00Z",
+	}, got[0])
+}
+
+func TestSliceBuilderCompilesLikeOnce(t *testing.T) {
+	filters, err := ParseFilter(`{"$and": [{"$or": [{"name": {"$like": "lamp"}}, {"parts": {"$elemMatch": {"sku": {"$like": "b_lb"}}}}]}, {"name": {"$like": "lamp"}}]}`)
+	assert.NoError(t, err)
+
+	// Patterns are compiled up front, once each, in nested filters too
+	m := newMatcher(filters)
+	assert.Len(t, m.likes, 2)
+	assert.Same(t, m.likes["lamp"], m.like("lamp"))
+	assert.Same(t, m.likes["b_lb"], m.like("b_lb"))
+
+	got, err := NewSliceBuilder[Item]().Apply(items, filters, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, itemIDs(got))
+}
+
+func BenchmarkSliceBuilderLike(b *testing.B) {
+	many := make([]Item, 0, 1000)
+	for len(many) < cap(many) {
+		many = append(many, items...)
+	}
+	filters := []Filter{{Field: "name", Operator: OpLike, Value: "lamp"}}
+	builder := NewSliceBuilder[Item]()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := builder.Apply(many, filters, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSliceBuilderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters []Filter
+		options *QueryOptions
+		wantErr string
+	}{
+		{
+			name:    "unknown field",
+			filters: []Filter{{Field: "color", Operator: OpEq, Value: "red"}},
+			wantErr: `field "color" is not a valid JSON field`,
+		},
+		{
+			name:    "grouping",
+			options: &QueryOptions{GroupBy: []string{"category"}},
+			wantErr: "SliceBuilder does not support groupBy or aggregates",
+		},
+		{
+			name:    "like without a string",
+			filters: []Filter{{Field: "name", Operator: OpLike, Value: 1}},
+			wantErr: `$like operator requires a string value for field "name"`,
+		},
+		{
+			name:    "unknown projection",
+			options: &QueryOptions{Fields: []string{"color"}},
+			wantErr: `field "color" is not a valid JSON field for selection`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSliceBuilder[Item]().Apply(items, tt.filters, tt.options)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+
+	_, err := NewSliceBuilder[int]().Apply(nil, nil, nil)
+	assert.EqualError(t, err, "failed to get model schema: expected struct or pointer to struct, got int")
+}
@@ -3,6 +3,7 @@ package queryparser
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/Masterminds/squirrel"
 )
@@ -24,6 +25,16 @@ type SqlBuilder struct {
 	insertBuilder     squirrel.InsertBuilder
 	ctx               context.Context
 	placeholderFormat squirrel.PlaceholderFormat
+	naming            NamingStrategy
+	columnMap         map[string]string
+	strictColumns     bool
+	table             string
+	relationMode      RelationMode
+	joined            map[string]bool
+	dialect           Dialect
+	textSearchConfig  string
+	clock             func() time.Time
+	authorizer        Authorizer
 }
 
 // ToSql returns the SQL query string and arguments from the underlying Squirrel
@@ -45,36 +56,66 @@ func (qb *SqlBuilder) ToSql() (string, []any, error) {
 
 // Apply applies the filters and options to the QueryBuilder
 func (qb *SqlBuilder) Apply(filters []Filter, options *QueryOptions, model any) (*SqlBuilder, error) {
-	// Get JSON tags and DB tags from the model
-	jsonTags, err := getJSONTags(model)
+	// Get the cached schema of the model
+	schema, err := schemaOf(model)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get JSON tags: %w", err)
+		return nil, fmt.Errorf("failed to get model schema: %w", err)
 	}
 
-	dbTags, err := getDBTags(model)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get DB tags: %w", err)
+	// Resolve JSON field names to DB column names, letting the explicit
+	// column map override the naming strategy
+	columns := columnResolver{
+		schema:    schema,
+		naming:    qb.naming,
+		columnMap: qb.columnMap,
+	}
+
+	// Validate fields against the model's JSON fields
+	if err := validateFields(filters, options, schema); err != nil {
+		return nil, err
+	}
+
+	if err := validatePaths(filters, options, schema); err != nil {
+		return nil, err
+	}
+
+	// Check that the caller may use the fields, by their roles and the
+	// authorizer
+	if err := authorizeFields(qb.ctx, filters, options, schema, qb.authorizer); err != nil {
+		return nil, err
 	}
 
-	// Create mapping from JSON field names to DB column names
-	jsonToDB := make(map[string]string)
-	for fieldName, jsonTag := range jsonTags {
-		if dbTag, exists := dbTags[fieldName]; exists {
-			jsonToDB[jsonTag] = dbTag
+	// Joined tables may have columns of the same name, such as id
+	if qb.relationMode == RelationJoin {
+		columns.table = qb.table
+	}
+
+	if qb.strictColumns {
+		if err := validateColumns(filters, options, columns); err != nil {
+			return nil, err
 		}
 	}
 
-	// Validate fields against JSON tags
-	if err := validateFields(filters, options, jsonTags); err != nil {
+	// Resolve relative times such as "now-7d" on time fields
+	filters, err = resolveTimes(filters, schema, clockNow(qb.clock), false)
+	if err != nil {
 		return nil, err
 	}
 
 	if qb.selectBuilder != (squirrel.SelectBuilder{}) {
-		qb, err := qb.applySelectFilters(filters, jsonToDB)
+		qb, err := qb.applySelectFilters(filters, columns)
+		if err != nil {
+			return nil, err
+		}
+		qb, err = qb.applyAggregation(options, columns)
+		if err != nil {
+			return nil, err
+		}
+		qb, err = qb.applyRelevance(filters, options, columns)
 		if err != nil {
 			return nil, err
 		}
-		return qb.applyOptions(options, jsonToDB)
+		return qb.applyOptions(options, columns)
 	}
 	// Add support for other query types as needed
 	return qb, nil
@@ -109,27 +150,260 @@ func (qb *SqlBuilder) GetPlaceholderFormat() squirrel.PlaceholderFormat {
 	return qb.placeholderFormat
 }
 
+// SetNamingStrategy sets how column names are derived from model fields.
+// The default is DBTagNaming.
+//
+// Example:
+//
+//	qb := NewSqlBuilder(ctx)
+//	qb.SetNamingStrategy(SnakeCaseNaming)
+//	// A CreatedAt field is now filtered as created_at without a db tag
+func (qb *SqlBuilder) SetNamingStrategy(naming NamingStrategy) {
+	qb.naming = naming
+}
+
+// SetColumnMap sets explicit column names keyed by JSON field name. Entries
+// take precedence over the naming strategy but do not make fields filterable
+// that the model does not expose.
+//
+// Example:
+//
+//	qb := NewSqlBuilder(ctx)
+//	qb.SetColumnMap(map[string]string{"name": "u.full_name"})
+func (qb *SqlBuilder) SetColumnMap(columns map[string]string) {
+	qb.columnMap = columns
+}
+
+// SetStrictColumns makes Apply return an error when a filtered or sorted field
+// has no column from the column map or naming strategy, instead of falling
+// back to the JSON field name
+func (qb *SqlBuilder) SetStrictColumns(strict bool) {
+	qb.strictColumns = strict
+}
+
+// validateColumns checks that every filtered and sorted field resolves to a column
+func validateColumns(filters []Filter, options *QueryOptions, columns columnResolver) error {
+	err := Walk(filters, func(c *Cursor) error {
+		filter := c.Filter()
+		if filter.Operator == OpOr || filter.Operator == OpAnd || filter.Operator == OpText {
+			return nil
+		}
+		if filter.Operator == OpElemMatch {
+			// Elements of a relation live in the related table
+			if chain, ok := columns.schema.lookup(filter.Field); ok && chain[len(chain)-1].Relation != nil {
+				return SkipChildren
+			}
+			if _, exists := columns.column(filter.Field); !exists {
+				return fmt.Errorf("field %q has no mapped column", filter.Field)
+			}
+			return SkipChildren
+		}
+		if _, exists := columns.column(filter.Field); !exists {
+			return fmt.Errorf("field %q has no mapped column", filter.Field)
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	if options != nil {
+		for _, sortField := range options.sortFields() {
+			field := sortField.Field
+			if options.isAggregateAlias(field) {
+				continue
+			}
+			if _, exists := columns.column(field); !exists {
+				return fmt.Errorf("field %q has no mapped column for sorting", field)
+			}
+		}
+		for _, field := range options.GroupBy {
+			if _, exists := columns.column(field); !exists {
+				return fmt.Errorf("field %q has no mapped column for grouping", field)
+			}
+		}
+		for _, agg := range options.Aggregates {
+			if _, exists := columns.column(agg.Field); agg.Field != "" && !exists {
+				return fmt.Errorf("field %q has no mapped column for aggregation", agg.Field)
+			}
+		}
+		for _, field := range options.Fields {
+			if _, exists := columns.column(field); !exists {
+				return fmt.Errorf("field %q has no mapped column for selection", field)
+			}
+		}
+	}
+	return nil
+}
+
+// validatePaths rejects dotted paths the SQL builder cannot compile: paths
+// descending into arrays stored in a JSON column, and paths crossing more
+// than one relation. Related fields cannot be used for sorting.
+func validatePaths(filters []Filter, options *QueryOptions, schema *modelSchema) error {
+	check := func(path string) error {
+		chain, ok := schema.lookup(path)
+		if !ok {
+			return nil
+		}
+		if err := validateRelationPath(path, chain); err != nil {
+			return err
+		}
+		if err := validateJSONKeys(path, chain); err != nil {
+			return err
+		}
+		if len(chain) < 2 || !chain[0].JSONColumn {
+			return nil
+		}
+		for _, field := range chain[:len(chain)-1] {
+			if field.Repeated {
+				return fmt.Errorf("field %q is inside a JSON array and cannot be filtered", path)
+			}
+		}
+		return nil
+	}
+
+	err := Walk(filters, func(c *Cursor) error {
+		filter := c.Filter()
+		if filter.Operator == OpOr || filter.Operator == OpAnd {
+			return nil
+		}
+		if filter.Operator == OpElemMatch {
+			// $elemMatch may name a relation itself; its conditions are
+			// relative to the elements
+			chain, ok := schema.lookup(filter.Field)
+			if ok && len(chain) == 1 && chain[0].Relation != nil {
+				return SkipChildren
+			}
+			if err := check(filter.Field); err != nil {
+				return err
+			}
+			return SkipChildren
+		}
+		return check(filter.Field)
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	if options != nil {
+		for _, sortField := range options.sortFields() {
+			field := sortField.Field
+			if err := check(field); err != nil {
+				return err
+			}
+			if relationOf(schema, field) != nil {
+				return fmt.Errorf("field %q cannot be used for sorting", field)
+			}
+		}
+	}
+	return nil
+}
+
 // applySelectFilters applies filters to a SELECT query
-func (qb *SqlBuilder) applySelectFilters(filters []Filter, jsonToDB map[string]string) (*SqlBuilder, error) {
-	conditions := make([]squirrel.Sqlizer, 0, len(filters))
+func (qb *SqlBuilder) applySelectFilters(filters []Filter, columns columnResolver) (*SqlBuilder, error) {
+	if len(filters) > 0 {
+		conditions, err := qb.buildConditions(filters, columns)
+		if err != nil {
+			return nil, err
+		}
+		qb.selectBuilder = qb.selectBuilder.Where(conditions)
+	}
 
-	for _, filter := range filters {
-		condition, err := qb.buildCondition(filter, jsonToDB)
+	return qb, nil
+}
+
+// buildCondition converts a Filter into a Squirrel condition
+func (qb *SqlBuilder) buildCondition(filter Filter, columns columnResolver) (squirrel.Sqlizer, error) {
+	switch filter.Operator {
+	case OpOr:
+		if len(filter.Filters) == 0 {
+			return nil, fmt.Errorf("$or operator requires nested filters")
+		}
+		orConditions := make([]squirrel.Sqlizer, 0, len(filter.Filters))
+		for _, nestedFilter := range filter.Filters {
+			condition, err := qb.buildCondition(nestedFilter, columns)
+			if err != nil {
+				return nil, err
+			}
+			orConditions = append(orConditions, condition)
+		}
+		return squirrel.Or(orConditions), nil
+	case OpAnd:
+		if len(filter.Filters) == 0 {
+			return nil, fmt.Errorf("$and operator requires nested filters")
+		}
+		return qb.buildConditions(filter.Filters, columns)
+	}
+	return qb.buildLeafCondition(filter, columns)
+}
+
+// buildConditions converts a list of filters into a conjunction. In
+// RelationExists mode the filters on the same relation share one EXISTS
+// subquery, so that, like in RelationJoin mode, a single related row has to
+// match all of them.
+func (qb *SqlBuilder) buildConditions(filters []Filter, columns columnResolver) (squirrel.Sqlizer, error) {
+	andConditions := make([]squirrel.Sqlizer, 0, len(filters))
+	positions := make(map[*relation]int)
+	related := make(map[*relation][]squirrel.Sqlizer)
+	for _, nestedFilter := range filters {
+		rel := qb.filterRelation(nestedFilter, columns.schema)
+		if rel == nil {
+			condition, err := qb.buildCondition(nestedFilter, columns)
+			if err != nil {
+				return nil, err
+			}
+			andConditions = append(andConditions, condition)
+			continue
+		}
+
+		// The conditions of a relation are wrapped once all are known
+		condition, err := buildTree(nestedFilter, func(f Filter) (squirrel.Sqlizer, error) {
+			return qb.buildLocalCondition(f, columns)
+		})
 		if err != nil {
 			return nil, err
 		}
-		conditions = append(conditions, condition)
+		if _, ok := positions[rel]; !ok {
+			// The EXISTS takes the place of the first condition
+			positions[rel] = len(andConditions)
+			andConditions = append(andConditions, nil)
+		}
+		related[rel] = append(related[rel], condition)
 	}
 
-	if len(conditions) > 0 {
-		qb.selectBuilder = qb.selectBuilder.Where(squirrel.And(conditions))
+	for rel, i := range positions {
+		if conditions := related[rel]; len(conditions) == 1 {
+			andConditions[i] = qb.relationCondition(rel, conditions[0])
+		} else {
+			andConditions[i] = qb.relationCondition(rel, squirrel.And(conditions))
+		}
 	}
+	return squirrel.And(andConditions), nil
+}
 
-	return qb, nil
+// filterRelation returns the relation that every condition of a filter tree
+// is on in RelationExists mode, or nil
+func (qb *SqlBuilder) filterRelation(filter Filter, schema *modelSchema) *relation {
+	if qb.relationMode != RelationExists {
+		return nil
+	}
+	if filter.Operator != OpOr && filter.Operator != OpAnd {
+		return relationOf(schema, filter.Field)
+	}
+	var rel *relation
+	for _, nestedFilter := range filter.Filters {
+		nested := qb.filterRelation(nestedFilter, schema)
+		if nested == nil || (rel != nil && nested != rel) {
+			return nil
+		}
+		rel = nested
+	}
+	return rel
 }
 
-// buildCondition converts a Filter into a Squirrel condition
-func (qb *SqlBuilder) buildCondition(filter Filter, jsonToDB map[string]string) (squirrel.Sqlizer, error) {
+// buildTree converts a filter into a Squirrel condition, combining $or and
+// $and groups and delegating every other condition to leaf
+func buildTree(filter Filter, leaf func(Filter) (squirrel.Sqlizer, error)) (squirrel.Sqlizer, error) {
 	// Handle $or and $and operators with nested filters
 	if filter.Operator == OpOr {
 		if len(filter.Filters) == 0 {
@@ -137,7 +411,7 @@ func (qb *SqlBuilder) buildCondition(filter Filter, jsonToDB map[string]string)
 		}
 		orConditions := make([]squirrel.Sqlizer, 0, len(filter.Filters))
 		for _, nestedFilter := range filter.Filters {
-			condition, err := qb.buildCondition(nestedFilter, jsonToDB)
+			condition, err := buildTree(nestedFilter, leaf)
 			if err != nil {
 				return nil, err
 			}
@@ -150,23 +424,60 @@ func (qb *SqlBuilder) buildCondition(filter Filter, jsonToDB map[string]string)
 		if len(filter.Filters) == 0 {
 			return nil, fmt.Errorf("$and operator requires nested filters")
 		}
-		andConditions := make([]squirrel.Sqlizer, 0, len(filter.Filters))
-		for _, nestedFilter := range filter.Filters {
-			condition, err := qb.buildCondition(nestedFilter, jsonToDB)
-			if err != nil {
-				return nil, err
-			}
-			andConditions = append(andConditions, condition)
+		return buildTrees(filter.Filters, leaf)
+	}
+
+	return leaf(filter)
+}
+
+// buildTrees converts a list of filters into a conjunction
+func buildTrees(filters []Filter, leaf func(Filter) (squirrel.Sqlizer, error)) (squirrel.Sqlizer, error) {
+	andConditions := make([]squirrel.Sqlizer, 0, len(filters))
+	for _, nestedFilter := range filters {
+		condition, err := buildTree(nestedFilter, leaf)
+		if err != nil {
+			return nil, err
 		}
-		return squirrel.And(andConditions), nil
+		andConditions = append(andConditions, condition)
+	}
+	return squirrel.And(andConditions), nil
+}
+
+// buildLeafCondition converts a condition on a single field
+func (qb *SqlBuilder) buildLeafCondition(filter Filter, columns columnResolver) (squirrel.Sqlizer, error) {
+	condition, err := qb.buildLocalCondition(filter, columns)
+	if err != nil {
+		return nil, err
 	}
 
-	// Map JSON field name to DB column name
-	dbField := filter.Field
-	if mappedField, exists := jsonToDB[filter.Field]; exists {
-		dbField = mappedField
+	if rel := relationOf(columns.schema, filter.Field); rel != nil {
+		return qb.relationCondition(rel, condition), nil
+	}
+	return condition, nil
+}
+
+// buildLocalCondition converts a condition on a single field, leaving the
+// condition on a related table unwrapped
+func (qb *SqlBuilder) buildLocalCondition(filter Filter, columns columnResolver) (squirrel.Sqlizer, error) {
+	var condition squirrel.Sqlizer
+	var err error
+	if isArrayOperator(filter.Operator) {
+		condition, err = qb.buildArrayCondition(filter, columns)
+	} else if filter.Operator == OpText || filter.Operator == OpSearch {
+		condition, err = qb.buildTextCondition(filter, columns)
+	} else if jp, ok := columns.jsonPath(filter.Field); ok {
+		// Conditions inside JSON columns need extraction and casting
+		condition, err = qb.buildJSONCondition(filter, jp)
+	} else {
+		// Map JSON field name to DB column name
+		condition, err = qb.buildFieldCondition(filter, columns.columnOrField(filter.Field))
 	}
+	return condition, err
+}
 
+// buildFieldCondition converts a single field condition into a Squirrel
+// condition on the given column
+func (qb *SqlBuilder) buildFieldCondition(filter Filter, dbField string) (squirrel.Sqlizer, error) {
 	switch filter.Operator {
 	case OpEq:
 		return squirrel.Eq{dbField: filter.Value}, nil
@@ -181,39 +492,84 @@ func (qb *SqlBuilder) buildCondition(filter Filter, jsonToDB map[string]string)
 	case OpGte:
 		return squirrel.GtOrEq{dbField: filter.Value}, nil
 	case OpIn:
-		return squirrel.Eq{dbField: filter.Value}, nil
+		values, err := setValues(filter)
+		if err != nil {
+			return nil, err
+		}
+		return squirrel.Eq{dbField: values}, nil
 	case OpNin:
-		return squirrel.NotEq{dbField: filter.Value}, nil
+		values, err := setValues(filter)
+		if err != nil {
+			return nil, err
+		}
+		return squirrel.NotEq{dbField: values}, nil
 	case OpLike:
+		pattern, err := likeValue(filter)
+		if err != nil {
+			return nil, err
+		}
 		// Use LIKE for database-agnostic case-insensitive search
 		// Note: Case sensitivity depends on the database collation settings
-		return squirrel.Expr(dbField+" LIKE ?", "%"+filter.Value.(string)+"%"), nil
+		return squirrel.Expr(dbField+" LIKE ?", "%"+pattern+"%"), nil
+	case OpExists:
+		exists, ok := filter.Value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("$exists operator requires a boolean value for field %q", filter.Field)
+		}
+		if exists {
+			return squirrel.NotEq{dbField: nil}, nil
+		}
+		return squirrel.Eq{dbField: nil}, nil
+	case OpBetween:
+		b, err := betweenValue(filter)
+		if err != nil {
+			return nil, err
+		}
+		if b.lowerInclusive() && b.upperInclusive() {
+			return squirrel.Expr(dbField+" BETWEEN ? AND ?", b.From, b.To), nil
+		}
+		var lower, upper squirrel.Sqlizer = squirrel.GtOrEq{dbField: b.From}, squirrel.LtOrEq{dbField: b.To}
+		if !b.lowerInclusive() {
+			lower = squirrel.Gt{dbField: b.From}
+		}
+		if !b.upperInclusive() {
+			upper = squirrel.Lt{dbField: b.To}
+		}
+		return squirrel.And{lower, upper}, nil
 	default:
 		return nil, fmt.Errorf("unsupported operator: %s", filter.Operator)
 	}
 }
 
 // applyOptions applies sorting and pagination options to the query
-func (qb *SqlBuilder) applyOptions(options *QueryOptions, jsonToDB map[string]string) (*SqlBuilder, error) {
+func (qb *SqlBuilder) applyOptions(options *QueryOptions, columns columnResolver) (*SqlBuilder, error) {
 	if options == nil {
 		return qb, nil
 	}
 
-	// Apply sorting
-	if len(options.Sort) > 0 {
-		for field, direction := range options.Sort {
-			// Map JSON field name to DB column name
-			dbField := field
-			if mappedField, exists := jsonToDB[field]; exists {
-				dbField = mappedField
-			}
+	// Apply sorting in the order of the sort fields
+	for _, sortField := range options.sortFields() {
+		// Map JSON field name to DB column name
+		dbField := columns.columnOrField(sortField.Field)
+
+		if sortField.Direction == SortDesc {
+			qb.selectBuilder = qb.selectBuilder.OrderBy(dbField + " DESC")
+		} else {
+			qb.selectBuilder = qb.selectBuilder.OrderBy(dbField + " ASC")
+		}
+	}
 
-			if direction == SortDesc {
-				qb.selectBuilder = qb.selectBuilder.OrderBy(dbField + " DESC")
-			} else {
-				qb.selectBuilder = qb.selectBuilder.OrderBy(dbField + " ASC")
+	// Select the projected fields, aliased to their JSON names
+	if len(options.Fields) > 0 {
+		selected := make([]string, 0, len(options.Fields))
+		for _, field := range options.Fields {
+			column := columns.columnOrField(field)
+			if column != field && aliasPattern.MatchString(field) {
+				column += " AS " + field
 			}
+			selected = append(selected, column)
 		}
+		qb.selectBuilder = qb.selectBuilder.RemoveColumns().Columns(selected...)
 	}
 
 	// Apply pagination
@@ -263,6 +619,8 @@ func NewSqlBuilderWithPlaceholderFormat(ctx context.Context, placeholderFormat s
 func (qb *SqlBuilder) WithSelect(table string) *SqlBuilder {
 	psql := squirrel.StatementBuilder.PlaceholderFormat(qb.placeholderFormat)
 	qb.selectBuilder = psql.Select("*").From(table)
+	qb.table = table
+	qb.joined = nil
 	qb.queryType = selectQuery
 	return qb
 }
@@ -271,6 +629,7 @@ func (qb *SqlBuilder) WithSelect(table string) *SqlBuilder {
 func (qb *SqlBuilder) WithUpdate(table string) *SqlBuilder {
 	psql := squirrel.StatementBuilder.PlaceholderFormat(qb.placeholderFormat)
 	qb.updateBuilder = psql.Update(table)
+	qb.table = table
 	qb.queryType = updateQuery
 	return qb
 }
@@ -279,6 +638,7 @@ func (qb *SqlBuilder) WithUpdate(table string) *SqlBuilder {
 func (qb *SqlBuilder) WithDelete(table string) *SqlBuilder {
 	psql := squirrel.StatementBuilder.PlaceholderFormat(qb.placeholderFormat)
 	qb.deleteBuilder = psql.Delete(table)
+	qb.table = table
 	qb.queryType = deleteQuery
 	return qb
 }
@@ -287,6 +647,7 @@ func (qb *SqlBuilder) WithDelete(table string) *SqlBuilder {
 func (qb *SqlBuilder) WithInsert(table string) *SqlBuilder {
 	psql := squirrel.StatementBuilder.PlaceholderFormat(qb.placeholderFormat)
 	qb.insertBuilder = psql.Insert(table)
+	qb.table = table
 	qb.queryType = insertQuery
 	return qb
 }
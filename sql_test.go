@@ -120,6 +120,19 @@ func TestParseFilter(t *testing.T) {
 				assert.True(t, fields["name"], "should have name field")
 			},
 		},
+		{
+			name:    "conditions in key order",
+			input:   `{"name": "mike", "age": {"$lt": 30, "$gt": 20}}`,
+			wantErr: false,
+			wantLen: 3,
+			validate: func(t *testing.T, filters []Filter) {
+				assert.Equal(t, []Filter{
+					{Field: "age", Operator: OpGt, Value: float64(20)},
+					{Field: "age", Operator: OpLt, Value: float64(30)},
+					{Field: "name", Operator: OpEq, Value: "mike"},
+				}, filters)
+			},
+		},
 		{
 			name:    "operator $gt",
 			input:   `{"age": {"$gt": 20}}`,
@@ -308,6 +321,27 @@ func TestQueryBuilder(t *testing.T) {
 				assert.Equal(t, []any{20}, args)
 			},
 		},
+		{
+			name: "ordered sort fields before the sort map",
+			options: &QueryOptions{
+				SortBy: []SortField{{Field: "name"}, {Field: "age", Direction: SortDesc}},
+				Sort:   map[string]SortDirection{"email": SortAsc, "age": SortAsc},
+			},
+			model: &TestUser{},
+			validate: func(t *testing.T, qb *SqlBuilder) {
+				sql, _, err := qb.selectBuilder.ToSql()
+				assert.NoError(t, err)
+				assert.Contains(t, sql, "ORDER BY name ASC, age DESC, email ASC")
+			},
+		},
+		{
+			name: "invalid sort direction",
+			options: &QueryOptions{
+				SortBy: []SortField{{Field: "age", Direction: "up"}},
+			},
+			model:   &TestUser{},
+			wantErr: true,
+		},
 		{
 			name: "invalid field without JSON tag",
 			filters: []Filter{
@@ -431,3 +465,231 @@ func TestSetPlaceholderFormat(t *testing.T) {
 	assert.Equal(t, "SELECT * FROM users WHERE (name = ?)", sql)
 	assert.Equal(t, []any{"John"}, args)
 }
+
+func TestColumnMapping(t *testing.T) {
+	ctx := context.Background()
+
+	type Account struct {
+		ID        int    `json:"id" db:"account_id"`
+		FullName  string `json:"full_name"`
+		CreatedAt string `json:"created_at" gorm:"column:inserted_at"`
+		Secret    string `json:"-"`
+	}
+
+	tests := []struct {
+		name      string
+		configure func(qb *SqlBuilder)
+		filters   []Filter
+		options   *QueryOptions
+		wantSQL   string
+		wantErr   string
+	}{
+		{
+			name: "default falls back to json name",
+			filters: []Filter{
+				{Field: "id", Operator: OpEq, Value: 1},
+				{Field: "full_name", Operator: OpEq, Value: "mike"},
+			},
+			wantSQL: "SELECT * FROM accounts WHERE (account_id = $1 AND full_name = $2)",
+		},
+		{
+			name: "snake case naming",
+			configure: func(qb *SqlBuilder) {
+				qb.SetNamingStrategy(SnakeCaseNaming)
+			},
+			filters: []Filter{
+				{Field: "id", Operator: OpEq, Value: 1},
+				{Field: "created_at", Operator: OpGt, Value: "2023-01-01"},
+			},
+			wantSQL: "SELECT * FROM accounts WHERE (id = $1 AND created_at > $2)",
+		},
+		{
+			name: "gorm naming",
+			configure: func(qb *SqlBuilder) {
+				qb.SetNamingStrategy(GormTagNaming)
+			},
+			filters: []Filter{
+				{Field: "created_at", Operator: OpGt, Value: "2023-01-01"},
+			},
+			options: &QueryOptions{Sort: map[string]SortDirection{"full_name": SortAsc}},
+			wantSQL: "SELECT * FROM accounts WHERE (inserted_at > $1) ORDER BY full_name ASC",
+		},
+		{
+			name: "column map overrides naming strategy",
+			configure: func(qb *SqlBuilder) {
+				qb.SetColumnMap(map[string]string{"id": "a.id", "full_name": "a.name"})
+			},
+			filters: []Filter{
+				{Field: "id", Operator: OpEq, Value: 1},
+				{Field: "full_name", Operator: OpEq, Value: "mike"},
+			},
+			wantSQL: "SELECT * FROM accounts WHERE (a.id = $1 AND a.name = $2)",
+		},
+		{
+			name: "column map does not expose hidden fields",
+			configure: func(qb *SqlBuilder) {
+				qb.SetColumnMap(map[string]string{"secret": "secret"})
+			},
+			filters: []Filter{
+				{Field: "secret", Operator: OpEq, Value: "x"},
+			},
+			wantErr: `field "secret" is not a valid JSON field`,
+		},
+		{
+			name: "strict mode rejects unmapped filter field",
+			configure: func(qb *SqlBuilder) {
+				qb.SetStrictColumns(true)
+			},
+			filters: []Filter{
+				{Operator: OpOr, Filters: []Filter{
+					{Field: "id", Operator: OpEq, Value: 1},
+					{Field: "full_name", Operator: OpEq, Value: "mike"},
+				}},
+			},
+			wantErr: `field "full_name" has no mapped column`,
+		},
+		{
+			name: "strict mode rejects unmapped sort field",
+			configure: func(qb *SqlBuilder) {
+				qb.SetStrictColumns(true)
+			},
+			options: &QueryOptions{Sort: map[string]SortDirection{"created_at": SortDesc}},
+			wantErr: `field "created_at" has no mapped column for sorting`,
+		},
+		{
+			name: "strict mode accepts column map entries",
+			configure: func(qb *SqlBuilder) {
+				qb.SetStrictColumns(true)
+				qb.SetColumnMap(map[string]string{"full_name": "name"})
+			},
+			filters: []Filter{
+				{Field: "id", Operator: OpEq, Value: 1},
+				{Field: "full_name", Operator: OpEq, Value: "mike"},
+			},
+			wantSQL: "SELECT * FROM accounts WHERE (account_id = $1 AND name = $2)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qb := NewSqlBuilder(ctx)
+			if tt.configure != nil {
+				tt.configure(qb)
+			}
+			qb.WithSelect("accounts")
+
+			qb, err := qb.Apply(tt.filters, tt.options, &Account{})
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+
+			sql, _, err := qb.ToSql()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantSQL, sql)
+		})
+	}
+}
+
+func TestNestedPaths(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		filters  []Filter
+		options  *QueryOptions
+		wantSQL  string
+		wantArgs []any
+		wantErr  string
+	}{
+		{
+			name:     "nested struct maps to joined column",
+			filters:  []Filter{{Field: "address.city", Operator: OpEq, Value: "Paris"}},
+			wantSQL:  "SELECT * FROM customers WHERE (addr.city = $1)",
+			wantArgs: []any{"Paris"},
+		},
+		{
+			name:     "deeply nested struct uses innermost alias",
+			filters:  []Filter{{Field: "address.geo.lat", Operator: OpGt, Value: 48.8}},
+			wantSQL:  "SELECT * FROM customers WHERE (geo.lat > $1)",
+			wantArgs: []any{48.8},
+		},
+		{
+			name:     "slice of structs maps to joined column",
+			filters:  []Filter{{Field: "lines.sku", Operator: OpIn, Value: []any{"a", "b"}}},
+			wantSQL:  "SELECT * FROM customers WHERE (lines.sku IN ($1,$2))",
+			wantArgs: []any{"a", "b"},
+		},
+		{
+			name:     "json column uses text extraction",
+			filters:  []Filter{{Field: "billing.city", Operator: OpEq, Value: "Paris"}},
+			wantSQL:  "SELECT * FROM customers WHERE (billing->>'city' = $1)",
+			wantArgs: []any{"Paris"},
+		},
+		{
+			name:     "json column casts numeric leaves",
+			filters:  []Filter{{Field: "billing.geo.lat", Operator: OpLt, Value: 10}},
+			options:  &QueryOptions{Sort: map[string]SortDirection{"billing.zip": SortDesc}},
+			wantSQL:  "SELECT * FROM customers WHERE ((billing->'geo'->>'lat')::numeric < $1) ORDER BY (billing->>'zip')::numeric DESC",
+			wantArgs: []any{10},
+		},
+		{
+			name:    "unknown nested field",
+			filters: []Filter{{Field: "address.street", Operator: OpEq, Value: "Main"}},
+			wantErr: `field "address.street" is not a valid JSON field`,
+		},
+		{
+			name:    "path into scalar field",
+			filters: []Filter{{Field: "id.value", Operator: OpEq, Value: 1}},
+			wantErr: `field "id.value" is not a valid JSON field`,
+		},
+		{
+			name:    "path into json array",
+			filters: []Filter{{Field: "items.sku", Operator: OpEq, Value: "a"}},
+			wantErr: `field "items.sku" is inside a JSON array and cannot be filtered`,
+		},
+		{
+			name:    "sort through slice",
+			options: &QueryOptions{Sort: map[string]SortDirection{"lines.qty": SortAsc}},
+			wantErr: `field "lines.qty" cannot be used for sorting`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qb, err := NewSqlBuilder(ctx).WithSelect("customers").Apply(tt.filters, tt.options, &Customer{})
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+
+			sql, args, err := qb.ToSql()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantSQL, sql)
+			assert.Equal(t, tt.wantArgs, args)
+		})
+	}
+}
+
+func TestSqlBuilderFields(t *testing.T) {
+	qb := NewSqlBuilder(context.Background())
+	qb.SetColumnMap(map[string]string{"name": "u.full_name"})
+	qb, err := qb.WithSelect("users u").Apply(
+		[]Filter{{Field: "age", Operator: OpGt, Value: 18}},
+		&QueryOptions{Fields: []string{"id", "name"}},
+		&TestUser{},
+	)
+	assert.NoError(t, err)
+	sql, args, err := qb.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT id, u.full_name AS name FROM users u WHERE (age > $1)", sql)
+	assert.Equal(t, []any{18}, args)
+
+	_, err = NewSqlBuilder(context.Background()).WithSelect("users").Apply(nil, &QueryOptions{
+		Fields:  []string{"id"},
+		GroupBy: []string{"age"},
+	}, &TestUser{})
+	assert.EqualError(t, err, "fields cannot be combined with groupBy or aggregates")
+}
@@ -0,0 +1,392 @@
+package queryparser
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// OpRef references a filter of a FilterRegistry by name, e.g.
+// {"$ref": "open_tickets"}. Its Value is the name. References are expanded
+// by FilterRegistry.Resolve; the builders reject them.
+const OpRef Operator = "$ref"
+
+// variablePrefix marks the variables of a JSON filter, e.g. "$$currentUser"
+const variablePrefix = "$$"
+
+// literalPrefix escapes a literal string that starts with "$$", e.g.
+// "$$$price" for the string "$$price"
+const literalPrefix = "$$$"
+
+// Variable is a placeholder for a value bound when the filter is executed,
+// written "$$name" in JSON filters:
+//
+//	{"owner": "$$currentUser", "created_at": {"$gt": "$$since"}}
+//
+// ParseFilter turns such strings into Variables, in condition values and in
+// the elements of arrays and $between ranges. Bind replaces them with their
+// values; the builders reject filters with unbound variables.
+//
+// A literal string that starts with "$$" is written with one more "$":
+// {"code": "$$$off"} matches the string "$$off". MarshalFilter adds the "$"
+// back to such strings.
+type Variable string
+
+// MarshalJSON encodes a variable as "$$name", as written in JSON filters
+func (v Variable) MarshalJSON() ([]byte, error) {
+	return json.Marshal(variablePrefix + string(v))
+}
+
+// parseVariables turns the "$$name" strings of a parsed JSON value into
+// Variables
+func parseVariables(value any) any {
+	switch v := value.(type) {
+	case string:
+		if strings.HasPrefix(v, literalPrefix) {
+			return v[1:]
+		}
+		if name, ok := strings.CutPrefix(v, variablePrefix); ok && name != "" {
+			return Variable(name)
+		}
+	case []any:
+		values := make([]any, len(v))
+		for i, item := range v {
+			values[i] = parseVariables(item)
+		}
+		return values
+	case map[string]any:
+		object := make(map[string]any, len(v))
+		for key, item := range v {
+			object[key] = parseVariables(item)
+		}
+		return object
+	}
+	return value
+}
+
+// escapeLiterals escapes the strings of a value that would parse as
+// variables or escaped literals, the inverse of parseVariables
+func escapeLiterals(value any) any {
+	switch v := value.(type) {
+	case string:
+		if strings.HasPrefix(v, variablePrefix) {
+			return "$" + v
+		}
+	case []string:
+		values := make([]any, len(v))
+		for i, item := range v {
+			values[i] = escapeLiterals(item)
+		}
+		return values
+	case []any:
+		values := make([]any, len(v))
+		for i, item := range v {
+			values[i] = escapeLiterals(item)
+		}
+		return values
+	case map[string]any:
+		object := make(map[string]any, len(v))
+		for key, item := range v {
+			object[key] = escapeLiterals(item)
+		}
+		return object
+	}
+	return value
+}
+
+// Variables returns the distinct names of the variables of a filter tree, in
+// the order they first appear
+func Variables(filters []Filter) []string {
+	var names []string
+	seen := make(map[string]bool)
+	_ = Walk(filters, func(c *Cursor) error {
+		visitVariables(c.Filter().Value, func(v Variable) {
+			if !seen[string(v)] {
+				seen[string(v)] = true
+				names = append(names, string(v))
+			}
+		})
+		return nil
+	}, nil)
+	return names
+}
+
+// visitVariables calls visit for every variable of a value
+func visitVariables(value any, visit func(Variable)) {
+	switch v := value.(type) {
+	case Variable:
+		visit(v)
+	case []any:
+		for _, item := range v {
+			visitVariables(item, visit)
+		}
+	case map[string]any:
+		for _, item := range v {
+			visitVariables(item, visit)
+		}
+	case Between:
+		visitVariables(v.From, visit)
+		visitVariables(v.To, visit)
+	}
+}
+
+// Bind returns a copy of the filters with every variable replaced by its
+// value in vars. It returns an error naming the first variable without a
+// value. A variable may stand for a whole list, e.g. {"$in": "$$statuses"}.
+//
+// Example:
+//
+//	filters, _ := ParseFilter(`{"owner": "$$currentUser", "created_at": {"$gt": "$$since"}}`)
+//	filters, err := Bind(filters, map[string]any{"currentUser": 42, "since": "now-7d"})
+func Bind(filters []Filter, vars map[string]any) ([]Filter, error) {
+	return Rewrite(filters, func(c *Cursor) error {
+		f := c.Filter()
+		value, err := bindValue(f.Value, vars)
+		if err != nil {
+			return err
+		}
+		f.Value = value
+		c.Replace(f)
+		return nil
+	}, nil)
+}
+
+// bindValue replaces the variables of a value
+func bindValue(value any, vars map[string]any) (any, error) {
+	switch v := value.(type) {
+	case Variable:
+		bound, ok := vars[string(v)]
+		if !ok {
+			return nil, fmt.Errorf("variable %q is not bound", variablePrefix+string(v))
+		}
+		return bound, nil
+	case []any:
+		values := make([]any, len(v))
+		for i, item := range v {
+			bound, err := bindValue(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = bound
+		}
+		return values, nil
+	case map[string]any:
+		object := make(map[string]any, len(v))
+		for key, item := range v {
+			bound, err := bindValue(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			object[key] = bound
+		}
+		return object, nil
+	case Between:
+		from, err := bindValue(v.From, vars)
+		if err != nil {
+			return nil, err
+		}
+		to, err := bindValue(v.To, vars)
+		if err != nil {
+			return nil, err
+		}
+		v.From, v.To = from, to
+		return v, nil
+	}
+	return value, nil
+}
+
+// variablesKey is the context key of the variables set by WithVariables
+type variablesKey struct{}
+
+// WithVariables returns a copy of ctx carrying values for the variables of
+// filters, for BindContext. Values already in ctx are kept unless vars
+// overrides them.
+func WithVariables(ctx context.Context, vars map[string]any) context.Context {
+	merged := make(map[string]any)
+	for name, value := range VariablesFromContext(ctx) {
+		merged[name] = value
+	}
+	for name, value := range vars {
+		merged[name] = value
+	}
+	return context.WithValue(ctx, variablesKey{}, merged)
+}
+
+// VariablesFromContext returns the variables set by WithVariables
+func VariablesFromContext(ctx context.Context) map[string]any {
+	if ctx == nil {
+		return nil
+	}
+	vars, _ := ctx.Value(variablesKey{}).(map[string]any)
+	return vars
+}
+
+// BindContext binds the variables of the filters to the values set in ctx
+// with WithVariables, e.g. by a middleware that sets "currentUser"
+func BindContext(ctx context.Context, filters []Filter) ([]Filter, error) {
+	return Bind(filters, VariablesFromContext(ctx))
+}
+
+// validateTemplate rejects the references and variables left in a filter,
+// which must be resolved and bound before it is applied
+func validateTemplate(filter Filter) error {
+	if filter.Operator == OpRef {
+		return fmt.Errorf("filter reference %v must be resolved before the filter is applied", filter.Value)
+	}
+	var unbound *Variable
+	visitVariables(filter.Value, func(v Variable) {
+		if unbound == nil {
+			unbound = &v
+		}
+	})
+	if unbound != nil {
+		return fmt.Errorf("variable %q is not bound", variablePrefix+string(*unbound))
+	}
+	return nil
+}
+
+// FilterRegistry holds named filters, such as saved views, that other
+// filters reference with {"$ref": "name"}. Filters may reference each other
+// and may contain variables, which are bound after they are resolved:
+//
+//	registry := NewFilterRegistry()
+//	registry.RegisterJSON("open_tickets", `{"status": {"$in": ["new", "open"]}}`)
+//	registry.RegisterJSON("my_open_tickets", `{"$ref": "open_tickets", "owner": "$$currentUser"}`)
+//
+//	filters, _ := ParseFilter(`{"$ref": "my_open_tickets", "priority": "high"}`)
+//	filters, err := registry.Resolve(filters)
+//	filters, err = Bind(filters, map[string]any{"currentUser": userID})
+//
+// It is safe for concurrent use.
+type FilterRegistry struct {
+	mu      sync.RWMutex
+	filters map[string][]Filter
+}
+
+// NewFilterRegistry creates an empty FilterRegistry
+func NewFilterRegistry() *FilterRegistry {
+	return &FilterRegistry{filters: make(map[string][]Filter)}
+}
+
+// Register adds a named filter, replacing any filter with the same name.
+// References are checked when filters are resolved, so that filters can be
+// registered in any order.
+func (r *FilterRegistry) Register(name string, filters []Filter) error {
+	if name == "" {
+		return fmt.Errorf("filter name cannot be empty")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.filters[name] = filters
+	return nil
+}
+
+// RegisterJSON parses a JSON filter and adds it under a name
+func (r *FilterRegistry) RegisterJSON(name, filter string) error {
+	filters, err := ParseFilter(filter)
+	if err != nil {
+		return fmt.Errorf("invalid filter %q: %w", name, err)
+	}
+	return r.Register(name, filters)
+}
+
+// Lookup returns the named filter
+func (r *FilterRegistry) Lookup(name string) ([]Filter, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	filters, ok := r.filters[name]
+	return filters, ok
+}
+
+// Resolve returns a copy of the filters with every reference replaced by the
+// filter it names, as an $and group when it has several conditions. A
+// reference to an empty filter matches everything: it is dropped from the
+// conditions it is combined with, and an $or with such a branch matches
+// everything as well, so it is dropped in turn. It returns an error for
+// unknown names and for filters that reference themselves, directly or
+// through others.
+func (r *FilterRegistry) Resolve(filters []Filter) ([]Filter, error) {
+	return r.resolve(filters, nil)
+}
+
+// resolve expands the references of a conjunction of filters, dropping the
+// ones that match everything. refs are the names being expanded, outermost
+// first.
+func (r *FilterRegistry) resolve(filters []Filter, refs []string) ([]Filter, error) {
+	result := make([]Filter, 0, len(filters))
+	for _, f := range filters {
+		resolved, everything, err := r.resolveFilter(f, refs)
+		if err != nil {
+			return nil, err
+		}
+		if !everything {
+			result = append(result, resolved)
+		}
+	}
+	return result, nil
+}
+
+// resolveFilter expands the references of a filter. It reports whether the
+// filter matches everything, which it does when it references an empty
+// filter, when an $or has such a branch, or when every condition of an $and
+// does.
+func (r *FilterRegistry) resolveFilter(f Filter, refs []string) (Filter, bool, error) {
+	if f.Operator == OpOr && len(f.Filters) > 0 {
+		children := make([]Filter, 0, len(f.Filters))
+		for _, child := range f.Filters {
+			resolved, everything, err := r.resolveFilter(child, refs)
+			if err != nil {
+				return Filter{}, false, err
+			}
+			if everything {
+				return Filter{}, true, nil
+			}
+			children = append(children, resolved)
+		}
+		f.Filters = children
+		return f, false, nil
+	}
+	if f.Operator != OpRef {
+		if len(f.Filters) > 0 {
+			children, err := r.resolve(f.Filters, refs)
+			if err != nil {
+				return Filter{}, false, err
+			}
+			if len(children) == 0 && f.Operator == OpAnd {
+				return Filter{}, true, nil
+			}
+			f.Filters = children
+		}
+		return f, false, nil
+	}
+
+	name, ok := f.Value.(string)
+	if !ok {
+		return Filter{}, false, fmt.Errorf("$ref operator requires a filter name, got %v", f.Value)
+	}
+	for i, ref := range refs {
+		if ref == name {
+			cycle := append(append([]string(nil), refs[i:]...), name)
+			return Filter{}, false, fmt.Errorf("filter %q references itself: %s", name, strings.Join(cycle, " -> "))
+		}
+	}
+	named, ok := r.Lookup(name)
+	if !ok {
+		return Filter{}, false, fmt.Errorf("unknown filter %q", name)
+	}
+	expanded, err := r.resolve(named, append(refs, name))
+	if err != nil {
+		return Filter{}, false, err
+	}
+	switch len(expanded) {
+	case 0:
+		// An empty filter matches everything
+		return Filter{}, true, nil
+	case 1:
+		return expanded[0], false, nil
+	default:
+		return Filter{Operator: OpAnd, Filters: expanded}, false, nil
+	}
+}
@@ -0,0 +1,238 @@
+package queryparser
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVariables(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		want   []Filter
+	}{
+		{name: "implicit equality", filter: `{"owner": "$$currentUser"}`, want: []Filter{{Field: "owner", Operator: OpEq, Value: Variable("currentUser")}}},
+		{name: "operator", filter: `{"created": {"$gt": "$$since"}}`, want: []Filter{{Field: "created", Operator: OpGt, Value: Variable("since")}}},
+		{name: "whole list", filter: `{"status": {"$in": "$$statuses"}}`, want: []Filter{{Field: "status", Operator: OpIn, Value: Variable("statuses")}}},
+		{name: "list element", filter: `{"status": {"$in": ["open", "$$status"]}}`, want: []Filter{{Field: "status", Operator: OpIn, Value: []any{"open", Variable("status")}}}},
+		{
+			name:   "range bound",
+			filter: `{"price": {"$between": {"from": "$$min", "to": 100, "bounds": "[)"}}}`,
+			want:   []Filter{{Field: "price", Operator: OpBetween, Value: map[string]any{"from": Variable("min"), "to": float64(100), "bounds": "[)"}}},
+		},
+		{name: "bare prefix", filter: `{"name": "$$"}`, want: []Filter{{Field: "name", Operator: OpEq, Value: "$$"}}},
+		{name: "single dollar", filter: `{"name": "$5"}`, want: []Filter{{Field: "name", Operator: OpEq, Value: "$5"}}},
+		{name: "escaped literal", filter: `{"code": "$$$off"}`, want: []Filter{{Field: "code", Operator: OpEq, Value: "$$off"}}},
+		{name: "escaped list element", filter: `{"code": {"$in": ["$$$off", "$$$$x"]}}`, want: []Filter{{Field: "code", Operator: OpIn, Value: []any{"$$off", "$$$x"}}}},
+		{name: "reference", filter: `{"$ref": "open_tickets"}`, want: []Filter{{Operator: OpRef, Value: "open_tickets"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := ParseFilter(tt.filter)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, filters)
+		})
+	}
+
+	_, err := ParseFilter(`{"$ref": 1}`)
+	assert.EqualError(t, err, "$ref operator requires a filter name")
+}
+
+func TestBind(t *testing.T) {
+	filters, err := ParseFilter(`{"$or": [{"owner": "$$currentUser"}, {"status": {"$in": ["open", "$$status"]}}]}`)
+	assert.NoError(t, err)
+	filters = append(filters, Filter{Field: "price", Operator: OpBetween, Value: Between{From: Variable("min"), To: 100}})
+	assert.Equal(t, []string{"currentUser", "status", "min"}, Variables(filters))
+
+	bound, err := Bind(filters, map[string]any{"currentUser": 42, "status": "new", "min": 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []Filter{
+		{Operator: OpOr, Filters: []Filter{
+			{Field: "owner", Operator: OpEq, Value: 42},
+			{Field: "status", Operator: OpIn, Value: []any{"open", "new"}},
+		}},
+		{Field: "price", Operator: OpBetween, Value: Between{From: 10, To: 100}},
+	}, bound)
+	assert.Empty(t, Variables(bound))
+	assert.Equal(t, Variable("currentUser"), filters[0].Filters[0].Value, "the input is not modified")
+
+	_, err = Bind(filters, map[string]any{"currentUser": 42})
+	assert.EqualError(t, err, `variable "$$status" is not bound`)
+
+	ctx := WithVariables(context.Background(), map[string]any{"currentUser": 7, "status": "new"})
+	ctx = WithVariables(ctx, map[string]any{"min": 1, "status": "closed"})
+	bound, err = BindContext(ctx, filters)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, bound[0].Filters[0].Value)
+	assert.Equal(t, []any{"open", "closed"}, bound[0].Filters[1].Value)
+	assert.Equal(t, Between{From: 1, To: 100}, bound[1].Value)
+}
+
+func TestBuildersRejectTemplates(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		wantErr string
+	}{
+		{name: "unbound variable", filter: `{"name": "$$name"}`, wantErr: `variable "$$name" is not bound`},
+		{name: "unbound list element", filter: `{"$or": [{"qty": 1}, {"qty": {"$in": [2, "$$qty"]}}]}`, wantErr: `variable "$$qty" is not bound`},
+		{name: "unbound element condition", filter: `{"tags": {"$elemMatch": {"$eq": "$$tag"}}}`, wantErr: `variable "$$tag" is not bound`},
+		{name: "unresolved reference", filter: `{"$ref": "cheap", "qty": 1}`, wantErr: "filter reference cheap must be resolved before the filter is applied"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := ParseFilter(tt.filter)
+			if !assert.NoError(t, err) {
+				return
+			}
+			_, err = NewSqlBuilder(context.Background()).WithSelect("items").Apply(filters, nil, &Item{})
+			assert.EqualError(t, err, tt.wantErr)
+			_, err = NewMongoBuilder().Query(filters, nil, &Item{})
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestFilterRegistry(t *testing.T) {
+	registry := NewFilterRegistry()
+	assert.NoError(t, registry.RegisterJSON("in_stock", `{"qty": {"$gt": 0}}`))
+	assert.NoError(t, registry.RegisterJSON("cheap", `{"price": {"$lt": "$$maxPrice"}}`))
+	assert.NoError(t, registry.RegisterJSON("bargains", `{"$and": [{"$ref": "in_stock"}, {"$or": [{"$ref": "cheap"}, {"active": false}]}]}`))
+	assert.NoError(t, registry.Register("everything", nil))
+	assert.EqualError(t, registry.Register("", nil), "filter name cannot be empty")
+	assert.EqualError(t, registry.RegisterJSON("broken", `{"qty":`), `invalid filter "broken": failed to parse filter JSON: unexpected end of JSON input`)
+
+	filters, err := ParseFilter(`{"$and": [{"$ref": "bargains"}, {"$ref": "everything"}, {"category": "office"}]}`)
+	assert.NoError(t, err)
+	resolved, err := registry.Resolve(filters)
+	assert.NoError(t, err)
+	resolved, err = Bind(resolved, map[string]any{"maxPrice": 20})
+	assert.NoError(t, err)
+
+	qb, err := NewSqlBuilder(context.Background()).WithSelect("items").Apply(Normalize(resolved), nil, &Item{})
+	if assert.NoError(t, err) {
+		query, args, err := qb.ToSql()
+		assert.NoError(t, err)
+		assert.Equal(t, "SELECT * FROM items WHERE ((active = $1 OR price < $2) AND category = $3 AND qty > $4)", query)
+		assert.Equal(t, []any{false, 20, "office", float64(0)}, args)
+	}
+}
+
+func TestFilterRegistryEmptyFilters(t *testing.T) {
+	registry := NewFilterRegistry()
+	assert.NoError(t, registry.Register("all", nil))
+	assert.NoError(t, registry.RegisterJSON("also_all", `{"$and": [{"$ref": "all"}]}`))
+	closed := Filter{Field: "status", Operator: OpEq, Value: "closed"}
+
+	tests := []struct {
+		name   string
+		filter string
+		want   []Filter
+	}{
+		{name: "top level", filter: `{"$ref": "all", "status": "closed"}`, want: []Filter{closed}},
+		{name: "and", filter: `{"$and": [{"$ref": "all"}, {"status": "closed"}]}`, want: []Filter{{Operator: OpAnd, Filters: []Filter{closed}}}},
+		{name: "or", filter: `{"$or": [{"$ref": "all"}, {"status": "closed"}]}`, want: []Filter{}},
+		{name: "or of one", filter: `{"$or": [{"$ref": "all"}]}`, want: []Filter{}},
+		{name: "or in and", filter: `{"$and": [{"$or": [{"$ref": "all"}, {"qty": 1}]}, {"status": "closed"}]}`, want: []Filter{{Operator: OpAnd, Filters: []Filter{closed}}}},
+		{
+			name:   "or in or",
+			filter: `{"$and": [{"$or": [{"status": "closed"}, {"$or": [{"$ref": "all"}, {"qty": 1}]}]}, {"name": "x"}]}`,
+			want:   []Filter{{Operator: OpAnd, Filters: []Filter{{Field: "name", Operator: OpEq, Value: "x"}}}},
+		},
+		{name: "and of empty filters in or", filter: `{"$or": [{"$and": [{"$ref": "all"}]}, {"status": "closed"}]}`, want: []Filter{}},
+		{name: "empty through a reference", filter: `{"$or": [{"$ref": "also_all"}, {"status": "closed"}]}`, want: []Filter{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := ParseFilter(tt.filter)
+			assert.NoError(t, err)
+			resolved, err := registry.Resolve(filters)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, resolved)
+		})
+	}
+
+	// Matching everything, the $or compiles to no condition rather than to
+	// the other branch alone
+	filters, err := ParseFilter(`{"$or": [{"$ref": "all"}, {"status": "closed"}]}`)
+	assert.NoError(t, err)
+	resolved, err := registry.Resolve(filters)
+	assert.NoError(t, err)
+	qb, err := NewSqlBuilder(context.Background()).WithSelect("items").Apply(resolved, nil, &Item{})
+	if assert.NoError(t, err) {
+		query, _, err := qb.ToSql()
+		assert.NoError(t, err)
+		assert.Equal(t, "SELECT * FROM items", query)
+	}
+}
+
+func TestFilterRegistryErrors(t *testing.T) {
+	registry := NewFilterRegistry()
+	assert.NoError(t, registry.RegisterJSON("a", `{"$ref": "b"}`))
+	assert.NoError(t, registry.RegisterJSON("b", `{"qty": 1, "$or": [{"name": "x"}, {"$ref": "c"}]}`))
+	assert.NoError(t, registry.RegisterJSON("c", `{"$ref": "a"}`))
+	assert.NoError(t, registry.RegisterJSON("self", `{"$ref": "self"}`))
+	assert.NoError(t, registry.RegisterJSON("missing", `{"$ref": "nope"}`))
+
+	tests := []struct {
+		name    string
+		filters []Filter
+		wantErr string
+	}{
+		{name: "cycle", filters: []Filter{{Operator: OpRef, Value: "a"}}, wantErr: `filter "a" references itself: a -> b -> c -> a`},
+		{name: "cycle entered midway", filters: []Filter{{Operator: OpRef, Value: "c"}}, wantErr: `filter "c" references itself: c -> a -> b -> c`},
+		{name: "self reference", filters: []Filter{{Operator: OpRef, Value: "self"}}, wantErr: `filter "self" references itself: self -> self`},
+		{name: "unknown", filters: []Filter{{Operator: OpRef, Value: "missing"}}, wantErr: `unknown filter "nope"`},
+		{name: "not a name", filters: []Filter{{Operator: OpRef, Value: 3}}, wantErr: "$ref operator requires a filter name, got 3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := registry.Resolve(tt.filters)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+
+	// A filter may be referenced more than once outside of a cycle
+	assert.NoError(t, registry.RegisterJSON("d", `{"qty": 2}`))
+	resolved, err := registry.Resolve([]Filter{{Operator: OpRef, Value: "d"}, {Operator: OpOr, Filters: []Filter{{Operator: OpRef, Value: "d"}}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Filter{{Field: "qty", Operator: OpEq, Value: float64(2)}, {Operator: OpOr, Filters: []Filter{{Field: "qty", Operator: OpEq, Value: float64(2)}}}}, resolved)
+}
+
+func TestMarshalTemplates(t *testing.T) {
+	filters, err := ParseFilter(`{"$ref": "open_tickets", "owner": "$$currentUser", "status": {"$in": ["new", "$$status"]}}`)
+	assert.NoError(t, err)
+	data, err := MarshalFilter(Normalize(filters))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"$ref": "open_tickets", "owner": {"$eq": "$$currentUser"}, "status": {"$in": ["$$status", "new"]}}`, string(data))
+
+	reparsed, err := ParseFilter(string(data))
+	assert.NoError(t, err)
+	assert.Equal(t, Normalize(filters), Normalize(reparsed))
+}
+
+func TestMarshalLiterals(t *testing.T) {
+	filters := []Filter{
+		{Field: "code", Operator: OpEq, Value: "$$off"},
+		{Field: "tag", Operator: OpIn, Value: []string{"$$a", "$b"}},
+		{Field: "name", Operator: OpEq, Value: "$$"},
+	}
+	data, err := MarshalFilter(filters)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"code": {"$eq": "$$$off"}, "tag": {"$in": ["$$$a", "$b"]}, "name": {"$eq": "$$$"}}`, string(data))
+
+	reparsed, err := ParseFilter(string(data))
+	assert.NoError(t, err)
+	assert.Empty(t, Variables(reparsed))
+	assert.Equal(t, []Filter{
+		{Field: "code", Operator: OpEq, Value: "$$off"},
+		{Field: "name", Operator: OpEq, Value: "$$"},
+		{Field: "tag", Operator: OpIn, Value: []any{"$$a", "$b"}},
+	}, reparsed)
+}
@@ -0,0 +1,206 @@
+package queryparser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// searchText returns the query string of a $text or $search filter
+func searchText(filter Filter) (string, error) {
+	text, ok := filter.Value.(string)
+	if !ok || strings.TrimSpace(text) == "" {
+		return "", fmt.Errorf("%s operator requires a non-empty string", filter.Operator)
+	}
+	return text, nil
+}
+
+// searchable returns the top-level fields tagged query:"searchable" in
+// declaration order
+func (s *modelSchema) searchable() []*schemaField {
+	var fields []*schemaField
+	for _, f := range s.fields {
+		if f.Searchable {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// validateTextFilter checks that $search targets a searchable field and that
+// the model declares searchable fields for $text
+func validateTextFilter(filter Filter, schema *modelSchema) error {
+	if filter.Operator == OpText {
+		if len(schema.searchable()) == 0 {
+			return fmt.Errorf(`$text operator requires fields tagged query:"searchable"`)
+		}
+		return nil
+	}
+
+	chain, ok := schema.lookup(filter.Field)
+	if !ok || !chain[len(chain)-1].Filterable {
+		return unknownField("field %q is not a valid JSON field", filter.Field)
+	}
+	if !acceptsOperator(chain, OpSearch) {
+		return fmt.Errorf("field %q is not searchable", filter.Field)
+	}
+	return nil
+}
+
+// textFilter returns the first $text or $search filter in the tree
+func textFilter(filters []Filter) (Filter, bool) {
+	var found *Filter
+	_ = Walk(filters, func(c *Cursor) error {
+		f := c.Filter()
+		if found == nil && (f.Operator == OpText || f.Operator == OpSearch) {
+			found = &f
+		}
+		return nil
+	}, nil)
+	if found == nil {
+		return Filter{}, false
+	}
+	return *found, true
+}
+
+// textColumns returns the columns searched by a $text or $search filter
+func textColumns(filter Filter, columns columnResolver) []string {
+	if filter.Operator == OpSearch {
+		return []string{columns.columnOrField(filter.Field)}
+	}
+	fields := columns.schema.searchable()
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = columns.columnOrField(f.JSON)
+	}
+	return names
+}
+
+// SetTextSearchConfig sets the PostgreSQL text search configuration passed to
+// to_tsvector and websearch_to_tsquery. Expression indexes are only used when
+// the query names the same configuration as the index. The default is empty,
+// which uses the server's default_text_search_config.
+//
+// Example:
+//
+//	qb := NewSqlBuilder(ctx)
+//	qb.SetTextSearchConfig("english")
+//	// {"title": {"$search": "go"}} now generates:
+//	// to_tsvector('english', title) @@ websearch_to_tsquery('english', $1)
+func (qb *SqlBuilder) SetTextSearchConfig(config string) {
+	qb.textSearchConfig = config
+}
+
+// tsVector returns the Postgres tsvector expression over the columns
+func (qb *SqlBuilder) tsVector(names []string) string {
+	document := names[0]
+	if len(names) > 1 {
+		parts := make([]string, len(names))
+		for i, name := range names {
+			parts[i] = "coalesce(" + name + ", '')"
+		}
+		document = strings.Join(parts, " || ' ' || ")
+	}
+	if qb.textSearchConfig != "" {
+		return "to_tsvector(" + quoteLiteral(qb.textSearchConfig) + ", " + document + ")"
+	}
+	return "to_tsvector(" + document + ")"
+}
+
+// tsQuery returns the Postgres tsquery expression for one argument
+func (qb *SqlBuilder) tsQuery() string {
+	if qb.textSearchConfig != "" {
+		return "websearch_to_tsquery(" + quoteLiteral(qb.textSearchConfig) + ", ?)"
+	}
+	return "websearch_to_tsquery(?)"
+}
+
+// buildTextCondition converts $text and $search into the full-text match of
+// the dialect:
+//
+//   - PostgreSQL: to_tsvector(...) @@ websearch_to_tsquery(?)
+//   - MySQL: MATCH (...) AGAINST (?), which needs a FULLTEXT index over the
+//     same columns
+//   - SQLite: FTS5 MATCH on the column, or on the table for $text
+//   - SQL Server: FREETEXT((...), ?)
+func (qb *SqlBuilder) buildTextCondition(filter Filter, columns columnResolver) (squirrel.Sqlizer, error) {
+	text, err := searchText(filter)
+	if err != nil {
+		return nil, err
+	}
+	names := textColumns(filter, columns)
+	if len(names) == 0 {
+		return nil, fmt.Errorf(`$text operator requires fields tagged query:"searchable"`)
+	}
+
+	switch qb.dialect {
+	case DialectPostgres:
+		return squirrel.Expr(qb.tsVector(names)+" @@ "+qb.tsQuery(), text), nil
+	case DialectMySQL:
+		return squirrel.Expr("MATCH ("+strings.Join(names, ", ")+") AGAINST (?)", text), nil
+	case DialectSQLite:
+		target := names[0]
+		if filter.Operator == OpText {
+			target = qb.table
+		}
+		return squirrel.Expr(target+" MATCH ?", text), nil
+	case DialectSQLServer:
+		return squirrel.Expr("FREETEXT(("+strings.Join(names, ", ")+"), ?)", text), nil
+	default:
+		return nil, fmt.Errorf("full-text search is not supported by the %s dialect", qb.dialect)
+	}
+}
+
+// applyRelevance orders the results by full-text relevance, best match first,
+// when the options ask for it. It must run before the sort fields are applied.
+func (qb *SqlBuilder) applyRelevance(filters []Filter, options *QueryOptions, columns columnResolver) (*SqlBuilder, error) {
+	if options == nil || !options.Relevance {
+		return qb, nil
+	}
+	filter, ok := textFilter(filters)
+	if !ok {
+		return nil, errors.New("relevance sorting requires a $text or $search filter")
+	}
+	text, err := searchText(filter)
+	if err != nil {
+		return nil, err
+	}
+	names := textColumns(filter, columns)
+
+	switch qb.dialect {
+	case DialectPostgres:
+		rank := "ts_rank(" + qb.tsVector(names) + ", " + qb.tsQuery() + ") DESC"
+		qb.selectBuilder = qb.selectBuilder.OrderByClause(rank, text)
+	case DialectMySQL:
+		qb.selectBuilder = qb.selectBuilder.OrderByClause("MATCH ("+strings.Join(names, ", ")+") AGAINST (?) DESC", text)
+	case DialectSQLite:
+		// FTS5 rank is lower for better matches
+		qb.selectBuilder = qb.selectBuilder.OrderBy("rank")
+	default:
+		return nil, fmt.Errorf("relevance sorting is not supported by the %s dialect", qb.dialect)
+	}
+	return qb, nil
+}
+
+// buildTextQuery converts $text into a multi_match over the searchable fields
+// and $search into a match query. Without a model, $text searches the index's
+// default fields.
+func buildTextQuery(filter Filter, schema *modelSchema) (map[string]any, error) {
+	text, err := searchText(filter)
+	if err != nil {
+		return nil, err
+	}
+	if filter.Operator == OpSearch {
+		return map[string]any{"match": map[string]any{filter.Field: map[string]any{"query": text}}}, nil
+	}
+
+	fields := []string{}
+	if schema != nil {
+		for _, f := range schema.searchable() {
+			fields = append(fields, f.JSON)
+		}
+	}
+	return map[string]any{"multi_match": map[string]any{"query": text, "fields": fields}}, nil
+}
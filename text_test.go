@@ -0,0 +1,201 @@
+package queryparser
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/assert"
+)
+
+// Article declares its title and body as searchable
+type Article struct {
+	ID     int    `json:"id" db:"id"`
+	Title  string `json:"title" db:"title" query:"searchable"`
+	Body   string `json:"body" db:"body" query:"searchable"`
+	Author string `json:"author" db:"author"`
+}
+
+func TestTextSearch(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		dialect  Dialect
+		format   squirrel.PlaceholderFormat
+		config   string
+		filter   string
+		options  *QueryOptions
+		wantSQL  string
+		wantArgs []any
+	}{
+		{
+			name:     "postgres $text",
+			dialect:  DialectPostgres,
+			format:   squirrel.Dollar,
+			filter:   `{"$text": {"$search": "go generics"}}`,
+			wantSQL:  "SELECT * FROM articles WHERE (to_tsvector(coalesce(title, '') || ' ' || coalesce(body, '')) @@ websearch_to_tsquery($1))",
+			wantArgs: []any{"go generics"},
+		},
+		{
+			name:     "postgres $search with config",
+			dialect:  DialectPostgres,
+			format:   squirrel.Dollar,
+			config:   "english",
+			filter:   `{"title": {"$search": "go"}}`,
+			wantSQL:  "SELECT * FROM articles WHERE (to_tsvector('english', title) @@ websearch_to_tsquery('english', $1))",
+			wantArgs: []any{"go"},
+		},
+		{
+			name:    "postgres relevance",
+			dialect: DialectPostgres,
+			format:  squirrel.Dollar,
+			filter:  `{"$text": "go", "author": "ann"}`,
+			options: &QueryOptions{Relevance: true, Sort: map[string]SortDirection{"id": SortAsc}},
+			wantSQL: "SELECT * FROM articles WHERE (to_tsvector(coalesce(title, '') || ' ' || coalesce(body, '')) @@ websearch_to_tsquery($1) AND author = $2) " +
+				"ORDER BY ts_rank(to_tsvector(coalesce(title, '') || ' ' || coalesce(body, '')), websearch_to_tsquery($3)) DESC, id ASC",
+			wantArgs: []any{"go", "ann", "go"},
+		},
+		{
+			name:     "mysql $text with relevance",
+			dialect:  DialectMySQL,
+			format:   squirrel.Question,
+			filter:   `{"$text": {"$search": "go"}}`,
+			options:  &QueryOptions{Relevance: true},
+			wantSQL:  "SELECT * FROM articles WHERE (MATCH (title, body) AGAINST (?)) ORDER BY MATCH (title, body) AGAINST (?) DESC",
+			wantArgs: []any{"go", "go"},
+		},
+		{
+			name:     "sqlite $text with relevance",
+			dialect:  DialectSQLite,
+			format:   squirrel.Question,
+			filter:   `{"$text": {"$search": "go"}}`,
+			options:  &QueryOptions{Relevance: true},
+			wantSQL:  "SELECT * FROM articles WHERE (articles MATCH ?) ORDER BY rank",
+			wantArgs: []any{"go"},
+		},
+		{
+			name:     "sqlite $search",
+			dialect:  DialectSQLite,
+			format:   squirrel.Question,
+			filter:   `{"body": {"$search": "go"}}`,
+			wantSQL:  "SELECT * FROM articles WHERE (body MATCH ?)",
+			wantArgs: []any{"go"},
+		},
+		{
+			name:     "sqlserver $text",
+			dialect:  DialectSQLServer,
+			format:   squirrel.AtP,
+			filter:   `{"$text": {"$search": "go"}}`,
+			wantSQL:  "SELECT * FROM articles WHERE (FREETEXT((title, body), @p1))",
+			wantArgs: []any{"go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := ParseFilter(tt.filter)
+			assert.NoError(t, err)
+
+			qb := NewSqlBuilderWithPlaceholderFormat(ctx, tt.format)
+			qb.SetDialect(tt.dialect)
+			qb.SetTextSearchConfig(tt.config)
+			qb, err = qb.WithSelect("articles").Apply(Normalize(filters), tt.options, &Article{})
+			assert.NoError(t, err)
+
+			sql, args, err := qb.ToSql()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantSQL, sql)
+			assert.Equal(t, tt.wantArgs, args)
+		})
+	}
+}
+
+func TestTextSearchErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters []Filter
+		options *QueryOptions
+		model   any
+		wantErr string
+	}{
+		{
+			name:    "field is not searchable",
+			filters: []Filter{{Field: "author", Operator: OpSearch, Value: "ann"}},
+			model:   &Article{},
+			wantErr: `field "author" is not searchable`,
+		},
+		{
+			name:    "model without searchable fields",
+			filters: []Filter{{Operator: OpText, Value: "go"}},
+			model:   &TestUser{},
+			wantErr: `$text operator requires fields tagged query:"searchable"`,
+		},
+		{
+			name:    "empty search",
+			filters: []Filter{{Operator: OpText, Value: " "}},
+			model:   &Article{},
+			wantErr: "$text operator requires a non-empty string",
+		},
+		{
+			name:    "relevance without text filter",
+			filters: []Filter{{Field: "author", Operator: OpEq, Value: "ann"}},
+			options: &QueryOptions{Relevance: true},
+			model:   &Article{},
+			wantErr: "relevance sorting requires a $text or $search filter",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSqlBuilder(context.Background()).WithSelect("articles").Apply(tt.filters, tt.options, tt.model)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+
+	qb := NewSqlBuilder(context.Background())
+	qb.SetDialect(DialectSQLServer)
+	_, err := qb.WithSelect("articles").Apply([]Filter{{Operator: OpText, Value: "go"}}, &QueryOptions{Relevance: true}, &Article{})
+	assert.EqualError(t, err, "relevance sorting is not supported by the sqlserver dialect")
+}
+
+func TestElasticTextSearch(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters []Filter
+		model   any
+		want    string
+	}{
+		{
+			name:    "$text over searchable fields",
+			filters: []Filter{{Operator: OpText, Value: "go"}},
+			model:   &Article{},
+			want:    `{"bool":{"must":{"multi_match":{"fields":["title","body"],"query":"go"}}}}`,
+		},
+		{
+			name:    "$text without a model",
+			filters: []Filter{{Operator: OpText, Value: "go"}},
+			want:    `{"bool":{"must":{"multi_match":{"fields":[],"query":"go"}}}}`,
+		},
+		{
+			name:    "$search",
+			filters: []Filter{{Field: "title", Operator: OpSearch, Value: "go"}},
+			model:   &Article{},
+			want:    `{"bool":{"must":{"match":{"title":{"query":"go"}}}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewElasticBuilder(nil).Apply(tt.filters, nil, tt.model)
+			assert.NoError(t, err)
+
+			source, err := got.Source()
+			assert.NoError(t, err)
+			sourceStr, err := json.Marshal(source)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(sourceStr))
+		})
+	}
+}
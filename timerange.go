@@ -0,0 +1,309 @@
+package queryparser
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Between is the value of a $between filter. From and To are the bounds and
+// Bounds selects which of them are included, in interval notation: "[]" (the
+// default), "[)", "(]" or "()".
+//
+// In JSON the value is either a two-element array, which includes both
+// bounds, or an object:
+//
+//	{"created": {"$between": ["now-7d", "now"]}}
+//	{"created": {"$between": {"from": "startOfMonth", "to": "now", "bounds": "[)"}}}
+type Between struct {
+	From   any
+	To     any
+	Bounds string
+}
+
+// lowerInclusive reports whether the lower bound is part of the range
+func (b Between) lowerInclusive() bool {
+	return b.Bounds == "" || b.Bounds[0] == '['
+}
+
+// upperInclusive reports whether the upper bound is part of the range
+func (b Between) upperInclusive() bool {
+	return b.Bounds == "" || b.Bounds[len(b.Bounds)-1] == ']'
+}
+
+// betweenValue converts the value of a $between filter into a Between
+func betweenValue(filter Filter) (Between, error) {
+	var b Between
+	switch v := filter.Value.(type) {
+	case Between:
+		b = v
+	case *Between:
+		if v != nil {
+			b = *v
+		}
+	case map[string]any:
+		b.From, b.To = v["from"], v["to"]
+		if bounds, ok := v["bounds"]; ok {
+			s, ok := bounds.(string)
+			if !ok {
+				return Between{}, fmt.Errorf("$between bounds for field %q must be a string", filter.Field)
+			}
+			b.Bounds = s
+		}
+	default:
+		values, ok := sliceValues(filter.Value)
+		if !ok || len(values) != 2 {
+			return Between{}, fmt.Errorf("$between operator requires two values for field %q", filter.Field)
+		}
+		b.From, b.To = values[0], values[1]
+	}
+
+	if b.From == nil || b.To == nil {
+		return Between{}, fmt.Errorf("$between operator requires two values for field %q", filter.Field)
+	}
+	switch b.Bounds {
+	case "", "[]", "[)", "(]", "()":
+		return b, nil
+	default:
+		return Between{}, fmt.Errorf("invalid $between bounds %q for field %q, use [], [), (] or ()", b.Bounds, filter.Field)
+	}
+}
+
+// timeKeywords are the named relative times. They always resolve to the
+// start of the period, whatever the operator.
+var timeKeywords = map[string]string{
+	"startOfDay":   "d",
+	"startOfWeek":  "w",
+	"startOfMonth": "M",
+	"startOfYear":  "y",
+}
+
+// isRelativeTime reports whether a value is a relative time expression:
+// a keyword such as "startOfMonth" or Elasticsearch-style date math
+// starting with "now", e.g. "now-7d" or "now/d"
+func isRelativeTime(v any) bool {
+	s, ok := v.(string)
+	if !ok {
+		return false
+	}
+	if _, ok := timeKeywords[s]; ok {
+		return true
+	}
+	return len(s) >= 3 && s[:3] == "now"
+}
+
+// isDateMath reports whether a relative time is date math that Elasticsearch
+// evaluates natively
+func isDateMath(v any) bool {
+	s, ok := v.(string)
+	return ok && len(s) >= 3 && s[:3] == "now"
+}
+
+// resolveRelativeTime evaluates a relative time expression against now.
+//
+// Date math supports the units y, M, w, d, h (or H), m and s, e.g.
+// "now-1M/M". Rounding follows Elasticsearch: it rounds down, except when
+// roundUp is set, which is used for $lte and $gt, where it moves to the last
+// millisecond of the period. Weeks start on Monday.
+func resolveRelativeTime(expr string, now time.Time, roundUp bool) (time.Time, error) {
+	if unit, ok := timeKeywords[expr]; ok {
+		return floorTime(now, unit), nil
+	}
+	if !isDateMath(expr) {
+		return time.Time{}, fmt.Errorf("invalid relative time %q", expr)
+	}
+
+	t := now
+	rest := expr[3:]
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '+', '-':
+			i := 1
+			for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+				i++
+			}
+			if i == 1 || i == len(rest) {
+				return time.Time{}, fmt.Errorf("invalid relative time %q", expr)
+			}
+			n, err := strconv.Atoi(rest[1:i])
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid relative time %q", expr)
+			}
+			if rest[0] == '-' {
+				n = -n
+			}
+			if t, err = addTime(t, n, string(rest[i])); err != nil {
+				return time.Time{}, fmt.Errorf("invalid relative time %q: %w", expr, err)
+			}
+			rest = rest[i+1:]
+		case '/':
+			if len(rest) < 2 || !isTimeUnit(rest[1]) {
+				return time.Time{}, fmt.Errorf("invalid relative time %q", expr)
+			}
+			unit := string(rest[1])
+			t = floorTime(t, unit)
+			if roundUp {
+				next, _ := addTime(t, 1, unit)
+				t = next.Add(-time.Millisecond)
+			}
+			rest = rest[2:]
+		default:
+			return time.Time{}, fmt.Errorf("invalid relative time %q", expr)
+		}
+	}
+	return t, nil
+}
+
+// isTimeUnit reports whether a byte is a date math unit
+func isTimeUnit(c byte) bool {
+	switch c {
+	case 'y', 'M', 'w', 'd', 'h', 'H', 'm', 's':
+		return true
+	default:
+		return false
+	}
+}
+
+// addTime adds n units to t
+func addTime(t time.Time, n int, unit string) (time.Time, error) {
+	switch unit {
+	case "y":
+		return t.AddDate(n, 0, 0), nil
+	case "M":
+		return t.AddDate(0, n, 0), nil
+	case "w":
+		return t.AddDate(0, 0, 7*n), nil
+	case "d":
+		return t.AddDate(0, 0, n), nil
+	case "h", "H":
+		return t.Add(time.Duration(n) * time.Hour), nil
+	case "m":
+		return t.Add(time.Duration(n) * time.Minute), nil
+	case "s":
+		return t.Add(time.Duration(n) * time.Second), nil
+	default:
+		return time.Time{}, fmt.Errorf("unknown unit %q", unit)
+	}
+}
+
+// floorTime rounds t down to the start of the unit, in t's location
+func floorTime(t time.Time, unit string) time.Time {
+	y, mo, d := t.Date()
+	loc := t.Location()
+	switch unit {
+	case "y":
+		return time.Date(y, time.January, 1, 0, 0, 0, 0, loc)
+	case "M":
+		return time.Date(y, mo, 1, 0, 0, 0, 0, loc)
+	case "w":
+		offset := (int(t.Weekday()) + 6) % 7 // days since Monday
+		return time.Date(y, mo, d-offset, 0, 0, 0, 0, loc)
+	case "d":
+		return time.Date(y, mo, d, 0, 0, 0, 0, loc)
+	case "h", "H":
+		return time.Date(y, mo, d, t.Hour(), 0, 0, 0, loc)
+	case "m":
+		return time.Date(y, mo, d, t.Hour(), t.Minute(), 0, 0, loc)
+	default:
+		return time.Date(y, mo, d, t.Hour(), t.Minute(), t.Second(), 0, loc)
+	}
+}
+
+// isTimeField reports whether a path names a time.Time field of the model
+func isTimeField(schema *modelSchema, path string) bool {
+	if schema == nil {
+		return false
+	}
+	chain, ok := schema.lookup(path)
+	if !ok || len(chain) < strings.Count(path, ".")+1 {
+		return false
+	}
+	typ := chain[len(chain)-1].Type
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ == timeType
+}
+
+// resolveTimes returns a copy of the filters with relative times resolved
+// against now. Values are resolved on time.Time fields of the model, or on
+// any range condition when schema is nil. When keepDateMath is set, date math
+// is left for the backend to evaluate and only keywords are resolved.
+func resolveTimes(filters []Filter, schema *modelSchema, now time.Time, keepDateMath bool) ([]Filter, error) {
+	resolve := func(f Filter, v any, roundUp bool) (any, error) {
+		if !isRelativeTime(v) || (keepDateMath && isDateMath(v)) {
+			return v, nil
+		}
+		t, err := resolveRelativeTime(v.(string), now, roundUp)
+		if err != nil {
+			// Without a model the value may be a plain string
+			if schema == nil {
+				return v, nil
+			}
+			return nil, fmt.Errorf("%w in field %q", err, f.Field)
+		}
+		return t, nil
+	}
+
+	return Rewrite(filters, func(c *Cursor) error {
+		f := c.Filter()
+		switch f.Operator {
+		case OpGt, OpGte, OpLt, OpLte, OpBetween:
+		default:
+			return nil
+		}
+		if schema != nil && !isTimeField(schema, f.Field) {
+			return nil
+		}
+
+		if f.Operator == OpBetween {
+			b, err := betweenValue(f)
+			if err != nil {
+				return err
+			}
+			if b.From, err = resolve(f, b.From, !b.lowerInclusive()); err != nil {
+				return err
+			}
+			if b.To, err = resolve(f, b.To, b.upperInclusive()); err != nil {
+				return err
+			}
+			f.Value = b
+		} else {
+			v, err := resolve(f, f.Value, f.Operator == OpGt || f.Operator == OpLte)
+			if err != nil {
+				return err
+			}
+			f.Value = v
+		}
+		c.Replace(f)
+		return nil
+	}, nil)
+}
+
+// SetClock sets the clock used to resolve relative times such as "now-7d".
+// The default is time.Now.
+//
+// Example:
+//
+//	qb := NewSqlBuilder(ctx)
+//	qb.SetClock(func() time.Time { return time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC) })
+//	// {"created": {"$gte": "now/d"}} now compares created with 2024-05-15T00:00:00Z
+func (qb *SqlBuilder) SetClock(clock func() time.Time) {
+	qb.clock = clock
+}
+
+// SetClock sets the clock used to resolve relative times, see
+// DSLBuilder.SetClock
+func (eb *ElasticBuilder) SetClock(clock func() time.Time) {
+	eb.dsl.SetClock(clock)
+}
+
+// clockNow returns the current time of a clock, defaulting to time.Now
+func clockNow(clock func() time.Time) time.Time {
+	if clock == nil {
+		return time.Now()
+	}
+	return clock()
+}
@@ -0,0 +1,190 @@
+package queryparser
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Event has a time field for relative time filters
+type Event struct {
+	ID       int        `json:"id" db:"id"`
+	Name     string     `json:"name" db:"name"`
+	Score    int        `json:"score" db:"score"`
+	Created  time.Time  `json:"created" db:"created"`
+	Resolved *time.Time `json:"resolved" db:"resolved"`
+}
+
+// fixedClock is Wednesday 2024-05-15 13:45:30 UTC
+func fixedClock() time.Time {
+	return time.Date(2024, 5, 15, 13, 45, 30, 0, time.UTC)
+}
+
+func TestResolveRelativeTime(t *testing.T) {
+	tests := []struct {
+		expr    string
+		roundUp bool
+		want    time.Time
+	}{
+		{expr: "now", want: fixedClock()},
+		{expr: "now-7d", want: time.Date(2024, 5, 8, 13, 45, 30, 0, time.UTC)},
+		{expr: "now+1h-30m", want: time.Date(2024, 5, 15, 14, 15, 30, 0, time.UTC)},
+		{expr: "now/d", want: time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC)},
+		{expr: "now/d", roundUp: true, want: time.Date(2024, 5, 15, 23, 59, 59, int(999*time.Millisecond), time.UTC)},
+		{expr: "now-1M/M", want: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "now/w", want: time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC)},
+		{expr: "startOfDay", roundUp: true, want: time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC)},
+		{expr: "startOfWeek", want: time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC)},
+		{expr: "startOfMonth", want: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "startOfYear", want: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := resolveRelativeTime(tt.expr, fixedClock(), tt.roundUp)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	for _, expr := range []string{"now-7", "now-d", "now/x", "now7d", "nowhere"} {
+		_, err := resolveRelativeTime(expr, fixedClock(), false)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestBetween(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   string
+		wantSQL  string
+		wantArgs []any
+	}{
+		{
+			name:     "inclusive numbers",
+			filter:   `{"score": {"$between": [10, 20]}}`,
+			wantSQL:  "SELECT * FROM events WHERE (score BETWEEN $1 AND $2)",
+			wantArgs: []any{float64(10), float64(20)},
+		},
+		{
+			name:     "half-open range",
+			filter:   `{"score": {"$between": {"from": 10, "to": 20, "bounds": "[)"}}}`,
+			wantSQL:  "SELECT * FROM events WHERE ((score >= $1 AND score < $2))",
+			wantArgs: []any{float64(10), float64(20)},
+		},
+		{
+			name:     "relative times",
+			filter:   `{"created": {"$between": ["now-7d", "now"]}}`,
+			wantSQL:  "SELECT * FROM events WHERE (created BETWEEN $1 AND $2)",
+			wantArgs: []any{time.Date(2024, 5, 8, 13, 45, 30, 0, time.UTC), fixedClock()},
+		},
+		{
+			name:    "rounded days",
+			filter:  `{"created": {"$between": ["now-1d/d", "now/d"]}}`,
+			wantSQL: "SELECT * FROM events WHERE (created BETWEEN $1 AND $2)",
+			wantArgs: []any{
+				time.Date(2024, 5, 14, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 5, 15, 23, 59, 59, int(999*time.Millisecond), time.UTC),
+			},
+		},
+		{
+			name:     "relative time in range operators",
+			filter:   `{"resolved": {"$gte": "startOfMonth"}}`,
+			wantSQL:  "SELECT * FROM events WHERE (resolved >= $1)",
+			wantArgs: []any{time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			name:     "strings on other fields are kept",
+			filter:   `{"name": {"$gt": "now"}}`,
+			wantSQL:  "SELECT * FROM events WHERE (name > $1)",
+			wantArgs: []any{"now"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := ParseFilter(tt.filter)
+			assert.NoError(t, err)
+
+			qb := NewSqlBuilder(context.Background())
+			qb.SetClock(fixedClock)
+			qb, err = qb.WithSelect("events").Apply(filters, nil, &Event{})
+			assert.NoError(t, err)
+
+			sql, args, err := qb.ToSql()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantSQL, sql)
+			assert.Equal(t, tt.wantArgs, args)
+		})
+	}
+}
+
+func TestBetweenErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  Filter
+		wantErr string
+	}{
+		{
+			name:    "one value",
+			filter:  Filter{Field: "score", Operator: OpBetween, Value: []any{1}},
+			wantErr: `$between operator requires two values for field "score"`,
+		},
+		{
+			name:    "invalid bounds",
+			filter:  Filter{Field: "score", Operator: OpBetween, Value: Between{From: 1, To: 2, Bounds: "[["}},
+			wantErr: `invalid $between bounds "[[" for field "score", use [], [), (] or ()`,
+		},
+		{
+			name:    "invalid relative time",
+			filter:  Filter{Field: "created", Operator: OpGte, Value: "now-1q"},
+			wantErr: `invalid relative time "now-1q": unknown unit "q" in field "created"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSqlBuilder(context.Background()).WithSelect("events").Apply([]Filter{tt.filter}, nil, &Event{})
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestElasticBetween(t *testing.T) {
+	tests := []struct {
+		name   string
+		clock  func() time.Time
+		filter Filter
+		want   string
+	}{
+		{
+			name:   "date math is passed through",
+			filter: Filter{Field: "created", Operator: OpBetween, Value: []any{"now-7d/d", "now"}},
+			want:   `{"bool":{"must":{"range":{"created":{"gte":"now-7d/d","lte":"now"}}}}}`,
+		},
+		{
+			name:   "keywords are resolved",
+			clock:  fixedClock,
+			filter: Filter{Field: "created", Operator: OpBetween, Value: Between{From: "startOfMonth", To: "now/d", Bounds: "[)"}},
+			want:   `{"bool":{"must":{"range":{"created":{"gte":"2024-05-01T00:00:00Z","lt":"2024-05-15T00:00:00Z"}}}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eb := NewElasticBuilder(nil)
+			eb.SetClock(tt.clock)
+			got, err := eb.Apply([]Filter{tt.filter}, nil, &Event{})
+			assert.NoError(t, err)
+
+			source, err := got.Source()
+			assert.NoError(t, err)
+			sourceStr, err := json.Marshal(source)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(sourceStr))
+		})
+	}
+}
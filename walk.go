@@ -0,0 +1,187 @@
+package queryparser
+
+import (
+	"errors"
+	"strconv"
+)
+
+// SkipChildren is returned by a pre hook to skip the children of the current
+// node. It is never returned by Walk or Rewrite.
+var SkipChildren = errors.New("skip children")
+
+// WalkFunc is a hook called for every node of a filter tree
+type WalkFunc func(c *Cursor) error
+
+// Cursor describes the node currently being visited by Walk or Rewrite
+type Cursor struct {
+	filter   Filter
+	parent   *Filter
+	path     string
+	depth    int
+	deleted  bool
+	readOnly bool
+}
+
+// Filter returns the current node
+func (c *Cursor) Filter() Filter {
+	return c.filter
+}
+
+// Parent returns the enclosing $and/$or node, or nil at the top level
+func (c *Cursor) Parent() *Filter {
+	return c.parent
+}
+
+// Depth returns the nesting level of the current node, starting at 0
+func (c *Cursor) Depth() int {
+	return c.depth
+}
+
+// Path returns the location of the current node, e.g. "[0].$or[1]"
+func (c *Cursor) Path() string {
+	return c.path
+}
+
+// Replace replaces the current node. During Rewrite the children of the new
+// node are visited instead of the old ones. It has no effect during Walk.
+func (c *Cursor) Replace(f Filter) {
+	if c.readOnly {
+		return
+	}
+	c.filter = f
+	c.deleted = false
+}
+
+// Delete removes the current node from its parent. Its children and post hook
+// are skipped. Deleting every child of a group leaves an empty group, which
+// the builders reject. It has no effect during Walk.
+func (c *Cursor) Delete() {
+	if c.readOnly {
+		return
+	}
+	c.deleted = true
+}
+
+// Walk traverses the filter tree depth-first. pre is called before a node's
+// children and post after them; either may be nil. Returning SkipChildren
+// from pre skips the node's children, any other error stops the walk and is
+// returned.
+//
+// Example:
+//
+//	// Collect every $like condition
+//	var likes []Filter
+//	err := Walk(filters, func(c *Cursor) error {
+//		if c.Filter().Operator == OpLike {
+//			likes = append(likes, c.Filter())
+//		}
+//		return nil
+//	}, nil)
+func Walk(filters []Filter, pre, post WalkFunc) error {
+	w := &walker{pre: pre, post: post, readOnly: true}
+	_, err := w.list(filters, nil, "", 0)
+	return err
+}
+
+// Rewrite traverses the filter tree like Walk and returns a copy in which
+// nodes replaced or deleted through the Cursor have been updated. The input
+// is never modified.
+//
+// Example:
+//
+//	// Rename a field everywhere in the tree
+//	filters, err := Rewrite(filters, func(c *Cursor) error {
+//		if f := c.Filter(); f.Field == "login" {
+//			f.Field = "email"
+//			c.Replace(f)
+//		}
+//		return nil
+//	}, nil)
+func Rewrite(filters []Filter, pre, post WalkFunc) ([]Filter, error) {
+	w := &walker{pre: pre, post: post}
+	return w.list(filters, nil, "", 0)
+}
+
+// walker holds the hooks for a single traversal
+type walker struct {
+	pre, post WalkFunc
+	readOnly  bool
+}
+
+// list visits every filter in a list and returns the rewritten list
+func (w *walker) list(filters []Filter, parent *Filter, prefix string, depth int) ([]Filter, error) {
+	result := make([]Filter, 0, len(filters))
+	for i, f := range filters {
+		c := &Cursor{
+			filter:   f,
+			parent:   parent,
+			path:     prefix + "[" + strconv.Itoa(i) + "]",
+			depth:    depth,
+			readOnly: w.readOnly,
+		}
+		keep, err := w.node(c)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			result = append(result, c.filter)
+		}
+	}
+	return result, nil
+}
+
+// node visits a single node and its children. It reports whether the node
+// should be kept.
+func (w *walker) node(c *Cursor) (bool, error) {
+	skip := false
+	if w.pre != nil {
+		if err := w.pre(c); err != nil {
+			if !errors.Is(err, SkipChildren) {
+				return false, err
+			}
+			skip = true
+		}
+	}
+	if c.deleted {
+		return false, nil
+	}
+
+	if !skip && len(c.filter.Filters) > 0 {
+		node := c.filter
+		children, err := w.list(node.Filters, &node, c.path+"."+string(node.Operator), c.depth+1)
+		if err != nil {
+			return false, err
+		}
+		c.filter.Filters = children
+	}
+
+	if w.post != nil {
+		if err := w.post(c); err != nil && !errors.Is(err, SkipChildren) {
+			return false, err
+		}
+	}
+	return !c.deleted, nil
+}
+
+// Fields returns the distinct field names referenced anywhere in the filter
+// tree, in the order they first appear
+func Fields(filters []Filter) []string {
+	var fields []string
+	seen := make(map[string]bool)
+	_ = Walk(filters, func(c *Cursor) error {
+		f := c.Filter()
+		if f.Operator == OpAnd || f.Operator == OpOr || f.Operator == OpText || f.Operator == OpRef {
+			return nil
+		}
+		if !seen[f.Field] {
+			seen[f.Field] = true
+			fields = append(fields, f.Field)
+		}
+		// Conditions of $elemMatch name fields of the elements
+		if f.Operator == OpElemMatch {
+			return SkipChildren
+		}
+		return nil
+	}, nil)
+	return fields
+}
@@ -0,0 +1,189 @@
+package queryparser
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// walkFixture is a filter tree shared by the walk tests
+var walkFixture = []Filter{
+	{Field: "name", Operator: OpEq, Value: "mike"},
+	{
+		Operator: OpOr,
+		Filters: []Filter{
+			{Field: "age", Operator: OpGt, Value: 20},
+			{
+				Operator: OpAnd,
+				Filters: []Filter{
+					{Field: "email", Operator: OpLike, Value: "example"},
+					{Field: "age", Operator: OpLt, Value: 10},
+				},
+			},
+		},
+	},
+}
+
+func TestWalk(t *testing.T) {
+	var events []string
+	err := Walk(walkFixture, func(c *Cursor) error {
+		events = append(events, "pre "+c.Path())
+		return nil
+	}, func(c *Cursor) error {
+		events = append(events, "post "+c.Path())
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"pre [0]",
+		"post [0]",
+		"pre [1]",
+		"pre [1].$or[0]",
+		"post [1].$or[0]",
+		"pre [1].$or[1]",
+		"pre [1].$or[1].$and[0]",
+		"post [1].$or[1].$and[0]",
+		"pre [1].$or[1].$and[1]",
+		"post [1].$or[1].$and[1]",
+		"post [1].$or[1]",
+		"post [1]",
+	}, events)
+}
+
+func TestWalkParentAndDepth(t *testing.T) {
+	depths := make(map[string]int)
+	parents := make(map[string]Operator)
+	err := Walk(walkFixture, func(c *Cursor) error {
+		f := c.Filter()
+		if f.Field == "" {
+			return nil
+		}
+		depths[f.Field+string(f.Operator)] = c.Depth()
+		if c.Parent() != nil {
+			parents[f.Field+string(f.Operator)] = c.Parent().Operator
+		}
+		return nil
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"name$eq": 0, "age$gt": 1, "email$like": 2, "age$lt": 2}, depths)
+	assert.Equal(t, map[string]Operator{"age$gt": OpOr, "email$like": OpAnd, "age$lt": OpAnd}, parents)
+}
+
+func TestWalkSkipChildren(t *testing.T) {
+	var visited []string
+	err := Walk(walkFixture, func(c *Cursor) error {
+		visited = append(visited, c.Path())
+		if c.Filter().Operator == OpAnd {
+			return SkipChildren
+		}
+		return nil
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"[0]", "[1]", "[1].$or[0]", "[1].$or[1]"}, visited)
+}
+
+func TestWalkStopsOnError(t *testing.T) {
+	errStop := errors.New("stop")
+	count := 0
+	err := Walk(walkFixture, func(c *Cursor) error {
+		count++
+		if c.Filter().Operator == OpGt {
+			return errStop
+		}
+		return nil
+	}, nil)
+	assert.ErrorIs(t, err, errStop)
+	assert.Equal(t, 3, count)
+}
+
+func TestWalkIgnoresModifications(t *testing.T) {
+	count := 0
+	err := Walk(walkFixture, func(c *Cursor) error {
+		count++
+		c.Delete()
+		c.Replace(Filter{Field: "other", Operator: OpEq, Value: 1})
+		return nil
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, count)
+}
+
+func TestRewrite(t *testing.T) {
+	t.Run("rename fields", func(t *testing.T) {
+		got, err := Rewrite(walkFixture, func(c *Cursor) error {
+			if f := c.Filter(); f.Field == "age" {
+				f.Field = "years"
+				c.Replace(f)
+			}
+			return nil
+		}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"name", "years", "email"}, Fields(got))
+		assert.Equal(t, []string{"name", "age", "email"}, Fields(walkFixture), "input must not be modified")
+	})
+
+	t.Run("strip conditions", func(t *testing.T) {
+		got, err := Rewrite(walkFixture, func(c *Cursor) error {
+			if c.Filter().Field == "email" {
+				c.Delete()
+			}
+			return nil
+		}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []Filter{
+			{Field: "name", Operator: OpEq, Value: "mike"},
+			{
+				Operator: OpOr,
+				Filters: []Filter{
+					{Field: "age", Operator: OpGt, Value: 20},
+					{
+						Operator: OpAnd,
+						Filters:  []Filter{{Field: "age", Operator: OpLt, Value: 10}},
+					},
+				},
+			},
+		}, got)
+	})
+
+	t.Run("replaced node children are visited", func(t *testing.T) {
+		var visited []string
+		_, err := Rewrite([]Filter{{Field: "age", Operator: OpEq, Value: 1}}, func(c *Cursor) error {
+			visited = append(visited, c.Filter().Field)
+			if c.Filter().Field == "age" {
+				c.Replace(Filter{Operator: OpOr, Filters: []Filter{
+					{Field: "min_age", Operator: OpEq, Value: 1},
+					{Field: "max_age", Operator: OpEq, Value: 1},
+				}})
+			}
+			return nil
+		}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"age", "min_age", "max_age"}, visited)
+	})
+
+	t.Run("post hook sees rewritten children", func(t *testing.T) {
+		got, err := Rewrite(walkFixture, func(c *Cursor) error {
+			if c.Filter().Field == "email" {
+				c.Delete()
+			}
+			return nil
+		}, func(c *Cursor) error {
+			// Collapse groups left with a single child
+			if f := c.Filter(); f.Operator == OpAnd && len(f.Filters) == 1 {
+				c.Replace(f.Filters[0])
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []Filter{
+			{Field: "age", Operator: OpGt, Value: 20},
+			{Field: "age", Operator: OpLt, Value: 10},
+		}, got[1].Filters)
+	})
+}
+
+func TestFields(t *testing.T) {
+	assert.Equal(t, []string{"name", "age", "email"}, Fields(walkFixture))
+	assert.Empty(t, Fields(nil))
+}